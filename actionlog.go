@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actionLogDir is the directory in the state dir holding per-action logs
+const actionLogDir = "actions"
+
+// ActionLog copies the log lines written while an action executes into a
+// file of its own, so an incident can be reviewed without picking the
+// action's lines out of manager.log
+type ActionLog struct {
+	path string   // Log file path relative to the state dir
+	file *os.File // Open log file
+}
+
+// actionLogTee is part of the standard log output and copies every line into
+// the open action log, if any. Goroutines that serve other work while an
+// action executes log through backgroundLog, which bypasses it.
+type actionLogTee struct {
+	mu   sync.Mutex
+	file io.Writer
+}
+
+// actionTee is the tee of the standard logger
+var actionTee = &actionLogTee{}
+
+func (t *actionLogTee) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.file != nil {
+		t.file.Write(p)
+	}
+	return len(p), nil
+}
+
+// attach makes w the action log lines are copied into, none if w is nil
+func (t *actionLogTee) attach(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file = w
+}
+
+// openActionLog creates actions/<key>-<timestamp>.log in stateDir and
+// attaches it to the standard logger until Close
+func openActionLog(stateDir, key string, now time.Time) (*ActionLog, error) {
+	dir := filepath.Join(stateDir, actionLogDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s.log", actionLogName(key), now.UTC().Format("20060102T150405Z"))
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &ActionLog{path: filepath.Join(actionLogDir, name), file: file}
+	actionTee.attach(file)
+	return l, nil
+}
+
+// Path returns the log file path relative to the state dir
+func (l *ActionLog) Path() string {
+	if l == nil {
+		return ""
+	}
+	return l.path
+}
+
+// Close detaches the log file from the standard logger and closes it. It is
+// a no-op on a nil ActionLog.
+func (l *ActionLog) Close() {
+	if l == nil {
+		return
+	}
+	actionTee.attach(nil)
+	if err := l.file.Close(); err != nil {
+		log.Printf("[WARN] Failed to close action log %s: %v", l.path, err)
+	}
+}
+
+// actionLogName turns an action key, which holds colons and for reboots a
+// URL, into a file name
+func actionLogName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, key)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Files the admin API keeps in the config directory
+const (
+	adminSocketFile = "admin.sock"  // Default Unix socket
+	adminTokenFile  = "admin.token" // Bearer token clients must present
+)
+
+// AdminConfig configures the local control API served in daemon mode
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"` // Serve the admin API
+	Socket  string `yaml:"socket,omitempty"`  // Unix socket path (default <config dir>/admin.sock)
+	Listen  string `yaml:"listen,omitempty"`  // TCP address to serve on instead of the socket, e.g. "127.0.0.1:9091"
+}
+
+// AdminStatus is the body of GET /v1/status
+type AdminStatus struct {
+	Paused    bool   `json:"paused"`               // Cycles are skipped until resumed
+	LastRun   string `json:"last_run,omitempty"`   // Status of the most recent cycle
+	UpdatedAt string `json:"updated_at,omitempty"` // RFC3339 time of the last poll
+}
+
+// AdminPending is the body of GET /v1/pending
+type AdminPending struct {
+	Candidates []CandidateStatus `json:"candidates"` // Actions gathering votes, most votes first
+	Actions    map[string]string `json:"actions"`    // Lifecycle state of actions not yet done
+	Approvals  []ApprovalStatus  `json:"approvals"`  // Actions queued for approval
+}
+
+// adminDecision is the body of POST /v1/approve and /v1/reject
+type adminDecision struct {
+	Action string `json:"action"` // Action key
+}
+
+// adminError is the body of failed admin requests
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// loadOrCreateAdminToken returns the bearer token for the admin API,
+// generating it on first use
+func loadOrCreateAdminToken(configDir string) (string, error) {
+	path := filepath.Join(configDir, adminTokenFile)
+	if data, err := os.ReadFile(path); err == nil {
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read admin token: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate admin token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write admin token: %w", err)
+	}
+	log.Printf("[INFO] Generated admin API token in %s", path)
+	return token, nil
+}
+
+// adminSocketPath returns the Unix socket the admin API listens on
+func adminSocketPath(cfg Config) string {
+	if cfg.Admin.Socket != "" {
+		return cfg.Admin.Socket
+	}
+	return filepath.Join(cfg.ConfigPath, adminSocketFile)
+}
+
+// AdminServer exposes pending actions, approvals, pause/resume, on-demand
+// polls, and history to external orchestration over a local API
+type AdminServer struct {
+	m        *Manager
+	token    string
+	stateDir string
+}
+
+// writeJSON sends v as the JSON response body with the given status code
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// hasBearerToken reports whether r carries token as its bearer token
+func hasBearerToken(r *http.Request, token string) bool {
+	got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// authenticate rejects requests that lack the bearer token
+func (s *AdminServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, s.token) {
+			writeJSON(w, http.StatusUnauthorized, adminError{"missing or invalid bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *AdminServer) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	state, err := s.m.dashboard.snapshot()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminStatus{
+		Paused:    s.m.paused.Load(),
+		LastRun:   state.LastRun,
+		UpdatedAt: state.UpdatedAt,
+	})
+}
+
+func (s *AdminServer) handlePending(w http.ResponseWriter, _ *http.Request) {
+	state, err := s.m.dashboard.snapshot()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, adminError{err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminPending{
+		Candidates: state.Candidates,
+		Actions:    state.Actions,
+		Approvals:  state.Approvals,
+	})
+}
+
+func (s *AdminServer) handleHistory(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.m.dashboard.History())
+}
+
+// handleDecision approves or rejects a queued action
+func (s *AdminServer) handleDecision(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body adminDecision
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Action == "" {
+			writeJSON(w, http.StatusBadRequest, adminError{`body must be {"action": "<action key>"}`})
+			return
+		}
+		if _, err := decideApproval(s.stateDir, body.Action, status, "admin API"); err != nil {
+			writeJSON(w, http.StatusBadRequest, adminError{err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handlePause pauses or resumes processing
+func (s *AdminServer) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if s.m.paused.Swap(paused) != paused {
+			if paused {
+				backgroundLog.Println("[INFO] Processing paused via admin API")
+			} else {
+				backgroundLog.Println("[INFO] Processing resumed via admin API")
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handlePoll starts a cycle without waiting for the poll interval. A poll
+// already requested but not yet started is not queued twice.
+func (s *AdminServer) handlePoll(w http.ResponseWriter, _ *http.Request) {
+	select {
+	case s.m.pollNow <- struct{}{}:
+		backgroundLog.Println("[INFO] Poll requested via admin API")
+	default:
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Serve starts the admin API on the configured Unix socket, or on a TCP
+// address if admin.listen is set. The server is closed when shutdown is
+// requested.
+func (s *AdminServer) Serve(shutdown *ShutdownHandler) error {
+	var (
+		ln   net.Listener
+		addr string
+		err  error
+	)
+	if listen := s.m.config.Admin.Listen; listen != "" {
+		addr = listen
+		ln, err = net.Listen("tcp", listen)
+	} else {
+		addr = adminSocketPath(s.m.config)
+		// A socket left behind by a crashed daemon would block the bind
+		if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale admin socket: %w", err)
+		}
+		ln, err = net.Listen("unix", addr)
+		if err == nil {
+			err = os.Chmod(addr, 0600)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to listen for admin API on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", s.handleStatus)
+	mux.HandleFunc("GET /v1/pending", s.handlePending)
+	mux.HandleFunc("GET /v1/history", s.handleHistory)
+	mux.HandleFunc("POST /v1/approve", s.handleDecision(approvalApproved))
+	mux.HandleFunc("POST /v1/reject", s.handleDecision(approvalRejected))
+	mux.HandleFunc("POST /v1/pause", s.handlePause(true))
+	mux.HandleFunc("POST /v1/resume", s.handlePause(false))
+	mux.HandleFunc("POST /v1/poll", s.handlePoll)
+
+	srv := &http.Server{Handler: s.authenticate(mux), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		backgroundLog.Printf("[INFO] Serving admin API on %s", addr)
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			backgroundLog.Printf("[ERROR] Admin API failed: %v", err)
+		}
+	}()
+	go func() {
+		<-shutdown.Context().Done()
+		srv.Close()
+	}()
+	return nil
+}
+
+func newAdminCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:       "admin <status|pending|history|pause|resume|poll|approve KEY|reject KEY>",
+		Short:     "Call the admin API of the running daemon",
+		Args:      cobra.RangeArgs(1, 2),
+		ValidArgs: []string{"status", "pending", "history", "pause", "resume", "poll", "approve", "reject"},
+		Run: func(cmd *cobra.Command, args []string) {
+			adminCLI(g.configDir, g.stateDir, args)
+		},
+	}
+}
+
+// adminCLI calls the admin API of the running daemon
+func adminCLI(configDir, stateDir string, args []string) {
+	cfg := loadConfig(configDir, stateDir)
+	tokenData, err := os.ReadFile(filepath.Join(configDir, adminTokenFile))
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read admin token (is the admin API enabled?): %v", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	base := "http://" + cfg.Admin.Listen
+	if cfg.Admin.Listen == "" {
+		socket := adminSocketPath(cfg)
+		base = "http://admin"
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+
+	method, path := http.MethodGet, ""
+	var body io.Reader
+	switch cmd := args[0]; cmd {
+	case "status", "pending", "history":
+		path = cmd
+	case "pause", "resume", "poll":
+		method, path = http.MethodPost, cmd
+	case "approve", "reject":
+		if len(args) < 2 {
+			log.Fatalf("[ERROR] admin %s requires an action key", cmd)
+		}
+		data, _ := json.Marshal(adminDecision{Action: args[1]})
+		method, path, body = http.MethodPost, cmd, bytes.NewReader(data)
+	default:
+		log.Fatalf("[ERROR] Unknown admin command '%s'", cmd)
+	}
+
+	req, err := http.NewRequest(method, base+"/v1/"+path, body)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(tokenData)))
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("[ERROR] Admin API request failed (is the daemon running?): %v", err)
+	}
+	defer resp.Body.Close()
+
+	out, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		var e adminError
+		if json.Unmarshal(out, &e) == nil && e.Error != "" {
+			log.Fatalf("[ERROR] %s", e.Error)
+		}
+		log.Fatalf("[ERROR] Admin API returned %s", resp.Status)
+	}
+	if len(out) > 0 {
+		os.Stdout.Write(out)
+	}
+}
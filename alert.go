@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults for operator alerts
+const (
+	defaultRelayLossAlertAfter   = 10 * time.Minute
+	defaultQuorumStuckAlertAfter = 24 * time.Hour
+	alertRepeatInterval          = 6 * time.Hour // an unchanged alert is not repeated sooner
+)
+
+// alertConditions lists the conditions exported as firing alert metrics
+var alertConditions = []string{notifyRelaysLost, notifyQuorumStuck, notifyExecutionFailed, notifySignerSilent, notifyActionBlocked, notifyResyncStalled}
+
+// AlertState persists what the operator was told so alerts are not repeated
+// every poll and relay outages are measured across runs
+type AlertState struct {
+	Sent            map[string]string `yaml:"sent"`                        // key: alert key, value: ISO8601 time last sent
+	RelaysLostSince string            `yaml:"relays_lost_since,omitempty"` // ISO8601 time no relay could be reached since
+	RelaysLostHeld  bool              `yaml:"relays_lost_held,omitempty"`  // The relay loss alert awaits delivery over nostr once relays recover
+	path            string            // alert state file path (not in YAML)
+}
+
+// Alerter tracks alert conditions and notifies the operator about them over
+// the configured notification channels. Without channels the conditions are
+// still tracked for metrics.
+type Alerter struct {
+	cfg      Config
+	kp       Keypair
+	channels []notifyChannel
+	state    *AlertState
+	stuck    int  // Candidates below quorum for longer than quorum_stuck_alert_after
+	silent   int  // Follows silent for longer than signer_silence_alert_after
+	blocked  int  // Actions whose circuit breaker is open
+	verbose  bool // Log alerts held back as repeats
+}
+
+// newAlerter returns the alerter for cfg
+func newAlerter(cfg Config, kp Keypair, shutdown *ShutdownHandler, verbose bool) *Alerter {
+	var channels []notifyChannel
+	for _, nc := range notifierConfigs(cfg) {
+		c, err := newNotifyChannel(nc, cfg, kp, shutdown)
+		if err != nil {
+			log.Printf("[WARN] Skipping %s notifier: %v", nc.Type, err)
+			continue
+		}
+		channels = append(channels, c)
+	}
+	return &Alerter{
+		cfg:      cfg,
+		kp:       kp,
+		channels: channels,
+		state:    loadAlertState(cfg.StatePath),
+		verbose:  verbose,
+	}
+}
+
+// loadAlertState reads the alert state file, returning an empty state if missing
+func loadAlertState(stateDir string) *AlertState {
+	s := &AlertState{
+		Sent: make(map[string]string),
+		path: filepath.Join(stateDir, "alerts.yaml"),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s
+	} else if err != nil {
+		log.Printf("[WARN] Failed to read alert state %s: %v", s.path, err)
+		return s
+	}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		log.Printf("[WARN] Failed to parse alert state %s: %v", s.path, err)
+	}
+	if s.Sent == nil {
+		s.Sent = make(map[string]string)
+	}
+	return s
+}
+
+// Save writes the alert state back to the YAML file
+func (s *AlertState) Save() error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// Send notifies the operator unless an alert with the same key was sent
+// within the repeat interval. It is a no-op on a nil Alerter.
+func (a *Alerter) Send(key string, n Notification) {
+	if a == nil {
+		return
+	}
+	a.sendOnce(key, n, nil)
+}
+
+// sendOnce sends n over the channels match selects, all if match is nil,
+// unless an alert with the same key was sent within the repeat interval
+func (a *Alerter) sendOnce(key string, n Notification, match func(notifyChannel) bool) {
+	if last, err := time.Parse(time.RFC3339, a.state.Sent[key]); err == nil && time.Since(last) < alertRepeatInterval {
+		if a.verbose {
+			log.Printf("[DEBUG] Alert %s already sent at %s", key, a.state.Sent[key])
+		}
+		return
+	}
+	if !a.send(n, match) {
+		return
+	}
+
+	a.state.Sent[key] = time.Now().UTC().Format(time.RFC3339)
+	if err := a.state.Save(); err != nil {
+		log.Printf("[WARN] Failed to save alert state: %v", err)
+	}
+}
+
+// send delivers n over the channels match selects, all if match is nil, and
+// reports whether any channel delivered it. A channel whose template fails
+// falls back to the plain text.
+func (a *Alerter) send(n Notification, match func(notifyChannel) bool) bool {
+	var channels []notifyChannel
+	for _, c := range a.channels {
+		if match == nil || match(c) {
+			channels = append(channels, c)
+		}
+	}
+	if len(channels) == 0 {
+		return false
+	}
+	n.Npub = a.kp.Npub
+	n.Time = time.Now()
+	n.RunID, n.ActionID = runID, currentActionID()
+	if n.Action != nil {
+		n.ActionID = actionCorrelationID(n.Action.Key)
+	}
+	log.Printf("[INFO] Alerting the operator: %s", n.Text)
+
+	delivered := false
+	for _, c := range channels {
+		body, err := c.render(n)
+		if err != nil {
+			log.Printf("[WARN] Failed to render %s notification: %v", c.notifier.Name(), err)
+			body = n.Text
+		}
+		if err := c.notifier.Notify(n, body); err != nil {
+			log.Printf("[WARN] Failed to send %s notification: %v", c.notifier.Name(), err)
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+// Clear forgets a sent alert so the condition is reported again if it recurs
+func (a *Alerter) Clear(key string) {
+	if a == nil {
+		return
+	}
+	if _, ok := a.state.Sent[key]; !ok {
+		return
+	}
+	delete(a.state.Sent, key)
+	if err := a.state.Save(); err != nil {
+		log.Printf("[WARN] Failed to save alert state: %v", err)
+	}
+}
+
+// RelaysPolled tracks relay connectivity. Once no relay could be reached for
+// longer than relay_loss_alert_after the operator is alerted, and told again
+// when connectivity returns. Channels publishing to relays cannot deliver the
+// alert during the outage, so it is held for them until relays recover.
+func (a *Alerter) RelaysPolled(connected int) {
+	if a == nil {
+		return
+	}
+	now := time.Now().UTC()
+
+	if connected > 0 {
+		if a.state.RelaysLostSince == "" {
+			return
+		}
+		since, _ := time.Parse(time.RFC3339, a.state.RelaysLostSince)
+		a.state.RelaysLostSince = ""
+		if a.state.RelaysLostHeld {
+			a.state.RelaysLostHeld = false
+			a.send(Notification{
+				Event: notifyRelaysLost,
+				Text:  fmt.Sprintf("no relay was reachable from %s to %s", since.Format(time.RFC3339), now.Format(time.RFC3339)),
+			}, usesRelays)
+		}
+		if _, alerted := a.state.Sent["relays"]; alerted {
+			delete(a.state.Sent, "relays")
+			a.send(Notification{
+				Event: notifyRelaysRestored,
+				Text:  fmt.Sprintf("relay connectivity restored after %v", now.Sub(since).Round(time.Second)),
+			}, nil)
+		}
+		if err := a.state.Save(); err != nil {
+			log.Printf("[WARN] Failed to save alert state: %v", err)
+		}
+		return
+	}
+
+	if a.state.RelaysLostSince == "" {
+		a.state.RelaysLostSince = now.Format(time.RFC3339)
+		if err := a.state.Save(); err != nil {
+			log.Printf("[WARN] Failed to save alert state: %v", err)
+		}
+		return
+	}
+	since, err := time.Parse(time.RFC3339, a.state.RelaysLostSince)
+	if err != nil || now.Sub(since) < a.cfg.RelayLossAlertAfter {
+		return
+	}
+	a.sendOnce("relays", Notification{
+		Event: notifyRelaysLost,
+		Text:  fmt.Sprintf("no relay reachable since %s", a.state.RelaysLostSince),
+	}, func(c notifyChannel) bool { return !usesRelays(c) })
+	if !a.state.RelaysLostHeld && slices.ContainsFunc(a.channels, usesRelays) {
+		a.state.RelaysLostHeld = true
+		if _, alerted := a.state.Sent["relays"]; !alerted {
+			a.state.Sent["relays"] = now.Format(time.RFC3339)
+		}
+		if err := a.state.Save(); err != nil {
+			log.Printf("[WARN] Failed to save alert state: %v", err)
+		}
+	}
+}
+
+// usesRelays reports whether a channel delivers by publishing to relays
+func usesRelays(c notifyChannel) bool {
+	_, ok := c.notifier.(*NostrNotifier)
+	return ok
+}
+
+// QuorumStuck alerts the operator about candidates that have stayed below
+// quorum for longer than quorum_stuck_alert_after, which usually means
+// signers are unreachable or their signals do not reach the relays, and
+// forgets the alerts of candidates that since reached quorum or went away
+func (a *Alerter) QuorumStuck(tally *signal.Evaluator, history *History) {
+	if a == nil {
+		return
+	}
+	below := make(map[string]bool)
+	a.stuck = 0
+	for _, key := range tally.BelowQuorum(history) {
+		below[key] = true
+		lc := history.Lifecycle[key]
+		if lc == nil || lc.State != stateDetected {
+			continue
+		}
+		since, err := time.Parse(time.RFC3339, lc.UpdatedAt)
+		if err != nil || time.Since(since) < a.cfg.QuorumStuckAlertAfter {
+			continue
+		}
+		a.stuck++
+		a.Send("quorum:"+key, Notification{
+			Event:  notifyQuorumStuck,
+			Text:   fmt.Sprintf("%s has been stuck at %d/%d vote(s) since %s", key, len(tally.Votes[key]), tally.Quorum(), lc.UpdatedAt),
+			Action: tally.Actions[key],
+		})
+	}
+	for key := range a.state.Sent {
+		if action, ok := strings.CutPrefix(key, "quorum:"); ok && !below[action] {
+			a.Clear(key)
+		}
+	}
+}
+
+// ActionsBlocked alerts the operator about actions that failed too often to
+// be retried, and forgets the alerts of actions that were reset or done
+func (a *Alerter) ActionsBlocked(failures *Failures) {
+	if a == nil {
+		return
+	}
+	blocked := failures.Blocked()
+	a.blocked = len(blocked)
+	for _, key := range blocked {
+		entry := failures.Entries[key]
+		a.Send("blocked:"+key, Notification{
+			Event: notifyActionBlocked,
+			Text:  fmt.Sprintf("%s failed %d time(s) and is blocked; run 'qube-manager retry-action %s' once fixed", key, entry.Failures, key),
+			Error: entry.Error,
+		})
+	}
+	for key := range a.state.Sent {
+		if action, ok := strings.CutPrefix(key, "blocked:"); ok && !slices.Contains(blocked, action) {
+			a.Clear(key)
+		}
+	}
+}
+
+// Firing returns whether each alert condition currently holds, or nil on a
+// nil Alerter
+func (a *Alerter) Firing(history *History) map[string]bool {
+	if a == nil {
+		return nil
+	}
+	firing := map[string]bool{notifyQuorumStuck: a.stuck > 0, notifySignerSilent: a.silent > 0, notifyActionBlocked: a.blocked > 0}
+	if since, err := time.Parse(time.RFC3339, a.state.RelaysLostSince); err == nil {
+		firing[notifyRelaysLost] = time.Since(since) >= a.cfg.RelayLossAlertAfter
+	}
+	for key := range a.state.Sent {
+		if strings.HasPrefix(key, "resync:") {
+			firing[notifyResyncStalled] = true
+		}
+	}
+	for _, lc := range history.Lifecycle {
+		if lc.State == stateFailed {
+			firing[notifyExecutionFailed] = true
+		}
+	}
+	return firing
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// AlertRules is a Prometheus rule file, loadable through rule_files so
+// Alertmanager routes the manager's alert conditions like any other alert
+type AlertRules struct {
+	Groups []AlertRuleGroup `yaml:"groups" json:"groups"`
+}
+
+// AlertRuleGroup is a named group of alerting rules
+type AlertRuleGroup struct {
+	Name  string      `yaml:"name" json:"name"`
+	Rules []AlertRule `yaml:"rules" json:"rules"`
+}
+
+// AlertRule is a single Prometheus alerting rule
+type AlertRule struct {
+	Alert       string            `yaml:"alert" json:"alert"`
+	Expr        string            `yaml:"expr" json:"expr"`
+	For         string            `yaml:"for,omitempty" json:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels" json:"labels"`
+	Annotations map[string]string `yaml:"annotations" json:"annotations"`
+}
+
+// alertRules returns the rules for the alert conditions exported in the
+// metrics textfile. The thresholds are applied by the manager, which
+// exports whether each condition holds, so the rules stay correct when the
+// thresholds change; only staleness is measured by Prometheus itself.
+func alertRules(cfg Config, staleAfter time.Duration) AlertRules {
+	firing := func(alert string) string {
+		return fmt.Sprintf("qube_manager_alert_firing{alert=%q} == 1", alert)
+	}
+	rule := func(name, expr, severity, summary string) AlertRule {
+		return AlertRule{
+			Alert:       name,
+			Expr:        expr,
+			Labels:      map[string]string{"severity": severity},
+			Annotations: map[string]string{"summary": summary},
+		}
+	}
+
+	return AlertRules{Groups: []AlertRuleGroup{{
+		Name: "qube-manager",
+		Rules: []AlertRule{
+			rule("QubeManagerRelaysLost", firing(notifyRelaysLost), "critical",
+				fmt.Sprintf("No relay has been reachable for more than %v", cfg.RelayLossAlertAfter)),
+			rule("QubeManagerQuorumStuck", firing(notifyQuorumStuck), "warning",
+				fmt.Sprintf("A candidate action has stayed below quorum for more than %v", cfg.QuorumStuckAlertAfter)),
+			rule("QubeManagerSignerSilent", firing(notifySignerSilent), "warning",
+				fmt.Sprintf("A followed signer has published nothing for more than %v while others are active", cfg.SignerSilenceAlertAfter)),
+			rule("QubeManagerExecutionFailed", firing(notifyExecutionFailed), "critical",
+				"Executing or verifying an action failed; see the action_state metric for which"),
+			rule("QubeManagerActionBlocked", firing(notifyActionBlocked), "critical",
+				fmt.Sprintf("An action failed %d times and is not retried until 'qube-manager retry-action'", cfg.FailureBackoff.MaxFailures)),
+			rule("QubeManagerResyncStalled", firing(notifyResyncStalled), "warning",
+				fmt.Sprintf("The node's resync after a reboot has not advanced for more than %v", cfg.Resync.StallAfter)),
+			rule("QubeManagerNotRunning", fmt.Sprintf("time() - qube_manager_last_run_timestamp_seconds > %d", int64(staleAfter.Seconds())), "critical",
+				fmt.Sprintf("The manager has not completed a run for more than %v", staleAfter)),
+		},
+	}}}
+}
+
+func newAlertRulesCommand(g *globals) *cobra.Command {
+	var staleAfter time.Duration
+	cmd := &cobra.Command{
+		Use:   "alert-rules",
+		Short: "Print Prometheus alerting rules for the configured thresholds",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			alertRulesCLI(g.configDir, g.stateDir, g.output, staleAfter)
+		},
+	}
+	cmd.Flags().DurationVar(&staleAfter, "stale-after", 0, "How long without a run before QubeManagerNotRunning fires (three poll intervals if unset)")
+	return cmd
+}
+
+// alertRulesCLI prints Prometheus alerting rules for the configured
+// thresholds
+func alertRulesCLI(configDir, stateDir, output string, staleAfter time.Duration) {
+	cfg := loadConfig(configDir, stateDir)
+	if cfg.MetricsTextfile == "" {
+		log.Println("[WARN] metrics_textfile is not set; the rules need the metrics it exports")
+	}
+	if staleAfter <= 0 {
+		staleAfter = 3 * cfg.PollInterval
+	}
+
+	rules := alertRules(cfg, staleAfter)
+	if output == outputJSON {
+		printJSON(rules)
+		return
+	}
+	data, err := yaml.Marshal(rules)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	fmt.Print(string(data))
+}
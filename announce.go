@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/spf13/cobra"
+)
+
+// version is the qube-manager release, set at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version string
+
+// managerVersion returns the qube-manager release, falling back to the
+// module version recorded by go install
+func managerVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// AnnounceConfig configures the kind-0 profile published for the manager's
+// npub, so coordinators can tell which node a done event or heartbeat came
+// from
+type AnnounceConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"` // Publish the profile, and again whenever it changes
+	Alias   string `yaml:"alias,omitempty"`   // Profile name (default the hostname)
+	Picture string `yaml:"picture,omitempty"` // Profile picture URL (none if unset)
+}
+
+// Validate checks the profile settings
+func (a AnnounceConfig) Validate() error {
+	if a.Picture == "" {
+		return nil
+	}
+	u, err := url.Parse(a.Picture)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("picture must be an http or https URL (got %q)", a.Picture)
+	}
+	return nil
+}
+
+// Profile is the NIP-01 metadata published in the kind-0 event
+type Profile struct {
+	Name    string `json:"name"`              // Alias or hostname of the node
+	About   string `json:"about"`             // qube-manager release
+	Picture string `json:"picture,omitempty"` // Picture URL
+}
+
+// profile returns the metadata to announce with the current settings
+func (a AnnounceConfig) profile() Profile {
+	name := a.Alias
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	return Profile{Name: name, About: "qube-manager " + managerVersion(), Picture: a.Picture}
+}
+
+// Announcement records the profile last published, so it is only published
+// again once it changes
+type Announcement struct {
+	Profile     Profile `json:"profile"`      // Metadata published
+	EventID     string  `json:"event_id"`     // ID of the kind-0 event
+	PublishedAt string  `json:"published_at"` // ISO8601 timestamp of the publish
+	Relays      int     `json:"relays"`       // Relays that accepted the event
+}
+
+// announcementPath returns where the last announcement is recorded
+func announcementPath(stateDir string) string {
+	return filepath.Join(stateDir, "announce.json")
+}
+
+// loadAnnouncement reads the last announcement, nil if none was recorded or
+// the record is unreadable
+func loadAnnouncement(stateDir string) *Announcement {
+	data, err := os.ReadFile(announcementPath(stateDir))
+	if err != nil {
+		return nil
+	}
+	var a Announcement
+	if err := json.Unmarshal(data, &a); err != nil {
+		log.Printf("[WARN] Ignoring unreadable %s: %v", announcementPath(stateDir), err)
+		return nil
+	}
+	return &a
+}
+
+// save records the announcement in the state directory
+func (a *Announcement) save(stateDir string) error {
+	data, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(announcementPath(stateDir), data, 0644)
+}
+
+// newProfileEvent builds the unsigned kind-0 event carrying p
+func newProfileEvent(p Profile) (nostr.Event, error) {
+	content, err := json.Marshal(p)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	return nostr.Event{
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      nostr.KindProfileMetadata,
+		Tags:      correlationTags(),
+		Content:   string(content),
+	}, nil
+}
+
+// announce publishes p to the write relays and records it once at least
+// publish_min_relays accepted it
+func announce(cfg Config, kp Keypair, p Profile, shutdown *ShutdownHandler) (*Announcement, error) {
+	ev, err := newProfileEvent(p)
+	if err == nil {
+		err = signEvent(kp, &ev)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to build profile event: %w", err)
+	}
+
+	relays := cfg.writeRelays()
+	accepted := publishToRelays(relays, ev, cfg.ConnectTimeout, cfg.ReadTimeout, shutdown)()
+	if accepted < cfg.PublishMinRelays {
+		return nil, fmt.Errorf("profile accepted by %d/%d relays, %d required", accepted, len(relays), cfg.PublishMinRelays)
+	}
+	a := &Announcement{Profile: p, EventID: ev.ID, PublishedAt: time.Now().UTC().Format(time.RFC3339), Relays: accepted}
+	if err := a.save(cfg.StatePath); err != nil {
+		log.Printf("[WARN] Failed to record announcement: %v", err)
+	}
+	log.Printf("[INFO] Announced profile %q (%s) to %d/%d relays", p.Name, p.About, accepted, len(relays))
+	return a, nil
+}
+
+// refreshAnnouncement publishes the profile if announcing is enabled and it
+// changed since it was last published, e.g. after the alias was edited or
+// qube-manager upgraded. Failures are retried on the next run.
+func (m *Manager) refreshAnnouncement() {
+	cfg := m.config.Announce
+	if !cfg.Enabled || m.dryRun {
+		return
+	}
+	p := cfg.profile()
+	if last := loadAnnouncement(m.config.StatePath); last != nil && last.Profile == p {
+		return
+	}
+	if _, err := announce(m.config, m.keypair, p, m.shutdown); err != nil {
+		noisyLog.Printf(noiseRelay, "announce", "[WARN] Failed to announce profile: %v", err)
+	}
+}
+
+func newAnnounceCommand(g *globals) *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "announce",
+		Short: "Publish the kind-0 profile of this manager's npub",
+		Long: `Publish the kind-0 profile of this manager's npub, named after announce.alias
+or the hostname, so coordinators can tell which node a done event came from.
+The profile is published even if announce.enabled is off; with it on, the
+manager republishes it by itself whenever it changes.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !announceCLI(g.configDir, g.stateDir, g.keypair, g.output, dryRun) {
+				os.Exit(1)
+			}
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the profile without publishing it")
+	return cmd
+}
+
+// announceCLI publishes the profile now and prints it. It returns false if
+// too few relays accepted it.
+func announceCLI(configDir, stateDir string, kp Keypair, output string, dryRun bool) bool {
+	cfg := loadConfig(configDir, stateDir)
+	p := cfg.Announce.profile()
+
+	a := &Announcement{Profile: p}
+	if !dryRun {
+		var err error
+		if a, err = announce(cfg, kp, p, newShutdownHandler(cfg.ShutdownGracePeriod)); err != nil {
+			log.Printf("[ERROR] Failed to announce profile: %v", err)
+			return false
+		}
+	}
+
+	if output == outputJSON {
+		printJSON(a)
+		return true
+	}
+	fmt.Printf("Profile of %s\n", kp.Npub)
+	fmt.Printf("  Name:    %s\n", p.Name)
+	fmt.Printf("  About:   %s\n", p.About)
+	if p.Picture != "" {
+		fmt.Printf("  Picture: %s\n", p.Picture)
+	}
+	if dryRun {
+		fmt.Println("Not published (--dry-run)")
+	} else {
+		fmt.Printf("Published as event %s, accepted by %d relays\n", a.EventID, a.Relays)
+	}
+	return true
+}
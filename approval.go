@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Approval states of an action queued for operator acknowledgment
+const (
+	approvalAwaiting = "awaiting"
+	approvalApproved = "approved"
+	approvalRejected = "rejected"
+)
+
+// ApprovalEntry records the operator decision on a quorum-reached action
+type ApprovalEntry struct {
+	Status    string `yaml:"status"`               // awaiting, approved, or rejected
+	Type      string `yaml:"type"`                 // "upgrade", "reboot", or "rollback"
+	Version   string `yaml:"version"`              // Original version string
+	Genesis   string `yaml:"genesis,omitempty"`    // Genesis URL for reboots
+	QueuedAt  string `yaml:"queued_at"`            // ISO8601 time the action reached quorum
+	DecidedAt string `yaml:"decided_at,omitempty"` // ISO8601 time of approval or rejection
+}
+
+// Approvals persists operator decisions for actions that require approval
+type Approvals struct {
+	Entries map[string]*ApprovalEntry `yaml:"entries"` // key: action key
+	path    string                    // approvals file path (not in YAML)
+}
+
+// loadApprovals reads the approvals file, returning an empty set if missing
+func loadApprovals(stateDir string) (*Approvals, error) {
+	a := &Approvals{
+		Entries: make(map[string]*ApprovalEntry),
+		path:    filepath.Join(stateDir, "approvals.yaml"),
+	}
+
+	data, err := os.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return a, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read approvals file %s: %w", a.path, err)
+	}
+	if err := yaml.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("failed to parse approvals file %s: %w", a.path, err)
+	}
+	if a.Entries == nil {
+		a.Entries = make(map[string]*ApprovalEntry)
+	}
+	return a, nil
+}
+
+// approvalsMu serializes updates of the approvals file within the process
+var approvalsMu sync.Mutex
+
+// updateApprovals applies fn to the approvals read from the file and saves
+// them, holding a lock on the file throughout so a decision from the
+// dashboard, the admin API, or the CLI is not lost to a concurrent write
+func updateApprovals(stateDir string, fn func(*Approvals) error) error {
+	approvalsMu.Lock()
+	defer approvalsMu.Unlock()
+
+	f, err := os.OpenFile(filepath.Join(stateDir, "approvals.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := lockFile(f); err == nil {
+		defer unlockFile(f)
+	} else if !errors.Is(err, errors.ErrUnsupported) {
+		return err
+	}
+
+	a, err := loadApprovals(stateDir)
+	if err != nil {
+		return err
+	}
+	if err := fn(a); err != nil {
+		return err
+	}
+	return a.Save()
+}
+
+// Save writes the approvals back to the YAML file, replacing it atomically
+// so the daemon and the dashboard never read a partly written file
+func (a *Approvals) Save() error {
+	data, err := yaml.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(a.path, data)
+}
+
+// Queue returns the approval entry for an action, queueing it as awaiting
+// approval if the operator has not seen it yet
+func (a *Approvals) Queue(action *signal.Action) *ApprovalEntry {
+	if entry, ok := a.Entries[action.Key]; ok {
+		return entry
+	}
+
+	entry := &ApprovalEntry{
+		Status:   approvalAwaiting,
+		Type:     action.Type,
+		Version:  action.Version.Original(),
+		Genesis:  action.Genesis,
+		QueuedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	a.Entries[action.Key] = entry
+	log.Printf("[WARN] Action %s is awaiting operator approval; run 'qube-manager approve %s' or 'qube-manager reject %s'",
+		action.Key, action.Key, action.Key)
+	return entry
+}
+
+// Rejected returns the keys of actions the operator rejected
+func (a *Approvals) Rejected() []string {
+	var keys []string
+	for key, entry := range a.Entries {
+		if entry.Status == approvalRejected {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Decide records the operator's decision (approvalApproved or
+// approvalRejected) on a queued action. It returns false if the action
+// already had that status.
+func (a *Approvals) Decide(key, status string) (bool, error) {
+	entry, ok := a.Entries[key]
+	if !ok {
+		return false, fmt.Errorf("action %s is not queued for approval", key)
+	}
+	if entry.Status == status {
+		return false, nil
+	}
+
+	entry.Status = status
+	entry.DecidedAt = time.Now().UTC().Format(time.RFC3339)
+	return true, nil
+}
+
+// decideApproval records a decision made through the dashboard or admin
+// API, logging where it came from
+func decideApproval(stateDir, key, status, origin string) (bool, error) {
+	var changed bool
+	err := updateApprovals(stateDir, func(a *Approvals) (err error) {
+		changed, err = a.Decide(key, status)
+		return err
+	})
+	if err == nil && changed {
+		log.Printf("[INFO] Action %s %s from %s", key, status, origin)
+	}
+	return changed, err
+}
+
+// Prune drops entries for actions that have since been recorded in history.
+// Rejections are kept so a rejected action is never executed later.
+func (a *Approvals) Prune(history *History) {
+	for key, entry := range a.Entries {
+		if entry.Status != approvalRejected && history.Has(key) {
+			delete(a.Entries, key)
+		}
+	}
+}
+
+func newApprovalCommand(g *globals, decision string) *cobra.Command {
+	short := "Approve a queued action, or list queued actions"
+	if decision == "reject" {
+		short = "Reject a queued action, or list queued actions"
+	}
+	return &cobra.Command{
+		Use:   decision + " [action-key]",
+		Short: short,
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			approvalCLI(g.stateDir, decision, args)
+		},
+	}
+}
+
+// approvalCLI records the operator's decision ("approve" or "reject") on a
+// queued action, or lists queued actions when no key is given
+func approvalCLI(stateDir string, decision string, args []string) {
+	approvals, err := loadApprovals(stateDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	if len(args) == 0 {
+		keys := make([]string, 0, len(approvals.Entries))
+		for key := range approvals.Entries {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		if len(keys) == 0 {
+			fmt.Println("No actions are queued for approval.")
+			return
+		}
+		for _, key := range keys {
+			entry := approvals.Entries[key]
+			fmt.Printf("%-40s %-9s queued %s\n", key, entry.Status, entry.QueuedAt)
+		}
+		return
+	}
+
+	key := args[0]
+	status := approvalApproved
+	if decision == "reject" {
+		status = approvalRejected
+	}
+	var changed bool
+	err = updateApprovals(stateDir, func(a *Approvals) (err error) {
+		changed, err = a.Decide(key, status)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if !changed {
+		log.Printf("[INFO] Action %s is already %s", key, status)
+		return
+	}
+	log.Printf("[INFO] Action %s %s", key, status)
+}
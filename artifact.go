@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// defaultArtifactDir caches downloads when neither artifacts.dir nor a state
+// dir is set; it survives reboots so interrupted downloads can resume
+const defaultArtifactDir = "/var/tmp/qube-manager-artifacts"
+
+// ArtifactConfig configures how genesis files and release binaries are
+// downloaded
+type ArtifactConfig struct {
+	Dir       string   `yaml:"dir,omitempty"`        // Download cache (default artifacts in the state dir)
+	Mirrors   []string `yaml:"mirrors,omitempty"`    // Local mirror base URLs tried first; the artifact's file name is appended
+	RateLimit int64    `yaml:"rate_limit,omitempty"` // Download bandwidth limit in bytes per second (0 for unlimited)
+}
+
+// Validate checks the mirror URLs and rate limit
+func (c ArtifactConfig) Validate() error {
+	for _, m := range c.Mirrors {
+		u, err := url.ParseRequestURI(m)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid artifact mirror %q (expected an http or https URL)", m)
+		}
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("artifact rate_limit must not be negative (got %d)", c.RateLimit)
+	}
+	return nil
+}
+
+// dir returns the download cache directory
+func (c ArtifactConfig) dir() string {
+	if c.Dir != "" {
+		return c.Dir
+	}
+	return defaultArtifactDir
+}
+
+// candidates returns the URLs to try for a file: local mirrors first, then
+// the announced or configured URLs
+func (c ArtifactConfig) candidates(urls []string) []string {
+	var out []string
+	if len(urls) > 0 {
+		name := artifactName(urls[0])
+		for _, m := range c.Mirrors {
+			out = append(out, strings.TrimSuffix(m, "/")+"/"+name)
+		}
+	}
+	return append(out, urls...)
+}
+
+// path returns where a file downloaded from urls is cached. Verified files
+// are kept apart by hash.
+func (c ArtifactConfig) path(urls []string, sum string) string {
+	dir := "unverified"
+	if sum != "" {
+		dir = sum
+	}
+	return filepath.Join(c.dir(), dir, artifactName(urls[0]))
+}
+
+// artifactName returns the file name a URL downloads to
+func artifactName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := path.Base(u.Path); name != "/" && name != "." {
+			return name
+		}
+	}
+	return "artifact"
+}
+
+// fetchArtifact downloads a file from the first URL that serves it and
+// returns its path in the download cache. Partial downloads resume with HTTP
+// range requests, also across mirrors. With a sha256 the file is rejected
+// unless it matches, and a verified file already in the cache is reused;
+// without one the file is downloaded afresh.
+func fetchArtifact(ctx context.Context, cfg ArtifactConfig, urls []string, sum string) (string, error) {
+	if len(urls) == 0 {
+		return "", errors.New("no download URL")
+	}
+
+	dest := cfg.path(urls, sum)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", fmt.Errorf("failed to create artifact cache: %w", err)
+	}
+	part := dest + ".part"
+
+	if sum != "" {
+		if err := verifySHA256(dest, sum); err == nil {
+			log.Printf("[INFO] Using cached %s", dest)
+			return dest, nil
+		}
+	} else {
+		os.Remove(part)
+	}
+
+	var errs []error
+	for _, u := range cfg.candidates(urls) {
+		if err := downloadResumable(ctx, u, part, cfg.RateLimit); err != nil {
+			log.Printf("[WARN] Download from %s failed: %v", u, err)
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if sum != "" {
+			if err := verifySHA256(part, sum); err != nil {
+				log.Printf("[WARN] Download from %s rejected: %v", u, err)
+				errs = append(errs, err)
+				os.Remove(part)
+				continue
+			}
+			log.Printf("[INFO] Verified sha256 of %s", filepath.Base(dest))
+		}
+		if err := os.Rename(part, dest); err != nil {
+			return "", err
+		}
+		return dest, nil
+	}
+	return "", fmt.Errorf("download failed from every URL: %w", errors.Join(errs...))
+}
+
+// downloadResumable appends url to the partial file at part, asking the
+// server for the missing range only. Servers ignoring the range restart the
+// file from scratch.
+func downloadResumable(ctx context.Context, url, part string, rateLimit int64) error {
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		log.Printf("[INFO] Resuming download of %s at byte %d", url, offset)
+	case http.StatusOK:
+		if offset > 0 {
+			log.Printf("[INFO] %s does not support resuming - restarting download", url)
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		} else {
+			log.Printf("[INFO] Downloading %s", url)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file already holds the whole artifact
+		return nil
+	default:
+		return fmt.Errorf("download of %s failed: %s", url, resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	if rateLimit > 0 {
+		body = &rateLimitedReader{r: resp.Body, limit: rateLimit, start: time.Now()}
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// rateLimitedReader delays reads to stay below limit bytes per second on
+// average
+type rateLimitedReader struct {
+	r     io.Reader
+	limit int64
+	start time.Time
+	read  int64
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.limit {
+		p = p[:r.limit]
+	}
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	due := time.Duration(float64(r.read) / float64(r.limit) * float64(time.Second))
+	if wait := due - time.Since(r.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
+
+// verifySHA256 checks the SHA-256 of the file at path
+func verifySHA256(path, sum string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("sha256 mismatch: got %s, expected %s", actual, sum)
+	}
+	return nil
+}
+
+// reverifyArtifact checks a file from the artifact cache once more right
+// before it is used, so a file changed since it was downloaded or last
+// verified is not installed. Files without a sha256 are not checked.
+func reverifyArtifact(path, sum string) error {
+	if sum == "" {
+		return nil
+	}
+	if err := verifySHA256(path, sum); err != nil {
+		return fmt.Errorf("%s changed since it was verified: %w", path, err)
+	}
+	log.Printf("[INFO] Re-verified sha256 of %s before use", filepath.Base(path))
+	return nil
+}
+
+// copyVerified copies a file from the artifact cache to dst and checks the
+// copy, which is what the node uses, against sum
+func copyVerified(src, dst string, perm os.FileMode, sum string) error {
+	if err := copyFile(src, dst, perm); err != nil {
+		return err
+	}
+	if err := reverifyArtifact(dst, sum); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// copyFile copies src to dst with the given permissions
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// artifactSources returns the URLs and hash to download an action's file
+// from: the announced artifact first, then the fallback URLs
+func artifactSources(artifact *signal.Artifact, fallback ...string) ([]string, string) {
+	if artifact == nil {
+		return fallback, ""
+	}
+	return slices.Concat(artifact.URLs, fallback), artifact.SHA256
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/spf13/cobra"
+)
+
+// Kinds of trust decisions recorded in the audit log
+const (
+	auditVote       = "vote"       // a signal event was counted, rejected, or retracted
+	auditQuorum     = "quorum"     // an action reached quorum and was selected
+	auditRevocation = "revocation" // a signer key was revoked
+	auditExecution  = "execution"  // an action was executed, or its execution failed
+)
+
+// Reasons votes are rejected before they reach the evaluator
+var (
+	errBadSignature    = errors.New("bad signature")
+	errNotFollowed     = errors.New("author is not an active follow")
+	errStaleVote       = errors.New("stale: action already performed")
+	errInsufficientPoW = errors.New("insufficient proof of work")
+	errExpired         = errors.New("expired")
+)
+
+// AuditRecord is a single line of the audit log. Hash covers every other
+// field but MAC, including the hash of the preceding record in Prev, so
+// editing, removing, or reordering lines breaks the chain. MAC authenticates
+// Hash with a key only the node holds, so a rewritten chain cannot be
+// passed off as the node's.
+type AuditRecord struct {
+	Seq      int    `json:"seq"`                // Position in the log, starting at 1
+	Time     string `json:"time"`               // ISO8601 timestamp of the decision
+	Kind     string `json:"kind"`               // vote, quorum, revocation, or execution
+	Decision string `json:"decision"`           // e.g. accepted, rejected, retracted, selected, executed, failed
+	Reason   string `json:"reason,omitempty"`   // Why a vote was rejected or an execution failed
+	Action   string `json:"action,omitempty"`   // Action key the decision concerns
+	EventID  string `json:"event_id,omitempty"` // Signal or deletion event a vote came from or was retracted by
+	PubKey   string `json:"pubkey,omitempty"`   // Hex pubkey of the signer or revoked key
+	Relay    string `json:"relay,omitempty"`    // Relay a vote was received from
+	Votes    int    `json:"votes,omitempty"`    // Votes counted for the action
+	Quorum   int    `json:"quorum,omitempty"`   // Votes the action needed
+	Prev     string `json:"prev"`               // Hash of the preceding record (empty for the first)
+	Hash     string `json:"hash"`               // Hex SHA-256 of this record with Hash and MAC empty
+	MAC      string `json:"mac"`                // Hex HMAC-SHA256 of Hash keyed by auditKey
+}
+
+// digest returns the hash of the record with its Hash and MAC fields cleared
+func (r AuditRecord) digest() string {
+	r.Hash, r.MAC = "", ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditKey derives the key audit records are authenticated with from the
+// node's private key, or returns nil if the keypair has none
+func auditKey(kp Keypair) []byte {
+	sk, err := kp.secretKey()
+	if err != nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(sk))
+	mac.Write([]byte("qube-manager audit log"))
+	return mac.Sum(nil)
+}
+
+// auditMAC returns the hex HMAC of a record hash under key
+func auditMAC(key []byte, hash string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AuditLog is an append-only, hash-chained JSON Lines file of every trust
+// decision, kept apart from manager.log. The tail of the chain is kept in
+// memory and only read again when another process appended to the file. A
+// nil *AuditLog records nothing.
+type AuditLog struct {
+	path string
+	key  []byte          // Key records are authenticated with
+	seq  int             // Seq of the last record
+	last string          // Hash of the last record
+	size int64           // File size after the last record this log read or wrote
+	seen map[string]bool // Votes already recorded, so relays re-sending events don't repeat them
+}
+
+// auditLogPath returns the location of the audit log
+func auditLogPath(stateDir string) string {
+	return filepath.Join(stateDir, "audit.jsonl")
+}
+
+// openAuditLog loads the tail of the chain and the votes already recorded;
+// records are authenticated with a key derived from kp
+func openAuditLog(stateDir string, kp Keypair) *AuditLog {
+	l := &AuditLog{
+		path: auditLogPath(stateDir),
+		key:  auditKey(kp),
+		seen: make(map[string]bool),
+	}
+	l.load()
+	return l
+}
+
+// load reads the tail of the chain and the votes recorded in the file
+func (l *AuditLog) load() {
+	records, err := readAuditLog(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] Failed to read audit log %s: %v (run 'qube-manager audit verify')", l.path, err)
+	}
+	for _, r := range records {
+		if r.Kind == auditVote {
+			l.seen[voteRecordKey(r)] = true
+		}
+	}
+	if n := len(records); n > 0 {
+		l.seq, l.last = records[n-1].Seq, records[n-1].Hash
+	}
+	l.size = fileSize(l.path)
+}
+
+// fileSize returns the size of the file at path, 0 if it cannot be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// voteRecordKey identifies a vote decision for deduplication
+func voteRecordKey(r AuditRecord) string {
+	return r.EventID + "|" + r.Action + "|" + r.Decision + "|" + r.Reason
+}
+
+// Vote records that the signal event ev was counted for action, or rejected
+// for the given reason if it is non-nil
+func (l *AuditLog) Vote(ev *nostr.Event, relayURL, action string, rejected error) {
+	r := AuditRecord{Kind: auditVote, Decision: "accepted", Action: action, EventID: ev.ID, PubKey: ev.PubKey, Relay: relayURL}
+	if rejected != nil {
+		r.Decision, r.Reason = "rejected", rejected.Error()
+	}
+	if l == nil || l.seen[voteRecordKey(r)] {
+		return
+	}
+	l.append(r)
+	l.seen[voteRecordKey(r)] = true
+}
+
+// Retraction records that the deletion event ev removed the vote its author
+// cast for action
+func (l *AuditLog) Retraction(ev *nostr.Event, relayURL, action string) {
+	r := AuditRecord{Kind: auditVote, Decision: "retracted", Action: action, EventID: ev.ID, PubKey: ev.PubKey, Relay: relayURL}
+	if l == nil || l.seen[voteRecordKey(r)] {
+		return
+	}
+	l.append(r)
+	l.seen[voteRecordKey(r)] = true
+}
+
+// Replacement records that the addressable signal event ev replaced an
+// earlier version whose vote counted for action
+func (l *AuditLog) Replacement(ev *nostr.Event, relayURL, action string) {
+	r := AuditRecord{Kind: auditVote, Decision: "replaced", Action: action, EventID: ev.ID, PubKey: ev.PubKey, Relay: relayURL}
+	if l == nil || l.seen[voteRecordKey(r)] {
+		return
+	}
+	l.append(r)
+	l.seen[voteRecordKey(r)] = true
+}
+
+// Withdrawal records that the signal event ev withdrew the vote its signer
+// pubkey cast for action through an older signal
+func (l *AuditLog) Withdrawal(ev *nostr.Event, relayURL, pubkey, action string) {
+	r := AuditRecord{Kind: auditVote, Decision: "withdrawn", Action: action, EventID: ev.ID, PubKey: pubkey, Relay: relayURL}
+	if l == nil || l.seen[voteRecordKey(r)] {
+		return
+	}
+	l.append(r)
+	l.seen[voteRecordKey(r)] = true
+}
+
+// Quorum records that action was selected with votes out of quorum
+func (l *AuditLog) Quorum(action *signal.Action, votes, quorum int) {
+	l.append(AuditRecord{Kind: auditQuorum, Decision: "selected", Action: action.Key, Votes: votes, Quorum: quorum})
+}
+
+// Revocation records that the revoke-key action key revoked target
+func (l *AuditLog) Revocation(key, target string, votes, required int) {
+	l.append(AuditRecord{Kind: auditRevocation, Decision: "revoked", Action: key, PubKey: target, Votes: votes, Quorum: required})
+}
+
+// Execution records the outcome of executing action: "executed",
+// "skipped" for a no-op, or "failed" with the cause
+func (l *AuditLog) Execution(action, decision string, cause error) {
+	r := AuditRecord{Kind: auditExecution, Decision: decision, Action: action}
+	if cause != nil {
+		r.Reason = cause.Error()
+	}
+	l.append(r)
+}
+
+// append chains r to the last record and writes it to the file
+func (l *AuditLog) append(r AuditRecord) {
+	if l == nil {
+		return
+	}
+	// Another process, e.g. 'resume-action', may have appended since
+	if fileSize(l.path) != l.size {
+		l.load()
+	}
+	r.Seq = l.seq + 1
+	r.Time = time.Now().UTC().Format(time.RFC3339)
+	r.Prev = l.last
+	r.Hash = r.digest()
+	r.MAC = auditMAC(l.key, r.Hash)
+
+	line, err := json.Marshal(r)
+	if err == nil {
+		err = appendLine(l.path, line)
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to write audit record for %s %s: %v", r.Kind, r.Action, err)
+		return
+	}
+	l.seq, l.last = r.Seq, r.Hash
+	l.size += int64(len(line)) + 1
+}
+
+// appendLine appends line and a newline to the file at path
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readAuditLog parses every line of an audit log, returning the records
+// read before the first malformed line along with an error for it
+func readAuditLog(path string) ([]AuditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return records, fmt.Errorf("%s line %d: invalid audit record", path, lineNo)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// verifyAuditLog walks the hash chain and returns the number of intact
+// records, with an error at the first record that was altered, removed,
+// reordered, not authenticated with key, or cannot be parsed
+func verifyAuditLog(path string, key []byte) (int, error) {
+	records, readErr := readAuditLog(path)
+	if os.IsNotExist(readErr) {
+		return 0, nil
+	}
+	prev := ""
+	for i, r := range records {
+		switch {
+		case r.Seq != i+1:
+			return i, fmt.Errorf("record %d has sequence number %d; records were removed or reordered", i+1, r.Seq)
+		case r.Prev != prev:
+			return i, fmt.Errorf("record %d does not chain to the preceding record", r.Seq)
+		case r.Hash != r.digest():
+			return i, fmt.Errorf("record %d was modified: hash mismatch", r.Seq)
+		case !hmac.Equal([]byte(r.MAC), []byte(auditMAC(key, r.Hash))):
+			return i, fmt.Errorf("record %d was not written with this node's key: MAC mismatch", r.Seq)
+		}
+		prev = r.Hash
+	}
+	return len(records), readErr
+}
+
+func newAuditCommand(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the audit log",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "verify",
+		Short: "Check the audit log hash chain is intact and written by this node",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			auditVerifyCLI(g.stateDir, g.keypair, g.output)
+		},
+	})
+	return cmd
+}
+
+// auditVerifyCLI checks the audit log hash chain against the key of kp and
+// prints the result
+func auditVerifyCLI(stateDir string, kp Keypair, output string) {
+	path := auditLogPath(stateDir)
+	count, err := verifyAuditLog(path, auditKey(kp))
+	if output == outputJSON {
+		result := map[string]any{"path": path, "records": count, "intact": err == nil}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		printJSON(result)
+	} else if err == nil {
+		fmt.Printf("Audit log %s is intact: %d record(s)\n", path, count)
+	} else {
+		fmt.Printf("Audit log %s is NOT intact after %d record(s): %v\n", path, count, err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults for spacing out the attempts of a failing action
+const (
+	defaultFailureBackoffInitial     = 5 * time.Minute
+	defaultFailureBackoffMax         = 6 * time.Hour
+	defaultFailureBackoffMaxFailures = 5
+)
+
+// FailureBackoffConfig spaces out the attempts of an action whose execution,
+// verification, or done event keeps failing, and stops retrying it after
+// max_failures attempts until the operator runs 'qube-manager retry-action'
+type FailureBackoffConfig struct {
+	Initial     time.Duration `yaml:"initial,omitempty"`      // Wait after the first failure, doubled after each further one (default 5m)
+	Max         time.Duration `yaml:"max,omitempty"`          // Longest wait between attempts (default 6h)
+	MaxFailures int           `yaml:"max_failures,omitempty"` // Failed attempts before the action is blocked (default 5)
+}
+
+// applyDefaults fills in unset backoff settings
+func (c *FailureBackoffConfig) applyDefaults() {
+	if c.Initial <= 0 {
+		c.Initial = defaultFailureBackoffInitial
+	}
+	if c.Max <= 0 {
+		c.Max = defaultFailureBackoffMax
+	}
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = defaultFailureBackoffMaxFailures
+	}
+}
+
+// delay returns the wait after the given number of consecutive failures
+func (c FailureBackoffConfig) delay(failures int) time.Duration {
+	d := c.Initial
+	for i := 1; i < failures && d < c.Max; i++ {
+		d *= 2
+	}
+	return min(d, c.Max)
+}
+
+// FailureEntry records the consecutive failed attempts of an action
+type FailureEntry struct {
+	Failures    int    `yaml:"failures"`             // Failed attempts since the last success or reset
+	LastFailure string `yaml:"last_failure"`         // ISO8601 time of the latest failure
+	Error       string `yaml:"error,omitempty"`      // Reason of the latest failure
+	RetryAt     string `yaml:"retry_at,omitempty"`   // ISO8601 time before which the action is not attempted again
+	BlockedAt   string `yaml:"blocked_at,omitempty"` // ISO8601 time the circuit breaker opened, if it did
+}
+
+// Blocked reports whether the circuit breaker of the action is open
+func (e *FailureEntry) Blocked() bool {
+	return e.BlockedAt != ""
+}
+
+// Failures persists the failed attempts of actions not yet done. It is kept
+// apart from history and read every run, so 'qube-manager retry-action'
+// takes effect on a running daemon.
+type Failures struct {
+	Entries map[string]*FailureEntry `yaml:"entries"` // key: action key
+	path    string                   // failures file path (not in YAML)
+}
+
+// loadFailures reads the failures file, returning an empty set if missing
+func loadFailures(stateDir string) (*Failures, error) {
+	f := &Failures{
+		Entries: make(map[string]*FailureEntry),
+		path:    filepath.Join(stateDir, "failures.yaml"),
+	}
+
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read failures file %s: %w", f.path, err)
+	}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("failed to parse failures file %s: %w", f.path, err)
+	}
+	if f.Entries == nil {
+		f.Entries = make(map[string]*FailureEntry)
+	}
+	return f, nil
+}
+
+// Save writes the failures back to the YAML file
+func (f *Failures) Save() error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, data, 0644)
+}
+
+// Record counts a failed attempt of an action and schedules the next one
+// with exponential backoff. It returns true if this failure opened the
+// circuit breaker.
+func (f *Failures) Record(key string, cause error, cfg FailureBackoffConfig, now time.Time) bool {
+	entry, ok := f.Entries[key]
+	if !ok {
+		entry = &FailureEntry{}
+		f.Entries[key] = entry
+	}
+	entry.Failures++
+	entry.LastFailure = now.UTC().Format(time.RFC3339)
+	entry.Error = cause.Error()
+
+	if entry.Blocked() {
+		return false
+	}
+	if entry.Failures >= cfg.MaxFailures {
+		entry.RetryAt = ""
+		entry.BlockedAt = entry.LastFailure
+		log.Printf("[ERROR] Action %s failed %d time(s) - not retrying it until 'qube-manager retry-action %s'", key, entry.Failures, key)
+		return true
+	}
+	wait := cfg.delay(entry.Failures)
+	entry.RetryAt = now.Add(wait).UTC().Format(time.RFC3339)
+	log.Printf("[WARN] Action %s failed %d/%d time(s) - next attempt in %v", key, entry.Failures, cfg.MaxFailures, wait)
+	return false
+}
+
+// Held returns the failure entry of an action that may not be attempted at
+// now, because its breaker is open or its backoff has not elapsed, or nil
+func (f *Failures) Held(key string, now time.Time) *FailureEntry {
+	entry, ok := f.Entries[key]
+	if !ok {
+		return nil
+	}
+	if entry.Blocked() {
+		return entry
+	}
+	if retryAt, err := time.Parse(time.RFC3339, entry.RetryAt); err == nil && now.Before(retryAt) {
+		return entry
+	}
+	return nil
+}
+
+// Reset forgets the failed attempts of an action. It returns false if none
+// were recorded.
+func (f *Failures) Reset(key string) bool {
+	if _, ok := f.Entries[key]; !ok {
+		return false
+	}
+	delete(f.Entries, key)
+	return true
+}
+
+// Blocked returns the keys of actions whose circuit breaker is open
+func (f *Failures) Blocked() []string {
+	var keys []string
+	for key, entry := range f.Entries {
+		if entry.Blocked() {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Prune drops entries for actions that have since been recorded in history
+func (f *Failures) Prune(history *History) {
+	for key := range f.Entries {
+		if history.Has(key) {
+			delete(f.Entries, key)
+		}
+	}
+}
+
+func newRetryActionCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "retry-action [action-key]",
+		Short: "Reset the failures of an action so it is attempted on the next run, or list failing actions",
+		Long: `Reset the failures of an action so it is attempted on the next run, or list
+failing actions. An action that failed is attempted again after a backoff
+that doubles with every failure, up to failure_backoff.max; after
+failure_backoff.max_failures failures it is blocked until reset here.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			retryActionCLI(g.stateDir, g.output, args)
+		},
+	}
+}
+
+// retryActionCLI resets the failures of an action, or lists the actions with
+// recorded failures when no key is given
+func retryActionCLI(stateDir, output string, args []string) {
+	failures, err := loadFailures(stateDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	if len(args) == 0 {
+		if output == outputJSON {
+			printJSON(failures.Entries)
+			return
+		}
+		keys := make([]string, 0, len(failures.Entries))
+		for key := range failures.Entries {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		if len(keys) == 0 {
+			fmt.Println("No actions have failed.")
+			return
+		}
+		for _, key := range keys {
+			entry := failures.Entries[key]
+			next := "retry at " + entry.RetryAt
+			if entry.Blocked() {
+				next = "blocked since " + entry.BlockedAt
+			}
+			fmt.Printf("%-40s %d failure(s), %s: %s\n", key, entry.Failures, next, entry.Error)
+		}
+		return
+	}
+
+	key := args[0]
+	if !failures.Reset(key) {
+		log.Printf("[INFO] Action %s has no recorded failures", key)
+		return
+	}
+	if err := failures.Save(); err != nil {
+		log.Fatalf("[ERROR] Failed to save failures: %v", err)
+	}
+	log.Printf("[INFO] Failures of %s reset; it is attempted on the next run while it keeps quorum", key)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// defaultBackupKeep is the number of backups retained when keep is unset
+const defaultBackupKeep = 3
+
+// BackupConfig configures the snapshot of the node data taken before a
+// reboot resync clears it, so an errant reboot signal is recoverable
+type BackupConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`  // Archive the data directory before resyncing
+	Path    string `yaml:"path,omitempty"`     // Directory the tarballs are written to
+	Keep    int    `yaml:"keep,omitempty"`     // Newest backups retained; older ones are deleted
+	DataDir string `yaml:"data_dir,omitempty"` // Node data directory (default $HOME/.znn)
+}
+
+// Validate checks that an enabled backup has somewhere to go
+func (c BackupConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Path == "" {
+		return errors.New("backup path is required when backup is enabled")
+	}
+	if c.Keep < 0 {
+		return fmt.Errorf("backup keep must not be negative (got %d)", c.Keep)
+	}
+	return nil
+}
+
+// backupScript archives the data directory into the backup path through a
+// temporary file, then deletes all but the newest $3 backups. It runs under
+// sh so the step also works on fleet hosts over SSH.
+const backupScript = `set -e
+data="${1:-$HOME/.znn}"
+mkdir -p "$2"
+tar -czf "$2/$4.tmp" -C "$data" .
+mv "$2/$4.tmp" "$2/$4"
+ls -1t "$2"/qube-backup-*.tar.gz | tail -n +$(($3 + 1)) | while read -r old; do rm -f "$old"; done`
+
+// backupStep returns the step that snapshots the node data for action
+func backupStep(cfg BackupConfig, action *signal.Action) Step {
+	keep := cfg.Keep
+	if keep == 0 {
+		keep = defaultBackupKeep
+	}
+	name := fmt.Sprintf("qube-backup-%s-%s.tar.gz",
+		actionLogName(action.Key), time.Now().UTC().Format("20060102T150405Z"))
+	return Step{
+		Name:    "backup",
+		Command: []string{"sh", "-c", backupScript, "backup", cfg.DataDir, cfg.Path, strconv.Itoa(keep), name},
+	}
+}
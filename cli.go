@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
+)
+
+// globals holds the flags shared by every command and the state set up from
+// them before a command runs
+type globals struct {
+	configDir string
+	stateDir  string
+	verbose   bool
+	output    string
+	quiet     bool
+	identity  string
+	profile   string
+
+	configDirSet bool // --config-dir or --profile was given on the command line
+	stateDirSet  bool // --state-dir or --profile was given on the command line
+
+	keypair Keypair
+}
+
+// newRootCommand builds the command tree. Without a subcommand the root
+// command runs the manager.
+func newRootCommand() *cobra.Command {
+	g := &globals{}
+	var dryRun, daemon, force bool
+	var signalsFile string
+
+	root := &cobra.Command{
+		Use:   "qube-manager",
+		Short: "Upgrade and reboot nodes on quorum-signed Nostr signals",
+		Long: `qube-manager follows a set of Nostr keys and upgrades or reboots the node
+once a quorum of them signal the same action. Without a subcommand it
+evaluates signals once, or keeps polling relays with --daemon.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return g.setup(cmd)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			runManager(g, dryRun, daemon, force, signalsFile)
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&g.configDir, "config-dir", "", "Configuration directory for config and keys (default $XDG_CONFIG_HOME/qube-manager)")
+	flags.StringVar(&g.stateDir, "state-dir", "", "State directory for history, logs, and caches (default $XDG_STATE_HOME/qube-manager, or the config dir if --config-dir is set)")
+	flags.BoolVar(&g.verbose, "verbose", false, "Enable verbose logging including go-nostr logs")
+	flags.StringVar(&g.output, "output", outputText, "Output format for command results: 'text' or 'json'")
+	flags.BoolVar(&g.quiet, "quiet", false, "Only print warnings and errors to the console (the log file keeps everything)")
+	flags.StringVar(&g.identity, "identity", defaultIdentity, "Named identity in keys.json to sign with, e.g. 'operator' for send-message")
+	flags.StringVar(&g.profile, "profile", "", "Named profile keeping config, keys, history, and logs in ~/.qube-manager/profiles/<name>, e.g. 'testnet'")
+	root.MarkPersistentFlagDirname("config-dir")
+	root.MarkPersistentFlagDirname("state-dir")
+	root.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputText, outputJSON}, cobra.ShellCompDirectiveNoFileComp))
+
+	root.Flags().BoolVar(&dryRun, "dry-run", false, "Perform a trial run without saving actions")
+	root.Flags().BoolVar(&daemon, "daemon", false, "Keep running and poll relays every poll_interval")
+	root.Flags().StringVar(&signalsFile, "signals-file", "", "Read signed events from a file ('-' for stdin), a JSON array or one event per line, instead of polling relays")
+	root.Flags().BoolVar(&force, "force", false, "Execute actions even if the startup security checks fail")
+
+	root.AddCommand(
+		newSendMessageCommand(g),
+		newVerifyMessageCommand(g),
+		newConfigCommand(g),
+		newReplayCommand(g),
+		newSimulateCommand(g),
+		newCosignCommand(g),
+		newApprovalCommand(g, "approve"),
+		newApprovalCommand(g, "reject"),
+		newStatusCommand(g),
+		newHistoryCommand(g),
+		newStatsCommand(g),
+		newReportCommand(g),
+		newExecutorHelperCommand(g),
+		newKubernetesCRDCommand(),
+		newRelaysCommand(g),
+		newAdminCommand(g),
+		newAlertRulesCommand(g),
+		newAuditCommand(g),
+		newResumeActionCommand(g),
+		newRetryActionCommand(g),
+		newKeysCommand(g),
+		newDoctorCommand(g),
+		newAnnounceCommand(g),
+	)
+
+	root.SetArgs(normalizeArgs(os.Args[1:]))
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w\nRun '%s --help' for usage", err, cmd.CommandPath())
+	})
+	return root
+}
+
+// setup validates the global flags, resolves and creates the directories,
+// starts logging, and loads the keypair before any command runs, except for
+// commands annotated to skip the keypair. Shell completion needs none of it.
+func (g *globals) setup(cmd *cobra.Command) error {
+	if cmd.Name() == "completion" || (cmd.HasParent() && cmd.Parent().Name() == "completion") ||
+		cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+		return nil
+	}
+
+	if g.output != outputText && g.output != outputJSON {
+		return fmt.Errorf("invalid output format '%s'. Must be 'text' or 'json'", g.output)
+	}
+	if !validIdentity(g.identity) {
+		return fmt.Errorf("invalid identity '%s'. Use lowercase letters, digits, '-', and '_'", g.identity)
+	}
+	g.configDirSet = cmd.Flags().Changed("config-dir")
+	g.stateDirSet = cmd.Flags().Changed("state-dir")
+	if g.profile != "" {
+		if !validIdentity(g.profile) {
+			return fmt.Errorf("invalid profile '%s'. Use lowercase letters, digits, '-', and '_'", g.profile)
+		}
+		if g.configDirSet || g.stateDirSet {
+			return fmt.Errorf("--profile cannot be combined with --config-dir or --state-dir")
+		}
+		g.configDir = profileDir(g.profile)
+		g.stateDir = g.configDir
+		g.configDirSet, g.stateDirSet = true, true
+	}
+
+	if g.quiet {
+		setLogOutput(quietWriter{os.Stderr})
+	}
+
+	log.Printf("[INFO] Starting Qube Manager")
+
+	if g.profile != "" {
+		log.Printf("[INFO] Using profile %s", g.profile)
+	}
+	g.configDir, g.stateDir = resolveDirs(g.configDir, g.stateDir)
+	if err := os.MkdirAll(g.configDir, 0755); err != nil {
+		log.Fatalf("[ERROR] Failed to create config directory: %v", err)
+	} else {
+		log.Printf("[INFO] Ensured config directory exists at %s", g.configDir)
+	}
+	if err := os.MkdirAll(g.stateDir, 0755); err != nil {
+		log.Fatalf("[ERROR] Failed to create state directory: %v", err)
+	} else {
+		log.Printf("[INFO] Ensured state directory exists at %s", g.stateDir)
+	}
+
+	// Setup logging to file and stdout
+	setupLogging(g.stateDir, g.output, g.quiet)
+
+	if g.verbose {
+		log.Println("[INFO] Verbose logging enabled")
+	}
+
+	if cmd.Annotations[skipKeypairAnnotation] == "" {
+		log.Printf("[INFO] Loading or creating keypair of identity %s", g.identity)
+		g.keypair = loadOrCreateKeypair(g.configDir, g.identity)
+		if _, _, err := nip19.Decode(g.keypair.Nsec); err != nil {
+			log.Fatalf("[ERROR] Invalid private key in config: %v", err)
+		}
+	}
+
+	// Suppress go-nostr info logs like "filter doesn't match"
+	configureNostrLogging(g.verbose)
+	log.Println("[INFO] Nostr logging configured")
+
+	if cmd.HasParent() {
+		log.Printf("[INFO] Handling '%s' command", strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "))
+	}
+	return nil
+}
+
+// normalizeArgs rewrites long flags written with a single dash, as the flag
+// package accepted them (-config-dir), to the double-dash form, so existing
+// scripts and unit files keep working. Arguments after "--" are left alone.
+func normalizeArgs(args []string) []string {
+	out := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if len(arg) > 2 && arg[0] == '-' && arg[1] != '-' {
+			name, _, _ := strings.Cut(arg[1:], "=")
+			if len(name) > 1 {
+				arg = "-" + arg
+			}
+		}
+		out = append(out, arg)
+	}
+	return out
+}
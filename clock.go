@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Defaults for the startup clock check
+const (
+	defaultNTPServer    = "pool.ntp.org"
+	defaultMaxClockSkew = 30 * time.Second
+	clockCheckTimeout   = 5 * time.Second
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900) and
+// the Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// ClockCheckConfig configures the startup check of the local clock.
+// Freshness windows, quorum windows, and scheduling all compare event
+// timestamps with the local time, so a skewed clock makes the manager accept
+// stale signals, reject fresh ones, or execute at the wrong time.
+type ClockCheckConfig struct {
+	Disabled  bool          `yaml:"disabled,omitempty"`   // Skip the check
+	NTPServer string        `yaml:"ntp_server,omitempty"` // NTP server asked for the time (default pool.ntp.org); the relays are asked if it cannot be reached
+	MaxSkew   time.Duration `yaml:"max_skew,omitempty"`   // Largest tolerated difference from the reference time (default 30s)
+	Refuse    bool          `yaml:"refuse,omitempty"`     // Refuse to run instead of only warning when the skew exceeds max_skew
+}
+
+// applyDefaults fills in unset clock check settings
+func (c *ClockCheckConfig) applyDefaults() {
+	if c.NTPServer == "" {
+		c.NTPServer = defaultNTPServer
+	}
+	if c.MaxSkew == 0 {
+		c.MaxSkew = defaultMaxClockSkew
+	}
+}
+
+// checkClock compares the local clock with a reference time and warns if it
+// is off by more than max_skew. It returns false if the manager should refuse
+// to run. A clock that cannot be checked is only reported.
+func checkClock(cfg ClockCheckConfig, relays []string, verbose bool) bool {
+	if cfg.Disabled {
+		return true
+	}
+
+	skew, source, err := clockSkew(cfg, relays, verbose)
+	if err != nil {
+		log.Printf("[WARN] Could not check the local clock: %v", err)
+		return true
+	}
+	if skew.Abs() <= cfg.MaxSkew {
+		log.Printf("[INFO] Local clock is within %v of %s (skew %v)", cfg.MaxSkew, source, skew.Round(time.Millisecond))
+		return true
+	}
+
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	log.Printf("[WARN] ************************************************************")
+	log.Printf("[WARN] Local clock is %v %s %s (max_skew %v)", skew.Abs().Round(time.Millisecond), direction, source, cfg.MaxSkew)
+	log.Printf("[WARN] Signal freshness, quorum windows, and scheduled actions depend on")
+	log.Printf("[WARN] accurate local time; synchronize the clock (e.g. enable NTP)")
+	log.Printf("[WARN] ************************************************************")
+	if cfg.Refuse {
+		log.Println("[ERROR] Refusing to run with a skewed clock (clock_check.refuse)")
+		return false
+	}
+	return true
+}
+
+// clockSkew measures how far the local clock is ahead of the NTP server, or
+// of the Date header relays send with their NIP-11 document if the server
+// cannot be reached. It returns the skew and where the reference time came
+// from. Sources that fail are logged only if verbose is set.
+func clockSkew(cfg ClockCheckConfig, relays []string, verbose bool) (time.Duration, string, error) {
+	skew, err := ntpSkew(cfg.NTPServer, clockCheckTimeout)
+	if err == nil {
+		return skew, "NTP server " + cfg.NTPServer, nil
+	}
+	if verbose {
+		log.Printf("[DEBUG] NTP query to %s failed: %v", cfg.NTPServer, err)
+	}
+
+	for _, relay := range relays {
+		skew, relayErr := relaySkew(relay, clockCheckTimeout)
+		if relayErr == nil {
+			return skew, "relay " + relay, nil
+		}
+		if verbose {
+			log.Printf("[DEBUG] Relay time from %s unavailable: %v", relay, relayErr)
+		}
+	}
+	return 0, "", fmt.Errorf("NTP server %s unreachable (%v) and no relay reported its time", cfg.NTPServer, err)
+}
+
+// ntpSkew queries an NTP server with a single SNTP request (RFC 4330) and
+// returns the local clock offset from it
+func ntpSkew(server string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// Version 4, client mode
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	t0 := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	t3 := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 {
+		return 0, errors.New("short NTP response")
+	}
+	if resp[0]&0x7 != 4 || resp[1] == 0 {
+		return 0, errors.New("invalid NTP response (wrong mode or kiss-of-death)")
+	}
+
+	t1 := ntpTime(resp[32:40])
+	t2 := ntpTime(resp[40:48])
+	offset := (t1.Sub(t0) + t2.Sub(t3)) / 2
+	return -offset, nil
+}
+
+// ntpTime decodes a 64-bit NTP timestamp
+func ntpTime(b []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(b[:4])) - ntpEpochOffset
+	frac := int64(binary.BigEndian.Uint32(b[4:]))
+	return time.Unix(secs, frac*int64(time.Second)>>32)
+}
+
+// relaySkew returns the local clock offset from the Date header a relay
+// sends with its NIP-11 document. The header has a resolution of one second.
+func relaySkew(relay string, timeout time.Duration) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodGet, relayInfoURL(relay), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+
+	client := &http.Client{Timeout: timeout}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	mid := start.Add(time.Since(start) / 2)
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("no valid Date header: %w", err)
+	}
+	// The header truncates to the second; compare with the middle of it
+	return mid.Sub(date.Add(time.Second / 2)), nil
+}
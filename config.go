@@ -1,34 +1,432 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"gopkg.in/yaml.v3"
 )
 
 // Config holds application settings loaded from YAML config file
 type Config struct {
-	Relays     []string `yaml:"relays"`  // List of relay URLs to connect to
-	Follows    []string `yaml:"follows"` // List of Nostr npubs to follow
+	Relays     []Relay  `yaml:"relays"`  // List of relays to connect to
+	Follows    []Follow `yaml:"follows"` // List of Nostr npubs to follow
 	Quorum     int      `yaml:"quorum"`  // Number of follows needed to trigger action
 	ConfigPath string   `yaml:"-"`       // Path to config directory (not in YAML)
+	StatePath  string   `yaml:"-"`       // Path to state directory for history, logs, and caches (not in YAML)
+	Discovered []Relay  `yaml:"-"`       // Relays found through relay_discovery, used besides Relays (not in YAML)
+
+	// Relays recommended by the follows or a coordinator, merged with relays
+	RelayDiscovery RelayDiscoveryConfig `yaml:"relay_discovery,omitempty"`
+
+	// Number of follows that must sign a revoke-key message (two-thirds if unset)
+	RevokeQuorum int `yaml:"revoke_quorum,omitempty"`
+
+	// How long an in-flight action may keep running after SIGINT/SIGTERM
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period,omitempty"`
+
+	// Sources used to detect the running node version
+	Node NodeConfig `yaml:"node,omitempty"`
+
+	// Backend used to apply actions to the node (execution disabled if unset)
+	Executor ExecutorConfig `yaml:"executor,omitempty"`
+
+	// Backends of co-hosted services, e.g. zenon or an orchestrator, keyed by
+	// the service named in upgrade and rollback signals
+	Services map[string]ExecutorConfig `yaml:"services,omitempty"`
+
+	// Snapshot of the node data taken before a reboot resync clears it
+	Backup BackupConfig `yaml:"backup,omitempty"`
+
+	// Upper bound of the deterministic per-node delay before executing an action
+	MaxStagger time.Duration `yaml:"max_stagger,omitempty"`
+
+	// Minimum time between two executed actions, so the node settles after
+	// one before the next starts
+	ActionCooldown time.Duration `yaml:"action_cooldown,omitempty"`
+
+	// Recurring period actions are executed in; due actions wait for it
+	MaintenanceWindow MaintenanceWindow `yaml:"maintenance_window,omitempty"`
+
+	// Prometheus textfile collector output written at the end of every run
+	MetricsTextfile string `yaml:"metrics_textfile,omitempty"`
+
+	// Deduplication and rate limits of noisy log lines, e.g. failing relays
+	LogLimits LogLimitConfig `yaml:"log_limits,omitempty"`
+
+	// How many history entries are kept in full before older ones are compacted
+	HistoryRetention HistoryRetention `yaml:"history_retention,omitempty"`
+
+	// Endpoint the summary of every run, also kept in last_run.json, is posted to
+	RunSummary RunSummaryConfig `yaml:"run_summary,omitempty"`
+
+	// Remote hosts to execute actions on over SSH instead of the local node
+	Fleet FleetConfig `yaml:"fleet,omitempty"`
+
+	// Queue quorum-reached actions until an operator runs 'qube-manager approve'
+	RequireApproval bool `yaml:"require_approval,omitempty"`
+
+	// Trust model: "votes" counts one signal event per follow, "threshold"
+	// requires a single event co-signed by at least quorum follows
+	SignalMode string `yaml:"signal_mode,omitempty"`
+
+	// Kinds, tags, and limits of the relay subscription for signals
+	Subscription SubscriptionConfig `yaml:"subscription,omitempty"`
+
+	// Where the private key is kept: "file" (keys.json) or "keyring" (OS keyring)
+	KeyStore string `yaml:"key_store,omitempty"`
+
+	// Time allowed to open a relay connection
+	ConnectTimeout time.Duration `yaml:"connect_timeout,omitempty"`
+
+	// Longest silence from a relay before it signals the end of stored events
+	// (EOSE), and how long the daemon listens for live events afterwards
+	ReadTimeout time.Duration `yaml:"read_timeout,omitempty"`
+
+	// Upper bound on a whole relay poll across all relays
+	TotalTimeout time.Duration `yaml:"total_timeout,omitempty"`
+
+	// How often relays are polled in daemon mode
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+
+	// Address serving /healthz and /readyz in daemon mode, e.g. "127.0.0.1:9090"
+	HealthListen string `yaml:"health_listen,omitempty"`
+
+	// Operator npub that receives encrypted DM alerts about failures
+	AlertNpub string `yaml:"alert_npub,omitempty"`
+
+	// Further channels alerts are sent over, each with its own message template
+	Notifiers []NotifierConfig `yaml:"notifiers,omitempty"`
+
+	// How long no relay may be reachable before the operator is alerted
+	RelayLossAlertAfter time.Duration `yaml:"relay_loss_alert_after,omitempty"`
+
+	// How long a candidate may stay below quorum before the operator is alerted
+	QuorumStuckAlertAfter time.Duration `yaml:"quorum_stuck_alert_after,omitempty"`
+
+	// How long a follow may publish no signal or heartbeat while others do
+	// before the operator is alerted
+	SignerSilenceAlertAfter time.Duration `yaml:"signer_silence_alert_after,omitempty"`
+
+	// Web UI served in daemon mode
+	Dashboard DashboardConfig `yaml:"dashboard,omitempty"`
+
+	// Authenticated local control API served in daemon mode
+	Admin AdminConfig `yaml:"admin,omitempty"`
+
+	// Live feed of validated signals and lifecycle events served in daemon mode
+	EventStream StreamConfig `yaml:"event_stream,omitempty"`
+
+	// Kind-0 profile published for this manager's npub
+	Announce AnnounceConfig `yaml:"announce,omitempty"`
+
+	// Progress events published while the node resyncs after a reboot
+	Resync ResyncConfig `yaml:"resync,omitempty"`
+
+	// Node stats sampled every run and published in heartbeat events
+	Telemetry TelemetryConfig `yaml:"telemetry,omitempty"`
+
+	// Rollout cohort of this node, e.g. "canary"; upgrades signaled for
+	// another cohort are ignored
+	Cohort string `yaml:"cohort,omitempty"`
+
+	// Network or chain this node runs on, e.g. "hyperqube-mainnet". Signals
+	// for another network are ignored, as are all signals naming a network
+	// while this is unset, and performed actions are recorded per network so
+	// they never collide in one history file.
+	Network string `yaml:"network,omitempty"`
+
+	// Lease shared by redundant managers so only one of them executes
+	Coordination CoordinationConfig `yaml:"coordination,omitempty"`
+
+	// Guardrails on the versions executed, independent of what signers announce
+	VersionPolicy VersionPolicy `yaml:"version_policy,omitempty"`
+
+	// Safeguards each signal severity (routine, security, critical) may skip,
+	// e.g. so critical security fixes are not staggered or held for the
+	// maintenance window; routine actions and severities not listed keep
+	// every safeguard
+	SeverityPolicy map[string]SeverityPolicy `yaml:"severity_policy,omitempty"`
+
+	// Relays that must accept a done event before it leaves the outbox
+	PublishMinRelays int `yaml:"publish_min_relays,omitempty"`
+
+	// Which of an upgrade and a reboot that reach quorum together is executed:
+	// "highest_version", "reboot_wins", or "in_order" to execute both
+	ConflictPolicy string `yaml:"conflict_policy,omitempty"`
+
+	// Count only each follow's newest signal per action type, so a newer
+	// signal, e.g. for v1.3.1, withdraws its vote for v1.3.0
+	LatestSignalWins bool `yaml:"latest_signal_wins,omitempty"`
+
+	// Leading zero bits of NIP-13 proof of work a signal event must commit to
+	// before it counts (no proof of work required if unset)
+	MinPowDifficulty int `yaml:"min_pow_difficulty,omitempty"`
+
+	// Startup check of the local clock against NTP or relay time
+	ClockCheck ClockCheckConfig `yaml:"clock_check,omitempty"`
+
+	// Track signals, quorum, and alerts without executing or recording
+	// actions, e.g. on a developer machine or testnet observer. Managers whose
+	// executor cannot run on their platform observe as well.
+	ObserveOnly bool `yaml:"observe_only,omitempty"`
+
+	// Re-broadcast of validated signals to the relays that did not deliver them
+	Gossip GossipConfig `yaml:"gossip,omitempty"`
+
+	// Rebuild history at startup from the done events this manager published,
+	// so a wiped state dir does not re-execute actions already performed
+	RecoverHistory bool `yaml:"recover_history,omitempty"`
+
+	// Publish a done event after executing an action (default true). With
+	// false the action is only recorded in local history, which then cannot
+	// be recovered from relays.
+	PublishDone *bool `yaml:"publish_done,omitempty"`
+
+	// Fields of the done event to omit or set
+	DoneTemplate DoneTemplate `yaml:"done_template,omitempty"`
+
+	// Wait between the attempts of a failing action, and failures after
+	// which it is blocked until 'qube-manager retry-action'
+	FailureBackoff FailureBackoffConfig `yaml:"failure_backoff,omitempty"`
+}
+
+// Defaults applied to optional config fields left unset in the YAML file
+const (
+	defaultShutdownGracePeriod = 30 * time.Second
+	defaultExecutorMaxAttempts = 3
+	defaultExecutorRetryDelay  = 10 * time.Second
+	defaultExecutorStepTimeout = time.Hour
+	defaultPollInterval        = time.Minute
+	defaultConnectTimeout      = 5 * time.Second
+	defaultReadTimeout         = 10 * time.Second
+	defaultTotalTimeout        = time.Minute
+	defaultSignalMode          = signalModeVotes
+	defaultCohort              = "stable"
+	defaultPublishMinRelays    = 1
+	defaultConflictPolicy      = signal.ConflictHighestVersion
+)
+
+// applyDefaults fills in optional settings that were omitted from the file
+func (c *Config) applyDefaults() {
+	if c.ShutdownGracePeriod <= 0 {
+		c.ShutdownGracePeriod = defaultShutdownGracePeriod
+	}
+	c.Executor.applyDefaults(c.StatePath)
+	c.Executor.Backup = c.Backup
+	for name, ex := range c.Services {
+		ex.applyDefaults(c.StatePath)
+		c.Services[name] = ex
+	}
+	if c.KeyStore == "" {
+		c.KeyStore = keyStoreFile
+	}
+	if c.SignalMode == "" {
+		c.SignalMode = defaultSignalMode
+	}
+	if c.Cohort == "" {
+		c.Cohort = defaultCohort
+	}
+	if c.ConflictPolicy == "" {
+		c.ConflictPolicy = defaultConflictPolicy
+	}
+	if c.Subscription.MaxEventsPerRelay == 0 {
+		c.Subscription.MaxEventsPerRelay = defaultMaxEventsPerRelay
+	}
+	if c.Subscription.MaxEventsTotal == 0 {
+		c.Subscription.MaxEventsTotal = defaultMaxEventsTotal
+	}
+	if c.Subscription.MaxCandidates == 0 {
+		c.Subscription.MaxCandidates = defaultMaxCandidates
+	}
+	if c.ConnectTimeout <= 0 {
+		c.ConnectTimeout = defaultConnectTimeout
+	}
+	if c.ReadTimeout <= 0 {
+		c.ReadTimeout = defaultReadTimeout
+	}
+	if c.TotalTimeout <= 0 {
+		c.TotalTimeout = defaultTotalTimeout
+	}
+	if c.RelayLossAlertAfter <= 0 {
+		c.RelayLossAlertAfter = defaultRelayLossAlertAfter
+	}
+	if c.QuorumStuckAlertAfter <= 0 {
+		c.QuorumStuckAlertAfter = defaultQuorumStuckAlertAfter
+	}
+	if c.SignerSilenceAlertAfter <= 0 {
+		c.SignerSilenceAlertAfter = defaultSignerSilenceAlertAfter
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = defaultPollInterval
+	}
+	if c.PublishMinRelays == 0 {
+		c.PublishMinRelays = defaultPublishMinRelays
+	}
+	if c.Coordination.Enabled() && c.Coordination.LeaseTimeout <= 0 {
+		c.Coordination.LeaseTimeout = leaseTimeoutCycles * c.PollInterval
+	}
+	c.Telemetry.applyDefaults()
+	c.Resync.applyDefaults()
+	c.RelayDiscovery.applyDefaults()
+	c.ClockCheck.applyDefaults()
+	c.Gossip.applyDefaults()
+	c.FailureBackoff.applyDefaults()
+	c.RunSummary.applyDefaults()
+	c.LogLimits.applyDefaults()
+	if c.Fleet.Parallelism <= 0 {
+		c.Fleet.Parallelism = defaultFleetParallelism
+	}
+	if c.Fleet.HealthTimeout <= 0 {
+		c.Fleet.HealthTimeout = defaultFleetHealthTimeout
+	}
+}
+
+// Signal trust models selectable with signal_mode
+const (
+	signalModeVotes     = "votes"     // one signal event per follow, counted toward quorum
+	signalModeThreshold = "threshold" // one event co-signed by at least quorum follows
+)
+
+// trustedSigners returns the signers whose co-signatures count in threshold
+// mode, or nil when counting votes
+func trustedSigners(cfg Config, active []string) map[string]bool {
+	if cfg.SignalMode != signalModeThreshold {
+		return nil
+	}
+	trusted := make(map[string]bool, len(active))
+	for _, pk := range active {
+		trusted[pk] = true
+	}
+	return trusted
+}
+
+// Follow is a followed signer. In YAML it is either a bare npub string or a
+// mapping with the npub and per-follow options.
+type Follow struct {
+	NPub      string `yaml:"npub"`                // Nostr npub of the signer
+	Encrypted bool   `yaml:"encrypted,omitempty"` // Signals arrive as encrypted DMs instead of public notes
+	Role      string `yaml:"role,omitempty"`      // "proposer" or "approver" to count the signer's votes for that role only
+}
+
+// UnmarshalYAML accepts both the bare npub form and the mapping form
+func (f *Follow) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		f.NPub = node.Value
+		return nil
+	}
+	type plain Follow
+	return node.Decode((*plain)(f))
+}
+
+// MarshalYAML writes follows without options as bare npub strings, and the
+// others, including any role, in the mapping form
+func (f Follow) MarshalYAML() (any, error) {
+	if !f.Encrypted && f.Role == "" {
+		return f.NPub, nil
+	}
+	type plain Follow
+	return plain(f), nil
+}
+
+// Relay roles: read relays are subscribed to and queried, write relays
+// receive the events the manager publishes
+const (
+	relayRead  = "read"
+	relayWrite = "write"
+	relayBoth  = "both"
+)
+
+// Relay is a relay URL and the role the manager uses it in
+type Relay struct {
+	URL  string `yaml:"url"`            // Relay URL
+	Role string `yaml:"role,omitempty"` // "read", "write", or "both" (default)
+}
+
+// UnmarshalYAML accepts both the bare URL form and the mapping form
+func (r *Relay) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		r.URL = node.Value
+		return nil
+	}
+	type plain Relay
+	return node.Decode((*plain)(r))
+}
+
+// MarshalYAML writes relays used for both roles as bare URL strings
+func (r Relay) MarshalYAML() (any, error) {
+	if r.Role == "" || r.Role == relayBoth {
+		return r.URL, nil
+	}
+	type plain Relay
+	return plain(r), nil
+}
+
+// reads reports whether the manager subscribes to and queries the relay
+func (r Relay) reads() bool { return r.Role != relayWrite }
+
+// writes reports whether the manager publishes to the relay
+func (r Relay) writes() bool { return r.Role != relayRead }
+
+// relayURLs returns the URLs of all configured relays
+func (c Config) relayURLs() []string {
+	return c.relaysWhere(func(Relay) bool { return true })
+}
+
+// readRelays returns the URLs of the relays signals and events are read from
+func (c Config) readRelays() []string {
+	return c.relaysWhere(Relay.reads)
+}
+
+// writeRelays returns the URLs of the relays events are published to
+func (c Config) writeRelays() []string {
+	return c.relaysWhere(Relay.writes)
+}
+
+// relaysWhere returns the URLs of the relays matching keep: the configured
+// ones, then those discovered that are not configured
+func (c Config) relaysWhere(keep func(Relay) bool) []string {
+	var urls []string
+	for _, r := range c.Relays {
+		if keep(r) {
+			urls = append(urls, r.URL)
+		}
+	}
+	for _, r := range c.Discovered {
+		if keep(r) && !c.configuredRelay(r.URL) {
+			urls = append(urls, r.URL)
+		}
+	}
+	return urls
+}
+
+// configuredRelay reports whether url is listed in relays
+func (c Config) configuredRelay(url string) bool {
+	return slices.ContainsFunc(c.Relays, func(r Relay) bool { return nostr.NormalizeURL(r.URL) == nostr.NormalizeURL(url) })
 }
 
 // loadConfig reads the YAML config file or creates a default one if missing,
-// then validates npubs and relay URLs.
-func loadConfig(configDir string) Config {
+// then validates npubs and relay URLs. State the config refers to lives in
+// stateDir.
+func loadConfig(configDir, stateDir string) Config {
 	path := filepath.Join(configDir, "config.yaml")
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		log.Printf("[WARN] Config file not found at %s, creating default config", path)
 		defaultCfg := Config{
-			Relays: []string{"wss://nostr.zenon.network"},
-			Follows: []string{
-				"npub1sr47j9awvw2xa0m4w770dr2rl7ylzq4xt9k5rel3h4h58sc3mjysx6pj64", // george
+			Relays: []Relay{{URL: "wss://nostr.zenon.network"}},
+			Follows: []Follow{
+				{NPub: "npub1sr47j9awvw2xa0m4w770dr2rl7ylzq4xt9k5rel3h4h58sc3mjysx6pj64"}, // george
 			},
 			Quorum: 1,
 		}
@@ -46,35 +444,387 @@ func loadConfig(configDir string) Config {
 		log.Printf("[INFO] Config file found at %s, loading", path)
 	}
 
-	data, err := os.ReadFile(path)
+	cfg, err := readConfig(configDir, stateDir)
 	if err != nil {
-		log.Fatalf("[ERROR] Failed to read config file %s: %v", path, err)
+		log.Printf("[ERROR] %v", err)
+		os.Exit(exitConfigError)
+	}
+	log.Printf("[INFO] Loaded config: %d relay(s), %d follow(s), quorum=%d", len(cfg.Relays), len(cfg.Follows), cfg.Quorum)
+
+	// Report every problem at once rather than stopping at the first one
+	problems, warnings := splitProblems(validateConfig(cfg))
+	for _, p := range warnings {
+		log.Printf("[WARN] Config: %s", p)
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("[ERROR] Invalid config: %s", p)
+		}
+		log.Printf("[ERROR] Config file %s has %d problem(s); run 'qube-manager config validate' for details", path, len(problems))
+		os.Exit(exitConfigError)
+	}
+
+	return cfg
+}
+
+// readConfig parses config.yaml and applies defaults without validating it
+func readConfig(configDir, stateDir string) (Config, error) {
+	path := filepath.Join(configDir, "config.yaml")
+	data, err := readConfigData(configDir)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read config file %s: %w", path, err)
 	}
 
 	var cfg Config
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		log.Fatalf("[ERROR] Failed to parse config file %s: %v", path, err)
+		return Config{}, fmt.Errorf("failed to parse config file %s: %w", path, err)
 	}
 	cfg.ConfigPath = configDir
-	log.Printf("[INFO] Loaded config: %d relay(s), %d follow(s), quorum=%d", len(cfg.Relays), len(cfg.Follows), cfg.Quorum)
+	cfg.StatePath = stateDir
+	cfg.applyDefaults()
+	return cfg, nil
+}
 
-	// Validate npubs
-	for _, npub := range cfg.Follows {
-		kind, _, err := nip19.Decode(npub)
+// decodeFollows converts the configured npubs to hex pubkeys, skipping any
+// entry that does not decode to a valid npub
+func decodeFollows(follows []Follow) []string {
+	hexFollows := make([]string, 0, len(follows))
+	for _, f := range follows {
+		npub := f.NPub
+		kind, pubkeyAny, err := nip19.Decode(npub)
 		if err != nil {
-			log.Fatalf("[ERROR] Invalid npub in config: %v", err)
+			log.Printf("[WARN] Skipping invalid npub (%s): %v", npub, err)
+			continue
 		}
 		if kind != "npub" {
-			log.Fatalf("[ERROR] Expected npub but got %s in config: %s", kind, npub)
+			log.Printf("[WARN] Expected npub but got %s: %s", kind, npub)
+			continue
 		}
+		pubkey, ok := pubkeyAny.(string)
+		if !ok {
+			log.Printf("[WARN] Unexpected pubkey format for %s: %v", npub, pubkeyAny)
+			continue
+		}
+		hexFollows = append(hexFollows, pubkey)
 	}
+	return hexFollows
+}
 
-	// Validate relay URLs
-	for _, r := range cfg.Relays {
-		if _, err := url.ParseRequestURI(r); err != nil {
-			log.Fatalf("[ERROR] Invalid relay URL in config: %s", r)
+// encryptedFollows returns the hex pubkeys of follows that signal via
+// encrypted direct messages
+func encryptedFollows(follows []Follow) map[string]bool {
+	encrypted := make(map[string]bool)
+	for _, f := range follows {
+		if !f.Encrypted {
+			continue
+		}
+		if _, pk, err := nip19.Decode(f.NPub); err == nil {
+			if s, ok := pk.(string); ok {
+				encrypted[s] = true
+			}
 		}
 	}
+	return encrypted
+}
 
-	return cfg
+// followRoles returns the role of each follow that has one, keyed by hex
+// pubkey
+func followRoles(follows []Follow) map[string]string {
+	roles := make(map[string]string)
+	for _, f := range follows {
+		if f.Role == "" {
+			continue
+		}
+		if _, pk, err := nip19.Decode(f.NPub); err == nil {
+			if s, ok := pk.(string); ok {
+				roles[s] = f.Role
+			}
+		}
+	}
+	return roles
+}
+
+// configProblem describes a single invalid config setting
+type configProblem struct {
+	Field   string // YAML path of the offending setting, e.g. "follows[2]"
+	Message string // What is wrong and how to fix it
+	Warning bool   // Likely a mistake, but the config is still accepted
+}
+
+func (p configProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Field, p.Message)
+}
+
+// splitProblems separates the problems that reject the config from warnings
+func splitProblems(problems []configProblem) (errs, warnings []configProblem) {
+	for _, p := range problems {
+		if p.Warning {
+			warnings = append(warnings, p)
+		} else {
+			errs = append(errs, p)
+		}
+	}
+	return errs, warnings
+}
+
+// validateConfig checks every setting and returns all problems found,
+// including warnings that do not reject the config
+func validateConfig(cfg Config) []configProblem {
+	var problems []configProblem
+	add := func(field, format string, args ...any) {
+		problems = append(problems, configProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, format string, args ...any) {
+		problems = append(problems, configProblem{Field: field, Message: fmt.Sprintf(format, args...), Warning: true})
+	}
+
+	// Validate relay URLs. A relay listed twice is polled and published to
+	// twice; its roles belong on a single entry.
+	seenRelays := make(map[string]int)
+	for i, r := range cfg.Relays {
+		field := fmt.Sprintf("relays[%d]", i)
+		if j, ok := seenRelays[nostr.NormalizeURL(r.URL)]; ok {
+			warn(field, "duplicate of relays[%d] (%s); list each relay once with role both if it should be read and written", j, r.URL)
+		} else {
+			seenRelays[nostr.NormalizeURL(r.URL)] = i
+		}
+		switch r.Role {
+		case "", relayRead, relayWrite, relayBoth:
+		default:
+			add(field+".role", "must be read, write, or both (got %q)", r.Role)
+		}
+		u, err := url.ParseRequestURI(r.URL)
+		if err != nil {
+			add(field, "invalid relay URL %q", r.URL)
+			continue
+		}
+		if u.Scheme != "ws" && u.Scheme != "wss" {
+			add(field, "relay URL %q must use ws:// or wss://", r.URL)
+		}
+	}
+	if len(cfg.Relays) > 0 {
+		if len(cfg.readRelays()) == 0 {
+			add("relays", "at least one relay must have the read or both role")
+		}
+		if len(cfg.writeRelays()) == 0 {
+			add("relays", "at least one relay must have the write or both role")
+		}
+	}
+
+	// Validate npubs. A key followed twice still casts a single vote, so
+	// only distinct valid follows count toward the quorum.
+	seenFollows := make(map[string]int)
+	for i, f := range cfg.Follows {
+		npub := f.NPub
+		field := fmt.Sprintf("follows[%d]", i)
+		kind, pk, err := nip19.Decode(npub)
+		if err != nil {
+			add(field, "invalid npub %q: %v", npub, err)
+			continue
+		}
+		if kind != "npub" {
+			add(field, "expected an npub but got %s: %s", kind, npub)
+			continue
+		}
+		if j, ok := seenFollows[pk.(string)]; ok {
+			if cfg.Follows[j].Role != f.Role {
+				add(field, "duplicate of follows[%d] with a different role; give the key a single entry", j)
+			} else {
+				warn(field, "duplicate of follows[%d]; the key casts a single vote however often it is listed", j)
+			}
+		} else {
+			seenFollows[pk.(string)] = i
+		}
+		if f.Role != "" && f.Role != signal.RoleProposer && f.Role != signal.RoleApprover {
+			add(field+".role", "must be %q or %q (got %q)", signal.RoleProposer, signal.RoleApprover, f.Role)
+		}
+	}
+
+	// With roles, a quorum needs a signer able to propose and one able to
+	// approve
+	var proposers, approvers, both int
+	for _, f := range cfg.Follows {
+		switch f.Role {
+		case signal.RoleProposer:
+			proposers++
+		case signal.RoleApprover:
+			approvers++
+		default:
+			both++
+		}
+	}
+	if proposers+approvers > 0 {
+		if proposers+both == 0 {
+			add("follows", "no follow can propose actions; give at least one the role %q or none", signal.RoleProposer)
+		}
+		if approvers+both == 0 {
+			add("follows", "no follow can approve actions; give at least one the role %q or none", signal.RoleApprover)
+		}
+		if cfg.Quorum == 1 && both == 0 {
+			add("quorum", "1 cannot cover both roles; raise it or leave a follow without a role")
+		}
+	}
+
+	// A quorum that can never be met means the manager silently never acts
+	if cfg.Quorum < 1 {
+		add("quorum", "must be at least 1 (got %d)", cfg.Quorum)
+	} else if cfg.Quorum > len(seenFollows) {
+		add("quorum", "%d exceeds the number of distinct valid follows (%d); no action could ever reach quorum", cfg.Quorum, len(seenFollows))
+	}
+
+	// A done event no set of relays can satisfy would stay queued forever
+	if writers := len(cfg.writeRelays()); cfg.PublishMinRelays < 0 || (writers > 0 && cfg.PublishMinRelays > writers) {
+		add("publish_min_relays", "must be between 1 and the number of write relays (%d), or omitted for 1", writers)
+	}
+
+	if cfg.ActionCooldown < 0 {
+		add("action_cooldown", "must not be negative (got %v)", cfg.ActionCooldown)
+	}
+
+	if err := cfg.MaintenanceWindow.Validate(); err != nil {
+		add("maintenance_window", "%v", err)
+	}
+
+	for severity := range cfg.SeverityPolicy {
+		if !signal.ValidSeverity(severity) {
+			add("severity_policy", "unknown severity %q (must be %s, %s, or %s)", severity, signal.SeverityRoutine, signal.SeveritySecurity, signal.SeverityCritical)
+		}
+	}
+
+	if cfg.SignalMode != "" && cfg.SignalMode != signalModeVotes && cfg.SignalMode != signalModeThreshold {
+		add("signal_mode", "must be %q or %q (got %q)", signalModeVotes, signalModeThreshold, cfg.SignalMode)
+	}
+
+	switch cfg.ConflictPolicy {
+	case "", signal.ConflictHighestVersion, signal.ConflictRebootWins, signal.ConflictInOrder:
+	default:
+		add("conflict_policy", "must be %q, %q, or %q (got %q)", signal.ConflictHighestVersion, signal.ConflictRebootWins, signal.ConflictInOrder, cfg.ConflictPolicy)
+	}
+
+	// Event IDs are 256 bits long
+	if cfg.MinPowDifficulty < 0 || cfg.MinPowDifficulty > 256 {
+		add("min_pow_difficulty", "must be between 0 and 256 (got %d)", cfg.MinPowDifficulty)
+	}
+
+	if cfg.ClockCheck.MaxSkew < 0 {
+		add("clock_check.max_skew", "must not be negative (got %v)", cfg.ClockCheck.MaxSkew)
+	}
+
+	if cfg.Gossip.MaxPerHour < 0 {
+		add("gossip.max_per_hour", "must not be negative (got %d)", cfg.Gossip.MaxPerHour)
+	}
+
+	if b := cfg.FailureBackoff; b.Initial < 0 || b.Max < 0 {
+		add("failure_backoff", "initial and max must not be negative (got %v and %v)", b.Initial, b.Max)
+	} else if b.Initial > 0 && b.Max > 0 && b.Initial > b.Max {
+		add("failure_backoff.initial", "%v exceeds failure_backoff.max (%v)", b.Initial, b.Max)
+	}
+	if cfg.FailureBackoff.MaxFailures < 0 {
+		add("failure_backoff.max_failures", "must not be negative (got %d)", cfg.FailureBackoff.MaxFailures)
+	}
+
+	if err := cfg.RunSummary.Validate(); err != nil {
+		add("run_summary.url", "%v", err)
+	}
+
+	if cfg.Network != "" && !signal.ValidNetwork(cfg.Network) {
+		add("network", "invalid network %q (use lowercase letters, digits, dots, dashes, and underscores)", cfg.Network)
+	}
+
+	if cfg.Cohort != "" && !signal.ValidCohort(cfg.Cohort) {
+		add("cohort", "invalid cohort %q (use lowercase letters, digits, dashes, and underscores)", cfg.Cohort)
+	}
+
+	if cfg.AlertNpub != "" {
+		if kind, _, err := nip19.Decode(cfg.AlertNpub); err != nil || kind != "npub" {
+			add("alert_npub", "invalid npub %q", cfg.AlertNpub)
+		}
+	}
+
+	for i, nc := range cfg.Notifiers {
+		if _, err := newNotifyChannel(nc, cfg, Keypair{}, nil); err != nil {
+			add(fmt.Sprintf("notifiers[%d]", i), "%v", err)
+		}
+	}
+
+	if cfg.KeyStore != "" && cfg.KeyStore != keyStoreFile && cfg.KeyStore != keyStoreKeyring {
+		add("key_store", "must be %q or %q (got %q)", keyStoreFile, keyStoreKeyring, cfg.KeyStore)
+	}
+
+	if cfg.RevokeQuorum < 0 || cfg.RevokeQuorum > len(cfg.Follows) {
+		add("revoke_quorum", "must be between 1 and the number of follows (%d), or omitted for a two-thirds super-majority", len(cfg.Follows))
+	}
+
+	// Executor settings, including the deployment script for the shell backend.
+	// In fleet mode the backend runs on the remote hosts instead.
+	if cfg.Fleet.Enabled() {
+		if _, err := newFleet(cfg.Fleet, cfg.Executor); err != nil {
+			add("executor", "%v", err)
+		}
+	} else if _, err := newExecutor(cfg.Executor); err != nil {
+		add("executor", "%v", err)
+	}
+	if err := cfg.Backup.Validate(); err != nil {
+		add("backup", "%v", err)
+	}
+
+	problems = append(problems, validateServices(cfg)...)
+	problems = append(problems, cfg.Subscription.validate()...)
+	problems = append(problems, cfg.DoneTemplate.validate()...)
+
+	if cfg.HealthListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.HealthListen); err != nil {
+			add("health_listen", "invalid listen address %q: %v", cfg.HealthListen, err)
+		}
+	}
+	if cfg.Dashboard.Listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.Dashboard.Listen); err != nil {
+			add("dashboard.listen", "invalid listen address %q: %v", cfg.Dashboard.Listen, err)
+		}
+	}
+	if err := cfg.Announce.Validate(); err != nil {
+		add("announce", "%v", err)
+	}
+	if err := cfg.RelayDiscovery.Validate(); err != nil {
+		add("relay_discovery", "%v", err)
+	}
+	if cfg.EventStream.Listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.EventStream.Listen); err != nil {
+			add("event_stream.listen", "invalid listen address %q: %v", cfg.EventStream.Listen, err)
+		}
+	}
+	if cfg.Admin.Listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.Admin.Listen); err != nil {
+			add("admin.listen", "invalid listen address %q: %v", cfg.Admin.Listen, err)
+		}
+	}
+
+	if err := cfg.HistoryRetention.Validate(); err != nil {
+		add("history_retention", "%v", err)
+	}
+
+	if err := cfg.VersionPolicy.Validate(); err != nil {
+		add("version_policy", "%v", err)
+	}
+
+	if cfg.Coordination.Enabled() && !filepath.IsAbs(cfg.Coordination.LeaseFile) {
+		add("coordination.lease_file", "must be an absolute path (got %q)", cfg.Coordination.LeaseFile)
+	}
+
+	// Fleet hosts need unique names for their state files
+	names := make(map[string]bool)
+	for i, h := range cfg.Fleet.Hosts {
+		field := fmt.Sprintf("fleet.hosts[%d]", i)
+		if h.Name == "" || strings.ContainsAny(h.Name, `/\`) {
+			add(field+".name", "must be a non-empty name without path separators")
+		} else if names[h.Name] {
+			add(field+".name", "duplicate host name %q", h.Name)
+		}
+		names[h.Name] = true
+		if h.Address == "" {
+			add(field+".address", "is required")
+		}
+	}
+
+	return problems
 }
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func newConfigCommand(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Check or show the configuration",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Check the config file and print every problem found",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !validateConfigCLI(g.configDir, g.stateDir) {
+				os.Exit(1)
+			}
+		},
+	})
+	var reveal bool
+	show := &cobra.Command{
+		Use:   "show",
+		Short: "Print the resolved configuration and where each setting came from",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			showConfigCLI(g, g.configDir, g.stateDir, g.output, reveal)
+		},
+	}
+	show.Flags().BoolVar(&reveal, "reveal", false, "Print the plaintext of values encrypted with age")
+	cmd.AddCommand(show)
+	return cmd
+}
+
+// Sources a resolved setting can come from, as reported by 'config show'
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// redacted replaces secrets in 'config show' output
+const redacted = "REDACTED"
+
+// encrypted replaces values encrypted with age in 'config show' output
+// unless --reveal is given
+const encrypted = "<encrypted>"
+
+// secretWords mark setting names and URL query parameters holding secrets
+var secretWords = []string{"password", "passwd", "secret", "token", "auth", "apikey", "api_key"}
+
+// showConfigCLI prints the configuration as the manager resolves it, with
+// defaults applied, secrets redacted, and the source of every setting.
+// Values encrypted in the config file are hidden unless reveal is set.
+func showConfigCLI(g *globals, configDir, stateDir string, output string, reveal bool) {
+	cfg := loadConfig(configDir, stateDir)
+
+	var resolved yaml.Node
+	if err := resolved.Encode(cfg); err != nil {
+		log.Fatalf("[ERROR] Failed to encode config: %v", err)
+	}
+	var file map[string]any
+	if data, err := readConfigData(configDir); err == nil {
+		yaml.Unmarshal(data, &file)
+	}
+
+	hidden := make(map[string]bool)
+	if !reveal {
+		hidden = encryptedSettings(configDir)
+	}
+	sources := make(map[string]string)
+	annotateConfig(&resolved, file, "", hidden, sources)
+	dirs := map[string]string{
+		"config_dir": dirSource(g.configDirSet, "XDG_CONFIG_HOME"),
+		"state_dir":  dirSource(g.stateDirSet || g.configDirSet, "XDG_STATE_HOME"),
+	}
+
+	if output != outputJSON {
+		fmt.Printf("# config_dir: %s (%s)\n", configDir, dirs["config_dir"])
+		fmt.Printf("# state_dir: %s (%s)\n", stateDir, dirs["state_dir"])
+		data, err := yaml.Marshal(&resolved)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to encode config: %v", err)
+		}
+		fmt.Print(string(data))
+		return
+	}
+
+	// Strip the annotations and decode the YAML tree so JSON keys match the
+	// config file
+	stripComments(&resolved)
+	var doc map[string]any
+	if err := resolved.Decode(&doc); err != nil {
+		log.Fatalf("[ERROR] Failed to encode config: %v", err)
+	}
+	printJSON(map[string]any{
+		"config_dir": map[string]string{"path": configDir, "source": dirs["config_dir"]},
+		"state_dir":  map[string]string{"path": stateDir, "source": dirs["state_dir"]},
+		"config":     doc,
+		"sources":    sources,
+	})
+}
+
+// annotateConfig walks the resolved config, redacting secrets, hiding the
+// settings in hidden, and recording for every setting whether it came from
+// the file or a default. file is the matching part of the config file, or nil
+// if the file does not set it. Settings are keyed by their dotted path in
+// hidden and sources and annotated with a line comment.
+func annotateConfig(node *yaml.Node, file any, path string, hidden map[string]bool, sources map[string]string) {
+	if node.Kind == yaml.DocumentNode {
+		for _, n := range node.Content {
+			annotateConfig(n, file, path, hidden, sources)
+		}
+		return
+	}
+	fileMap, _ := file.(map[string]any)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		name := key.Value
+		if path != "" {
+			name = path + "." + key.Value
+		}
+		fileValue, inFile := fileMap[key.Value]
+
+		redactNode(value, isSecretName(key.Value))
+		hideNode(value, name, hidden)
+		if value.Kind == yaml.MappingNode && len(value.Content) > 0 {
+			annotateConfig(value, fileValue, name, hidden, sources)
+			continue
+		}
+		source := sourceDefault
+		if inFile {
+			source = sourceFile
+		}
+		sources[name] = source
+		if value.Kind == yaml.SequenceNode && len(value.Content) > 0 {
+			key.LineComment = source
+		} else {
+			value.LineComment = source
+		}
+	}
+}
+
+// redactNode replaces the secrets in a scalar or in the scalars of a list:
+// the whole value if secret is set, or else the password and secret query
+// parameters of URLs
+func redactNode(node *yaml.Node, secret bool) {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, n := range node.Content {
+			redactNode(n, secret)
+		}
+	case yaml.ScalarNode:
+		if secret && node.Value != "" {
+			node.Value = redacted
+		} else {
+			node.Value = redactURL(node.Value)
+		}
+	}
+}
+
+// hideNode replaces node, or the list items and their fields within it, with
+// a placeholder if its dotted path is in hidden
+func hideNode(node *yaml.Node, path string, hidden map[string]bool) {
+	if hidden[path] {
+		*node = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: encrypted}
+		return
+	}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			hideNode(n, path+"."+strconv.Itoa(i), hidden)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			hideNode(node.Content[i+1], path+"."+node.Content[i].Value, hidden)
+		}
+	}
+}
+
+// encryptedSettings returns the dotted paths of the values encrypted with age
+// in config.yaml, with list items keyed by their index
+func encryptedSettings(configDir string) map[string]bool {
+	paths := make(map[string]bool)
+	data, err := readSecretFile(filepath.Join(configDir, "config.yaml"), configDir)
+	if err != nil {
+		return paths
+	}
+	var doc yaml.Node
+	if yaml.Unmarshal(data, &doc) != nil {
+		return paths
+	}
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, n := range node.Content {
+				walk(n, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				name := node.Content[i].Value
+				if path != "" {
+					name = path + "." + name
+				}
+				walk(node.Content[i+1], name)
+			}
+		case yaml.SequenceNode:
+			for i, n := range node.Content {
+				walk(n, path+"."+strconv.Itoa(i))
+			}
+		case yaml.ScalarNode:
+			if isAgeValue(node) {
+				paths[path] = true
+			}
+		}
+	}
+	walk(&doc, "")
+	return paths
+}
+
+// redactURL returns s with the password and any secret query parameters
+// replaced if it is a URL, or s unchanged otherwise
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return s
+	}
+	changed := false
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+		changed = true
+	}
+	query := u.Query()
+	for name := range query {
+		if isSecretName(name) {
+			query.Set(name, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return s
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// isSecretName reports whether a setting or parameter name suggests a secret
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range secretWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripComments removes the annotations added by annotateConfig
+func stripComments(node *yaml.Node) {
+	node.LineComment = ""
+	for _, n := range node.Content {
+		stripComments(n)
+	}
+}
+
+// dirSource reports where a directory came from: a command-line flag, the
+// XDG environment variable, or the default
+func dirSource(flagSet bool, env string) string {
+	if flagSet {
+		return sourceFlag
+	}
+	if filepath.IsAbs(os.Getenv(env)) {
+		return sourceEnv
+	}
+	return sourceDefault
+}
+
+// validateConfigCLI checks the config file without modifying it and prints
+// every problem found. It returns true if the config is valid.
+func validateConfigCLI(configDir, stateDir string) bool {
+	path := filepath.Join(configDir, "config.yaml")
+
+	cfg, problems, warnings, err := checkConfigFile(configDir, stateDir)
+	if err != nil {
+		fmt.Printf("[FAIL] %v\n", err)
+		return false
+	}
+
+	for _, w := range warnings {
+		fmt.Printf("[WARN] %s\n", w)
+	}
+	if len(problems) == 0 {
+		fmt.Printf("[PASS] %s is valid (%d relay(s), %d follow(s), quorum=%d)\n", path, len(cfg.Relays), len(cfg.Follows), cfg.Quorum)
+		return true
+	}
+
+	fmt.Printf("[FAIL] %s has %d problem(s):\n", path, len(problems))
+	for _, p := range problems {
+		fmt.Printf("  - %s\n", p)
+	}
+	return false
+}
+
+// checkConfigFile decodes the config file strictly and validates it. It
+// returns the config with defaults applied, every problem found, and the
+// warnings that do not reject the config, or an error if the file cannot be
+// read or is not YAML at all.
+func checkConfigFile(configDir, stateDir string) (Config, []string, []string, error) {
+	path := filepath.Join(configDir, "config.yaml")
+
+	data, err := readConfigData(configDir)
+	if err != nil {
+		return Config{}, nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var problems []string
+
+	// Strict decoding reports unknown keys (typos) and type mismatches; yaml.v3
+	// keeps decoding after such errors so they can all be reported together
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return Config{}, nil, nil, fmt.Errorf("%s is not valid YAML: %w", path, err)
+		}
+		problems = append(problems, typeErr.Errors...)
+	}
+	cfg.ConfigPath = configDir
+	cfg.StatePath = stateDir
+	cfg.applyDefaults()
+
+	var warnings []string
+	for _, p := range validateConfig(cfg) {
+		if p.Warning {
+			warnings = append(warnings, p.String())
+		} else {
+			problems = append(problems, p.String())
+		}
+	}
+	return cfg, problems, warnings, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay connections opened through a RelayConnections across the whole
+// process: those not yet closed, and all ever opened
+var (
+	openRelayConnections   atomic.Int64
+	openedRelayConnections atomic.Int64
+)
+
+// RelayConnections owns the relay connections opened for one unit of work,
+// e.g. a poll or a publish, so they are closed together once it finishes
+// instead of lingering until the process exits
+type RelayConnections struct {
+	mu     sync.Mutex
+	relays map[string]*nostr.Relay // Open connections by relay URL
+}
+
+// newRelayConnections returns an empty connection set
+func newRelayConnections() *RelayConnections {
+	return &RelayConnections{relays: make(map[string]*nostr.Relay)}
+}
+
+// Connect returns the open connection to url, connecting first if there is
+// none or the previous one dropped. Dials run without the lock, so a slow
+// relay never holds up connections to the others; of two concurrent dials
+// to one relay the first stored wins.
+func (c *RelayConnections) Connect(ctx context.Context, url string) (*nostr.Relay, error) {
+	c.mu.Lock()
+	if r, ok := c.relays[url]; ok {
+		if r.IsConnected() {
+			c.mu.Unlock()
+			return r, nil
+		}
+		c.closeLocked(url, r)
+	}
+	c.mu.Unlock()
+
+	dialed, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.relays[url]; ok {
+		if r.IsConnected() {
+			dialed.Close()
+			return r, nil
+		}
+		c.closeLocked(url, r)
+	}
+	c.relays[url] = dialed
+	openRelayConnections.Add(1)
+	openedRelayConnections.Add(1)
+	return dialed, nil
+}
+
+// Close closes the connection to url, if one is open
+func (c *RelayConnections) Close(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r, ok := c.relays[url]; ok {
+		c.closeLocked(url, r)
+	}
+}
+
+// CloseAll closes every connection in the set
+func (c *RelayConnections) CloseAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for url, r := range c.relays {
+		c.closeLocked(url, r)
+	}
+}
+
+// Open returns how many connections in the set are open
+func (c *RelayConnections) Open() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.relays)
+}
+
+// closeLocked closes r and forgets it; c.mu must be held
+func (c *RelayConnections) closeLocked(url string, r *nostr.Relay) {
+	r.Close()
+	delete(c.relays, url)
+	openRelayConnections.Add(-1)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Correlation IDs tie together the log lines, metrics, notifications, and
+// events of one process and one action. The run ID is random per process;
+// the action ID is derived from the action key, so every node reports the
+// same ID for the same action and activity can be matched across machines
+// and relays.
+var (
+	runID = newRunID()
+
+	correlationMu sync.Mutex
+	actionID      string // ID of the action being handled, "" between actions
+)
+
+// newRunID returns a random ID for this process
+func newRunID() string {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatalf("[ERROR] Failed to generate run ID: %v", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// actionCorrelationID returns the ID of the action with the given key
+func actionCorrelationID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:6])
+}
+
+// setAction makes key the action handled from now on, or clears it if key is
+// empty, and prefixes log lines with its ID
+func setAction(key string) {
+	correlationMu.Lock()
+	defer correlationMu.Unlock()
+	actionID = ""
+	if key != "" {
+		actionID = actionCorrelationID(key)
+	}
+	log.SetPrefix(correlationPrefix())
+}
+
+// currentActionID returns the ID of the action being handled, or ""
+func currentActionID() string {
+	correlationMu.Lock()
+	defer correlationMu.Unlock()
+	return actionID
+}
+
+// correlationPrefix returns the log prefix carrying the current IDs. The
+// caller holds correlationMu.
+func correlationPrefix() string {
+	if actionID == "" {
+		return "run=" + runID + " "
+	}
+	return "run=" + runID + " action=" + actionID + " "
+}
+
+// correlationTags returns the tags carrying the current IDs, added to the
+// public events this manager builds. Encrypted DMs carry none: the action ID
+// derives from the action key, so it would reveal what the DM is about.
+func correlationTags() nostr.Tags {
+	tags := nostr.Tags{{"run", runID}}
+	if id := currentActionID(); id != "" {
+		tags = append(tags, nostr.Tag{"action", id})
+	}
+	return tags
+}
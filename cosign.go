@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/spf13/cobra"
+)
+
+func newCosignCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "cosign [signal-json | -]",
+		Short: "Co-sign signal content for a threshold-signed event",
+		Long: `Co-sign signal content with this manager's key and print the resulting tag,
+to be passed to 'send-message --cosig' by whoever publishes the
+threshold-signed event. The content is read from stdin if the argument is
+'-' or missing.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			content := "-"
+			if len(args) > 0 {
+				content = args[0]
+			}
+			cosignCLI(g.keypair, content)
+		},
+	}
+}
+
+// cosignCLI co-signs signal content with this manager's key and prints the
+// resulting tag, to be passed to 'send-message --cosig' by whoever publishes
+// the threshold-signed event
+func cosignCLI(kp Keypair, content string) {
+	if content == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to read signal from stdin: %v", err)
+		}
+		content = strings.TrimRight(string(data), "\n")
+	}
+
+	action, err := signal.Parse(content)
+	if err != nil {
+		log.Fatalf("[ERROR] Refusing to co-sign content that is not a valid signal: %v", err)
+	}
+
+	sk, err := kp.secretKey()
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	tag, err := signal.Cosign(content, sk)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to co-sign: %v", err)
+	}
+
+	out, err := json.Marshal(tag)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	log.Printf("[INFO] Co-signed %s", action.Key)
+	fmt.Println(string(out))
+}
+
+// parseCosigTags decodes the tags given with --cosig and checks that each one
+// is a valid co-signature over content
+func parseCosigTags(values []string, content string) (nostr.Tags, error) {
+	var tags nostr.Tags
+	for _, v := range values {
+		var tag nostr.Tag
+		if err := json.Unmarshal([]byte(v), &tag); err != nil {
+			return nil, fmt.Errorf("invalid --cosig %s: %w", v, err)
+		}
+		if len(tag) < 3 || tag[0] != signal.CosigTag {
+			return nil, fmt.Errorf("invalid --cosig %s: expected [\"%s\", pubkey, signature]", v, signal.CosigTag)
+		}
+		tags = append(tags, tag)
+	}
+
+	if _, errs := signal.Cosigners(&nostr.Event{Content: content, Tags: tags}); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return tags, nil
+}
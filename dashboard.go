@@ -0,0 +1,310 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// dashboardHistory is how many performed actions the dashboard lists
+const dashboardHistory = 20
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// DashboardConfig configures the web UI served in daemon mode
+type DashboardConfig struct {
+	Listen         string `yaml:"listen,omitempty"`          // Address to serve the dashboard on, e.g. "127.0.0.1:8080" (loopback if only a port is given)
+	AllowApprovals bool   `yaml:"allow_approvals,omitempty"` // Allow approving and rejecting queued actions from the UI with the admin token
+}
+
+// RelayPoll is the outcome of polling one relay
+type RelayPoll struct {
+	URL           string   `json:"url"`                  // Relay URL
+	Connected     bool     `json:"connected"`            // Subscription was established
+	ConnectMillis int64    `json:"connect_ms,omitempty"` // Time to open the connection
+	Events        int      `json:"events"`               // Events received
+	Capped        bool     `json:"capped,omitempty"`     // Reading stopped at an event limit
+	Error         string   `json:"error,omitempty"`      // Why the relay could not be polled
+	Warnings      []string `json:"warnings,omitempty"`   // Announced limitations conflicting with the manager's needs
+}
+
+// FollowStatus is a followed signer as shown on the dashboard
+type FollowStatus struct {
+	Npub      string `json:"npub"`      // Signer npub
+	Encrypted bool   `json:"encrypted"` // Signals arrive as encrypted DMs
+	Revoked   bool   `json:"revoked"`   // Key was revoked by a revoke-key action
+	Voted     int    `json:"voted"`     // Pending candidates the signer voted for
+}
+
+// CandidateStatus is a pending action with its vote progress
+type CandidateStatus struct {
+	Action  string   `json:"action"`  // Action key
+	Type    string   `json:"type"`    // "upgrade", "reboot", or "rollback"
+	Version string   `json:"version"` // Original version string
+	Votes   int      `json:"votes"`   // Signers that voted for the action
+	Quorum  int      `json:"quorum"`  // Votes required
+	Signers []string `json:"signers"` // npubs of the signers that voted
+}
+
+// ApprovalStatus is an action queued for operator approval
+type ApprovalStatus struct {
+	Action   string `json:"action"`    // Action key
+	Status   string `json:"status"`    // awaiting, approved, or rejected
+	QueuedAt string `json:"queued_at"` // ISO8601 time the action was queued
+}
+
+// DashboardState is the JSON snapshot served to the dashboard page
+type DashboardState struct {
+	Npub           string            `json:"npub"`            // This manager's public key
+	UpdatedAt      string            `json:"updated_at"`      // RFC3339 time of the last poll
+	LastRun        string            `json:"last_run"`        // Status of the most recent cycle
+	Quorum         int               `json:"quorum"`          // Votes needed for an action
+	AllowApprovals bool              `json:"allow_approvals"` // Approval buttons are enabled
+	Relays         []RelayPoll       `json:"relays"`          // Outcome of the last poll per relay
+	Follows        []FollowStatus    `json:"follows"`         // Followed signers
+	Candidates     []CandidateStatus `json:"candidates"`      // Pending actions, most votes first
+	Actions        map[string]string `json:"actions"`         // Lifecycle state of actions not yet done
+	History        []HistoryRecord   `json:"history"`         // Recently performed actions, newest first
+	Approvals      []ApprovalStatus  `json:"approvals"`       // Actions queued for approval
+}
+
+// Dashboard holds the latest snapshot of the daemon shared by the web UI and
+// the admin API
+type Dashboard struct {
+	mu       sync.Mutex
+	state    DashboardState
+	history  []HistoryRecord // Every performed action, newest first
+	stateDir string
+	logs     *logHub
+	token    string // Admin token approvals must present
+}
+
+// newDashboard returns dashboard state for the daemon of cfg
+func newDashboard(cfg Config, kp Keypair) *Dashboard {
+	return &Dashboard{
+		state: DashboardState{
+			Npub:           kp.Npub,
+			Quorum:         cfg.Quorum,
+			AllowApprovals: cfg.Dashboard.AllowApprovals,
+		},
+		stateDir: cfg.StatePath,
+		logs:     newLogHub(),
+	}
+}
+
+// PollDone records the relay poll and the candidates it produced
+func (d *Dashboard) PollDone(cfg Config, polls []RelayPoll, tally *signal.Evaluator, history *History, revoked map[string]bool) {
+	if d == nil {
+		return
+	}
+
+	voted := make(map[string]int)
+	var candidates []CandidateStatus
+	for key, action := range tally.Actions {
+		if action.Type == signal.TypeRevokeKey || history.Has(key) {
+			continue
+		}
+		c := CandidateStatus{
+			Action:  key,
+			Type:    action.Type,
+			Version: action.Version.Original(),
+			Votes:   len(tally.Votes[key]),
+			Quorum:  tally.Quorum(),
+		}
+		for pk := range tally.Votes[key] {
+			voted[pk]++
+			if npub, err := nip19.EncodePublicKey(pk); err == nil {
+				c.Signers = append(c.Signers, npub)
+			}
+		}
+		slices.Sort(c.Signers)
+		candidates = append(candidates, c)
+	}
+	slices.SortFunc(candidates, func(a, b CandidateStatus) int {
+		if a.Votes != b.Votes {
+			return b.Votes - a.Votes
+		}
+		return strings.Compare(a.Action, b.Action)
+	})
+
+	var follows []FollowStatus
+	for _, f := range cfg.Follows {
+		fs := FollowStatus{Npub: f.NPub, Encrypted: f.Encrypted}
+		if _, pk, err := nip19.Decode(f.NPub); err == nil {
+			fs.Revoked = revoked[pk.(string)]
+			fs.Voted = voted[pk.(string)]
+		}
+		follows = append(follows, fs)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	d.state.Quorum = cfg.Quorum
+	d.state.Relays = polls
+	d.state.Follows = follows
+	d.state.Candidates = candidates
+}
+
+// Refresh records the run status, history, and lifecycle state at the end
+// of a cycle
+func (d *Dashboard) Refresh(history *History, lastRun string) {
+	if d == nil {
+		return
+	}
+
+	records := historyRecords(history)
+	slices.Reverse(records)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.history = records
+	d.state.History = records[:min(len(records), dashboardHistory)]
+	d.state.Actions = history.ActiveStates()
+	d.state.LastRun = lastRun
+}
+
+// History returns every performed action as of the last cycle, newest first
+func (d *Dashboard) History() []HistoryRecord {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.history
+}
+
+// snapshot returns the current state with the approval queue read from disk
+func (d *Dashboard) snapshot() (DashboardState, error) {
+	d.mu.Lock()
+	state := d.state
+	d.mu.Unlock()
+
+	approvals, err := loadApprovals(d.stateDir)
+	if err != nil {
+		return state, err
+	}
+	for key, entry := range approvals.Entries {
+		state.Approvals = append(state.Approvals, ApprovalStatus{Action: key, Status: entry.Status, QueuedAt: entry.QueuedAt})
+	}
+	slices.SortFunc(state.Approvals, func(a, b ApprovalStatus) int { return strings.Compare(a.QueuedAt, b.QueuedAt) })
+	return state, nil
+}
+
+// handleState serves the JSON snapshot
+func (d *Dashboard) handleState(w http.ResponseWriter, _ *http.Request) {
+	state, err := d.snapshot()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// handleLogs streams log lines as server-sent events, starting with the
+// recent backlog
+func (d *Dashboard) handleLogs(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	lines, backlog := d.logs.subscribe()
+	defer d.logs.unsubscribe(lines)
+
+	for _, line := range backlog {
+		fmt.Fprintf(w, "data: %s\n\n", line)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line := <-lines:
+			fmt.Fprintf(w, "data: %s\n\n", line)
+			flusher.Flush()
+		}
+	}
+}
+
+// handleApproval approves or rejects a queued action; only registered when
+// dashboard.allow_approvals is set. The request must carry the admin token,
+// and a browser request must come from the dashboard page itself, so another
+// site the operator visits cannot submit a decision.
+func (d *Dashboard) handleApproval(w http.ResponseWriter, r *http.Request) {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+			http.Error(w, "cross-origin approvals are not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	if !hasBearerToken(r, d.token) {
+		http.Error(w, "missing or invalid admin token", http.StatusUnauthorized)
+		return
+	}
+	key := r.FormValue("action")
+	var status string
+	switch r.FormValue("decision") {
+	case "approve":
+		status = approvalApproved
+	case "reject":
+		status = approvalRejected
+	default:
+		http.Error(w, "decision must be approve or reject", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := decideApproval(d.stateDir, key, status, "the dashboard ("+r.RemoteAddr+")"); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Serve starts the dashboard on addr, on loopback if addr names only a port,
+// and mirrors the log into its live view. The server is closed when shutdown
+// is requested.
+func (d *Dashboard) Serve(addr string, shutdown *ShutdownHandler) {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	setLogOutput(io.MultiWriter(logOutput, d.logs))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+	mux.HandleFunc("GET /api/state", d.handleState)
+	mux.HandleFunc("GET /api/logs", d.handleLogs)
+	if d.state.AllowApprovals {
+		mux.HandleFunc("POST /api/approvals", d.handleApproval)
+	}
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		backgroundLog.Printf("[INFO] Serving dashboard on http://%s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			backgroundLog.Printf("[ERROR] Dashboard failed: %v", err)
+		}
+	}()
+	go func() {
+		<-shutdown.Context().Done()
+		srv.Close()
+	}()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// appDirName names the application's directory under the XDG base
+// directories
+const appDirName = "qube-manager"
+
+// profilesDirName names the directory under ~/.qube-manager holding one
+// directory per --profile
+const profilesDirName = "profiles"
+
+// configFiles are the entries of a legacy directory that belong in the
+// config dir; everything else is state
+var configFiles = []string{"config.yaml", "keys.json", adminTokenFile}
+
+// xdgDir returns $env/qube-manager, or fallback/qube-manager if env is unset
+// or not absolute, as the XDG base directory spec requires. The fallback is
+// the platform's default base directory.
+func xdgDir(env, fallback string) string {
+	if base := os.Getenv(env); filepath.IsAbs(base) {
+		return filepath.Join(base, appDirName)
+	}
+	return filepath.Join(fallback, appDirName)
+}
+
+// homeDir returns the user's home directory: $HOME on Unix, %USERPROFILE%
+// on Windows
+func homeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return os.Getenv("HOME")
+	}
+	return home
+}
+
+// legacyDir is the single directory that held config and state before the
+// XDG layout
+func legacyDir() string {
+	return filepath.Join(homeDir(), ".qube-manager")
+}
+
+// profileDir returns the directory of a named profile, holding its config,
+// keys, and state like a --config-dir, so managers for several networks or
+// nodes run side by side
+func profileDir(name string) string {
+	return filepath.Join(legacyDir(), profilesDirName, name)
+}
+
+// resolveDirs returns the config and state directories for the given
+// --config-dir and --state-dir flags (empty if not set). Without flags the
+// XDG directories are used, migrating a legacy ~/.qube-manager into them on
+// first use; if it cannot be migrated it is used in place. An explicit
+// --config-dir without --state-dir keeps state next to the config, as
+// before.
+func resolveDirs(configFlag, stateFlag string) (string, string) {
+	switch {
+	case configFlag != "" && stateFlag != "":
+		return configFlag, stateFlag
+	case configFlag != "":
+		return configFlag, configFlag
+	}
+
+	configDir := xdgDir("XDG_CONFIG_HOME", defaultConfigBase())
+	stateDir := stateFlag
+	if stateDir == "" {
+		stateDir = xdgDir("XDG_STATE_HOME", defaultStateBase())
+	}
+	legacy := legacyDir()
+	if err := migrateLegacyDir(legacy, configDir, stateDir); err != nil {
+		if errors.Is(err, errMigrationIncomplete) {
+			log.Fatalf("[ERROR] Failed to migrate %s: %v - move the remaining files by hand", legacy, err)
+		}
+		log.Printf("[WARN] Not migrating %s: %v - using it in place", legacy, err)
+		if stateFlag == "" {
+			stateDir = legacy
+		}
+		return legacy, stateDir
+	}
+	return configDir, stateDir
+}
+
+// errMigrationIncomplete reports a migration that moved some files of the
+// legacy directory and could not move them back
+var errMigrationIncomplete = errors.New("migration incomplete")
+
+// migrateLegacyDir moves the config and keys of a legacy directory to
+// configDir and everything else to stateDir, then removes it. Profiles stay
+// where they are. Nothing is moved if any file already exists at its
+// destination, and files moved before a failure are moved back, so the
+// legacy directory stays usable whenever an error is returned that is not
+// errMigrationIncomplete.
+func migrateLegacyDir(legacy, configDir, stateDir string) error {
+	entries, err := os.ReadDir(legacy)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries = slices.DeleteFunc(entries, func(e os.DirEntry) bool { return e.Name() == profilesDirName })
+	if len(entries) == 0 {
+		return nil
+	}
+
+	dests := make(map[string]string, len(entries))
+	for _, e := range entries {
+		dest := filepath.Join(stateDir, e.Name())
+		if slices.Contains(configFiles, e.Name()) {
+			dest = filepath.Join(configDir, e.Name())
+		}
+		if _, err := os.Lstat(dest); err == nil {
+			return fmt.Errorf("%s already exists", dest)
+		}
+		dests[e.Name()] = dest
+	}
+
+	log.Printf("[INFO] Migrating %s to %s (config) and %s (state)", legacy, configDir, stateDir)
+	for _, dir := range []string{configDir, stateDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	var moved []string
+	for _, e := range entries {
+		src := filepath.Join(legacy, e.Name())
+		if err := os.Rename(src, dests[e.Name()]); err != nil {
+			for _, name := range moved {
+				if undo := os.Rename(dests[name], filepath.Join(legacy, name)); undo != nil {
+					return fmt.Errorf("%w: %v, and moving %s back failed: %v", errMigrationIncomplete, err, name, undo)
+				}
+			}
+			return err
+		}
+		moved = append(moved, e.Name())
+	}
+	if _, err := os.Stat(filepath.Join(legacy, profilesDirName)); err == nil {
+		return nil
+	}
+	if err := os.Remove(legacy); err != nil {
+		log.Printf("[WARN] Failed to remove %s after migrating it: %v", legacy, err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "path/filepath"
+
+// defaultConfigBase is the base of the config dir when XDG_CONFIG_HOME is
+// unset, ~/.config as the XDG base directory spec requires
+func defaultConfigBase() string {
+	return filepath.Join(homeDir(), ".config")
+}
+
+// defaultStateBase is the base of the state dir when XDG_STATE_HOME is unset
+func defaultStateBase() string {
+	return filepath.Join(homeDir(), ".local", "state")
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// defaultConfigBase is the base of the config dir when XDG_CONFIG_HOME is
+// unset: the roaming %AppData% directory
+func defaultConfigBase() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return dir
+	}
+	return filepath.Join(homeDir(), "AppData", "Roaming")
+}
+
+// defaultStateBase is the base of the state dir when XDG_STATE_HOME is unset:
+// the machine-local %LocalAppData% directory
+func defaultStateBase() string {
+	if dir := os.Getenv("LocalAppData"); filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(homeDir(), "AppData", "Local")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
+	"github.com/nbd-wtf/go-nostr/nip59"
+)
+
+// errWrongChannel is returned by signalContent when a follow signals over a
+// channel other than the one configured for it
+var errWrongChannel = errors.New("signal received over the wrong channel")
+
+// secretKey returns the hex private key of the keypair
+func (kp Keypair) secretKey() (string, error) {
+	_, sk, err := nip19.Decode(kp.Nsec)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	return sk.(string), nil
+}
+
+// publicKey returns the hex public key of the keypair
+func (kp Keypair) publicKey() (string, error) {
+	sk, err := kp.secretKey()
+	if err != nil {
+		return "", err
+	}
+	return nostr.GetPublicKey(sk)
+}
+
+// signalContent returns the plaintext signal carried by an event: the content
+// of a public note (kind 1 or an extra subscribed kind), or of a direct
+// message unwrapped from a gift wrap addressed to this manager.
+// Encrypted follows may only signal via DM and other follows only publicly.
+func signalContent(ev *nostr.Event, encrypted map[string]bool) (string, error) {
+	if ev.Kind == nostr.KindDirectMessage {
+		if !encrypted[ev.PubKey] {
+			return "", fmt.Errorf("%w: direct message from follow %s not configured as encrypted", errWrongChannel, ev.PubKey)
+		}
+		return ev.Content, nil
+	}
+
+	// Any other subscribed kind is a public note
+	if encrypted[ev.PubKey] {
+		return "", fmt.Errorf("%w: public note from encrypted follow %s", errWrongChannel, ev.PubKey)
+	}
+	return ev.Content, nil
+}
+
+// unwrapDM opens a NIP-17 gift wrap addressed to the keypair and returns the
+// direct message (a kind 14 rumor) inside. The rumor is unsigned; its author
+// is the signer of the seal around it, whose signature is checked here.
+func unwrapDM(ev *nostr.Event, kp Keypair) (*nostr.Event, error) {
+	sk, err := kp.secretKey()
+	if err != nil {
+		return nil, err
+	}
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	if tag := ev.Tags.FindWithValue("p", pk); tag == nil {
+		return nil, errors.New("gift wrap is not addressed to this manager")
+	}
+
+	rumor, err := nip59.GiftUnwrap(*ev, func(sender, ciphertext string) (string, error) {
+		key, err := nip44.GenerateConversationKey(sender, sk)
+		if err != nil {
+			return "", err
+		}
+		return nip44.Decrypt(ciphertext, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rumor.Kind != nostr.KindDirectMessage {
+		return nil, fmt.Errorf("gift wrap holds a kind %d event, not a direct message", rumor.Kind)
+	}
+	return &rumor, nil
+}
+
+// newDirectMessage returns an unsigned NIP-17 direct message (a kind 14
+// rumor) from the keypair to recipient. Tags are added to the rumor before
+// it is gift-wrapped with wrapDM.
+func newDirectMessage(kp Keypair, recipient string, plaintext string, createdAt nostr.Timestamp) (nostr.Event, error) {
+	pk, err := kp.publicKey()
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	return nostr.Event{
+		PubKey:    pk,
+		CreatedAt: createdAt,
+		Kind:      nostr.KindDirectMessage,
+		Tags:      nostr.Tags{{"p", recipient}},
+		Content:   plaintext,
+	}, nil
+}
+
+// wrapDM seals rumor with the keypair and gift-wraps it for recipient, both
+// layers encrypted with NIP-44 as NIP-59 describes. The gift wrap is signed
+// with a throwaway key and must not be signed again.
+func wrapDM(kp Keypair, rumor nostr.Event, recipient string) (nostr.Event, error) {
+	sk, err := kp.secretKey()
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	key, err := nip44.GenerateConversationKey(recipient, sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	rumor.ID = rumor.GetID()
+	return nip59.GiftWrap(rumor, recipient,
+		func(plaintext string) (string, error) { return nip44.Encrypt(plaintext, key) },
+		func(seal *nostr.Event) error { return seal.Sign(sk) },
+		nil)
+}
+
+// newEncryptedDM builds a direct message to recipient with plaintext and
+// tags, gift-wrapped and ready to publish
+func newEncryptedDM(kp Keypair, recipient string, plaintext string, tags nostr.Tags, createdAt nostr.Timestamp) (nostr.Event, error) {
+	rumor, err := newDirectMessage(kp, recipient, plaintext, createdAt)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	rumor.Tags = append(rumor.Tags, tags...)
+	return wrapDM(kp, rumor, recipient)
+}
+
+// splitVotes separates votes cast publicly from votes cast by encrypted DM
+func splitVotes(votes map[string]signal.Vote, encrypted map[string]bool) (public, private map[string]signal.Vote) {
+	public = make(map[string]signal.Vote)
+	private = make(map[string]signal.Vote)
+	for pk, v := range votes {
+		if encrypted[pk] {
+			private[pk] = v
+		} else {
+			public[pk] = v
+		}
+	}
+	return public, private
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+)
+
+// Outcomes of a doctor check
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// Free space below which the doctor warns, or fails
+const (
+	doctorLowDiskBytes      = 1 << 30
+	doctorCriticalDiskBytes = 100 << 20
+)
+
+// DoctorCheck is the outcome of one doctor check
+type DoctorCheck struct {
+	Name   string `json:"name"`           // What was checked
+	Status string `json:"status"`         // pass, warn, fail, or skip
+	Detail string `json:"detail"`         // What was found
+	Hint   string `json:"hint,omitempty"` // How to fix a warning or failure
+}
+
+func newDoctorCommand(g *globals) *cobra.Command {
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose the config, key, relays, executor, and host, with hints for what fails",
+		Long: `Diagnose the config, key, relays, executor, and host, with hints for what fails.
+The key is checked without being created, so doctor is safe to run before the
+first start. Exits 22 if any check fails.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipKeypairAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			if !doctorCLI(g.configDir, g.stateDir, g.output, timeout, g.verbose) {
+				os.Exit(exitCheckFailed)
+			}
+		},
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "Time allowed for each network check")
+	return cmd
+}
+
+// doctorCLI runs every check and prints a report. It returns true if no
+// check failed.
+func doctorCLI(configDir, stateDir, output string, timeout time.Duration, verbose bool) bool {
+	var checks []DoctorCheck
+	add := func(name, status, detail, hint string) {
+		checks = append(checks, DoctorCheck{Name: name, Status: status, Detail: detail, Hint: hint})
+	}
+
+	cfg, problems, warnings, err := checkConfigFile(configDir, stateDir)
+	switch {
+	case err != nil:
+		add("config", checkFail, err.Error(), "create config.yaml or fix its syntax; 'qube-manager config show' prints the effective config")
+	case len(problems) > 0:
+		add("config", checkFail, strings.Join(problems, "; "), "fix the listed keys; 'qube-manager config validate' lists them one per line")
+	case len(warnings) > 0:
+		add("config", checkWarn, strings.Join(warnings, "; "), "the config is accepted, but the listed keys are likely mistakes")
+	default:
+		add("config", checkPass, fmt.Sprintf("%d relay(s), %d follow(s), quorum=%d", len(cfg.Relays), len(cfg.Follows), cfg.Quorum), "")
+	}
+	loaded := err == nil
+
+	checks = append(checks, doctorKey(configDir))
+	if !loaded {
+		add("relays", checkSkip, "config could not be loaded", "")
+		add("executor", checkSkip, "config could not be loaded", "")
+		add("permissions", checkSkip, "config could not be loaded", "")
+	} else {
+		checks = append(checks, doctorRelays(cfg, timeout)...)
+		checks = append(checks, doctorExecutor(cfg)...)
+		checks = append(checks, doctorPermissions(cfg))
+	}
+	checks = append(checks, doctorDisk(cfg, stateDir)...)
+	if loaded {
+		checks = append(checks, doctorClock(cfg, verbose))
+	}
+
+	passed := true
+	for _, c := range checks {
+		passed = passed && c.Status != checkFail
+	}
+
+	if output == outputJSON {
+		printJSON(checks)
+		return passed
+	}
+	for _, c := range checks {
+		fmt.Printf("[%s] %-18s %s\n", strings.ToUpper(c.Status), c.Name, c.Detail)
+		if c.Hint != "" {
+			fmt.Printf("       %-18s hint: %s\n", "", c.Hint)
+		}
+	}
+	return passed
+}
+
+// doctorKey checks that the private key can be read from the configured key
+// store and, when kept in keys.json, that only its owner can read the file
+func doctorKey(configDir string) DoctorCheck {
+	c := DoctorCheck{Name: "key"}
+	path := filepath.Join(configDir, "keys.json")
+
+	if configuredKeyStore(configDir) == keyStoreKeyring {
+		nsec, err := keyring.Get(keyringService, keyringUser(configDir, defaultIdentity))
+		switch {
+		case errors.Is(err, keyring.ErrNotFound):
+			c.Status, c.Detail = checkWarn, "no private key in the OS keyring yet"
+			c.Hint = "one is created on the first run, or restore yours with 'qube-manager keys restore'"
+		case err != nil:
+			c.Status, c.Detail = checkFail, fmt.Sprintf("OS keyring unavailable: %v", err)
+			c.Hint = "unlock the keyring or set key_store: file"
+		case npubFromNsec(nsec) == "":
+			c.Status, c.Detail = checkFail, "the OS keyring holds an invalid private key"
+			c.Hint = "restore the key with 'qube-manager keys restore --force'"
+		default:
+			c.Status, c.Detail = checkPass, fmt.Sprintf("%s in the OS keyring", npubFromNsec(nsec))
+		}
+		return c
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		c.Status, c.Detail = checkWarn, fmt.Sprintf("%s does not exist yet", path)
+		c.Hint = "one is created on the first run, or restore yours with 'qube-manager keys restore'"
+		return c
+	} else if err != nil {
+		c.Status, c.Detail = checkFail, err.Error()
+		c.Hint = "make the config dir readable by the user running the manager"
+		return c
+	}
+
+	f, err := readKeyFile(configDir)
+	if err != nil {
+		c.Status, c.Detail = checkFail, fmt.Sprintf("cannot read %s: %v", path, err)
+		c.Hint = "check the file owner, or the age identity if the file is encrypted"
+		return c
+	}
+	kp := f.Keypair
+	if npubFromNsec(kp.Nsec) == "" {
+		c.Status, c.Detail = checkFail, fmt.Sprintf("%s holds no valid private key", path)
+		c.Hint = "restore the key with 'qube-manager keys restore --force'"
+		return c
+	}
+
+	c.Status, c.Detail = checkPass, fmt.Sprintf("%s in %s", npubFromNsec(kp.Nsec), path)
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		c.Status = checkWarn
+		c.Detail += fmt.Sprintf(" is readable by others (mode %04o)", info.Mode().Perm())
+		c.Hint = "chmod 600 " + path
+	}
+	return c
+}
+
+// doctorPermissions runs the startup security checks, which keep the
+// manager from executing actions while they fail
+func doctorPermissions(cfg Config) DoctorCheck {
+	c := DoctorCheck{Name: "permissions"}
+	switch problems := securityProblems(cfg); {
+	case !unixPermissions:
+		c.Status, c.Detail = checkSkip, "file permissions are not checked on "+runtime.GOOS
+	case len(problems) > 0:
+		c.Status, c.Detail = checkFail, strings.Join(problems, "; ")
+		c.Hint = "actions are only observed until this passes, unless the manager runs with --force"
+	default:
+		c.Status, c.Detail = checkPass, "keys.json, config dir, and deployment scripts are protected"
+	}
+	return c
+}
+
+// doctorRelays checks that every configured relay accepts a connection
+func doctorRelays(cfg Config, timeout time.Duration) []DoctorCheck {
+	if len(cfg.Relays) == 0 {
+		return []DoctorCheck{{Name: "relays", Status: checkFail, Detail: "no relays configured", Hint: "add relays to config.yaml"}}
+	}
+
+	checks := make([]DoctorCheck, len(cfg.Relays))
+	var wg sync.WaitGroup
+	for i, url := range cfg.relayURLs() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := DoctorCheck{Name: "relay " + url}
+			ctx, cancel := context.WithTimeout(context.Background(), min(cfg.ConnectTimeout, timeout))
+			defer cancel()
+			start := time.Now()
+			relay, err := nostr.RelayConnect(ctx, url)
+			if err != nil {
+				c.Status, c.Detail = checkFail, err.Error()
+				c.Hint = "check the URL and outbound network access; 'qube-manager relays test' runs deeper checks"
+			} else {
+				relay.Close()
+				c.Status, c.Detail = checkPass, fmt.Sprintf("connected in %dms", time.Since(start).Milliseconds())
+			}
+			checks[i] = c
+		}()
+	}
+	wg.Wait()
+	return checks
+}
+
+// doctorExecutor checks the executor configuration, which covers the
+// presence and executability of the deployment script, the privileges the
+// backend needs, and the state of the node's systemd unit
+func doctorExecutor(cfg Config) []DoctorCheck {
+	if cfg.ObserveOnly {
+		return []DoctorCheck{{Name: "executor", Status: checkSkip, Detail: "observe_only is set"}}
+	}
+	if cfg.Fleet.Enabled() {
+		_, err := newFleet(cfg.Fleet, cfg.Executor)
+		if err != nil {
+			return []DoctorCheck{{Name: "executor", Status: checkFail, Detail: err.Error(), Hint: "fix the fleet or executor settings"}}
+		}
+		return []DoctorCheck{{Name: "executor", Status: checkPass, Detail: fmt.Sprintf("%s over SSH on %d host(s)", cfg.Executor.Type, len(cfg.Fleet.Hosts))}}
+	}
+
+	ex, err := newExecutor(cfg.Executor)
+	switch {
+	case isUnsupportedPlatform(err):
+		return []DoctorCheck{{Name: "executor", Status: checkWarn, Detail: err.Error() + " - the manager will only observe", Hint: "run the manager on the node host, or set observe_only"}}
+	case err != nil:
+		return []DoctorCheck{{Name: "executor", Status: checkFail, Detail: err.Error(), Hint: "fix the executor settings in config.yaml"}}
+	case ex == nil:
+		return []DoctorCheck{{Name: "executor", Status: checkWarn, Detail: "no executor configured - actions are only recorded", Hint: "set executor.type to shell, docker, systemd, kubernetes, or helper"}}
+	}
+
+	checks := []DoctorCheck{{Name: "executor", Status: checkPass, Detail: ex.Name() + " executor configured"}}
+	checks = append(checks, doctorPrivileges(cfg.Executor))
+	switch cfg.Executor.Type {
+	case "systemd":
+		checks = append(checks, doctorUnit(cfg.Executor.Systemd.Unit))
+	case "kubernetes":
+		checks = append(checks, doctorKubernetes(cfg.Executor.Kubernetes))
+	case "helper":
+		checks = append(checks, doctorHelper(cfg.Executor.Helper))
+	}
+	return checks
+}
+
+// doctorPrivileges checks that the manager runs with the privileges its
+// executor needs: root to swap binaries and restart units, and root or
+// membership of the docker group to control containers
+func doctorPrivileges(cfg ExecutorConfig) DoctorCheck {
+	c := DoctorCheck{Name: "privileges"}
+	uid := os.Geteuid()
+	switch {
+	case uid < 0:
+		c.Status, c.Detail = checkSkip, "not checked on this platform"
+	case cfg.Type == "helper" && uid == 0:
+		c.Status, c.Detail = checkWarn, "running as root although the executor helper performs actions"
+		c.Hint = "run the manager as an unprivileged user so only the helper has root"
+	case cfg.Type == "helper":
+		c.Status, c.Detail = checkPass, fmt.Sprintf("running as uid %d; the executor helper performs actions as root", uid)
+	case cfg.Type == "kubernetes":
+		c.Status, c.Detail = checkPass, fmt.Sprintf("running as uid %d; kubectl acts with the cluster credentials", uid)
+	case uid == 0:
+		c.Status, c.Detail = checkPass, "running as root"
+	case cfg.Type == "systemd":
+		c.Status, c.Detail = checkFail, fmt.Sprintf("running as uid %d; the systemd executor replaces %s and restarts %s", uid, cfg.Systemd.BinaryPath, cfg.Systemd.Unit)
+		c.Hint = "run the manager as root, e.g. from its systemd unit"
+	case cfg.Type == "docker":
+		c.Status, c.Detail = checkWarn, fmt.Sprintf("running as uid %d", uid)
+		c.Hint = "the user needs access to the docker daemon, e.g. membership of the docker group"
+	default:
+		c.Status, c.Detail = checkPass, fmt.Sprintf("running as uid %d; the deployment script runs with the same privileges", uid)
+	}
+	return c
+}
+
+// doctorKubernetes checks that kubectl may patch the node workload
+func doctorKubernetes(cfg KubernetesExecutorConfig) DoctorCheck {
+	c := DoctorCheck{Name: "kubernetes"}
+	argv := cfg.kubectl("auth", "can-i", "patch", cfg.Workload)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).Output()
+	answer := strings.TrimSpace(string(out))
+	switch {
+	case answer == "yes":
+		c.Status, c.Detail = checkPass, "kubectl may patch "+cfg.Workload
+	case answer == "no":
+		c.Status, c.Detail = checkFail, "kubectl may not patch "+cfg.Workload
+		c.Hint = "grant the manager's service account patch on the workload and apply on its ConfigMap and qubemanagers"
+	default:
+		c.Status, c.Detail = checkFail, fmt.Sprintf("cannot query the cluster: %v", commandError(err))
+		c.Hint = "check that kubectl is on PATH and can reach the cluster"
+	}
+	return c
+}
+
+// doctorHelper checks that the executor helper accepts connections on its
+// socket. The connection is closed without a request.
+func doctorHelper(cfg HelperExecutorConfig) DoctorCheck {
+	c := DoctorCheck{Name: "executor helper"}
+	conn, err := net.DialTimeout("unix", cfg.socket(), 5*time.Second)
+	if err != nil {
+		c.Status, c.Detail = checkFail, err.Error()
+		c.Hint = "start 'qube-manager executor-helper' as root, and set executor.helper.group to a group of this user"
+		return c
+	}
+	conn.Close()
+	c.Status, c.Detail = checkPass, "listening on "+cfg.socket()
+	return c
+}
+
+// doctorUnit checks that the node's systemd unit is active
+func doctorUnit(unit string) DoctorCheck {
+	c := DoctorCheck{Name: "systemd unit"}
+	out, err := exec.Command("systemctl", "is-active", unit).Output()
+	state := strings.TrimSpace(string(out))
+	switch {
+	case state == "active":
+		c.Status, c.Detail = checkPass, unit+" is active"
+	case state != "":
+		c.Status, c.Detail = checkFail, fmt.Sprintf("%s is %s", unit, state)
+		c.Hint = fmt.Sprintf("inspect it with 'systemctl status %s' and 'journalctl -u %s'", unit, unit)
+	default:
+		c.Status, c.Detail = checkFail, fmt.Sprintf("cannot query %s: %v", unit, err)
+		c.Hint = "check that systemd runs on this host and the unit name is right"
+	}
+	return c
+}
+
+// doctorDisk checks the free space on the filesystems of the state dir and,
+// if telemetry or backups name one, the node data dir
+func doctorDisk(cfg Config, stateDir string) []DoctorCheck {
+	dirs := []string{stateDir}
+	for _, dir := range []string{cfg.Telemetry.DataDir, cfg.Backup.DataDir} {
+		if dir != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var checks []DoctorCheck
+	for _, dir := range dirs {
+		c := DoctorCheck{Name: "disk " + dir}
+		used, total, err := diskUsage(dir)
+		switch {
+		case err != nil:
+			c.Status, c.Detail = checkSkip, err.Error()
+		case total-used < doctorCriticalDiskBytes:
+			c.Status, c.Detail = checkFail, fmt.Sprintf("%s free of %s", formatBytes(total-used), formatBytes(total))
+			c.Hint = "free up space; actions, backups, and logs need room to write"
+		case total-used < doctorLowDiskBytes:
+			c.Status, c.Detail = checkWarn, fmt.Sprintf("%s free of %s", formatBytes(total-used), formatBytes(total))
+			c.Hint = "free up space before the next upgrade or backup"
+		default:
+			c.Status, c.Detail = checkPass, fmt.Sprintf("%s free of %s", formatBytes(total-used), formatBytes(total))
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// doctorClock checks the local clock against NTP or relay time
+func doctorClock(cfg Config, verbose bool) DoctorCheck {
+	c := DoctorCheck{Name: "clock"}
+	if cfg.ClockCheck.Disabled {
+		c.Status, c.Detail = checkSkip, "clock_check.disabled is set"
+		return c
+	}
+	skew, source, err := clockSkew(cfg.ClockCheck, cfg.relayURLs(), verbose)
+	switch {
+	case err != nil:
+		c.Status, c.Detail = checkWarn, err.Error()
+		c.Hint = "allow outbound NTP (UDP 123) or set clock_check.ntp_server to a reachable server"
+	case skew.Abs() > cfg.ClockCheck.MaxSkew:
+		c.Status, c.Detail = checkFail, fmt.Sprintf("off by %v from %s (max_skew %v)", skew.Round(time.Millisecond), source, cfg.ClockCheck.MaxSkew)
+		c.Hint = "synchronize the clock, e.g. enable NTP with 'timedatectl set-ntp true'"
+	default:
+		c.Status, c.Detail = checkPass, fmt.Sprintf("within %v of %s", skew.Abs().Round(time.Millisecond), source)
+	}
+	return c
+}
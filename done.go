@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// doneProtectedFields are the done content fields that identify the event as
+// a completion of an action. Recovery, reports, and coordinators rely on
+// them, so the done template can neither change nor omit them.
+var doneProtectedFields = []string{"type", "schemaVersion", "version", "extraData"}
+
+// DoneTemplate customizes the done event published after an action. Fields
+// outside the protected ones may be omitted or set; omitting fields that are
+// part of the action key, such as genesis or network, keeps history recovery
+// and reports from matching the event to its action.
+type DoneTemplate struct {
+	Omit   []string          `yaml:"omit,omitempty"`   // Content fields left out, e.g. [network, cohort]
+	Fields map[string]string `yaml:"fields,omitempty"` // Content fields added or replaced, e.g. {operator: eu-1}
+
+	// Do not reply to the signals that triggered the action or mention
+	// their signers, so the done event is not threaded to them
+	OmitSignalTags bool `yaml:"omit_signal_tags,omitempty"`
+}
+
+// empty reports whether the template leaves the done event unchanged
+func (t DoneTemplate) empty() bool {
+	return len(t.Omit) == 0 && len(t.Fields) == 0 && !t.OmitSignalTags
+}
+
+// validate reports fields the template may not touch
+func (t DoneTemplate) validate() []configProblem {
+	var problems []configProblem
+	for i, f := range t.Omit {
+		if slices.Contains(doneProtectedFields, f) {
+			problems = append(problems, configProblem{Field: fmt.Sprintf("done_template.omit[%d]", i), Message: fmt.Sprintf("%s identifies the done event and cannot be omitted", f)})
+		}
+	}
+	for f := range t.Fields {
+		if slices.Contains(doneProtectedFields, f) {
+			problems = append(problems, configProblem{Field: "done_template.fields." + f, Message: fmt.Sprintf("%s identifies the done event and cannot be set", f)})
+		}
+	}
+	return problems
+}
+
+// apply rewrites the content and tags of the unsigned done event ev
+func (t DoneTemplate) apply(ev *nostr.Event) error {
+	if t.empty() {
+		return nil
+	}
+	if t.OmitSignalTags {
+		ev.Tags = slices.DeleteFunc(ev.Tags, func(tag nostr.Tag) bool {
+			return len(tag) > 0 && (tag[0] == "e" || tag[0] == "p")
+		})
+	}
+	if len(t.Omit) == 0 && len(t.Fields) == 0 {
+		return nil
+	}
+
+	var content map[string]any
+	if err := json.Unmarshal([]byte(ev.Content), &content); err != nil {
+		return fmt.Errorf("failed to apply done template: %w", err)
+	}
+	for _, f := range t.Omit {
+		delete(content, f)
+	}
+	for f, v := range t.Fields {
+		content[f] = v
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("failed to apply done template: %w", err)
+	}
+	ev.Content = string(data)
+	return nil
+}
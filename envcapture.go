@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// recordedEnvVars are the inherited environment variables whose values are
+// recorded with an execution; others are listed by name only, as they may
+// hold secrets
+var recordedEnvVars = []string{"PATH", "HOME", "USER", "SHELL", "LANG", "LC_ALL", "TZ", "GOPATH", "GOROOT", "GOFLAGS", "GOTOOLCHAIN"}
+
+// ExecutionEnvironment records what an action was executed with, so an
+// execution that behaved differently on two hosts can be compared
+type ExecutionEnvironment struct {
+	Action        string            `json:"action"`                   // Key of the executed action
+	CapturedAt    string            `json:"captured_at"`              // RFC3339 time execution started
+	Executor      string            `json:"executor"`                 // Executor backend
+	Hostname      string            `json:"hostname,omitempty"`       // Host the manager runs on
+	OS            string            `json:"os"`                       // GOOS/GOARCH of the manager
+	OSRelease     string            `json:"os_release,omitempty"`     // PRETTY_NAME from /etc/os-release
+	Kernel        string            `json:"kernel,omitempty"`         // Kernel release
+	Manager       string            `json:"manager"`                  // qube-manager release
+	ManagerGo     string            `json:"manager_go"`               // Go version qube-manager was built with
+	HostGo        string            `json:"host_go,omitempty"`        // 'go version' of the host, which the shell executor builds the node with
+	NodeVersion   string            `json:"node_version,omitempty"`   // Node version running before execution
+	Script        string            `json:"script,omitempty"`         // Deployment script run by the shell executor
+	ScriptSHA256  string            `json:"script_sha256,omitempty"`  // SHA-256 of the deployment script
+	Interpreter   []string          `json:"interpreter,omitempty"`    // Command the script is run with
+	Env           map[string]string `json:"env"`                      // Variables set for step commands, and recorded inherited ones
+	InheritedVars []string          `json:"inherited_vars,omitempty"` // Names of the other inherited variables
+	Steps         []EnvironmentStep `json:"steps"`                    // Steps in order with their exact command lines
+}
+
+// EnvironmentStep is a step of the execution and the command it runs
+type EnvironmentStep struct {
+	Name    string   `json:"name"`              // Step name
+	Command []string `json:"command,omitempty"` // argv, none for built-in steps
+}
+
+// stepsRecorder is called by executeAction with the executor and the steps
+// it is about to run
+type stepsRecorder func(ex Executor, steps []Step)
+
+// environmentRecorder returns the recorder that saves the environment of an
+// execution of action started at now: next to the action log as the log's
+// name with .env.json instead of .log, or named like an action log in
+// actions/ if none is open. Fleet hosts each get a file of their own.
+func environmentRecorder(cfg Config, exCfg ExecutorConfig, action *signal.Action, actionLog *ActionLog, now time.Time) stepsRecorder {
+	base := filepath.Join(actionLogDir, fmt.Sprintf("%s-%s", actionLogName(action.Key), now.UTC().Format("20060102T150405Z")))
+	if actionLog != nil {
+		base = strings.TrimSuffix(actionLog.Path(), ".log")
+	}
+	return func(ex Executor, steps []Step) {
+		path := base
+		if remote, ok := ex.(*RemoteExecutor); ok {
+			path += "-" + actionLogName(remote.host.Name)
+		}
+		path += ".env.json"
+		if err := captureEnvironment(cfg, exCfg, ex, action, steps).save(filepath.Join(cfg.StatePath, path)); err != nil {
+			log.Printf("[WARN] Failed to record execution environment: %v", err)
+			return
+		}
+		log.Printf("[INFO] Recorded execution environment in %s", path)
+	}
+}
+
+// captureEnvironment describes the environment steps run in on this host.
+// It must not fail execution, so sources that cannot be read are left out.
+func captureEnvironment(cfg Config, exCfg ExecutorConfig, ex Executor, action *signal.Action, steps []Step) *ExecutionEnvironment {
+	env := &ExecutionEnvironment{
+		Action:     action.Key,
+		CapturedAt: time.Now().UTC().Format(time.RFC3339),
+		Executor:   ex.Name(),
+		OS:         runtime.GOOS + "/" + runtime.GOARCH,
+		OSRelease:  osRelease(),
+		Kernel:     kernelRelease(),
+		Manager:    managerVersion(),
+		ManagerGo:  runtime.Version(),
+		Env:        make(map[string]string),
+	}
+	env.Hostname, _ = os.Hostname()
+	if v, _, err := detectNodeVersion(cfg.Node); err == nil {
+		env.NodeVersion = v.Original()
+	}
+	if exCfg.Type == "shell" {
+		env.HostGo = hostGoVersion()
+		env.Script = exCfg.Shell.Script
+		env.ScriptSHA256 = fileSHA256(exCfg.Shell.Script)
+		env.Interpreter = exCfg.Shell.Interpreter
+	}
+
+	for _, kv := range stepEnv(action.Key) {
+		name, value, _ := strings.Cut(kv, "=")
+		switch {
+		case strings.HasPrefix(name, "QUBE_MANAGER_"), slices.Contains(recordedEnvVars, name):
+			env.Env[name] = value
+		case name != "":
+			env.InheritedVars = append(env.InheritedVars, name)
+		}
+	}
+	slices.Sort(env.InheritedVars)
+
+	for _, step := range steps {
+		env.Steps = append(env.Steps, EnvironmentStep{Name: step.Name, Command: step.Command})
+	}
+	return env
+}
+
+// stepEnv returns the environment step commands of the action with key run
+// with
+func stepEnv(key string) []string {
+	// Step commands can tag what they log or report with the same IDs
+	return append(os.Environ(), "QUBE_MANAGER_RUN_ID="+runID, "QUBE_MANAGER_ACTION_ID="+actionCorrelationID(key))
+}
+
+// save writes the environment as JSON to path, readable only by the owner as
+// it lists the host's environment
+func (e *ExecutionEnvironment) save(path string) error {
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// osRelease returns the distribution name from /etc/os-release
+func osRelease() string {
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return ""
+}
+
+// kernelRelease returns the running Linux kernel release
+func kernelRelease() string {
+	data, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// hostGoVersion returns the output of 'go version', empty if Go is not
+// installed
+func hostGoVersion() string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "go", "version").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path, empty if it cannot
+// be read
+func fileSHA256(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
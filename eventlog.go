@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// LoggedEvent is a single line of the event log
+type LoggedEvent struct {
+	ReceivedAt string       `json:"received_at"` // ISO8601 timestamp the event was accepted
+	Relay      string       `json:"relay"`       // Relay the event was received from
+	Event      *nostr.Event `json:"event"`       // Raw signed event
+}
+
+// EventLog is an append-only JSON Lines file of every accepted signal event,
+// kept for offline analysis and replay
+type EventLog struct {
+	path string
+	seen map[string]bool // IDs already in the file, so relays re-sending old events don't duplicate them
+}
+
+// eventLogPath returns the default location of the event log
+func eventLogPath(stateDir string) string {
+	return filepath.Join(stateDir, "events.jsonl")
+}
+
+// openEventLog loads the IDs of events already recorded in the state dir
+func openEventLog(stateDir string) *EventLog {
+	l := &EventLog{
+		path: eventLogPath(stateDir),
+		seen: make(map[string]bool),
+	}
+
+	entries, err := readEventLog(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] Failed to read event log %s: %v", l.path, err)
+	}
+	for _, e := range entries {
+		l.seen[e.Event.ID] = true
+	}
+	return l
+}
+
+// Append records an event unless it is already in the log
+func (l *EventLog) Append(ev *nostr.Event, relayURL string) error {
+	if l.seen[ev.ID] {
+		return nil
+	}
+
+	line, err := json.Marshal(LoggedEvent{
+		ReceivedAt: time.Now().UTC().Format(time.RFC3339),
+		Relay:      relayURL,
+		Event:      ev,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	l.seen[ev.ID] = true
+	return nil
+}
+
+// readEventLog parses every line of an event log file
+func readEventLog(path string) ([]LoggedEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []LoggedEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e LoggedEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.Event == nil {
+			return entries, fmt.Errorf("%s line %d: invalid event log entry", path, lineNo)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Types of the events on the live feed
+const (
+	streamSignal    = "signal"    // a signal event that passed validation and counted as a vote
+	streamLifecycle = "lifecycle" // an action moved to another lifecycle state
+	streamRun       = "run"       // an evaluation cycle finished
+)
+
+// Limits of the live feed
+const (
+	streamBuffer = 256  // Events a slow consumer may fall behind before it misses events
+	streamSeen   = 4096 // Signal events remembered so a later poll does not emit them again
+)
+
+// StreamConfig configures the live feed of validated events served in
+// daemon mode, so dashboards and bots consume the manager's validation
+// instead of subscribing to relays themselves
+type StreamConfig struct {
+	Listen string `yaml:"listen,omitempty"` // Address serving the WebSocket feed at /v1/events, e.g. "127.0.0.1:9092" (loopback if only a port is given)
+}
+
+// StreamEvent is one JSON message on the live feed
+type StreamEvent struct {
+	Type     string          `json:"type"`                // "signal", "lifecycle", or "run"
+	Time     string          `json:"time"`                // RFC3339 time the manager emitted the event
+	RunID    string          `json:"run_id"`              // ID of the manager process
+	ActionID string          `json:"action_id,omitempty"` // ID of the action concerned
+	Action   string          `json:"action,omitempty"`    // Key of the action concerned
+	State    string          `json:"state,omitempty"`     // Lifecycle state entered, or status of the finished run
+	Error    string          `json:"error,omitempty"`     // Why the action failed
+	Votes    int             `json:"votes,omitempty"`     // Votes the action has after this signal
+	Quorum   int             `json:"quorum,omitempty"`    // Votes the action needs
+	Relay    string          `json:"relay,omitempty"`     // Relay the signal was received from
+	Content  json.RawMessage `json:"content,omitempty"`   // Signal message, left out for encrypted DMs
+	Event    *nostr.Event    `json:"event,omitempty"`     // Signal event as signed by its author
+}
+
+// EventStream fans out feed events to the connected consumers. A nil
+// stream drops every event, so callers need no checks when the feed is off.
+type EventStream struct {
+	mu    sync.Mutex
+	subs  map[chan StreamEvent]struct{}
+	token string          // Admin token consumers must present
+	seen  map[string]bool // IDs of the signal events emitted
+	order []string        // seen in the order emitted, oldest first
+}
+
+func newEventStream(token string) *EventStream {
+	return &EventStream{subs: make(map[chan StreamEvent]struct{}), token: token, seen: make(map[string]bool)}
+}
+
+// publish passes ev to every consumer. Consumers that fell behind miss it
+// rather than blocking the manager.
+func (s *EventStream) publish(ev StreamEvent) {
+	if s == nil {
+		return
+	}
+	ev.Time = time.Now().UTC().Format(time.RFC3339)
+	ev.RunID = runID
+	if ev.ActionID == "" && ev.Action != "" {
+		ev.ActionID = actionCorrelationID(ev.Action)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Signal emits a validated signal event and the vote count of its action,
+// unless it was emitted before: every poll receives the signals in its
+// window again. The decrypted content of a DM is not passed on.
+func (s *EventStream) Signal(ev *nostr.Event, content, relay string, action *signal.Action, votes, quorum int) {
+	if s == nil || !s.firstSeen(ev.ID) {
+		return
+	}
+	se := StreamEvent{Type: streamSignal, Action: action.Key, Votes: votes, Quorum: quorum, Relay: relay, Event: ev}
+	if ev.Kind != nostr.KindGiftWrap && json.Valid([]byte(content)) {
+		se.Content = json.RawMessage(content)
+	}
+	s.publish(se)
+}
+
+// firstSeen records the signal event with id as emitted, reporting false if
+// it already was. The oldest IDs are forgotten beyond streamSeen.
+func (s *EventStream) firstSeen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[id] {
+		return false
+	}
+	s.seen[id] = true
+	s.order = append(s.order, id)
+	if len(s.order) > streamSeen {
+		delete(s.seen, s.order[0])
+		s.order = s.order[1:]
+	}
+	return true
+}
+
+// Lifecycle emits a lifecycle transition of the action with key
+func (s *EventStream) Lifecycle(key, state string, cause error) {
+	se := StreamEvent{Type: streamLifecycle, Action: key, State: state}
+	if cause != nil {
+		se.Error = cause.Error()
+	}
+	s.publish(se)
+}
+
+// Run emits the status of a finished cycle and the action it selected
+func (s *EventStream) Run(status, action string) {
+	s.publish(StreamEvent{Type: streamRun, State: status, Action: action})
+}
+
+// subscribe returns a channel receiving every event from now on
+func (s *EventStream) subscribe() chan StreamEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ch := make(chan StreamEvent, streamBuffer)
+	s.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe stops delivering events to ch
+func (s *EventStream) unsubscribe(ch chan StreamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, ch)
+}
+
+// handleEvents upgrades the request to a WebSocket and writes every feed
+// event as a JSON text message until the consumer disconnects. Messages
+// from the consumer are ignored. Consumers must present the admin token.
+func (s *EventStream) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !hasBearerToken(r, s.token) {
+		http.Error(w, "missing or invalid admin token", http.StatusUnauthorized)
+		return
+	}
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseNow()
+	ctx := conn.CloseRead(r.Context())
+
+	events := s.subscribe()
+	defer s.unsubscribe(events)
+	backgroundLog.Printf("[INFO] Event stream consumer connected from %s", r.RemoteAddr)
+
+	for {
+		select {
+		case <-ctx.Done():
+			backgroundLog.Printf("[INFO] Event stream consumer %s disconnected", r.RemoteAddr)
+			return
+		case ev := <-events:
+			writeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			err := wsjson.Write(writeCtx, conn, ev)
+			cancel()
+			if err != nil {
+				backgroundLog.Printf("[WARN] Dropping event stream consumer %s: %v", r.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
+
+// Serve starts the feed on addr, on loopback if addr names only a port. The
+// server is closed when shutdown is requested.
+func (s *EventStream) Serve(addr string, shutdown *ShutdownHandler) {
+	if host, port, err := net.SplitHostPort(addr); err == nil && host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/events", s.handleEvents)
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		backgroundLog.Printf("[INFO] Serving event stream on ws://%s/v1/events", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			backgroundLog.Printf("[ERROR] Event stream failed: %v", err)
+		}
+	}()
+	go func() {
+		<-shutdown.Context().Done()
+		srv.Close()
+	}()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/hypercore-one/qube-manager/signal"
+	"gopkg.in/yaml.v3"
+)
+
+// Execution and step status values persisted in the execution state file
+const (
+	execRunning   = "running"
+	execFailed    = "failed"
+	execCompleted = "completed"
+
+	stepPending = "pending"
+	stepDone    = "done"
+	stepFailed  = "failed"
+)
+
+// StepState records the progress of a single executor step
+type StepState struct {
+	Name     string `yaml:"name"`            // Step name from the executor plan
+	Status   string `yaml:"status"`          // pending, done, or failed
+	Attempts int    `yaml:"attempts"`        // Total attempts across all runs
+	Error    string `yaml:"error,omitempty"` // Last error message, if any
+}
+
+// ExecutionState tracks an action's progress through its executor steps so a
+// failed or interrupted execution resumes from the failed step instead of
+// leaving the node half torn down with no record
+type ExecutionState struct {
+	Action    string                 `yaml:"action"`             // Action key
+	Type      string                 `yaml:"type"`               // "upgrade", "reboot", or "rollback"
+	Version   string                 `yaml:"version"`            // Original version string
+	Genesis   string                 `yaml:"genesis,omitempty"`  // Genesis URL for reboots
+	Source    *signal.Source         `yaml:"source,omitempty"`   // Pinned source of the upgrade, if any
+	Artifact  *signal.Artifact       `yaml:"artifact,omitempty"` // Announced binary or genesis download, if any
+	Service   string                 `yaml:"service,omitempty"`  // Co-hosted service the action applies to ("" for the node)
+	Executor  string                 `yaml:"executor"`           // Executor backend name
+	Status    string                 `yaml:"status"`             // running, failed, or completed
+	UpdatedAt string                 `yaml:"updated_at"`         // ISO8601 timestamp of last change
+	Steps     []StepState            `yaml:"steps"`              // Per-step progress
+	Votes     map[string]signal.Vote `yaml:"votes,omitempty"`    // Votes that triggered the action, for the done event
+	path      string                 // state file path (not in YAML)
+}
+
+// executionStatePath returns the location of the execution state file
+func executionStatePath(stateDir string) string {
+	return filepath.Join(stateDir, "execution.yaml")
+}
+
+// loadExecutionState reads the execution state file at path, returning nil
+// if there is no execution in progress
+func loadExecutionState(path string) (*ExecutionState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read execution state %s: %w", path, err)
+	}
+
+	var s ExecutionState
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse execution state %s: %w", path, err)
+	}
+	s.path = path
+	return &s, nil
+}
+
+// pendingExecution returns the incomplete execution, if any. State left behind
+// by an action that already made it into history is removed.
+func pendingExecution(stateDir string, history *History) *ExecutionState {
+	state, err := loadExecutionState(executionStatePath(stateDir))
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+		return nil
+	}
+	if state == nil {
+		return nil
+	}
+	if history.Has(state.Action) {
+		log.Printf("[INFO] Removing execution state for %s, which is already in history", state.Action)
+		if err := state.Clear(); err != nil {
+			log.Printf("[WARN] Failed to clear execution state: %v", err)
+		}
+		return nil
+	}
+	return state
+}
+
+// prepareExecution returns the state to execute action with: the state
+// persisted at path if it belongs to the same action, otherwise a fresh one.
+// It refuses to start a different action while another execution is
+// incomplete.
+func prepareExecution(path string, ex Executor, action *signal.Action, votes map[string]signal.Vote) (*ExecutionState, error) {
+	existing, err := loadExecutionState(path)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing.Action != action.Key {
+			return nil, fmt.Errorf("execution of %s is incomplete (status %s); run 'qube-manager resume-action' or discard it with 'qube-manager resume-action --discard'",
+				existing.Action, existing.Status)
+		}
+		log.Printf("[INFO] Resuming execution of %s (status %s)", existing.Action, existing.Status)
+		return existing, nil
+	}
+
+	steps, err := ex.Steps(action)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ExecutionState{
+		Action:   action.Key,
+		Type:     action.Type,
+		Version:  action.Version.Original(),
+		Genesis:  action.Genesis,
+		Source:   action.Source,
+		Artifact: action.Artifact,
+		Service:  action.Service,
+		Executor: ex.Name(),
+		Status:   execRunning,
+		Votes:    votes,
+		path:     path,
+	}
+	for _, step := range steps {
+		s.Steps = append(s.Steps, StepState{Name: step.Name, Status: stepPending})
+	}
+	return s, s.Save()
+}
+
+// CandidateAction reconstructs the action this state belongs to
+func (s *ExecutionState) CandidateAction() (*signal.Action, error) {
+	v, err := semver.NewVersion(s.Version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version %s in execution state: %w", s.Version, err)
+	}
+	return &signal.Action{
+		Type:     s.Type,
+		Version:  v,
+		Key:      s.Action,
+		Genesis:  s.Genesis,
+		Source:   s.Source,
+		Artifact: s.Artifact,
+		Service:  s.Service,
+	}, nil
+}
+
+// Save writes the execution state to disk
+func (s *ExecutionState) Save() error {
+	s.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write execution state %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// Clear removes the execution state file once the action is recorded in history
+func (s *ExecutionState) Clear() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// matchSteps lines the persisted steps up with the executor's plan by name,
+// so a resumed execution survives steps added since it started, such as a
+// new verification step. Added steps start out pending. A persisted step
+// missing from the plan means the plan changed, for example after a config
+// edit, and is an error.
+func (s *ExecutionState) matchSteps(steps []Step) error {
+	used := make([]bool, len(s.Steps))
+	matched := make([]StepState, 0, len(steps))
+	for _, step := range steps {
+		ss := StepState{Name: step.Name, Status: stepPending}
+		for j, persisted := range s.Steps {
+			if !used[j] && persisted.Name == step.Name {
+				used[j] = true
+				ss = persisted
+				break
+			}
+		}
+		matched = append(matched, ss)
+	}
+	for j, persisted := range s.Steps {
+		if !used[j] {
+			return fmt.Errorf("execution state for %s records step %s, which is no longer in the executor plan; discard it with 'qube-manager resume-action --discard'",
+				s.Action, persisted.Name)
+		}
+	}
+	s.Steps = matched
+	return nil
+}
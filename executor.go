@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// ExecutorConfig selects and configures the backend that performs actions
+type ExecutorConfig struct {
+	Type        string                   `yaml:"type"`                   // "shell", "docker", "systemd", "kubernetes", "helper", or empty to disable execution
+	MaxAttempts int                      `yaml:"max_attempts,omitempty"` // Attempts per step before the execution fails
+	RetryDelay  time.Duration            `yaml:"retry_delay,omitempty"`  // Pause between attempts of a failed step
+	StepTimeout time.Duration            `yaml:"step_timeout,omitempty"` // Longest a step attempt may run before its processes are killed (default 1h)
+	Shell       ShellExecutorConfig      `yaml:"shell,omitempty"`        // settings for the zenon.sh backend
+	Docker      DockerExecutorConfig     `yaml:"docker,omitempty"`       // settings for the Docker backend
+	Systemd     SystemdExecutorConfig    `yaml:"systemd,omitempty"`      // settings for the systemd backend
+	Kubernetes  KubernetesExecutorConfig `yaml:"kubernetes,omitempty"`   // settings for the Kubernetes backend
+	Helper      HelperExecutorConfig     `yaml:"helper,omitempty"`       // socket of the privileged executor helper
+	Backup      BackupConfig             `yaml:"-"`                      // top-level backup settings, for the node's own executor only
+	Artifacts   ArtifactConfig           `yaml:"artifacts,omitempty"`    // mirrors, cache, and rate limit for genesis and binary downloads
+}
+
+// applyDefaults fills in unset retry, timeout, and cache settings
+func (c *ExecutorConfig) applyDefaults(stateDir string) {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultExecutorMaxAttempts
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = defaultExecutorRetryDelay
+	}
+	if c.StepTimeout <= 0 {
+		c.StepTimeout = defaultExecutorStepTimeout
+	}
+	if c.Artifacts.Dir == "" && stateDir != "" {
+		c.Artifacts.Dir = filepath.Join(stateDir, "artifacts")
+	}
+}
+
+// Step is a single unit of work performed while executing an action
+type Step struct {
+	Name    string                          // Short step name used in logs, e.g. "stop"
+	Command []string                        // External command to run (argv)
+	Run     func(ctx context.Context) error // In-process step, used when Command is empty
+	Recheck bool                            // Run again even if a resumed execution records it as done, for integrity checks
+	File    *StepFile                       // File an in-process step downloads or verifies, so a remote host can do the same
+}
+
+// StepFile is an announced file an in-process step places at Path and
+// checks against SHA256
+type StepFile struct {
+	URLs   []string // Mirrors to download from, none if the step only verifies the file
+	SHA256 string   // Announced SHA-256
+	Path   string   // Where the file ends up
+}
+
+// Executor turns a selected action into the steps that apply it to the node
+type Executor interface {
+	Name() string                                // Backend name for logging
+	Steps(action *signal.Action) ([]Step, error) // Ordered steps for the action
+	Validate() error                             // Checks the backend configuration
+}
+
+// newExecutor returns the executor backend selected in config, or nil if
+// execution is disabled. An error wrapping errUnsupportedPlatform means the
+// backend cannot run on this platform.
+func newExecutor(cfg ExecutorConfig) (Executor, error) {
+	ex, err := selectExecutor(cfg)
+	if ex == nil || err != nil {
+		return nil, err
+	}
+
+	if err := ex.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s executor config: %w", ex.Name(), err)
+	}
+	if err := checkPlatform(ex, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Artifacts.Validate(); err != nil {
+		return nil, err
+	}
+	return ex, nil
+}
+
+// selectExecutor returns the unvalidated backend selected in config, or nil
+// if execution is disabled
+func selectExecutor(cfg ExecutorConfig) (Executor, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "shell":
+		return &ShellExecutor{cfg: cfg.Shell, backup: cfg.Backup, artifacts: cfg.Artifacts}, nil
+	case "docker":
+		return &DockerExecutor{cfg: cfg.Docker, backup: cfg.Backup, artifacts: cfg.Artifacts}, nil
+	case "systemd":
+		return &SystemdExecutor{cfg: cfg.Systemd, artifacts: cfg.Artifacts}, nil
+	case "kubernetes":
+		return &KubernetesExecutor{cfg: cfg.Kubernetes, artifacts: cfg.Artifacts}, nil
+	case "helper":
+		return &HelperExecutor{cfg: cfg.Helper}, nil
+	default:
+		return nil, fmt.Errorf("unknown executor type %q (expected shell, docker, systemd, kubernetes, or helper)", cfg.Type)
+	}
+}
+
+// executeAction runs the action's steps in order, skipping steps the
+// execution state already records as done and retrying a failed step up to
+// the configured number of attempts. Progress is persisted after every
+// attempt so a later run can resume from the failed step. record, if not nil,
+// is called with the steps before the first one runs.
+func executeAction(ctx context.Context, ex Executor, action *signal.Action, state *ExecutionState, cfg ExecutorConfig, record stepsRecorder) error {
+	steps, err := ex.Steps(action)
+	if err != nil {
+		return err
+	}
+	if err := state.matchSteps(steps); err != nil {
+		return err
+	}
+	if record != nil {
+		record(ex, steps)
+	}
+
+	state.Status = execRunning
+	if err := state.Save(); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Executing %s with %s executor (%d steps)", action.Key, ex.Name(), len(steps))
+	for i, step := range steps {
+		ss := &state.Steps[i]
+		if ss.Status == stepDone && !step.Recheck {
+			log.Printf("[INFO] Step %d/%d: %s already done, skipping", i+1, len(steps), step.Name)
+			continue
+		}
+
+		for attempt := 1; ; attempt++ {
+			ss.Attempts++
+			log.Printf("[INFO] Step %d/%d: %s (attempt %d/%d)", i+1, len(steps), step.Name, attempt, cfg.MaxAttempts)
+			err := runStep(ctx, step, action.Key, cfg.StepTimeout)
+			if err == nil {
+				ss.Status = stepDone
+				ss.Error = ""
+				if err := state.Save(); err != nil {
+					return err
+				}
+				break
+			}
+
+			ss.Status = stepFailed
+			ss.Error = err.Error()
+			if attempt >= cfg.MaxAttempts {
+				state.Status = execFailed
+				if saveErr := state.Save(); saveErr != nil {
+					log.Printf("[WARN] Failed to save execution state: %v", saveErr)
+				}
+				return fmt.Errorf("step %s failed after %d attempt(s): %w", step.Name, attempt, err)
+			}
+			if err := state.Save(); err != nil {
+				return err
+			}
+
+			log.Printf("[WARN] Step %s failed: %v - retrying in %v", step.Name, err, cfg.RetryDelay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(cfg.RetryDelay):
+			}
+		}
+	}
+
+	state.Status = execCompleted
+	if err := state.Save(); err != nil {
+		return err
+	}
+	log.Printf("[INFO] Execution of %s completed", action.Key)
+	return nil
+}
+
+// stepOutputGrace is how long a killed step's output may keep draining
+// before its pipes are closed
+const stepOutputGrace = 10 * time.Second
+
+// runStep performs a single step, either in-process or as an external command,
+// giving up after timeout (unlimited if zero). Command output is streamed
+// into the log line by line, prefixed with the action key and step name, and
+// the command's whole process group is killed when the step times out or the
+// manager shuts down.
+func runStep(ctx context.Context, step Step, key string, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var err error
+	if len(step.Command) == 0 {
+		if step.Run != nil {
+			err = step.Run(ctx)
+		}
+	} else {
+		log.Printf("[INFO] Running: %s", strings.Join(step.Command, " "))
+		cmd := exec.CommandContext(ctx, step.Command[0], step.Command[1:]...)
+		killProcessGroup(cmd)
+		cmd.WaitDelay = stepOutputGrace
+		cmd.Env = stepEnv(key)
+		prefix := fmt.Sprintf("[%s] %s", key, step.Name)
+		stdout, stderr := newLineLogger(prefix), newLineLogger(prefix+" (stderr)")
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		err = cmd.Run()
+		stdout.Flush()
+		stderr.Flush()
+	}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+	return err
+}
+
+// lineLogger writes every complete line written to it to the log
+type lineLogger struct {
+	prefix string
+	buf    []byte
+}
+
+func newLineLogger(prefix string) *lineLogger {
+	return &lineLogger{prefix: prefix}
+}
+
+func (w *lineLogger) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		log.Printf("[INFO] %s: %s", w.prefix, strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Flush logs a trailing line that did not end with a newline
+func (w *lineLogger) Flush() {
+	if len(w.buf) > 0 {
+		log.Printf("[INFO] %s: %s", w.prefix, w.buf)
+		w.buf = nil
+	}
+}
+
+// describeSteps returns a human-readable plan for dry runs
+func describeSteps(steps []Step) []string {
+	lines := make([]string, 0, len(steps))
+	for _, step := range steps {
+		if len(step.Command) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", step.Name, strings.Join(step.Command, " ")))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: (built-in)", step.Name))
+		}
+	}
+	return lines
+}
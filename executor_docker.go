@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// Environment the Docker Compose backend passes to "docker compose", for the
+// compose file to reference as ${QUBE_NODE_IMAGE} and ${QUBE_NODE_GENESIS}
+const (
+	composeImageEnv   = "QUBE_NODE_IMAGE"
+	composeGenesisEnv = "QUBE_NODE_GENESIS"
+)
+
+// defaultGenesisMount is where the genesis file is mounted in the node
+// container when genesis_mount is unset
+const defaultGenesisMount = "/genesis.json"
+
+// DockerExecutorConfig configures the Docker backend
+type DockerExecutorConfig struct {
+	Image     string   `yaml:"image"`               // Image repository; the announced version is used as the tag
+	Container string   `yaml:"container,omitempty"` // Name of the node container (not with compose_file)
+	RunArgs   []string `yaml:"run_args,omitempty"`  // Extra arguments for "docker run" (volumes, ports, ...)
+
+	// Docker Compose file defining the node as service. The file selects the
+	// image with ${QUBE_NODE_IMAGE} and mounts the genesis file from
+	// ${QUBE_NODE_GENESIS}; the container is recreated with "docker compose up".
+	ComposeFile string `yaml:"compose_file,omitempty"`
+	Service     string `yaml:"service,omitempty"` // Compose service running the node (required with compose_file)
+
+	// Refuse images whose digest was not announced in the signal; announced
+	// digests are always checked
+	RequireDigest bool `yaml:"require_digest,omitempty"`
+
+	// Host file the verified genesis is installed to on reboots, mounted
+	// read-only into the container at genesis_mount once it exists
+	GenesisPath  string `yaml:"genesis_path,omitempty"`
+	GenesisMount string `yaml:"genesis_mount,omitempty"` // Container path of the genesis file (default /genesis.json)
+
+	// Host directory bind-mounted as the node data, cleared on reboots so the
+	// node resyncs from the new genesis (left alone if unset)
+	DataDir string `yaml:"data_dir,omitempty"`
+}
+
+// DockerExecutor applies actions by pulling the image tagged with the
+// announced version and recreating the node container, either directly or
+// as a Docker Compose service
+type DockerExecutor struct {
+	cfg       DockerExecutorConfig
+	backup    BackupConfig
+	artifacts ArtifactConfig
+}
+
+func (e *DockerExecutor) Name() string { return "docker" }
+
+// Validate checks that the image and the container or compose service are
+// configured
+func (e *DockerExecutor) Validate() error {
+	if e.cfg.Image == "" {
+		return errors.New("image is required")
+	}
+	if e.cfg.ComposeFile != "" {
+		if e.cfg.Service == "" {
+			return errors.New("service is required with compose_file")
+		}
+		if e.cfg.Container != "" || len(e.cfg.RunArgs) > 0 {
+			return errors.New("container and run_args do not apply with compose_file; configure the service in the compose file")
+		}
+	} else if e.cfg.Container == "" {
+		return errors.New("container is required")
+	}
+	if e.cfg.GenesisPath != "" && !filepath.IsAbs(e.cfg.GenesisPath) {
+		return fmt.Errorf("genesis_path must be an absolute path (got %q)", e.cfg.GenesisPath)
+	}
+	if e.cfg.DataDir != "" && !filepath.IsAbs(e.cfg.DataDir) {
+		return fmt.Errorf("data_dir must be an absolute path (got %q)", e.cfg.DataDir)
+	}
+	return nil
+}
+
+// imageRevisionScript fails unless image $1 carries the OCI revision label $2
+const imageRevisionScript = `actual=$(docker image inspect --format '{{index .Config.Labels "org.opencontainers.image.revision"}}' "$1") || exit 1
+if [ "$actual" != "$2" ]; then
+  echo "image $1 was built from revision '$actual', expected $2" >&2
+  exit 1
+fi`
+
+// imageDigestScript fails unless image $1 was pulled with digest $2
+const imageDigestScript = `digests=$(docker image inspect --format '{{range .RepoDigests}}{{println .}}{{end}}' "$1") || exit 1
+case "$digests" in
+  *"@$2"*) ;;
+  *) echo "image $1 has digest(s) $(echo $digests), expected $2" >&2; exit 1 ;;
+esac`
+
+// genesisFetchScript downloads genesis URL $1 to $2 through a temporary
+// file. It serves genesis files announced without a hash, which cannot be
+// fetched through the artifact cache.
+const genesisFetchScript = `set -e
+mkdir -p "$(dirname "$2")"
+curl -fsSL -o "$2.tmp" "$1"
+mv "$2.tmp" "$2"`
+
+// genesisRunScript starts the node container with "docker run -d" and the
+// remaining arguments, mounting genesis file $1 read-only at $2 once it
+// exists; before the first reboot installs it there is nothing to mount
+const genesisRunScript = `genesis=$1 mount=$2
+shift 2
+if [ -f "$genesis" ]; then
+  exec docker run -d -v "$genesis:$mount:ro" "$@"
+fi
+exec docker run -d "$@"`
+
+// absentContainerScript runs "docker $1 $2" on container $2 and succeeds if
+// the container does not exist, as on a first deploy
+const absentContainerScript = `out=$(docker "$1" "$2" 2>&1) && { echo "$out"; exit 0; }
+case "$out" in
+  *"No such container"*) echo "container $2 does not exist, nothing to $1"; exit 0 ;;
+esac
+echo "$out" >&2
+exit 1`
+
+// clearDataScript deletes the contents of data directory $1, keeping the
+// directory itself so its bind mount stays valid
+const clearDataScript = `set -e
+[ -d "$1" ] || exit 0
+find "$1" -mindepth 1 -maxdepth 1 -exec rm -rf {} +`
+
+// Steps pulls the image tagged with the announced version, checks it against
+// the announced digest and, for upgrades pinned to a source commit, its
+// org.opencontainers.image.revision label, and recreates the container on
+// it; rollbacks do the same with the earlier version, keeping data in the
+// container's volumes. Reboots also stop the node, back up and clear the
+// data directory if configured, and install the verified genesis file, which
+// the recreated container mounts.
+func (e *DockerExecutor) Steps(action *signal.Action) ([]Step, error) {
+	switch action.Type {
+	case "upgrade", "rollback":
+	case "reboot":
+		if e.cfg.GenesisPath == "" {
+			return nil, errors.New("docker executor needs genesis_path for reboot actions")
+		}
+	default:
+		return nil, fmt.Errorf("docker executor does not support %s actions", action.Type)
+	}
+	if e.cfg.RequireDigest && action.ImageDigest == "" {
+		return nil, fmt.Errorf("signal announces no image digest and require_digest is set")
+	}
+
+	image := fmt.Sprintf("%s:%s", e.cfg.Image, action.Version.Original())
+	steps := []Step{{Name: "pull", Command: e.pullCommand(image)}}
+	if action.ImageDigest != "" {
+		steps = append(steps, Step{Name: "verify-digest", Command: []string{"sh", "-c", imageDigestScript, "verify-digest", image, action.ImageDigest}})
+	}
+	if action.Source != nil {
+		steps = append(steps, Step{Name: "verify-source", Command: []string{"sh", "-c", imageRevisionScript, "verify-source", image, action.Source.Commit}})
+	}
+
+	if action.Type == "reboot" {
+		steps = append(steps, Step{Name: "stop", Command: e.stopCommand()})
+		if e.backup.Enabled {
+			backup := e.backup
+			if backup.DataDir == "" {
+				backup.DataDir = e.cfg.DataDir
+			}
+			steps = append(steps, backupStep(backup, action))
+		}
+		steps = append(steps, e.genesisStep(action))
+		if e.cfg.DataDir != "" {
+			steps = append(steps, Step{Name: "clear-data", Command: []string{"sh", "-c", clearDataScript, "clear-data", e.cfg.DataDir}})
+		}
+	}
+
+	if e.cfg.ComposeFile != "" {
+		return append(steps, Step{Name: "recreate", Command: e.composeCommand(image, "up", "-d", "--no-deps", "--force-recreate", e.cfg.Service)}), nil
+	}
+
+	run := []string{"docker", "run", "-d", "--name", e.cfg.Container, "--restart", "unless-stopped"}
+	if e.cfg.GenesisPath != "" {
+		run = []string{"sh", "-c", genesisRunScript, "run", e.cfg.GenesisPath, e.genesisMount(), "--name", e.cfg.Container, "--restart", "unless-stopped"}
+	}
+	run = append(run, e.cfg.RunArgs...)
+	run = append(run, image)
+	if action.Type != "reboot" {
+		steps = append(steps, Step{Name: "stop", Command: e.stopCommand()})
+	}
+	return append(steps,
+		Step{Name: "remove", Command: []string{"sh", "-c", absentContainerScript, "remove", "rm", e.cfg.Container}},
+		Step{Name: "run", Command: run},
+	), nil
+}
+
+// genesisStep installs the reboot's genesis file at genesis_path. A genesis
+// announced with a hash is fetched through the artifact cache and verified.
+func (e *DockerExecutor) genesisStep(action *signal.Action) Step {
+	if action.Artifact == nil {
+		return Step{Name: "genesis", Command: []string{"sh", "-c", genesisFetchScript, "genesis", action.Genesis, e.cfg.GenesisPath}}
+	}
+	urls, sum := artifactSources(action.Artifact)
+	return Step{Name: "genesis", File: &StepFile{URLs: urls, SHA256: sum, Path: e.cfg.GenesisPath}, Run: func(ctx context.Context) error {
+		path, err := fetchArtifact(ctx, e.artifacts, urls, sum)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(e.cfg.GenesisPath), 0755); err != nil {
+			return err
+		}
+		return copyVerified(path, e.cfg.GenesisPath, 0644, sum)
+	}}
+}
+
+// genesisMount returns the container path of the genesis file
+func (e *DockerExecutor) genesisMount() string {
+	if e.cfg.GenesisMount != "" {
+		return e.cfg.GenesisMount
+	}
+	return defaultGenesisMount
+}
+
+// pullCommand pulls image, through Compose if the node is a Compose service
+func (e *DockerExecutor) pullCommand(image string) []string {
+	if e.cfg.ComposeFile != "" {
+		return e.composeCommand(image, "pull", e.cfg.Service)
+	}
+	return []string{"docker", "pull", image}
+}
+
+// stopCommand stops the node container, if there is one
+func (e *DockerExecutor) stopCommand() []string {
+	if e.cfg.ComposeFile != "" {
+		return []string{"docker", "compose", "-f", e.cfg.ComposeFile, "stop", e.cfg.Service}
+	}
+	return []string{"sh", "-c", absentContainerScript, "stop", "stop", e.cfg.Container}
+}
+
+// composeCommand runs "docker compose" on the compose file with the image
+// and genesis file in its environment. It goes through env(1) so the
+// command also runs unchanged on fleet hosts over SSH.
+func (e *DockerExecutor) composeCommand(image string, args ...string) []string {
+	cmd := []string{"env", composeImageEnv + "=" + image}
+	if e.cfg.GenesisPath != "" {
+		cmd = append(cmd, composeGenesisEnv+"="+e.cfg.GenesisPath)
+	}
+	cmd = append(cmd, "docker", "compose", "-f", e.cfg.ComposeFile)
+	return append(cmd, args...)
+}
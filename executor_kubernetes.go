@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/spf13/cobra"
+)
+
+// Defaults of the Kubernetes backend
+const (
+	defaultRolloutTimeout = 10 * time.Minute
+	genesisConfigMapKey   = "genesis.json"
+	statusPublishTimeout  = 10 * time.Second // Longest a cycle waits for the QubeManager status to be written
+)
+
+// KubernetesExecutorConfig configures the Kubernetes backend, which runs the
+// manager in the cluster and drives the node workload with kubectl. In a pod
+// kubectl authenticates with the pod's service account.
+type KubernetesExecutorConfig struct {
+	Image     string `yaml:"image"`               // Image repository; the announced version is used as the tag
+	Namespace string `yaml:"namespace,omitempty"` // Namespace of the workload (the pod's own namespace if empty)
+	Workload  string `yaml:"workload"`            // statefulset/NAME or deployment/NAME running hqzd
+	Container string `yaml:"container"`           // Container of the pod template running hqzd
+
+	// Refuse images whose digest was not announced in the signal; announced
+	// digests are always pinned in the pod template
+	RequireDigest bool `yaml:"require_digest,omitempty"`
+
+	// ConfigMap the verified genesis is stored in on reboots, under the key
+	// genesis.json, for the pods to mount. ConfigMaps hold at most 1 MiB.
+	GenesisConfigMap string `yaml:"genesis_configmap,omitempty"`
+
+	RolloutTimeout time.Duration `yaml:"rollout_timeout,omitempty"` // Longest a rollout may take (default 10m)
+
+	// QubeManager resource the status of every run is published to (none
+	// if empty); create its definition with 'qube-manager kubernetes-crd'
+	StatusResource string `yaml:"status_resource,omitempty"`
+}
+
+// kubectl returns the argv running kubectl in the configured namespace
+func (c KubernetesExecutorConfig) kubectl(args ...string) []string {
+	cmd := []string{"kubectl"}
+	if c.Namespace != "" {
+		cmd = append(cmd, "--namespace", c.Namespace)
+	}
+	return append(cmd, args...)
+}
+
+// KubernetesExecutor applies actions by rolling the node workload to the
+// image tagged with the announced version
+type KubernetesExecutor struct {
+	cfg       KubernetesExecutorConfig
+	artifacts ArtifactConfig
+}
+
+func (e *KubernetesExecutor) Name() string { return "kubernetes" }
+
+// Validate checks that the image, workload, and container are configured
+func (e *KubernetesExecutor) Validate() error {
+	if e.cfg.Image == "" {
+		return errors.New("image is required")
+	}
+	kind, name, _ := strings.Cut(e.cfg.Workload, "/")
+	if (kind != "statefulset" && kind != "deployment") || name == "" {
+		return fmt.Errorf("workload must be statefulset/NAME or deployment/NAME (got %q)", e.cfg.Workload)
+	}
+	if e.cfg.Container == "" {
+		return errors.New("container is required")
+	}
+	return nil
+}
+
+// Steps points the workload's node container at the image tagged with the
+// announced version, pinned to the announced digest, and waits for the
+// rollout; rollbacks do the same with the earlier version, keeping the data
+// in the pods' volumes. Reboots first store the verified genesis in the
+// genesis ConfigMap and restart the pods so they read it even if the image
+// is unchanged. Clearing chain data for the new genesis is left to the pods,
+// e.g. to an init container.
+func (e *KubernetesExecutor) Steps(action *signal.Action) ([]Step, error) {
+	switch action.Type {
+	case "upgrade", "rollback":
+	case "reboot":
+		if e.cfg.GenesisConfigMap == "" {
+			return nil, errors.New("kubernetes executor needs genesis_configmap for reboot actions")
+		}
+	default:
+		return nil, fmt.Errorf("kubernetes executor does not support %s actions", action.Type)
+	}
+	if e.cfg.RequireDigest && action.ImageDigest == "" {
+		return nil, fmt.Errorf("signal announces no image digest and require_digest is set")
+	}
+	// The revision label of an image cannot be inspected from the cluster,
+	// but a digest pins the image the signed source was built into
+	if action.Source != nil && action.ImageDigest == "" {
+		return nil, fmt.Errorf("kubernetes executor cannot verify the source of %s without an announced image digest", action.Key)
+	}
+
+	image := fmt.Sprintf("%s:%s", e.cfg.Image, action.Version.Original())
+	if action.ImageDigest != "" {
+		image += "@" + action.ImageDigest
+	}
+
+	var steps []Step
+	if action.Type == "reboot" {
+		steps = append(steps, e.genesisStep(action))
+	}
+	steps = append(steps, Step{Name: "set-image", Command: e.cfg.kubectl("set", "image", e.cfg.Workload, e.cfg.Container+"="+image)})
+	if action.Type == "reboot" {
+		steps = append(steps, Step{Name: "restart", Command: e.cfg.kubectl("rollout", "restart", e.cfg.Workload)})
+	}
+	return append(steps, Step{Name: "rollout", Command: e.cfg.kubectl("rollout", "status", e.cfg.Workload, "--timeout", e.rolloutTimeout().String())}), nil
+}
+
+// rolloutTimeout returns the configured rollout timeout or the default
+func (e *KubernetesExecutor) rolloutTimeout() time.Duration {
+	if e.cfg.RolloutTimeout > 0 {
+		return e.cfg.RolloutTimeout
+	}
+	return defaultRolloutTimeout
+}
+
+// genesisStep downloads the reboot's genesis file through the artifact
+// cache, verified if announced with a hash, and applies it as the genesis
+// ConfigMap
+func (e *KubernetesExecutor) genesisStep(action *signal.Action) Step {
+	urls, sum := artifactSources(action.Artifact, action.Genesis)
+	return Step{Name: "genesis", Run: func(ctx context.Context) error {
+		path, err := fetchArtifact(ctx, e.artifacts, urls, sum)
+		if err != nil {
+			return err
+		}
+		create := e.cfg.kubectl("create", "configmap", e.cfg.GenesisConfigMap,
+			"--from-file="+genesisConfigMapKey+"="+path, "--dry-run=client", "--output=json")
+		manifest, err := exec.CommandContext(ctx, create[0], create[1:]...).Output()
+		if err != nil {
+			return fmt.Errorf("failed to render genesis ConfigMap: %w", commandError(err))
+		}
+		return kubectlApply(ctx, e.cfg, manifest)
+	}}
+}
+
+// kubectlApply applies a manifest with kubectl
+func kubectlApply(ctx context.Context, cfg KubernetesExecutorConfig, manifest []byte) error {
+	apply := cfg.kubectl("apply", "--filename=-")
+	cmd := exec.CommandContext(ctx, apply[0], apply[1:]...)
+	cmd.Stdin = bytes.NewReader(manifest)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("kubectl apply failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// commandError adds the stderr of a failed command to its error
+func commandError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// QubeManager resource the status of every run is published to
+const (
+	qubeManagerGroup   = "qube.hypercore.one"
+	qubeManagerVersion = "v1alpha1"
+)
+
+// qubeManagerCRD defines the QubeManager resource. Status is an ordinary
+// field rather than a subresource so kubectl apply can write it.
+const qubeManagerCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: qubemanagers.qube.hypercore.one
+spec:
+  group: qube.hypercore.one
+  scope: Namespaced
+  names:
+    kind: QubeManager
+    plural: qubemanagers
+    singular: qubemanager
+    shortNames: [qm]
+  versions:
+    - name: v1alpha1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+          properties:
+            status:
+              type: object
+              x-kubernetes-preserve-unknown-fields: true
+      additionalPrinterColumns:
+        - {name: Status, type: string, jsonPath: .status.lastStatus}
+        - {name: Action, type: string, jsonPath: .status.lastAction}
+        - {name: Node, type: string, jsonPath: .status.nodeVersion}
+        - {name: Updated, type: date, jsonPath: .status.updatedAt}
+`
+
+// QubeManagerStatus is the status published to the QubeManager resource
+type QubeManagerStatus struct {
+	UpdatedAt       string            `json:"updatedAt"`               // RFC3339 time the run ended
+	LastStatus      string            `json:"lastStatus"`              // Outcome of the run
+	LastAction      string            `json:"lastAction,omitempty"`    // Key of the selected action
+	NodeVersion     string            `json:"nodeVersion,omitempty"`   // Detected node version
+	RelaysConnected int               `json:"relaysConnected"`         // Relays that accepted the subscription
+	ActionsPending  int               `json:"actionsPending"`          // Candidate actions not yet in history
+	ActionStates    map[string]string `json:"actionStates,omitempty"`  // Lifecycle state of each action not yet done
+	EventsSeen      int               `json:"eventsSeen"`              // Events received from the relays
+	EventsDropped   int               `json:"eventsDropped,omitempty"` // Events not processed because of a limit
+}
+
+// publishKubernetesStatus writes the run result to the QubeManager resource
+// if the Kubernetes backend names one
+func publishKubernetesStatus(cfg Config, r *RunResult) {
+	k := cfg.Executor.Kubernetes
+	if cfg.Executor.Type != "kubernetes" || k.StatusResource == "" {
+		return
+	}
+
+	manifest, err := json.Marshal(map[string]any{
+		"apiVersion": qubeManagerGroup + "/" + qubeManagerVersion,
+		"kind":       "QubeManager",
+		"metadata":   map[string]string{"name": k.StatusResource},
+		"status": QubeManagerStatus{
+			UpdatedAt:       time.Now().UTC().Format(time.RFC3339),
+			LastStatus:      r.LastStatus,
+			LastAction:      r.LastAction,
+			NodeVersion:     r.NodeVersion,
+			RelaysConnected: r.RelaysConnected,
+			ActionsPending:  r.ActionsPending,
+			ActionStates:    r.ActionStates,
+			EventsSeen:      r.EventsSeen,
+			EventsDropped:   r.EventsDropped,
+		},
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to encode QubeManager status: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), statusPublishTimeout)
+	defer cancel()
+	if err := kubectlApply(ctx, k, manifest); err != nil {
+		log.Printf("[WARN] Failed to publish status to QubeManager %s: %v", k.StatusResource, err)
+		return
+	}
+	log.Printf("[INFO] Status published to QubeManager %s", k.StatusResource)
+}
+
+func newKubernetesCRDCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "kubernetes-crd",
+		Short: "Print the CustomResourceDefinition of the QubeManager status resource",
+		Long: `Print the CustomResourceDefinition of the QubeManager status resource.
+Install it with 'qube-manager kubernetes-crd | kubectl apply -f -' before
+setting executor.kubernetes.status_resource. The manager's service account
+needs to apply qubemanagers and the genesis ConfigMap, to patch the node
+workload, and to watch its rollout.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipKeypairAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(qubeManagerCRD)
+		},
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// ShellExecutorConfig configures the zenon.sh deployment script backend
+type ShellExecutorConfig struct {
+	Script    string `yaml:"script"`               // Path to the deployment repo's zenon.sh
+	Repo      string `yaml:"repo,omitempty"`       // Git repository to build from (script default if empty)
+	SourceDir string `yaml:"source_dir,omitempty"` // Checkout of pinned upgrades (default /tmp/qube-manager-source)
+
+	// Command the script is run with, e.g. [sh] for zenon.sh on Windows or
+	// [powershell, -NoProfile, -File] for a PowerShell port of it (the script
+	// is executed directly if unset)
+	Interpreter []string `yaml:"interpreter,omitempty"`
+}
+
+// defaultSourceDir is where pinned upgrades are checked out when source_dir is unset
+const defaultSourceDir = "/tmp/qube-manager-source"
+
+// checkoutScript clones tag $2 of repo $1 into $4 and fails unless it
+// resolves to commit $3. It runs under sh so fleet hosts can run it over SSH.
+const checkoutScript = `set -e
+rm -rf "$4"
+git -c advice.detachedHead=false clone --quiet --depth 1 --branch "$2" -- "$1" "$4"
+actual=$(git -C "$4" rev-parse HEAD)
+if [ "$actual" != "$3" ]; then
+  echo "tag $2 of $1 resolves to $actual, expected $3" >&2
+  exit 1
+fi`
+
+// ShellExecutor applies actions by invoking the deployment repo's zenon.sh
+type ShellExecutor struct {
+	cfg       ShellExecutorConfig
+	backup    BackupConfig
+	artifacts ArtifactConfig
+}
+
+func (e *ShellExecutor) Name() string { return "shell" }
+
+// Validate checks that the deployment script exists and can be run: through
+// the interpreter if one is set, otherwise by being executable. Windows runs
+// only programs and batch files directly.
+func (e *ShellExecutor) Validate() error {
+	if e.cfg.Script == "" {
+		return errors.New("script path is required")
+	}
+	info, err := os.Stat(e.cfg.Script)
+	if err != nil {
+		return fmt.Errorf("deployment script not found: %w", err)
+	}
+	switch {
+	case len(e.cfg.Interpreter) > 0:
+		if _, err := exec.LookPath(e.cfg.Interpreter[0]); err != nil {
+			return fmt.Errorf("interpreter %s not found: %w", e.cfg.Interpreter[0], err)
+		}
+	case runtime.GOOS == "windows":
+		if _, err := exec.LookPath(e.cfg.Script); err != nil {
+			return fmt.Errorf("running deployment script %s directly is %w; set interpreter, e.g. [sh]", e.cfg.Script, errUnsupportedPlatform)
+		}
+	case info.Mode()&0111 == 0:
+		return fmt.Errorf("deployment script %s is not executable", e.cfg.Script)
+	}
+	return nil
+}
+
+// script returns the command running the deployment script with args
+func (e *ShellExecutor) script(args ...string) []string {
+	cmd := append(slices.Clone(e.cfg.Interpreter), e.cfg.Script)
+	return append(cmd, args...)
+}
+
+// Steps maps an upgrade to a single deploy and a reboot to stop, resync
+// against the announced genesis, and deploy, with a backup of the node data
+// before the resync if enabled. A genesis announced with a hash is
+// downloaded from its mirrors and verified before the resync, which is then
+// given the local file. A rollback re-deploys the earlier version
+// from its tag, which leaves the node data in place. An upgrade pinned to a
+// source is first checked out and verified against the signed commit, and
+// then deployed from that checkout.
+func (e *ShellExecutor) Steps(action *signal.Action) ([]Step, error) {
+	if action.Source != nil {
+		return e.pinnedSteps(action.Source)
+	}
+
+	deploy := e.script("--deploy", action.Version.Original())
+	if e.cfg.Repo != "" {
+		deploy = append(deploy, "--repo", e.cfg.Repo)
+	}
+
+	switch action.Type {
+	case "upgrade", "rollback":
+		return []Step{
+			{Name: "deploy", Command: deploy},
+		}, nil
+	case "reboot":
+		steps := []Step{{Name: "stop", Command: e.script("--stop")}}
+		if e.backup.Enabled {
+			steps = append(steps, backupStep(e.backup, action))
+		}
+		genesis := action.Genesis
+		if action.Artifact != nil {
+			urls, sum := artifactSources(action.Artifact)
+			genesis = e.artifacts.path(urls, sum)
+			steps = append(steps, Step{Name: "download", File: &StepFile{URLs: urls, SHA256: sum, Path: genesis}, Run: func(ctx context.Context) error {
+				_, err := fetchArtifact(ctx, e.artifacts, urls, sum)
+				return err
+			}})
+			// The script reads the genesis from the cache, so it is checked
+			// again right before, also when a resumed execution skips the
+			// download
+			steps = append(steps, Step{Name: "verify-genesis", Recheck: true, File: &StepFile{SHA256: sum, Path: genesis}, Run: func(ctx context.Context) error {
+				return reverifyArtifact(genesis, sum)
+			}})
+		}
+		return append(steps,
+			Step{Name: "resync", Command: e.script("--resync", genesis)},
+			Step{Name: "deploy", Command: deploy},
+		), nil
+	default:
+		return nil, fmt.Errorf("shell executor does not support %s actions", action.Type)
+	}
+}
+
+// pinnedSteps checks out the signed tag, refuses it unless it resolves to the
+// signed commit, and deploys the tag from the verified checkout
+func (e *ShellExecutor) pinnedSteps(source *signal.Source) ([]Step, error) {
+	repo := source.Repo
+	if repo == "" {
+		repo = e.cfg.Repo
+	}
+	if repo == "" {
+		return nil, errors.New("pinned upgrade names no repo and the shell executor has none configured")
+	}
+	dir := e.cfg.SourceDir
+	if dir == "" {
+		dir = defaultSourceDir
+	}
+
+	return []Step{
+		{Name: "checkout", Command: []string{"sh", "-c", checkoutScript, "checkout", repo, source.Tag, source.Commit, dir}},
+		{Name: "deploy", Command: e.script("--deploy", source.Tag, "--repo", dir)},
+	}, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// SystemdExecutorConfig configures the systemd binary-swap backend
+type SystemdExecutorConfig struct {
+	Unit        string `yaml:"unit"`         // systemd unit running the node, e.g. "hqzd"
+	BinaryPath  string `yaml:"binary_path"`  // Installed node binary that gets replaced
+	DownloadURL string `yaml:"download_url"` // Release binary URL; "{version}" is replaced with the announced version
+
+	// Detached signature checked before the downloaded binary is installed
+	Signature SignatureConfig `yaml:"signature,omitempty"`
+}
+
+// SystemdExecutor applies upgrades by swapping the node binary in place and
+// restarting its systemd unit
+type SystemdExecutor struct {
+	cfg       SystemdExecutorConfig
+	artifacts ArtifactConfig
+}
+
+func (e *SystemdExecutor) Name() string { return "systemd" }
+
+// Validate checks that the unit, binary path, and download URL are configured
+func (e *SystemdExecutor) Validate() error {
+	if e.cfg.Unit == "" {
+		return errors.New("unit is required")
+	}
+	if e.cfg.BinaryPath == "" {
+		return errors.New("binary_path is required")
+	}
+	if !strings.Contains(e.cfg.DownloadURL, "{version}") {
+		return errors.New("download_url must contain a {version} placeholder")
+	}
+	return e.cfg.Signature.Validate()
+}
+
+// Steps downloads the release binary next to the installed one (from the
+// announced mirrors first, checked against the announced hash, if any),
+// verifies its signature if configured, swaps it in (keeping a .bak copy),
+// and restarts the unit. Rollbacks install the earlier release the same
+// way, leaving the node data untouched. Release binaries carry no source
+// commit, so an upgrade pinned to one is only performed if the signal also
+// announces the hash of the binary built from it. Reboots are rejected.
+func (e *SystemdExecutor) Steps(action *signal.Action) ([]Step, error) {
+	if action.Type != "upgrade" && action.Type != "rollback" {
+		return nil, fmt.Errorf("systemd executor does not support %s actions", action.Type)
+	}
+	if action.Source != nil && action.Artifact == nil {
+		return nil, fmt.Errorf("systemd executor cannot verify commit %s of a release binary; the signal must announce the binary's sha256", action.Source.Commit)
+	}
+
+	url := strings.ReplaceAll(e.cfg.DownloadURL, "{version}", action.Version.Original())
+	staged := e.cfg.BinaryPath + ".new"
+	urls, sum := artifactSources(action.Artifact, url)
+
+	steps := []Step{
+		{Name: "download", Run: func(ctx context.Context) error {
+			path, err := fetchArtifact(ctx, e.artifacts, urls, sum)
+			if err != nil {
+				return err
+			}
+			return copyVerified(path, staged, 0755, sum)
+		}},
+	}
+	if e.cfg.Signature.Enabled() {
+		steps = append(steps, Step{Name: "verify", Run: func(ctx context.Context) error {
+			return verifyArtifact(ctx, e.cfg.Signature, staged, url, action.Version.Original())
+		}})
+	}
+	return append(steps,
+		Step{Name: "swap", Run: func(ctx context.Context) error {
+			return swapBinary(staged, e.cfg.BinaryPath)
+		}},
+		Step{Name: "restart", Command: []string{"systemctl", "restart", e.cfg.Unit}},
+	), nil
+}
+
+// swapBinary moves the staged binary over the installed one, keeping the
+// previous binary as a .bak file
+func swapBinary(staged, installed string) error {
+	if _, err := os.Stat(installed); err == nil {
+		if err := os.Rename(installed, installed+".bak"); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", installed, err)
+		}
+	}
+	if err := os.Rename(staged, installed); err != nil {
+		return fmt.Errorf("failed to install %s: %w", installed, err)
+	}
+	log.Printf("[INFO] Installed new binary at %s (previous kept as %s.bak)", installed, installed)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+// Process exit codes of a single run, so cron wrappers and monitoring can
+// tell outcomes apart. Unexpected fatal errors exit with 1.
+const (
+	exitNoAction         = 0  // no eligible action, or daemon stopped cleanly
+	exitError            = 1  // the run stopped on an unexpected error
+	exitInterrupted      = 2  // an action was still in flight when the shutdown grace period expired
+	exitExecuted         = 10 // action executed and recorded in history
+	exitAwaitingApproval = 11 // action reached quorum but awaits operator approval
+	exitScheduled        = 12 // action reached quorum but is not due yet
+	exitDryRun           = 13 // dry run selected an action
+	exitStandby          = 14 // action reached quorum but another instance executes it
+	exitObserved         = 15 // action reached quorum but the manager only observes
+	exitBackoff          = 16 // action failed before and waits for its next attempt
+	exitConfigError      = 20 // config file missing, unparsable, or invalid (e.g. unreachable quorum)
+	exitClockSkew        = 21 // local clock is off by more than max_skew and clock_check.refuse is set
+	exitCheckFailed      = 22 // 'doctor' found a failing check
+	exitExecutionFailed  = 30 // execution, verification, or done event failed
+	exitBlocked          = 31 // action failed too often and waits for 'retry-action'
+	exitShutdown         = 40 // shutdown requested before the selected action started
+)
+
+// exitCode maps the status of a run to the process exit code
+func exitCode(status string) int {
+	switch status {
+	case statusExecuted:
+		return exitExecuted
+	case statusAwaitingApproval:
+		return exitAwaitingApproval
+	case statusScheduled:
+		return exitScheduled
+	case statusDryRun:
+		return exitDryRun
+	case statusStandby:
+		return exitStandby
+	case statusObserved:
+		return exitObserved
+	case statusBackoff:
+		return exitBackoff
+	case statusFailed:
+		return exitExecutionFailed
+	case statusBlocked:
+		return exitBlocked
+	case statusInterrupted:
+		return exitShutdown
+	case statusError:
+		return exitError
+	default:
+		return exitNoAction
+	}
+}
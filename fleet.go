@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// Defaults for optional fleet settings
+const (
+	defaultFleetParallelism   = 1
+	defaultFleetHealthTimeout = 2 * time.Minute
+	fleetHealthInterval       = 5 * time.Second
+)
+
+// roleCanary marks hosts that execute before the rest of the fleet
+const roleCanary = "canary"
+
+// FleetConfig lists remote hosts a single manager executes actions on over
+// SSH after evaluating quorum once
+type FleetConfig struct {
+	Hosts         []FleetHost   `yaml:"hosts"`                    // Remote hosts to execute actions on
+	Parallelism   int           `yaml:"parallelism,omitempty"`    // Hosts executing at the same time (default 1)
+	HealthCheck   string        `yaml:"health_check,omitempty"`   // Command run on each host after execution; must exit 0
+	HealthTimeout time.Duration `yaml:"health_timeout,omitempty"` // How long the health check may keep failing
+}
+
+// FleetHost is a single remote host in the fleet
+type FleetHost struct {
+	Name    string `yaml:"name"`              // Unique host name used in logs and state files
+	Address string `yaml:"address"`           // host or host:port of the SSH server
+	User    string `yaml:"user,omitempty"`    // SSH user (ssh default if empty)
+	SSHKey  string `yaml:"ssh_key,omitempty"` // Private key file (ssh default if empty)
+	Role    string `yaml:"role,omitempty"`    // "canary" hosts execute first; others are informational
+}
+
+// Enabled reports whether fleet mode is configured
+func (f FleetConfig) Enabled() bool {
+	return len(f.Hosts) > 0
+}
+
+// sshCommand returns the argv that runs command on the host over SSH
+func (h FleetHost) sshCommand(command []string) []string {
+	argv := []string{"ssh", "-o", "BatchMode=yes"}
+	if h.SSHKey != "" {
+		argv = append(argv, "-i", h.SSHKey)
+	}
+
+	target := h.Address
+	if host, port, err := net.SplitHostPort(h.Address); err == nil {
+		target = host
+		argv = append(argv, "-p", port)
+	}
+	if h.User != "" {
+		target = h.User + "@" + target
+	}
+
+	return append(argv, target, "--", shellJoin(command))
+}
+
+// shellJoin quotes argv for the remote shell ssh runs the command with
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
+
+// RemoteExecutor runs another backend's steps on a fleet host over SSH and
+// finishes with the fleet health check
+type RemoteExecutor struct {
+	host  FleetHost
+	inner Executor
+	cfg   FleetConfig
+}
+
+func (e *RemoteExecutor) Name() string { return "ssh:" + e.inner.Name() }
+
+// Validate checks the backend settings that do not depend on the local
+// filesystem; paths such as the deployment script refer to the remote host
+func (e *RemoteExecutor) Validate() error {
+	if shell, ok := e.inner.(*ShellExecutor); ok {
+		if shell.cfg.Script == "" {
+			return errors.New("script path is required")
+		}
+		return nil
+	}
+	return e.inner.Validate()
+}
+
+// remoteFileScript downloads file $1 from the first of the URLs from $3 on
+// that serves it with SHA-256 $2, unless it is already in place, and fails
+// unless the file at $1 has that hash. Without URLs it only checks the file.
+const remoteFileScript = `set -e
+path=$1 sum=$2
+shift 2
+mkdir -p "$(dirname "$path")"
+if [ $# -gt 0 ] && ! echo "$sum  $path" | sha256sum -c --status 2>/dev/null; then
+  for url in "$@"; do
+    if curl -fsSL -o "$path.tmp" "$url" && echo "$sum  $path.tmp" | sha256sum -c --status; then
+      mv "$path.tmp" "$path"
+      break
+    fi
+    rm -f "$path.tmp"
+  done
+fi
+echo "$sum  $path" | sha256sum -c --status || { echo "$path does not match sha256 $sum" >&2; exit 1; }`
+
+// Steps wraps every backend step in an SSH invocation and appends the
+// health check. Steps that download or verify an announced file in-process
+// do so on the host with curl and sha256sum instead; backends with other
+// in-process steps cannot run remotely.
+func (e *RemoteExecutor) Steps(action *signal.Action) ([]Step, error) {
+	steps, err := e.inner.Steps(action)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := make([]Step, 0, len(steps)+1)
+	for _, step := range steps {
+		command := step.Command
+		if len(command) == 0 && step.File != nil {
+			command = append([]string{"sh", "-c", remoteFileScript, step.Name, step.File.Path, step.File.SHA256}, step.File.URLs...)
+		}
+		if len(command) == 0 {
+			return nil, fmt.Errorf("%s step %s runs in-process and cannot be executed over SSH", e.inner.Name(), step.Name)
+		}
+		remote = append(remote, Step{Name: step.Name, Command: e.host.sshCommand(command), Recheck: step.Recheck})
+	}
+
+	if e.cfg.HealthCheck != "" {
+		remote = append(remote, Step{Name: "health", Run: e.waitHealthy})
+	}
+	return remote, nil
+}
+
+// waitHealthy runs the health check on the host until it succeeds or the
+// health timeout expires
+func (e *RemoteExecutor) waitHealthy(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.HealthTimeout)
+	defer cancel()
+
+	argv := e.host.sshCommand([]string{"sh", "-c", e.cfg.HealthCheck})
+	for {
+		out, err := exec.CommandContext(ctx, argv[0], argv[1:]...).CombinedOutput()
+		if err == nil {
+			log.Printf("[INFO] Host %s: health check passed", e.host.Name)
+			return nil
+		}
+		log.Printf("[DEBUG] Host %s: health check failed: %v %s", e.host.Name, err, strings.TrimSpace(string(out)))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("health check did not pass within %v: %w", e.cfg.HealthTimeout, err)
+		case <-time.After(fleetHealthInterval):
+		}
+	}
+}
+
+// Fleet executes actions across all configured hosts
+type Fleet struct {
+	cfg       FleetConfig
+	executors []*RemoteExecutor
+}
+
+// newFleet builds a remote executor for every host using the configured
+// executor backend
+func newFleet(cfg FleetConfig, exCfg ExecutorConfig) (*Fleet, error) {
+	inner, err := selectExecutor(exCfg)
+	if err != nil {
+		return nil, err
+	}
+	if inner == nil {
+		return nil, errors.New("fleet mode requires an executor type")
+	}
+	if err := exCfg.Artifacts.Validate(); err != nil {
+		return nil, err
+	}
+
+	f := &Fleet{cfg: cfg}
+	for _, h := range cfg.Hosts {
+		ex := &RemoteExecutor{host: h, inner: inner, cfg: cfg}
+		if err := ex.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s executor config: %w", inner.Name(), err)
+		}
+		f.executors = append(f.executors, ex)
+	}
+	return f, nil
+}
+
+// fleetStatePath returns the execution state file of a fleet host
+func fleetStatePath(stateDir string, host string) string {
+	return filepath.Join(stateDir, "fleet", host+".yaml")
+}
+
+// Execute runs the action on every host, canaries first and then the rest at
+// most cfg.Parallelism at a time. Each host keeps its own execution state so
+// a later run resumes the hosts that did not complete. Once a host fails,
+// no further hosts are started.
+func (f *Fleet) Execute(ctx context.Context, stateDir string, action *signal.Action, votes map[string]signal.Vote, exCfg ExecutorConfig, record stepsRecorder) error {
+	if err := os.MkdirAll(filepath.Join(stateDir, "fleet"), 0755); err != nil {
+		return fmt.Errorf("failed to create fleet state directory: %w", err)
+	}
+
+	var canaries, rest []*RemoteExecutor
+	for _, ex := range f.executors {
+		if ex.host.Role == roleCanary {
+			canaries = append(canaries, ex)
+		} else {
+			rest = append(rest, ex)
+		}
+	}
+
+	status := make(map[string]string, len(f.executors))
+	var failed []string
+	for _, wave := range [][]*RemoteExecutor{canaries, rest} {
+		if len(failed) > 0 {
+			break
+		}
+		failed = append(failed, f.runWave(ctx, stateDir, wave, action, votes, exCfg, record, status)...)
+	}
+
+	for _, ex := range f.executors {
+		s, ok := status[ex.host.Name]
+		if !ok {
+			s = "skipped"
+		}
+		name := ex.host.Name
+		if ex.host.Role != "" {
+			name += " (" + ex.host.Role + ")"
+		}
+		log.Printf("[INFO] Host %s: %s", name, s)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("execution failed on %d host(s): %s", len(failed), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runWave executes the action on hosts with bounded parallelism, recording
+// each host's status. It stops starting hosts after the first failure and
+// returns the names of the hosts that failed.
+func (f *Fleet) runWave(ctx context.Context, stateDir string, hosts []*RemoteExecutor, action *signal.Action, votes map[string]signal.Vote, exCfg ExecutorConfig, record stepsRecorder, status map[string]string) []string {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		failed  []string
+		slots   = make(chan struct{}, f.cfg.Parallelism)
+		aborted bool
+	)
+
+	for _, ex := range hosts {
+		slots <- struct{}{}
+
+		mu.Lock()
+		stop := aborted
+		mu.Unlock()
+		if stop || ctx.Err() != nil {
+			<-slots
+			break
+		}
+
+		wg.Add(1)
+		go func(ex *RemoteExecutor) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			log.Printf("[INFO] Host %s: executing %s", ex.host.Name, action.Key)
+			err := f.runHost(ctx, stateDir, ex, action, votes, exCfg, record)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("[ERROR] Host %s: %v - not starting remaining hosts", ex.host.Name, err)
+				status[ex.host.Name] = "failed: " + err.Error()
+				failed = append(failed, ex.host.Name)
+				aborted = true
+				return
+			}
+			status[ex.host.Name] = execCompleted
+		}(ex)
+	}
+
+	wg.Wait()
+	return failed
+}
+
+// runHost executes the action on a single host, resuming its persisted state
+func (f *Fleet) runHost(ctx context.Context, stateDir string, ex *RemoteExecutor, action *signal.Action, votes map[string]signal.Vote, exCfg ExecutorConfig, record stepsRecorder) error {
+	state, err := prepareExecution(fleetStatePath(stateDir, ex.host.Name), ex, action, votes)
+	if err != nil {
+		return err
+	}
+	return executeAction(ctx, ex, action, state, exCfg, record)
+}
+
+// Clear removes the per-host execution state once the action is in history
+func (f *Fleet) Clear(stateDir string) {
+	for _, ex := range f.executors {
+		path := fleetStatePath(stateDir, ex.host.Name)
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[WARN] Failed to clear execution state for host %s: %v", ex.host.Name, err)
+		}
+	}
+}
+
+// describe logs the plan for every host in dry runs
+func (f *Fleet) describe(action *signal.Action) {
+	for _, ex := range f.executors {
+		steps, err := ex.Steps(action)
+		if err != nil {
+			log.Printf("[WARN] Dry run - host %s cannot perform %s: %v", ex.host.Name, action.Key, err)
+			continue
+		}
+		for i, line := range describeSteps(steps) {
+			log.Printf("[INFO] Dry run - host %s step %d/%d would run %s", ex.host.Name, i+1, len(steps), line)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Defaults for re-broadcasting validated signals
+const (
+	defaultGossipMaxPerHour = 60
+	gossipRetention         = 7 * 24 * time.Hour
+)
+
+// GossipConfig configures the re-broadcast of validated signals. Publishers
+// that only reach some of the relays leave other managers short of votes;
+// republishing what this manager validated spreads the signals to every
+// configured relay.
+type GossipConfig struct {
+	Enabled    bool `yaml:"enabled,omitempty"`      // Republish validated signals to the relays that did not deliver them
+	MaxPerHour int  `yaml:"max_per_hour,omitempty"` // Most signals republished in any hour (default 60)
+}
+
+// applyDefaults fills in unset gossip settings
+func (c *GossipConfig) applyDefaults() {
+	if c.MaxPerHour == 0 {
+		c.MaxPerHour = defaultGossipMaxPerHour
+	}
+}
+
+// Gossip collects the signals validated in a run and the NIP-09 deletions of
+// signals, together with the relays that delivered them, and remembers which
+// were republished. Every event is republished at most once, so managers
+// gossiping to each other cannot loop, and at most max_per_hour per hour.
+// Deleted signals are never republished.
+type Gossip struct {
+	Sent map[string]string `json:"sent"` // Event ID -> ISO8601 timestamp it was republished
+
+	cfg          GossipConfig
+	path         string                     // gossip file path (not in JSON)
+	delivered    map[string][]string        // Event ID -> relays that delivered it this run
+	validated    []*nostr.Event             // Signals and deletions validated this run, in the order received
+	seen         map[string]*nostr.Event    // Validated signals and deletions by ID
+	deleted      map[string]bool            // "pubkey:event ID" of signals deleted by their author
+	deletedUntil map[string]nostr.Timestamp // Address -> newest deletion of its versions
+	verbose      bool                       // Log every event republished
+}
+
+// loadGossip reads the gossip file from stateDir, returning nil if gossip is
+// disabled
+func loadGossip(cfg GossipConfig, stateDir string, verbose bool) *Gossip {
+	if !cfg.Enabled {
+		return nil
+	}
+	g := &Gossip{
+		Sent:         map[string]string{},
+		cfg:          cfg,
+		path:         filepath.Join(stateDir, "gossip.json"),
+		delivered:    map[string][]string{},
+		seen:         map[string]*nostr.Event{},
+		deleted:      map[string]bool{},
+		deletedUntil: map[string]nostr.Timestamp{},
+		verbose:      verbose,
+	}
+
+	data, err := os.ReadFile(g.path)
+	if os.IsNotExist(err) {
+		return g
+	} else if err != nil {
+		log.Printf("[WARN] Failed to read gossip file %s: %v", g.path, err)
+		return g
+	}
+	if err := json.Unmarshal(data, g); err != nil {
+		log.Printf("[WARN] Failed to parse gossip file %s: %v", g.path, err)
+	}
+	if g.Sent == nil {
+		g.Sent = map[string]string{}
+	}
+	return g
+}
+
+// Delivered records that relayURL delivered the event. It is a no-op on a nil
+// Gossip.
+func (g *Gossip) Delivered(ev *nostr.Event, relayURL string) {
+	if g == nil || slices.Contains(g.delivered[ev.ID], relayURL) {
+		return
+	}
+	g.delivered[ev.ID] = append(g.delivered[ev.ID], relayURL)
+}
+
+// Validated marks the event as a valid signal worth republishing. Encrypted
+// signals are addressed to this manager alone and are left out. It is a no-op
+// on a nil Gossip.
+func (g *Gossip) Validated(ev *nostr.Event) {
+	if g == nil || ev.Kind == nostr.KindDirectMessage || g.seen[ev.ID] != nil {
+		return
+	}
+	g.seen[ev.ID] = ev
+	g.validated = append(g.validated, ev)
+}
+
+// Deletion records a NIP-09 deletion by a follow, to be republished like a
+// signal so the signals it retracts do not reappear on relays that missed
+// it, and so those signals are not republished. It is a no-op on a nil Gossip.
+func (g *Gossip) Deletion(ev *nostr.Event) {
+	if g == nil || g.seen[ev.ID] != nil {
+		return
+	}
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch {
+		case tag[0] == "e":
+			g.deleted[ev.PubKey+":"+tag[1]] = true
+		case tag[0] == "a" && strings.Contains(tag[1], ":"+ev.PubKey+":") && ev.CreatedAt > g.deletedUntil[tag[1]]:
+			g.deletedUntil[tag[1]] = ev.CreatedAt
+		}
+	}
+	g.seen[ev.ID] = ev
+	g.validated = append(g.validated, ev)
+}
+
+// isDeleted reports whether a deletion seen this run retracts the signal
+func (g *Gossip) isDeleted(ev *nostr.Event) bool {
+	if g.deleted[ev.PubKey+":"+ev.ID] {
+		return true
+	}
+	addr := signal.Address(ev)
+	return addr != "" && ev.CreatedAt <= g.deletedUntil[addr]
+}
+
+// Publish republishes the signals and deletions validated this run to the
+// relays that did not deliver them, skipping events republished before and
+// deleted signals and stopping at the hourly limit, then saves the gossip
+// file. It returns how many signals were
+// republished. It is a no-op on a nil Gossip.
+func (g *Gossip) Publish(cfg Config, shutdown *ShutdownHandler) int {
+	if g == nil {
+		return 0
+	}
+	now := time.Now()
+	g.prune(now)
+
+	budget := g.cfg.MaxPerHour - g.sentSince(now.Add(-time.Hour))
+	type publish struct {
+		ev   *nostr.Event
+		wait func() []string
+	}
+	var publishes []publish
+	for _, ev := range g.validated {
+		if _, ok := g.Sent[ev.ID]; ok || g.isDeleted(ev) {
+			continue
+		}
+		var missing []string
+		for _, r := range cfg.writeRelays() {
+			if !slices.Contains(g.delivered[ev.ID], r) {
+				missing = append(missing, r)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		if len(publishes) >= budget {
+			log.Printf("[INFO] Gossip limit of %d signals per hour reached - republishing the rest later", g.cfg.MaxPerHour)
+			break
+		}
+		if g.verbose {
+			log.Printf("[DEBUG] Republishing event %s to %d relay(s) that did not deliver it", ev.ID, len(missing))
+		}
+		publishes = append(publishes, publish{ev, publishEvent(missing, *ev, cfg.ConnectTimeout, cfg.ShutdownGracePeriod, shutdown)})
+	}
+	if len(publishes) == 0 {
+		return 0
+	}
+
+	sent := 0
+	for _, p := range publishes {
+		accepted := p.wait()
+		// Signals no relay took are tried again next run
+		if len(accepted) == 0 {
+			continue
+		}
+		g.Sent[p.ev.ID] = now.UTC().Format(time.RFC3339)
+		sent++
+	}
+	log.Printf("[INFO] Republished %d of %d validated event(s) to relays that missed them", sent, len(publishes))
+
+	if err := g.save(); err != nil {
+		log.Printf("[WARN] Error saving gossip file: %v", err)
+	}
+	return sent
+}
+
+// sentSince counts the signals republished after t
+func (g *Gossip) sentSince(t time.Time) int {
+	n := 0
+	for _, at := range g.Sent {
+		if sent, err := time.Parse(time.RFC3339, at); err == nil && sent.After(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// prune forgets signals republished longer than gossipRetention ago; relays
+// have long had them by then
+func (g *Gossip) prune(now time.Time) {
+	for id, at := range g.Sent {
+		if sent, err := time.Parse(time.RFC3339, at); err != nil || now.Sub(sent) > gossipRetention {
+			delete(g.Sent, id)
+		}
+	}
+}
+
+// save writes the republished signals to the gossip file
+func (g *Gossip) save() error {
+	data, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(g.path, data, 0644)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// securityProblems checks the files an attacker could tamper with to take
+// over the node: keys.json must be private to the user running the manager,
+// the config dir must not be writable by group or others, and deployment
+// scripts the shell executor runs must be writable by root only. Checks
+// are skipped on platforms without Unix permissions.
+func securityProblems(cfg Config) []string {
+	if !unixPermissions {
+		return nil
+	}
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	keys := filepath.Join(cfg.ConfigPath, "keys.json")
+	if info, err := os.Stat(keys); err == nil {
+		if perm := info.Mode().Perm(); perm&^0600 != 0 {
+			add("%s has mode %04o, expected 0600 (chmod 600 %s)", keys, perm, keys)
+		}
+		if uid, ok := fileOwner(info); ok && uid != os.Getuid() {
+			add("%s is owned by uid %d, not by the user running qube-manager (uid %d)", keys, uid, os.Getuid())
+		}
+	}
+
+	if info, err := os.Stat(cfg.ConfigPath); err == nil && info.Mode().Perm()&0022 != 0 {
+		add("config dir %s is writable by group or others (mode %04o; chmod go-w %s)", cfg.ConfigPath, info.Mode().Perm(), cfg.ConfigPath)
+	}
+
+	// Fleet hosts run their own copy of the script
+	if cfg.Fleet.Enabled() {
+		return problems
+	}
+	var scripts []string
+	if cfg.Executor.Type == "shell" {
+		scripts = append(scripts, cfg.Executor.Shell.Script)
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+		if ex := cfg.Services[name]; ex.Type == "shell" {
+			scripts = append(scripts, ex.Shell.Script)
+		}
+	}
+	slices.Sort(scripts)
+	for _, script := range slices.Compact(scripts) {
+		if script == "" {
+			continue
+		}
+		// Replacing the script only takes write access to its directory
+		for _, path := range []string{script, filepath.Dir(script)} {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if who := nonRootWriters(info); who != "" {
+				add("deployment script path %s is writable by %s (mode %04o); only root may modify what the executor runs", path, who, info.Mode().Perm())
+			}
+		}
+	}
+	return problems
+}
+
+// nonRootWriters describes who other than root can modify a file, or
+// returns "" if only root can
+func nonRootWriters(info os.FileInfo) string {
+	perm := info.Mode().Perm()
+	switch {
+	case perm&0002 != 0:
+		return "everyone"
+	case perm&0020 != 0:
+		return "its group"
+	}
+	if uid, ok := fileOwner(info); ok && uid != 0 && perm&0200 != 0 {
+		return fmt.Sprintf("its owner, uid %d", uid)
+	}
+	return ""
+}
+
+// checkHardening logs every security problem and reports whether actions
+// may be executed: they may if there are none, or if force is set
+func checkHardening(cfg Config, force bool) bool {
+	problems := securityProblems(cfg)
+	if len(problems) == 0 {
+		return true
+	}
+	level := "[ERROR]"
+	if force {
+		level = "[WARN]"
+	}
+	for _, p := range problems {
+		log.Printf("%s Security check failed: %s", level, p)
+	}
+	if force {
+		log.Println("[WARN] Executing actions despite failed security checks (--force)")
+		return true
+	}
+	log.Println("[ERROR] Refusing to execute actions until the security checks pass - observing only; fix the problems above or run with --force")
+	return false
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// unixPermissions reports whether file modes and owners can be checked
+const unixPermissions = false
+
+// fileOwner is unknown without Unix file ownership
+func fileOwner(info os.FileInfo) (int, bool) {
+	return 0, false
+}
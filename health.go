@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthStaleCycles is how many poll intervals may pass without a successful
+// poll before the daemon is reported as wedged
+const healthStaleCycles = 3
+
+// Health tracks daemon progress for liveness and readiness probes
+type Health struct {
+	mu              sync.Mutex
+	started         time.Time     // When the daemon started
+	staleAfter      time.Duration // Poll age after which liveness fails
+	lastPoll        time.Time     // End of the last poll that reached at least one relay
+	relaysConnected int           // Relays reached in the last poll
+	relaysTotal     int           // Relays configured in the last poll
+	executing       string        // Key of the action mid-execution, empty if idle
+}
+
+// HealthReport is the JSON body served by the probe endpoints
+type HealthReport struct {
+	Status               string  `json:"status"`                  // "ok" or "unavailable"
+	RelaysConnected      int     `json:"relays_connected"`        // Relays reached in the last poll
+	RelaysTotal          int     `json:"relays_total"`            // Relays configured
+	LastPoll             string  `json:"last_poll,omitempty"`     // RFC3339 time of the last successful poll
+	SecondsSinceLastPoll float64 `json:"seconds_since_last_poll"` // Age of the last successful poll (or uptime if none)
+	Executing            string  `json:"executing,omitempty"`     // Action mid-execution, if any
+	Reason               string  `json:"reason,omitempty"`        // Why the probe failed
+}
+
+// newHealth returns probe state for a daemon polling every pollInterval
+func newHealth(pollInterval time.Duration) *Health {
+	return &Health{
+		started:    time.Now(),
+		staleAfter: healthStaleCycles * pollInterval,
+	}
+}
+
+// PollDone records the outcome of a relay poll. Polls that reached no relay
+// do not count as successful.
+func (h *Health) PollDone(connected, total int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.relaysConnected = connected
+	h.relaysTotal = total
+	if connected > 0 {
+		h.lastPoll = time.Now()
+	}
+}
+
+// SetExecuting marks action as mid-execution; an empty key clears it
+func (h *Health) SetExecuting(key string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.executing = key
+}
+
+// report builds the probe response. Liveness fails when no poll succeeded
+// for several intervals unless an action is executing, since executions may
+// legitimately outlast the poll interval. Readiness additionally requires
+// the last poll to have reached a relay.
+func (h *Health) report(ready bool) (HealthReport, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	since := h.started
+	if !h.lastPoll.IsZero() {
+		since = h.lastPoll
+	}
+	r := HealthReport{
+		Status:               "ok",
+		RelaysConnected:      h.relaysConnected,
+		RelaysTotal:          h.relaysTotal,
+		SecondsSinceLastPoll: time.Since(since).Seconds(),
+		Executing:            h.executing,
+	}
+	if !h.lastPoll.IsZero() {
+		r.LastPoll = h.lastPoll.UTC().Format(time.RFC3339)
+	}
+
+	switch {
+	case h.executing == "" && time.Since(since) > h.staleAfter:
+		r.Reason = "no successful relay poll within " + h.staleAfter.String()
+	case ready && h.lastPoll.IsZero():
+		r.Reason = "no successful relay poll yet"
+	case ready && h.relaysConnected == 0:
+		r.Reason = "no relay reachable in the last poll"
+	}
+	if r.Reason != "" {
+		r.Status = "unavailable"
+		return r, false
+	}
+	return r, true
+}
+
+// handler serves the liveness or readiness report
+func (h *Health) handler(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		r, ok := h.report(ready)
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(r)
+	}
+}
+
+// Serve starts the /healthz and /readyz endpoints on addr. The server is
+// closed when shutdown is requested.
+func (h *Health) Serve(addr string, shutdown *ShutdownHandler) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.handler(false))
+	mux.HandleFunc("/readyz", h.handler(true))
+
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		backgroundLog.Printf("[INFO] Serving health endpoints on %s", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			backgroundLog.Printf("[ERROR] Health endpoint failed: %v", err)
+		}
+	}()
+	go func() {
+		<-shutdown.Context().Done()
+		srv.Close()
+	}()
+}
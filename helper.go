@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/spf13/cobra"
+)
+
+// Defaults and limits of the executor helper
+const (
+	defaultHelperSocket = "/run/qube-manager/executor.sock"
+	helperMaxRequest    = 16384 // Longest request line the helper reads
+)
+
+// HelperExecutorConfig connects the unprivileged manager to the executor
+// helper, which performs actions as root with the backend in its own config.
+// The helper reads the same settings to know where to listen.
+type HelperExecutorConfig struct {
+	Socket string `yaml:"socket,omitempty"` // Unix socket of the helper (default /run/qube-manager/executor.sock)
+	Group  string `yaml:"group,omitempty"`  // Group allowed to connect, set by the helper on the socket (owner only if empty)
+}
+
+// socket returns the configured socket path or the default
+func (c HelperExecutorConfig) socket() string {
+	if c.Socket == "" {
+		return defaultHelperSocket
+	}
+	return c.Socket
+}
+
+// HelperRequest asks the helper to perform an action. It carries only the
+// data identifying the action, never commands: the helper builds every step
+// from its own config, so a compromised manager cannot choose what runs as
+// root beyond picking a version, genesis, or pinned release, which the
+// helper's steps verify against the announced hashes themselves.
+type HelperRequest struct {
+	Type        string           `json:"type"`                  // "upgrade", "reboot", or "rollback"
+	Version     string           `json:"version"`               // Semantic version
+	Genesis     string           `json:"genesis,omitempty"`     // Genesis URL for reboots
+	Source      *signal.Source   `json:"source,omitempty"`      // Commit an upgrade is built from
+	Artifact    *signal.Artifact `json:"artifact,omitempty"`    // Release binary, or genesis file of a reboot, and its SHA-256
+	ImageDigest string           `json:"imageDigest,omitempty"` // Digest of the container image
+}
+
+// HelperResponse is the helper's answer once the action finished or failed
+type HelperResponse struct {
+	Error string `json:"error,omitempty"` // Why the action failed, empty on success
+}
+
+// HelperExecutor hands actions to the executor helper over its socket
+type HelperExecutor struct {
+	cfg HelperExecutorConfig
+}
+
+func (e *HelperExecutor) Name() string { return "helper" }
+
+// Validate checks that the socket path is absolute
+func (e *HelperExecutor) Validate() error {
+	if !filepath.IsAbs(e.cfg.socket()) {
+		return fmt.Errorf("socket path must be absolute: %s", e.cfg.socket())
+	}
+	return nil
+}
+
+// Steps returns a single step that runs the whole action in the helper,
+// which keeps its own per-step state and resumes a failed step when the
+// request is repeated. Pinned sources, artifacts, and image digests are
+// forwarded, so the helper's backend verifies them as a local one would.
+func (e *HelperExecutor) Steps(action *signal.Action) ([]Step, error) {
+	if action.Version == nil {
+		return nil, fmt.Errorf("helper cannot perform %s", action.Type)
+	}
+	req := HelperRequest{
+		Type:        action.Type,
+		Version:     action.Version.Original(),
+		Genesis:     action.Genesis,
+		Source:      action.Source,
+		Artifact:    action.Artifact,
+		ImageDigest: action.ImageDigest,
+	}
+	return []Step{{Name: "helper", Run: func(ctx context.Context) error {
+		return e.request(ctx, req)
+	}}}, nil
+}
+
+// request sends req to the helper and waits for its response. Cancelling ctx
+// abandons the wait, but the helper finishes the action regardless.
+func (e *HelperExecutor) request(ctx context.Context, req HelperRequest) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", e.cfg.socket())
+	if err != nil {
+		return fmt.Errorf("executor helper unreachable: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request to executor helper: %w", err)
+	}
+	var resp HelperResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("no response from executor helper: %w", err)
+	}
+	if resp.Error != "" {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+// helperAction validates a request and returns the action it describes.
+// The request is parsed as the signal it stands for, so the helper applies
+// the same rules to versions, pinned commits, hashes, and digests as the
+// manager did, and derives the same key.
+func helperAction(req HelperRequest) (*signal.Action, error) {
+	if req.Genesis != "" && req.Type != signal.TypeReboot {
+		return nil, fmt.Errorf("%s takes no genesis", req.Type)
+	}
+	if req.Source != nil && req.Type != signal.TypeUpgrade {
+		return nil, fmt.Errorf("%s takes no source", req.Type)
+	}
+
+	var msg any
+	switch req.Type {
+	case signal.TypeUpgrade:
+		upgrade := signal.UpgradeMessage{Type: req.Type, Version: req.Version, Binary: req.Artifact, ImageDigest: req.ImageDigest}
+		if s := req.Source; s != nil {
+			upgrade.Repo, upgrade.Tag, upgrade.CommitHash = s.Repo, s.Tag, s.Commit
+		}
+		msg = upgrade
+	case signal.TypeReboot:
+		u, err := url.ParseRequestURI(req.Genesis)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			return nil, fmt.Errorf("invalid genesis URL %q", req.Genesis)
+		}
+		reboot := signal.RebootMessage{Type: req.Type, Version: req.Version, Genesis: req.Genesis, ImageDigest: req.ImageDigest}
+		if a := req.Artifact; a != nil {
+			if len(a.URLs) == 0 || a.URLs[0] != req.Genesis {
+				return nil, fmt.Errorf("genesis download does not start with the genesis URL %s", req.Genesis)
+			}
+			reboot.GenesisSHA256, reboot.GenesisMirrors = a.SHA256, a.URLs[1:]
+		}
+		msg = reboot
+	case signal.TypeRollback:
+		msg = signal.RollbackMessage{Type: req.Type, Version: req.Version, Binary: req.Artifact, ImageDigest: req.ImageDigest}
+	default:
+		return nil, fmt.Errorf("unsupported action type %q", req.Type)
+	}
+
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return signal.Parse(string(content))
+}
+
+func newExecutorHelperCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "executor-helper",
+		Short: "Perform actions as root on behalf of an unprivileged manager",
+		Long: `Perform actions as root on behalf of an unprivileged manager.
+The helper listens on executor.helper.socket and executes the upgrades,
+reboots, and rollbacks the manager requests with the executor backend in its
+own config, so only the helper needs root while the code talking to relays
+runs as a normal user. Only the executor section of the helper's config is
+used. Point the manager at it with executor.type: helper.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipKeypairAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			executorHelperCLI(g.configDir, g.stateDir)
+		},
+	}
+}
+
+// executorHelperCLI serves requests on the helper socket until SIGINT or
+// SIGTERM, finishing a running action before it exits. Only the executor
+// settings of the config are used, so the helper's config needs no relays or
+// follows.
+func executorHelperCLI(configDir, stateDir string) {
+	cfg, err := readConfig(configDir, stateDir)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		os.Exit(exitConfigError)
+	}
+	if cfg.Executor.Type == "helper" {
+		log.Fatal("[ERROR] The executor helper needs a shell, docker, systemd, or kubernetes executor in its own config")
+	}
+	ex, err := newExecutor(cfg.Executor)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if ex == nil {
+		log.Fatal("[ERROR] No executor configured; set executor.type to shell, docker, systemd, or kubernetes")
+	}
+
+	ln, err := listenHelper(cfg.Executor.Helper)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	log.Printf("[INFO] Executor helper listening on %s with %s executor", cfg.Executor.Helper.socket(), ex.Name())
+
+	shutdown := newShutdownHandler(cfg.ShutdownGracePeriod)
+	go func() {
+		<-shutdown.Context().Done()
+		ln.Close()
+	}()
+
+	h := &executorHelper{ex: ex, cfg: cfg.Executor, statePath: filepath.Join(stateDir, "helper-execution.yaml")}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if shutdown.Requested() {
+				break
+			}
+			log.Printf("[WARN] Executor helper failed to accept a connection: %v", err)
+			continue
+		}
+		done := shutdown.Track("executor helper request")
+		go func() {
+			defer done()
+			h.serve(conn)
+		}()
+	}
+
+	if !shutdown.Wait(cfg.ShutdownGracePeriod) {
+		log.Println("[WARN] Action still running at the end of the grace period; the next request resumes it")
+	}
+	log.Println("[INFO] Executor helper stopped")
+}
+
+// listenHelper opens the helper socket, replacing a stale one, and restricts
+// it to the owner and the configured group
+func listenHelper(cfg HelperExecutorConfig) (net.Listener, error) {
+	path := cfg.socket()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+
+	mode := os.FileMode(0600)
+	if cfg.Group != "" {
+		grp, err := user.LookupGroup(cfg.Group)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("unknown socket group %s: %w", cfg.Group, err)
+		}
+		gid, _ := strconv.Atoi(grp.Gid)
+		if err := os.Chown(path, -1, gid); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("failed to hand socket to group %s: %w", cfg.Group, err)
+		}
+		mode = 0660
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to restrict socket %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+// executorHelper performs requested actions one at a time
+type executorHelper struct {
+	mu        sync.Mutex     // serializes executions
+	ex        Executor       // backend from the helper's config
+	cfg       ExecutorConfig // retry and timeout settings
+	statePath string         // execution state file of the helper
+}
+
+// serve reads a single request from conn, performs it, and writes the result
+func (h *executorHelper) serve(conn net.Conn) {
+	defer conn.Close()
+
+	line, err := bufio.NewReader(io.LimitReader(conn, helperMaxRequest)).ReadBytes('\n')
+	if err != nil {
+		if len(line) > 0 {
+			log.Printf("[WARN] Executor helper received an incomplete request: %v", err)
+		}
+		return
+	}
+
+	var resp HelperResponse
+	var req HelperRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		resp.Error = "invalid request: " + err.Error()
+	} else if err := h.perform(req); err != nil {
+		resp.Error = err.Error()
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Printf("[WARN] Executor helper could not deliver the result: %v", err)
+	}
+}
+
+// perform executes the requested action, resuming its persisted state. The
+// state of a completed action is kept until a different action is requested,
+// so a request repeated after a lost response completes without running any
+// step again.
+func (h *executorHelper) perform(req HelperRequest) error {
+	action, err := helperAction(req)
+	if err != nil {
+		log.Printf("[WARN] Executor helper rejected request: %v", err)
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if existing, err := loadExecutionState(h.statePath); err != nil {
+		return err
+	} else if existing != nil && existing.Status == execCompleted && existing.Action != action.Key {
+		if err := existing.Clear(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[INFO] Executor helper performing %s", action.Key)
+	state, err := prepareExecution(h.statePath, h.ex, action, nil)
+	if err != nil {
+		return err
+	}
+	if err := executeAction(context.Background(), h.ex, action, state, h.cfg, nil); err != nil {
+		log.Printf("[ERROR] Executor helper failed to perform %s: %v", action.Key, err)
+		return err
+	}
+	return nil
+}
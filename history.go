@@ -4,31 +4,199 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/hypercore-one/qube-manager/signal"
 	"gopkg.in/yaml.v3"
 )
 
-// History tracks performed actions to ensure idempotency
+// History tracks performed actions to ensure idempotency, and the lifecycle
+// of actions that are still in progress
 type History struct {
-	Entries map[string]string `yaml:"entries"` // key: message key, value: ISO8601 timestamp
-	path    string            // history file path (not in YAML)
+	Entries    map[string]HistoryEntry     `yaml:"entries"`              // key: message key
+	Lifecycle  map[string]*ActionLifecycle `yaml:"lifecycle,omitempty"`  // key: action key
+	Network    string                      `yaml:"network,omitempty"`    // network that entries recorded before one was configured belong to
+	Compacted  map[string]string           `yaml:"compacted,omitempty"`  // key: network, type, and service; highest version earlier releases compacted out of entries
+	Tombstones []string                    `yaml:"tombstones,omitempty"` // Sorted keys of actions compacted out of entries
+	path       string                      // history file path (not in YAML)
+	changed    bool                        // lifecycle changed since the last save (not in YAML)
+	retention  HistoryRetention            // compaction applied on save (not in YAML)
+	observer   func(string, string, error) // called on every lifecycle transition (not in YAML)
+
+	// Held while history changes or is written, so a flush during a forced
+	// shutdown never writes it halfway through a change (not in YAML)
+	mu sync.Mutex
+}
+
+// HistoryEntry records when an action was performed and the votes that
+// carried it to quorum, as evidence of why the node acted. Entries recorded
+// without votes, e.g. recovered from done events or executed by another
+// instance, carry only the time.
+type HistoryEntry struct {
+	PerformedAt string        `yaml:"performed_at" json:"performed_at"`       // ISO8601 timestamp
+	Votes       []signal.Vote `yaml:"votes,omitempty" json:"votes,omitempty"` // Votes counted when the action was performed, with their signed events, sorted by pubkey
+}
+
+// UnmarshalYAML accepts both the bare timestamp form of earlier history
+// files and the mapping form
+func (e *HistoryEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		e.PerformedAt = node.Value
+		return nil
+	}
+	type plain HistoryEntry
+	return node.Decode((*plain)(e))
 }
 
-// Has checks if an action key is already recorded in history
+// MarshalYAML writes entries without votes as bare timestamps
+func (e HistoryEntry) MarshalYAML() (any, error) {
+	if len(e.Votes) == 0 {
+		return e.PerformedAt, nil
+	}
+	type plain HistoryEntry
+	return plain(e), nil
+}
+
+// Has checks if an action key is already recorded in history, in full or
+// compacted
 func (h *History) Has(key string) bool {
 	_, ok := h.Entries[key]
-	return ok
+	return ok || h.compacted(key)
+}
+
+// LastPerformed returns when the most recent action in history was
+// performed, or the zero time if none was
+func (h *History) LastPerformed() time.Time {
+	var last time.Time
+	for _, entry := range h.Entries {
+		if t, err := time.Parse(time.RFC3339, entry.PerformedAt); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return last
 }
 
-// Add records a new action with the current UTC timestamp
-func (h *History) Add(key string) {
-	h.Entries[key] = time.Now().UTC().Format(time.RFC3339)
+// Add records a new action with the current UTC timestamp and the votes
+// that carried it to quorum, if known
+func (h *History) Add(key string, votes map[string]signal.Vote) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry := HistoryEntry{PerformedAt: time.Now().UTC().Format(time.RFC3339)}
+	for _, v := range votes {
+		entry.Votes = append(entry.Votes, v)
+	}
+	slices.SortFunc(entry.Votes, func(a, b signal.Vote) int { return strings.Compare(a.PubKey, b.PubKey) })
+	h.Entries[key] = entry
 	log.Printf("[INFO] Added history entry for key: %s", key)
+	h.rolledBack(key)
+	if _, ok := h.Lifecycle[key]; ok {
+		h.transition(key, stateDone, nil)
+	}
 }
 
-// Save writes the history back to the YAML file
+// Recover records an action confirmed by a done event published at
+// performed, unless history already has it. Keys signaled without a network
+// are recorded under the configured one, as SetNetwork does. It returns
+// true if the entry was added.
+func (h *History) Recover(key string, performed time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Network != "" && unnamespacedKey(key) {
+		key = signal.NetworkKey(h.Network, key)
+	}
+	if h.Has(key) {
+		return false
+	}
+	h.Entries[key] = HistoryEntry{PerformedAt: performed.UTC().Format(time.RFC3339)}
+	log.Printf("[INFO] Recovered history entry for key: %s", key)
+	return true
+}
+
+// SetNetwork moves entries recorded before a network was configured under
+// network the first time one is, since they were performed on it. It
+// returns true if history changed. Later changes of network leave history
+// alone: actions are keyed by network, so a node moved to another network
+// does not skip actions it only performed on the previous one.
+func (h *History) SetNetwork(network string) bool {
+	if network == "" {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Network != "" {
+		if h.Network != network {
+			log.Printf("[INFO] Network changed from %s to %s; actions performed on %s do not count for %s", h.Network, network, h.Network, network)
+		}
+		return false
+	}
+
+	h.Network = network
+	moved := 0
+	for key, performed := range h.Entries {
+		if unnamespacedKey(key) {
+			delete(h.Entries, key)
+			h.Entries[signal.NetworkKey(network, key)] = performed
+			moved++
+		}
+	}
+	for key, lc := range h.Lifecycle {
+		if unnamespacedKey(key) {
+			delete(h.Lifecycle, key)
+			h.Lifecycle[signal.NetworkKey(network, key)] = lc
+		}
+	}
+	for i, key := range h.Tombstones {
+		if unnamespacedKey(key) {
+			h.Tombstones[i] = signal.NetworkKey(network, key)
+		}
+	}
+	slices.Sort(h.Tombstones)
+	for track, v := range h.Compacted {
+		if !strings.Contains(track, "/") {
+			delete(h.Compacted, track)
+			h.Compacted[signal.NetworkKey(network, track)] = v
+		}
+	}
+	if moved > 0 {
+		log.Printf("[INFO] Recorded %d earlier history entries as performed on network %s", moved, network)
+	}
+	return true
+}
+
+// unnamespacedKey reports whether key is the key of an upgrade, reboot, or
+// rollback signaled without a network. Key revocations apply to every
+// network and are never namespaced.
+func unnamespacedKey(key string) bool {
+	for _, t := range []string{signal.TypeUpgrade, signal.TypeReboot, signal.TypeRollback} {
+		if strings.HasPrefix(key, t+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// Save compacts the history per its retention policy and writes it back to
+// the YAML file
 func (h *History) Save() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.compact(time.Now())
+	return h.write()
+}
+
+// Flush writes the history as it is, without compacting it. It is safe to
+// call while another goroutine changes history, e.g. from a shutdown flush.
+func (h *History) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.write()
+}
+
+// write saves the history to its YAML file with h.mu held
+func (h *History) write() error {
 	data, err := yaml.Marshal(h)
 	if err != nil {
 		log.Printf("[ERROR] Failed to marshal history: %v", err)
@@ -38,16 +206,18 @@ func (h *History) Save() error {
 		log.Printf("[ERROR] Failed to write history file %s: %v", h.path, err)
 		return err
 	}
+	h.changed = false
 	log.Printf("[INFO] History saved successfully to %s", h.path)
 	return nil
 }
 
 // loadHistory reads the YAML history file or creates a new empty history if missing
-func loadHistory(configDir string) *History {
-	path := filepath.Join(configDir, "history.yaml")
+func loadHistory(stateDir string) *History {
+	path := filepath.Join(stateDir, "history.yaml")
 	h := &History{
-		Entries: make(map[string]string),
-		path:    path,
+		Entries:   make(map[string]HistoryEntry),
+		Lifecycle: make(map[string]*ActionLifecycle),
+		path:      path,
 	}
 
 	if _, err := os.Stat(path); err == nil {
@@ -59,6 +229,9 @@ func loadHistory(configDir string) *History {
 		if err := yaml.Unmarshal(data, h); err != nil {
 			log.Fatalf("[ERROR] Failed to parse history file %s: %v", path, err)
 		}
+		if h.Lifecycle == nil {
+			h.Lifecycle = make(map[string]*ActionLifecycle)
+		}
 		log.Printf("[INFO] History loaded: %d entries", len(h.Entries))
 	} else if os.IsNotExist(err) {
 		log.Printf("[WARN] History file does not exist, creating new one at %s", path)
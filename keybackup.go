@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
+	"github.com/tyler-smith/go-bip39"
+	"github.com/zalando/go-keyring"
+)
+
+// skipKeypairAnnotation marks commands that must not load or create the
+// keypair before they run
+const skipKeypairAnnotation = "qube-manager/skip-keypair"
+
+// KeyBackup is the output of keys backup
+type KeyBackup struct {
+	Npub     string `json:"npub"`     // Identity the mnemonic restores
+	Mnemonic string `json:"mnemonic"` // 24-word BIP-39 encoding of the private key
+}
+
+// KeyIdentity is a named identity as listed by keys list
+type KeyIdentity struct {
+	Name string `json:"name"` // Identity name, "node" for the manager's own key
+	Npub string `json:"npub"` // Public key of the identity
+}
+
+func newKeysCommand(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "List, back up, and restore the manager's identities",
+		Long: `List, back up, and restore the manager's identities. keys.json holds the node
+identity, which reports on actions, and may hold further named identities,
+e.g. an operator key that signs signals. --identity selects the one a command
+uses. The node identity is created on the first run, others with keys create.`,
+	}
+
+	create := &cobra.Command{
+		Use:         "create",
+		Short:       "Create the identity selected with --identity",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipKeypairAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			keysCreateCLI(g.configDir, g.identity, g.output)
+		},
+	}
+
+	list := &cobra.Command{
+		Use:         "list",
+		Short:       "List the identities in keys.json",
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipKeypairAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			keysListCLI(g.configDir, g.output)
+		},
+	}
+
+	backup := &cobra.Command{
+		Use:   "backup",
+		Short: "Print the identity's private key as a 24-word BIP-39 mnemonic to write down",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			keysBackupCLI(g.keypair, g.identity, g.output)
+		},
+	}
+
+	var force bool
+	restore := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore the identity's private key from a BIP-39 mnemonic read from stdin",
+		Long: `Restore the private key from a BIP-39 mnemonic read from stdin, so the phrase
+stays out of the shell history. The key is stored where key_store selects.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{skipKeypairAnnotation: "true"},
+		Run: func(cmd *cobra.Command, args []string) {
+			keysRestoreCLI(g.configDir, g.identity, g.output, os.Stdin, force)
+		},
+	}
+	restore.Flags().BoolVar(&force, "force", false, "Replace an existing, different private key")
+
+	cmd.AddCommand(list, create, backup, restore)
+	return cmd
+}
+
+// keyMnemonic encodes an nsec as a BIP-39 mnemonic. The 32-byte private key
+// is used as the entropy, so the phrase is the key itself, not a seed it is
+// derived from.
+func keyMnemonic(nsec string) (string, error) {
+	_, sk, err := nip19.Decode(nsec)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	entropy, err := hex.DecodeString(sk.(string))
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// keypairFromMnemonic decodes a mnemonic made by keyMnemonic, checking its
+// checksum and that it holds a valid private key
+func keypairFromMnemonic(mnemonic string) (Keypair, error) {
+	mnemonic = strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")
+	entropy, err := bip39.EntropyFromMnemonic(mnemonic)
+	if err != nil {
+		return Keypair{}, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+	if len(entropy) != 32 {
+		return Keypair{}, fmt.Errorf("mnemonic holds %d bytes, a private key has 32 (expected 24 words)", len(entropy))
+	}
+
+	sk := hex.EncodeToString(entropy)
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return Keypair{}, fmt.Errorf("mnemonic does not hold a valid private key: %w", err)
+	}
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	npub, _ := nip19.EncodePublicKey(pk)
+	return Keypair{Nsec: nsec, Npub: npub}, nil
+}
+
+// keysBackupCLI prints the private key of an identity as a mnemonic
+func keysBackupCLI(kp Keypair, identity, output string) {
+	mnemonic, err := keyMnemonic(kp.Nsec)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	if output == outputJSON {
+		printJSON(KeyBackup{Npub: kp.Npub, Mnemonic: mnemonic})
+		return
+	}
+	fmt.Printf("Mnemonic for %s:\n\n", kp.Npub)
+	for i, word := range strings.Fields(mnemonic) {
+		fmt.Printf("%2d. %s\n", i+1, word)
+	}
+	fmt.Println("\nAnyone holding these words can sign as this manager. Write them down and")
+	restore := "qube-manager keys restore"
+	if identity != defaultIdentity {
+		restore += " --identity " + identity
+	}
+	fmt.Printf("keep them offline; restore them with '%s'.\n", restore)
+}
+
+// keysRestoreCLI reads a mnemonic from r and stores the private key it holds
+// as the identity in the configured key store, refusing to replace a
+// different key unless forced
+func keysRestoreCLI(configDir, identity, output string, r io.Reader, force bool) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read mnemonic: %v", err)
+	}
+	kp, err := keypairFromMnemonic(string(data))
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	if existing := existingNpub(configDir, identity); existing != "" {
+		switch {
+		case existing == kp.Npub:
+			log.Printf("[INFO] The private key of %s is already in place", kp.Npub)
+			printRestored(kp, output)
+			return
+		case !force:
+			log.Fatalf("[ERROR] Identity %s holds a different key (%s); pass --force to replace it", identity, existing)
+		}
+		log.Printf("[WARN] Replacing the key of %s", existing)
+	}
+
+	if configuredKeyStore(configDir) == keyStoreKeyring {
+		user := keyringUser(configDir, identity)
+		if err := keyring.Set(keyringService, user, kp.Nsec); err != nil {
+			log.Fatalf("[ERROR] Failed to store private key in the OS keyring: %v", err)
+		}
+		writeKeyFile(configDir, identity, Keypair{Npub: kp.Npub})
+		log.Printf("[INFO] Private key stored in the OS keyring (service %s, account %s)", keyringService, user)
+	} else {
+		writeKeyFile(configDir, identity, kp)
+		log.Printf("[INFO] Private key of identity %s written to %s", identity, filepath.Join(configDir, "keys.json"))
+	}
+	printRestored(kp, output)
+}
+
+// existingNpub returns the npub of the identity's key already in the keyring
+// or the config dir, or "" if there is none
+func existingNpub(configDir, identity string) string {
+	if configuredKeyStore(configDir) == keyStoreKeyring {
+		nsec, err := keyring.Get(keyringService, keyringUser(configDir, identity))
+		if err == nil {
+			return npubFromNsec(nsec)
+		}
+		if !errors.Is(err, keyring.ErrNotFound) {
+			log.Fatalf("[ERROR] Failed to read private key from the OS keyring: %v", err)
+		}
+		// A key not yet migrated to the keyring is still in keys.json
+	}
+
+	f, err := readKeyFile(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read %s: %v", filepath.Join(configDir, "keys.json"), err)
+	}
+	return npubFromNsec(f.Identity(identity).Nsec)
+}
+
+// keysCreateCLI creates a new key for an identity in the configured key
+// store, refusing to replace an existing one
+func keysCreateCLI(configDir, identity, output string) {
+	f, err := readKeyFile(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read %s: %v", filepath.Join(configDir, "keys.json"), err)
+	}
+	if kp := f.Identity(identity); kp.Nsec != "" || kp.Npub != "" {
+		log.Fatalf("[ERROR] Identity %s already exists", identity)
+	}
+
+	kp := loadKeypair(configDir, identity, true)
+	if output == outputJSON {
+		printJSON(KeyIdentity{Name: identity, Npub: kp.Npub})
+		return
+	}
+	fmt.Printf("Created identity %s: %s\n", identity, kp.Npub)
+}
+
+// keysListCLI prints the identities in keys.json with their npubs
+func keysListCLI(configDir, output string) {
+	f, err := readKeyFile(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read %s: %v", filepath.Join(configDir, "keys.json"), err)
+	}
+
+	identities := make([]KeyIdentity, 0, len(f.Identities)+1)
+	for _, name := range f.Names() {
+		kp := f.Identity(name)
+		npub := kp.Npub
+		if npub == "" {
+			npub = npubFromNsec(kp.Nsec)
+		}
+		identities = append(identities, KeyIdentity{Name: name, Npub: npub})
+	}
+
+	if output == outputJSON {
+		printJSON(identities)
+		return
+	}
+	if len(identities) == 0 {
+		fmt.Println("No identities yet; the node identity is created on the first run.")
+		return
+	}
+	for _, id := range identities {
+		fmt.Printf("%-16s %s\n", id.Name, id.Npub)
+	}
+}
+
+// printRestored reports the restored identity
+func printRestored(kp Keypair, output string) {
+	if output == outputJSON {
+		printJSON(map[string]string{"npub": kp.Npub})
+		return
+	}
+	fmt.Printf("Restored %s\n", kp.Npub)
+}
@@ -1,41 +1,299 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/zalando/go-keyring"
+	"gopkg.in/yaml.v3"
 )
 
+// Places the private key can be kept, selected with key_store
+const (
+	keyStoreFile    = "file"    // nsec stored in keys.json
+	keyStoreKeyring = "keyring" // nsec stored in the OS keyring (Secret Service, Keychain, Credential Manager)
+)
+
+// keyringService is the service name the nsec is stored under in the OS keyring
+const keyringService = "qube-manager"
+
+// defaultIdentity names the manager's own key, which reports on actions and
+// is kept at the top level of keys.json as single-key setups have it
+const defaultIdentity = "node"
+
 type Keypair struct {
-	Nsec string `json:"nsec"` // nsec...
-	Npub string `json:"npub"` // npub...
+	Nsec string `json:"nsec,omitempty"` // nsec... (empty in keys.json when kept in the keyring)
+	Npub string `json:"npub,omitempty"` // npub...
+}
+
+// KeyFile is the layout of keys.json: the node identity at the top level and
+// further named identities, e.g. an operator key signing signals, next to it
+type KeyFile struct {
+	Keypair
+	Identities map[string]Keypair `json:"identities,omitempty"` // Identity name -> keypair
+}
+
+// configuredKeyStore reads key_store from the config file without the full
+// load, since the keypair is needed before the config is validated
+func configuredKeyStore(configDir string) string {
+	data, err := readConfigData(configDir)
+	if err != nil {
+		return keyStoreFile
+	}
+	var cfg struct {
+		KeyStore string `yaml:"key_store"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil || cfg.KeyStore == "" {
+		return keyStoreFile
+	}
+	return cfg.KeyStore
+}
+
+// keyringUser returns the keyring account of an identity in a config
+// directory so several managers on one host keep separate keys. The node
+// identity keeps the account of single-key setups.
+func keyringUser(configDir, identity string) string {
+	user := configDir
+	if abs, err := filepath.Abs(configDir); err == nil {
+		user = abs
+	}
+	if identity != defaultIdentity {
+		user += "#" + identity
+	}
+	return user
+}
+
+// validIdentity reports whether name can name an identity: lowercase
+// letters, digits, dashes, and underscores
+func validIdentity(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
 }
 
-func loadOrCreateKeypair(configDir string) Keypair {
+// readKeyFile reads keys.json, returning an empty key file if it is missing
+func readKeyFile(configDir string) (KeyFile, error) {
+	var f KeyFile
+	data, err := readSecretFile(filepath.Join(configDir, "keys.json"), configDir)
+	if os.IsNotExist(err) {
+		return f, nil
+	} else if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return KeyFile{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return f, nil
+}
+
+// Identity returns the keypair of the named identity
+func (f KeyFile) Identity(name string) Keypair {
+	if name == defaultIdentity {
+		return f.Keypair
+	}
+	return f.Identities[name]
+}
+
+// hasPrivateKey reports whether any identity in the file holds its nsec
+func (f KeyFile) hasPrivateKey() bool {
+	for _, name := range f.Names() {
+		if f.Identity(name).Nsec != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Names returns the identities the file holds, the node identity first
+func (f KeyFile) Names() []string {
+	var names []string
+	if f.Npub != "" || f.Nsec != "" {
+		names = append(names, defaultIdentity)
+	}
+	rest := make([]string, 0, len(f.Identities))
+	for name := range f.Identities {
+		rest = append(rest, name)
+	}
+	slices.Sort(rest)
+	return append(names, rest...)
+}
+
+// loadOrCreateKeypair returns the keypair of an identity. The node identity
+// is created on first use; other identities must have been created with
+// 'keys create', so a mistyped --identity never signs with a fresh key.
+func loadOrCreateKeypair(configDir, identity string) Keypair {
+	return loadKeypair(configDir, identity, identity == defaultIdentity)
+}
+
+// loadKeypair returns the keypair of an identity, creating one if the
+// identity has no key yet and create is set
+func loadKeypair(configDir, identity string, create bool) Keypair {
 	keyPath := filepath.Join(configDir, "keys.json")
 
-	if data, err := os.ReadFile(keyPath); err == nil {
-		var kp Keypair
-		if json.Unmarshal(data, &kp) == nil {
-			return kp
+	// An encrypted key file that cannot be decrypted, or one that does not
+	// parse, must not be replaced by a fresh identity
+	f, err := readKeyFile(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read %s: %v", keyPath, err)
+	}
+	kp := f.Identity(identity)
+	if kp.Nsec == "" && kp.Npub == "" && !create {
+		log.Fatalf("[ERROR] Identity %s is not in %s; create it with 'qube-manager keys create --identity %s'", identity, keyPath, identity)
+	}
+
+	if configuredKeyStore(configDir) == keyStoreKeyring {
+		return loadKeyringKeypair(configDir, identity, kp)
+	}
+
+	if kp.Nsec != "" {
+		return kp
+	}
+	if kp.Npub != "" {
+		return migrateKeyringKeypair(configDir, identity, kp.Npub)
+	}
+
+	kp = generateKeypair()
+	writeKeyFile(configDir, identity, kp)
+	if identity != defaultIdentity {
+		log.Printf("[INFO] Created identity %s (%s)", identity, kp.Npub)
+	}
+	return kp
+}
+
+// loadKeyringKeypair returns the keypair whose nsec is kept in the OS
+// keyring. A plaintext nsec still present in keys.json is migrated into the
+// keyring and removed from the file.
+func loadKeyringKeypair(configDir, identity string, fileKp Keypair) Keypair {
+	user := keyringUser(configDir, identity)
+
+	nsec, err := keyring.Get(keyringService, user)
+	switch {
+	case err == nil:
+		if fileKp.Nsec != "" && fileKp.Nsec != nsec {
+			log.Fatalf("[ERROR] keys.json holds a different nsec than the OS keyring for identity %s; remove one of them to choose the key to keep", identity)
+		}
+		kp := Keypair{Nsec: nsec, Npub: fileKp.Npub}
+		if kp.Npub == "" || fileKp.Nsec != "" {
+			kp.Npub = npubFromNsec(nsec)
+			writeKeyFile(configDir, identity, Keypair{Npub: kp.Npub})
+		}
+		return kp
+
+	case errors.Is(err, keyring.ErrNotFound):
+		kp := fileKp
+		if kp.Nsec != "" {
+			log.Println("[INFO] Migrating private key from keys.json to the OS keyring")
+		} else {
+			kp = generateKeypair()
+		}
+		if err := keyring.Set(keyringService, user, kp.Nsec); err != nil {
+			log.Fatalf("[ERROR] Failed to store private key in the OS keyring: %v", err)
 		}
+		writeKeyFile(configDir, identity, Keypair{Npub: kp.Npub})
+		log.Printf("[INFO] Private key stored in the OS keyring (service %s, account %s)", keyringService, user)
+		return kp
+
+	default:
+		log.Fatalf("[ERROR] Failed to read private key from the OS keyring: %v", err)
+		return Keypair{}
+	}
+}
+
+// migrateKeyringKeypair moves the nsec of an identity whose keys.json entry
+// only holds its npub back from the OS keyring into keys.json, after
+// key_store was switched from keyring to file. The identity is never
+// replaced by a fresh key.
+func migrateKeyringKeypair(configDir, identity, npub string) Keypair {
+	user := keyringUser(configDir, identity)
+	nsec, err := keyring.Get(keyringService, user)
+	if err != nil {
+		log.Fatalf("[ERROR] keys.json holds no private key for identity %s (%s) and the OS keyring has none either (%v); set key_store: keyring again or restore the key with 'qube-manager keys restore'", identity, npub, err)
 	}
+	if npubFromNsec(nsec) != npub {
+		log.Fatalf("[ERROR] The OS keyring holds a different key than keys.json for identity %s; restore the key of %s with 'qube-manager keys restore'", identity, npub)
+	}
+
+	kp := Keypair{Nsec: nsec, Npub: npub}
+	log.Println("[INFO] Migrating private key from the OS keyring to keys.json")
+	writeKeyFile(configDir, identity, kp)
+	if err := keyring.Delete(keyringService, user); err != nil {
+		log.Printf("[WARN] Failed to remove the private key from the OS keyring: %v", err)
+	}
+	return kp
+}
 
-	// Generate new key
+// generateKeypair creates a new random keypair
+func generateKeypair() Keypair {
 	sk := nostr.GeneratePrivateKey()
 	pk, _ := nostr.GetPublicKey(sk)
 	nsec, _ := nip19.EncodePrivateKey(sk)
 	npub, _ := nip19.EncodePublicKey(pk)
+	return Keypair{Nsec: nsec, Npub: npub}
+}
 
-	kp := Keypair{
-		Nsec: nsec,
-		Npub: npub,
+// npubFromNsec derives the npub of an nsec, returning "" if it is invalid
+func npubFromNsec(nsec string) string {
+	_, sk, err := nip19.Decode(nsec)
+	if err != nil {
+		return ""
+	}
+	pk, err := nostr.GetPublicKey(sk.(string))
+	if err != nil {
+		return ""
 	}
-	data, _ := json.MarshalIndent(kp, "", "  ")
+	npub, _ := nip19.EncodePublicKey(pk)
+	return npub
+}
+
+// writeKeyFile saves an identity to keys.json, keeping the others. Only the
+// npub is saved when the nsec is kept in the keyring. A private key, of
+// this identity or any other, is never written in plaintext over an
+// encrypted key file: an age-encrypted one is encrypted again, and a SOPS
+// one must be decrypted by hand.
+func writeKeyFile(configDir, identity string, kp Keypair) {
+	path := filepath.Join(configDir, "keys.json")
+	f, err := readKeyFile(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read %s: %v", path, err)
+	}
+	if identity == defaultIdentity {
+		f.Keypair = kp
+	} else {
+		if f.Identities == nil {
+			f.Identities = make(map[string]Keypair)
+		}
+		f.Identities[identity] = kp
+	}
+
+	data, _ := json.MarshalIndent(f, "", "  ")
+	if raw, err := os.ReadFile(path); err == nil && f.hasPrivateKey() {
+		switch {
+		case bytes.HasPrefix(bytes.TrimSpace(raw), []byte(ageArmorHeader)):
+			if data, err = ageEncrypt(data, configDir); err != nil {
+				log.Fatalf("[ERROR] %s is encrypted and could not be encrypted again to change identity %s: %v", path, identity, err)
+			}
+			log.Printf("[INFO] Encrypted %s again to the recipient of the age identity", path)
+		case isSOPSFile(raw):
+			log.Fatalf("[ERROR] %s is encrypted with SOPS; decrypt it to change identity %s, then encrypt it again", path, identity)
+		}
+	}
+
 	os.MkdirAll(configDir, 0700)
-	_ = os.WriteFile(keyPath, data, 0600)
-	return kp
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		log.Printf("[WARN] Failed to write keys.json: %v", err)
+	}
 }
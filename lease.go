@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"time"
+)
+
+// leaseTimeoutCycles is how many poll intervals a lease stays valid without
+// renewal when lease_timeout is unset
+const leaseTimeoutCycles = 3
+
+// CoordinationConfig lets redundant managers watching the same node agree
+// on a single instance that executes actions
+type CoordinationConfig struct {
+	LeaseFile    string        `yaml:"lease_file,omitempty"`    // Lease file on storage shared by all instances (coordination disabled if unset)
+	LeaseTimeout time.Duration `yaml:"lease_timeout,omitempty"` // How long the leader's lease stays valid without renewal (default 3 poll intervals)
+	InstanceID   string        `yaml:"instance_id,omitempty"`   // Name of this instance in the lease (hostname and config dir if unset)
+}
+
+// Enabled reports whether a lease file is configured
+func (c CoordinationConfig) Enabled() bool {
+	return c.LeaseFile != ""
+}
+
+// Lease is the content of the lease file: the instance allowed to execute
+// actions, when it last renewed its claim, and the actions it completed
+type Lease struct {
+	Holder    string   `json:"holder"`              // Instance ID of the leader
+	RenewedAt string   `json:"renewed_at"`          // ISO8601 timestamp of the last renewal
+	Completed []string `json:"completed,omitempty"` // Keys of actions the leaders executed
+}
+
+// expired reports whether the lease was last renewed more than timeout ago
+func (l Lease) expired(timeout time.Duration) bool {
+	renewed, err := time.Parse(time.RFC3339, l.RenewedAt)
+	return err != nil || time.Since(renewed) > timeout
+}
+
+// Coordinator claims and renews the lease for this instance. A nil
+// *Coordinator always leads.
+type Coordinator struct {
+	cfg CoordinationConfig
+	id  string
+}
+
+// newCoordinator returns the coordinator for cfg, or nil if coordination is
+// disabled
+func newCoordinator(cfg Config) *Coordinator {
+	if !cfg.Coordination.Enabled() {
+		return nil
+	}
+	id := cfg.Coordination.InstanceID
+	if id == "" {
+		host, _ := os.Hostname()
+		id = host + ":" + cfg.ConfigPath
+	}
+	return &Coordinator{cfg: cfg.Coordination, id: id}
+}
+
+// Claim renews the lease if this instance holds it or the holder stopped
+// renewing it. It returns whether this instance leads and the current
+// holder. Instances that cannot reach the lease file never lead.
+func (c *Coordinator) Claim() (bool, string) {
+	if c == nil {
+		return true, ""
+	}
+	lease, err := c.update(func(l *Lease) {
+		if l.Holder == c.id || l.Holder == "" || l.expired(c.cfg.LeaseTimeout) {
+			if l.Holder != c.id {
+				log.Printf("[INFO] Taking over the execution lease from %q", l.Holder)
+			}
+			l.Holder = c.id
+			l.RenewedAt = time.Now().UTC().Format(time.RFC3339)
+		}
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to claim the execution lease: %v", err)
+		return false, ""
+	}
+	return lease.Holder == c.id, lease.Holder
+}
+
+// KeepAlive renews the lease every third of the lease timeout until the
+// returned function is called, so a long execution never lets it expire
+func (c *Coordinator) KeepAlive() func() {
+	if c == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(c.cfg.LeaseTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				c.Claim()
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// Complete records an executed action in the lease so standby instances
+// add it to their history instead of executing it again
+func (c *Coordinator) Complete(key string) {
+	if c == nil {
+		return
+	}
+	_, err := c.update(func(l *Lease) {
+		if !slices.Contains(l.Completed, key) {
+			l.Completed = append(l.Completed, key)
+		}
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to record %s in the execution lease: %v", key, err)
+	}
+}
+
+// Sync adds the actions the leaders completed to history. It returns
+// whether history changed.
+func (c *Coordinator) Sync(history *History) bool {
+	if c == nil {
+		return false
+	}
+	lease, err := c.update(func(*Lease) {})
+	if err != nil {
+		log.Printf("[WARN] Failed to read the execution lease: %v", err)
+		return false
+	}
+	changed := false
+	for _, key := range lease.Completed {
+		if !history.Has(key) {
+			log.Printf("[INFO] Action %s was executed by instance %q", key, lease.Holder)
+			history.Add(key, nil)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// update locks the lease file, applies fn to the lease, and writes it back
+// if it changed. It returns the lease as written.
+func (c *Coordinator) update(fn func(*Lease)) (Lease, error) {
+	f, err := os.OpenFile(c.cfg.LeaseFile, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return Lease{}, err
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return Lease{}, err
+	}
+	defer unlockFile(f)
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return Lease{}, err
+	}
+	var lease Lease
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &lease); err != nil {
+			return Lease{}, fmt.Errorf("invalid lease file %s: %w", c.cfg.LeaseFile, err)
+		}
+	}
+
+	fn(&lease)
+	updated, err := json.Marshal(lease)
+	if err != nil || string(updated) == string(data) {
+		return lease, err
+	}
+	if err := f.Truncate(0); err != nil {
+		return Lease{}, err
+	}
+	if _, err := f.WriteAt(updated, 0); err != nil {
+		return Lease{}, err
+	}
+	return lease, f.Sync()
+}
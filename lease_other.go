@@ -0,0 +1,19 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// lockFile is only implemented on Unix systems
+func lockFile(f *os.File) error {
+	return fmt.Errorf("file locking is not supported on this platform: %w", errors.ErrUnsupported)
+}
+
+// unlockFile is only implemented on Unix systems
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// Lifecycle states of an action, persisted per action in the history file
+const (
+	stateDetected  = "detected"  // signals seen, quorum not reached yet
+	stateQuorum    = "quorum"    // quorum reached and the action was selected
+	stateScheduled = "scheduled" // waiting for its staggered or notBefore execution time
+	stateExecuting = "executing" // executor steps running
+	stateVerifying = "verifying" // steps finished, checking the node runs the new version
+	stateDone      = "done"      // recorded in history and announced
+	stateFailed    = "failed"    // execution or verification failed; retried on a later run
+)
+
+// lifecycleStates lists the states in the order an action moves through them
+var lifecycleStates = []string{stateDetected, stateQuorum, stateScheduled, stateExecuting, stateVerifying, stateDone, stateFailed}
+
+// StateChange records when an action entered a lifecycle state
+type StateChange struct {
+	State string `yaml:"state"`           // Lifecycle state entered
+	At    string `yaml:"at"`              // ISO8601 timestamp of the transition
+	Error string `yaml:"error,omitempty"` // Failure reason for the failed state
+}
+
+// ActionLifecycle tracks an action from its first signal to completion
+type ActionLifecycle struct {
+	State       string        `yaml:"state"`              // Current lifecycle state
+	UpdatedAt   string        `yaml:"updated_at"`         // ISO8601 timestamp of the last transition
+	Error       string        `yaml:"error,omitempty"`    // Last failure reason, if any
+	LogFile     string        `yaml:"log_file,omitempty"` // Log of the latest execution attempt, relative to the state dir
+	Acked       string        `yaml:"acked,omitempty"`    // ISO8601 timestamp the pending event was published
+	Transitions []StateChange `yaml:"transitions"`        // Every state entered, oldest first
+}
+
+// stateRank orders states so transitions only move forward
+func stateRank(state string) int {
+	if state == stateFailed {
+		return slices.Index(lifecycleStates, stateDone)
+	}
+	return slices.Index(lifecycleStates, state)
+}
+
+// Transition moves an action to a lifecycle state. States only move forward,
+// except that a failed action may be retried from any later stage; done is
+// final. It returns whether the state changed.
+func (h *History) Transition(key, state string, cause error) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.transition(key, state, cause)
+}
+
+// transition is Transition with h.mu held
+func (h *History) transition(key, state string, cause error) bool {
+	if h.Lifecycle == nil {
+		h.Lifecycle = make(map[string]*ActionLifecycle)
+	}
+	lc, ok := h.Lifecycle[key]
+	if !ok {
+		lc = &ActionLifecycle{}
+		h.Lifecycle[key] = lc
+	}
+
+	switch {
+	case lc.State == stateDone || lc.State == state:
+		return false
+	case lc.State == stateFailed:
+		if state == stateDetected {
+			return false
+		}
+	case lc.State != "" && stateRank(state) <= stateRank(lc.State):
+		return false
+	}
+
+	change := StateChange{State: state, At: time.Now().UTC().Format(time.RFC3339)}
+	if cause != nil {
+		change.Error = cause.Error()
+	}
+	lc.State = state
+	lc.UpdatedAt = change.At
+	if state == stateFailed {
+		lc.Error = change.Error
+	}
+	lc.Transitions = append(lc.Transitions, change)
+	h.changed = true
+
+	log.Printf("[INFO] Action %s is now %s", key, state)
+	if h.observer != nil {
+		h.observer(key, state, cause)
+	}
+	return true
+}
+
+// OnTransition makes every later lifecycle transition call fn
+func (h *History) OnTransition(fn func(key, state string, cause error)) {
+	h.observer = fn
+}
+
+// SetLogFile records the log file of the action's latest execution attempt
+func (h *History) SetLogFile(key, path string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lc, ok := h.Lifecycle[key]
+	if !ok || lc.LogFile == path {
+		return
+	}
+	lc.LogFile = path
+	h.changed = true
+}
+
+// Acknowledged reports whether the pending event of an action was published
+func (h *History) Acknowledged(key string) bool {
+	lc, ok := h.Lifecycle[key]
+	return ok && lc.Acked != ""
+}
+
+// SetAcknowledged records that the pending event of an action was published
+func (h *History) SetAcknowledged(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lc, ok := h.Lifecycle[key]
+	if !ok {
+		return
+	}
+	lc.Acked = time.Now().UTC().Format(time.RFC3339)
+	h.changed = true
+}
+
+// ActiveStates returns the lifecycle state of every action not yet done
+func (h *History) ActiveStates() map[string]string {
+	states := make(map[string]string)
+	for key, lc := range h.Lifecycle {
+		if lc.State != stateDone {
+			states[key] = lc.State
+		}
+	}
+	return states
+}
+
+// SaveIfChanged writes the history file if a lifecycle transition happened
+// since it was last saved
+func (h *History) SaveIfChanged() error {
+	h.mu.Lock()
+	changed := h.changed
+	h.mu.Unlock()
+	if !changed {
+		return nil
+	}
+	return h.Save()
+}
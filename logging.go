@@ -1,27 +1,62 @@
 package main
 
 import (
+	"bytes"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/nbd-wtf/go-nostr"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-// setupLogging initializes logging to both stdout and a rotating file in configDir
-func setupLogging(configDir string) {
-	logFile := filepath.Join(configDir, "manager.log")
-	multi := io.MultiWriter(os.Stdout, &lumberjack.Logger{
-		Filename:   logFile,
+// setupLogging initializes logging to both the console and a rotating file in
+// stateDir. Console logs go to stdout unless command output is JSON, in which
+// case they go to stderr to keep stdout machine-readable. Quiet mode drops
+// INFO and DEBUG lines from the console only. Every line carries the run
+// ID, and the action ID while an action is handled.
+func setupLogging(stateDir string, output string, quiet bool) {
+	var console io.Writer = os.Stdout
+	if output == outputJSON {
+		console = os.Stderr
+	}
+	if quiet {
+		console = quietWriter{console}
+	}
+	setLogOutput(io.MultiWriter(console, logFileWriter(stateDir)))
+	log.SetFlags(log.LstdFlags | log.Lshortfile | log.Lmsgprefix)
+	setAction("")
+}
+
+// logOutput is where log lines go, apart from the action log
+var logOutput io.Writer = os.Stderr
+
+// backgroundLog is the logger of goroutines that run alongside actions, such
+// as servers and signal watchers. Its lines never go into an action log and
+// carry only the run ID.
+var backgroundLog = log.New(logOutput, "run="+runID+" ", log.LstdFlags|log.Lshortfile|log.Lmsgprefix)
+
+// setLogOutput sends log lines to w, and those of the standard logger also
+// to the open action log
+func setLogOutput(w io.Writer) {
+	logOutput = w
+	log.SetOutput(io.MultiWriter(w, actionTee))
+	backgroundLog.SetOutput(w)
+}
+
+// logFileWriter returns the rotating log file in stateDir
+func logFileWriter(stateDir string) io.Writer {
+	return &lumberjack.Logger{
+		Filename:   filepath.Join(stateDir, "manager.log"),
 		MaxSize:    10,   // megabytes
 		MaxBackups: 3,    // number of backup files
 		MaxAge:     28,   // days
 		Compress:   true, // compress backups
-	})
-	log.SetOutput(multi)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	}
 }
 
 func configureNostrLogging(verbose bool) {
@@ -30,3 +65,67 @@ func configureNostrLogging(verbose bool) {
 		nostr.InfoLogger = log.New(io.Discard, "", 0)
 	}
 }
+
+// quietWriter drops INFO and DEBUG log lines. The log package writes each
+// line with a single Write call.
+type quietWriter struct {
+	w io.Writer
+}
+
+func (q quietWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(" [INFO] ")) || bytes.Contains(p, []byte(" [DEBUG] ")) {
+		return len(p), nil
+	}
+	return q.w.Write(p)
+}
+
+// logBacklog is how many recent log lines a logHub keeps for new subscribers
+const logBacklog = 200
+
+// logHub fans log lines out to live subscribers such as the dashboard's
+// log stream, keeping a short backlog
+type logHub struct {
+	mu    sync.Mutex
+	lines []string
+	subs  map[chan string]struct{}
+}
+
+func newLogHub() *logHub {
+	return &logHub{subs: make(map[chan string]struct{})}
+}
+
+// Write records a log line and passes it to every subscriber. Slow
+// subscribers miss lines rather than blocking logging.
+func (h *logHub) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lines = append(h.lines, line)
+	if len(h.lines) > logBacklog {
+		h.lines = h.lines[len(h.lines)-logBacklog:]
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// subscribe returns a channel of new lines and the current backlog
+func (h *logHub) subscribe() (chan string, []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan string, 64)
+	h.subs[ch] = struct{}{}
+	return ch, slices.Clone(h.lines)
+}
+
+// unsubscribe stops delivering lines to ch
+func (h *logHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
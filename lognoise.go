@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the limits on noisy log lines
+const (
+	defaultLogLimitWindow = 10 * time.Minute
+	defaultLogLimitBurst  = 20
+)
+
+// Categories of noisy log lines, each limited separately
+const (
+	noiseRelay = "relay" // relay connection, subscription, and read failures
+	noiseEvent = "event" // events that are not valid signals
+)
+
+// LogLimitConfig bounds how much of manager.log noisy conditions, such as a
+// relay that stays down or a follow posting notes that are not signals, may
+// take up. Repeats of a line are counted instead of logged, and a summary
+// like "repeated 240 times" is logged once the window ends. --verbose logs
+// every line in full.
+type LogLimitConfig struct {
+	Window time.Duration `yaml:"window,omitempty"` // Period over which repeats are counted (default 10m)
+	Burst  int           `yaml:"burst,omitempty"`  // Distinct lines logged per category and window (default 20)
+}
+
+// applyDefaults fills in unset log limits
+func (c *LogLimitConfig) applyDefaults() {
+	if c.Window <= 0 {
+		c.Window = defaultLogLimitWindow
+	}
+	if c.Burst <= 0 {
+		c.Burst = defaultLogLimitBurst
+	}
+}
+
+// noisyLog limits the log lines of noisy conditions for the whole process
+var noisyLog = newNoisyLogger()
+
+// noisyLogger deduplicates and rate-limits log lines per category
+type noisyLogger struct {
+	mu      sync.Mutex
+	cfg     LogLimitConfig
+	verbose bool                    // Log every line in full
+	periods map[string]*noisePeriod // Category -> its current window
+}
+
+// noisePeriod counts the lines of one category within a window
+type noisePeriod struct {
+	start   time.Time
+	repeats map[string]*noiseRepeat // Key of each line logged -> its repeats
+	dropped int                     // Lines with new keys beyond the burst
+}
+
+// noiseRepeat counts the repeats of a logged line
+type noiseRepeat struct {
+	count int    // Repeats not logged
+	last  string // Latest text of the line
+}
+
+func newNoisyLogger() *noisyLogger {
+	n := &noisyLogger{periods: make(map[string]*noisePeriod)}
+	n.cfg.applyDefaults()
+	return n
+}
+
+// configure applies the limits from config; verbose disables them
+func (n *noisyLogger) configure(cfg LogLimitConfig, verbose bool) {
+	cfg.applyDefaults()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cfg, n.verbose = cfg, verbose
+}
+
+// Printf logs a line of a noisy condition in category. key identifies the
+// condition, e.g. the relay and what failed, so lines differing only in
+// details such as timings count as repeats. Repeats within the window are
+// counted instead of logged, as are lines with new keys once the category
+// logged its burst.
+func (n *noisyLogger) Printf(category, key, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+
+	n.mu.Lock()
+	suppressed := false
+	if !n.verbose {
+		now := time.Now()
+		p := n.periods[category]
+		if p != nil && now.Sub(p.start) >= n.cfg.Window {
+			n.flush(category, p)
+			p = nil
+		}
+		if p == nil {
+			p = &noisePeriod{start: now, repeats: make(map[string]*noiseRepeat)}
+			n.periods[category] = p
+		}
+		if r, ok := p.repeats[key]; ok {
+			r.count++
+			r.last = msg
+			suppressed = true
+		} else if len(p.repeats) >= n.cfg.Burst {
+			p.dropped++
+			suppressed = true
+		} else {
+			p.repeats[key] = &noiseRepeat{last: msg}
+		}
+	}
+	n.mu.Unlock()
+
+	if !suppressed {
+		log.Output(2, msg)
+	}
+}
+
+// FlushExpired logs the summaries of windows that ended
+func (n *noisyLogger) FlushExpired() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for category, p := range n.periods {
+		if time.Since(p.start) >= n.cfg.Window {
+			n.flush(category, p)
+		}
+	}
+}
+
+// Flush logs the summaries of every window, e.g. before the process exits
+func (n *noisyLogger) Flush() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for category, p := range n.periods {
+		n.flush(category, p)
+	}
+}
+
+// flush logs how often each line of a window repeated and ends the window.
+// The caller holds n.mu.
+func (n *noisyLogger) flush(category string, p *noisePeriod) {
+	delete(n.periods, category)
+	since := time.Since(p.start).Round(time.Second)
+	for _, key := range slices.Sorted(maps.Keys(p.repeats)) {
+		r := p.repeats[key]
+		if r.count == 0 {
+			continue
+		}
+		level, text := splitLevel(r.last)
+		log.Printf("%s Message repeated %d times in the last %v: %s", level, r.count, since, text)
+	}
+	if p.dropped > 0 {
+		log.Printf("[WARN] Suppressed %d further %s log line(s) in the last %v; run with --verbose for full detail", p.dropped, category, since)
+	}
+}
+
+// splitLevel splits a log line into its level tag, e.g. "[WARN]", and the
+// rest, defaulting to INFO for lines without a tag
+func splitLevel(line string) (string, string) {
+	if strings.HasPrefix(line, "[") {
+		if end := strings.Index(line, "] "); end > 0 {
+			return line[:end+1], line[end+2:]
+		}
+	}
+	return "[INFO]", line
+}
@@ -1,329 +1,151 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"flag"
-	"fmt"
 	"log"
-	"net/url"
 	"os"
-	"path/filepath"
 	"time"
-
-	"github.com/Masterminds/semver/v3"
-	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
-// CandidateAction holds details of a potential action to perform
-type CandidateAction struct {
-	Version *semver.Version // Parsed semantic version
-	Type    string          // "upgrade" or "reboot"
-	Key     string          // Unique history key
-	Genesis string          // Genesis URL for reboot, empty for upgrade
-}
-
 func main() {
-	// Command-line flags
-	var (
-		dryRun    = flag.Bool("dry-run", false, "Perform a trial run without saving actions")
-		configDir = flag.String("config-dir", filepath.Join(os.Getenv("HOME"), ".qube-manager"), "Configuration directory")
-		verbose   = flag.Bool("verbose", false, "Enable verbose logging including go-nostr logs")
-	)
-	flag.Parse()
-
-	log.Printf("[INFO] Starting Qube Manager")
-
-	if err := os.MkdirAll(*configDir, 0755); err != nil {
-		log.Fatalf("[ERROR] Failed to create config directory: %v", err)
-	} else {
-		log.Printf("[INFO] Ensured config directory exists at %s", *configDir)
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
 	}
+}
 
-	// Setup logging to file and stdout
-	setupLogging(*configDir)
-
-	if *dryRun {
+// runManager evaluates signals once, or keeps polling relays as a daemon,
+// and exits with the outcome. Unless force is set, actions are only observed
+// while the security checks fail. A signalsFile replaces the relays as the
+// source of events.
+func runManager(g *globals, dryRun, daemon, force bool, signalsFile string) {
+	if dryRun {
 		log.Println("[INFO] Running in dry-run mode")
 	}
-	if *verbose {
-		log.Println("[INFO] Verbose logging enabled")
-	}
 
-	log.Println("[INFO] Loading or creating keypair")
-	keypair := loadOrCreateKeypair(*configDir)
-	_, _, err := nip19.Decode(keypair.Nsec)
-	if err != nil {
-		log.Fatalf("[ERROR] Invalid private key in config: %v", err)
+	// Load configuration and history from files
+	config := loadConfig(g.configDir, g.stateDir)
+	history := loadHistory(g.stateDir)
+	history.SetRetention(config.HistoryRetention)
+	changed := history.SetNetwork(config.Network)
+	if history.compact(time.Now()) > 0 {
+		changed = true
 	}
-
-	// Suppress go-nostr info logs like "filter doesn't match"
-	configureNostrLogging(*verbose)
-	log.Println("[INFO] Nostr logging configured")
-
-	if len(os.Args) > 1 && os.Args[1] == "send-message" {
-		log.Println("[INFO] Handling 'send-message' command")
-		sendMessageCLI(*configDir)
-		return
+	if config.RecoverHistory && recoverHistory(config, g.keypair, history) > 0 {
+		changed = true
+	}
+	if changed && !dryRun {
+		if err := history.Save(); err != nil {
+			log.Fatalf("[ERROR] Failed to save history: %v", err)
+		}
 	}
-
-	// Load configuration and history from files
-	config := loadConfig(*configDir)
-	history := loadHistory(*configDir)
 
 	log.Printf("[INFO] Loaded config: %d relays, %d follows, quorum=%d",
 		len(config.Relays), len(config.Follows), config.Quorum)
 
-	// Context with timeout to avoid hanging connections
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Air-gapped nodes read the events transferred by hand instead of relays
+	var signals *SignalsFile
+	if signalsFile != "" {
+		var err error
+		if signals, err = openSignalsFile(signalsFile); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		log.Printf("[INFO] Reading signals from %s instead of polling relays", signals.Label())
+	}
 
-	// Map to hold candidate actions keyed by unique history keys
-	actions := make(map[string]*CandidateAction)
+	// Freshness windows, quorum windows, and scheduling trust the local clock
+	if !checkClock(config.ClockCheck, config.relayURLs(), g.verbose) {
+		os.Exit(exitClockSkew)
+	}
 
-	// Map of action key -> set of pubkeys that voted for this action
-	votes := make(map[string]map[string]bool)
+	if config.HealthListen != "" && !daemon {
+		log.Println("[WARN] health_listen is only served in daemon mode (--daemon)")
+	}
+	if config.Dashboard.Listen != "" && !daemon {
+		log.Println("[WARN] dashboard.listen is only served in daemon mode (--daemon)")
+	}
+	if config.EventStream.Listen != "" && !daemon {
+		log.Println("[WARN] event_stream.listen is only served in daemon mode (--daemon)")
+	}
+	if config.Admin.Enabled && !daemon {
+		log.Println("[WARN] The admin API is only served in daemon mode (--daemon)")
+	}
 
-	// Connect to each relay and subscribe to relevant events
-	for _, relayURL := range config.Relays {
-		start := time.Now()
-		log.Printf("[INFO] Connecting to relay: %s", relayURL)
-		relay, err := nostr.RelayConnect(ctx, relayURL)
+	// In fleet mode actions run on the remote hosts instead of the local node
+	var executor Executor
+	var services map[string]Executor
+	var fleet *Fleet
+	var err error
+	observe := config.ObserveOnly
+	if config.Fleet.Enabled() && !observe {
+		fleet, err = newFleet(config.Fleet, config.Executor)
 		if err != nil {
-			log.Printf("[WARN] Failed to connect to relay %s: %v (took %v)", relayURL, err, time.Since(start))
-			continue
+			log.Fatalf("[ERROR] %v", err)
 		}
-		log.Printf("[INFO] Connected to relay: %s (took %v)", relayURL, time.Since(start))
-
-		// Decode all npubs to hex pubkeys for filtering
-		hexFollows := make([]string, 0, len(config.Follows))
-		for _, npub := range config.Follows {
-			kind, pubkeyAny, err := nip19.Decode(npub)
-			if err != nil {
-				log.Printf("[WARN] Skipping invalid npub (%s): %v", npub, err)
-				continue
-			}
-			if kind != "npub" {
-				log.Printf("[WARN] Expected npub but got %s: %s", kind, npub)
-				continue
-			}
-			pubkey, ok := pubkeyAny.(string)
-			if !ok {
-				log.Printf("[WARN] Unexpected pubkey format for %s: %v", npub, pubkeyAny)
-				continue
-			}
-			hexFollows = append(hexFollows, pubkey)
+		log.Printf("[INFO] Fleet mode: executing on %d host(s) with %s executor over SSH", len(config.Fleet.Hosts), config.Executor.Type)
+	} else if !config.ObserveOnly {
+		executor, err = newExecutor(config.Executor)
+		if isUnsupportedPlatform(err) {
+			log.Printf("[WARN] %v - observing only, actions will not be executed", err)
+			observe = true
+		} else if err != nil {
+			log.Fatalf("[ERROR] %v", err)
 		}
-		log.Printf("[INFO] Relay %s: decoded %d valid npubs for following", relayURL, len(hexFollows))
-
-		// Subscribe to kind=1 events authored by followed pubkeys
-		sub, err := relay.Subscribe(ctx, nostr.Filters{{
-			Authors: hexFollows,
-			Kinds:   []int{1},
-		}})
-		if err != nil {
-			log.Printf("[ERROR] Subscription failed on %s: %v", relayURL, err)
-			continue
+		if services, err = newServiceExecutors(config); err != nil {
+			log.Fatalf("[ERROR] %v", err)
 		}
-		log.Printf("[INFO] Subscription successful on %s", relayURL)
-
-		// Ensure subscription gets cleaned up
-		defer func(relayURL string) {
-			log.Printf("[INFO] Closing subscription on %s", relayURL)
-			sub.Close()
-			log.Printf("[INFO] Subscription on relay %s closed", relayURL)
-		}(relayURL)
-
-		// Read events and parse messages
-		for ev := range sub.Events {
-			// Try to detect message type early
-			var meta struct{ Type string }
-			if err := json.Unmarshal([]byte(ev.Content), &meta); err != nil {
-				if *verbose {
-					log.Printf("[DEBUG] Skipping event with invalid JSON from pubkey %s: %s", ev.PubKey, ev.Content)
-				}
-				continue
-			}
-
-			switch meta.Type {
-			case "upgrade":
-				var msg UpgradeMessage
-				if err := json.Unmarshal([]byte(ev.Content), &msg); err != nil {
-					log.Printf("[WARN] Failed to parse upgrade message: %v", err)
-					continue
-				}
-
-				v, err := semver.NewVersion(msg.Version)
-				if err != nil {
-					log.Printf("[WARN] Invalid semantic version in upgrade: %s", msg.Version)
-					continue
-				}
-
-				key := fmt.Sprintf("upgrade:%s", v.Original())
-				action, exists := actions[key]
-				if !exists {
-					action = &CandidateAction{
-						Type:    "upgrade",
-						Version: v,
-						Key:     key,
-					}
-					actions[key] = action
-				}
-
-				if votes[key] == nil {
-					votes[key] = make(map[string]bool)
-				}
-				votes[key][ev.PubKey] = true
-
-				log.Printf("[INFO] Parsed upgrade message: version=%s pubkey=%s", v.Original(), ev.PubKey)
-
-			case "reboot":
-				var msg RebootMessage
-				if err := json.Unmarshal([]byte(ev.Content), &msg); err != nil {
-					log.Printf("[WARN] Failed to parse reboot message: %v", err)
-					continue
-				}
-
-				if _, err := url.ParseRequestURI(msg.Genesis); err != nil {
-					log.Printf("[WARN] Invalid genesis URL in reboot: %s", msg.Genesis)
-					continue
-				}
-
-				v, err := semver.NewVersion(msg.Version)
-				if err != nil {
-					log.Printf("[WARN] Invalid semantic version in reboot: %s", msg.Version)
-					continue
-				}
-
-				key := fmt.Sprintf("reboot:%s:%s", v.Original(), msg.Genesis)
-				action, exists := actions[key]
-				if !exists {
-					action = &CandidateAction{
-						Type:    "reboot",
-						Version: v,
-						Key:     key,
-						Genesis: msg.Genesis,
-					}
-					actions[key] = action
-				}
-
-				if votes[key] == nil {
-					votes[key] = make(map[string]bool)
-				}
-				votes[key][ev.PubKey] = true
-
-				log.Printf("[INFO] Parsed reboot message: version=%s genesis=%s pubkey=%s", v.Original(), msg.Genesis, ev.PubKey)
-
-			default:
-				if *verbose {
-					log.Printf("[DEBUG] Ignoring event with unknown type: %s", meta.Type)
-				}
-			}
+		for name, ex := range services {
+			log.Printf("[INFO] Using %s executor for service %s", ex.Name(), name)
 		}
 	}
-
-	// Select the latest semver action meeting quorum and not already in history
-	var latest *CandidateAction
-	for _, a := range actions {
-		if history.Has(a.Key) {
-			continue // skip already acted on
-		}
-
-		voteCount := 0
-		if vset, ok := votes[a.Key]; ok {
-			voteCount = len(vset)
-		}
-
-		if voteCount < config.Quorum {
-			log.Printf("[INFO] Skipping action %s - votes %d/%d (below quorum)", a.Key, voteCount, config.Quorum)
-			continue
-		}
-
-		if latest == nil || a.Version.GreaterThan(latest.Version) {
-			latest = a
+	// Whoever can rewrite the key, config, or deployment script controls the node
+	if !observe && (executor != nil || fleet != nil || len(services) > 0) && !checkHardening(config, force) {
+		observe = true
+	}
+	switch {
+	case observe:
+		if config.ObserveOnly {
+			log.Println("[INFO] Observing only (observe_only) - actions will not be executed")
 		}
+	case executor != nil:
+		log.Printf("[INFO] Using %s executor", executor.Name())
+	case fleet == nil:
+		log.Println("[INFO] No executor configured - actions will only be recorded")
 	}
 
-	if latest != nil {
-		log.Printf("[INFO] Selected action %s with version %s and %d votes",
-			latest.Key, latest.Version.Original(), len(votes[latest.Key]))
-
-		switch latest.Type {
-		case "upgrade":
-			log.Printf("[UPGRADE ACTION] Version: %s", latest.Version.Original())
-		case "reboot":
-			log.Printf("[REBOOT ACTION] Version: %s Genesis: %s", latest.Version.Original(), latest.Genesis)
+	// Stop accepting events on SIGINT/SIGTERM and give in-flight actions time to finish
+	shutdown := newShutdownHandler(config.ShutdownGracePeriod)
+	shutdown.OnFlush(func() {
+		if err := history.Flush(); err != nil {
+			log.Printf("[WARN] Error flushing history during shutdown: %v", err)
 		}
+	})
 
-		if !*dryRun {
-			var content []byte
-			var err error
-
-			switch latest.Type {
-			case "upgrade":
-				doneMsg := UpgradeMessage{
-					Type:      "upgrade",
-					Version:   latest.Version.Original(),
-					ExtraData: "done",
-				}
-				content, err = json.Marshal(doneMsg)
-
-			case "reboot":
-				doneMsg := RebootMessage{
-					Type:      "reboot",
-					Version:   latest.Version.Original(),
-					Genesis:   latest.Genesis,
-					ExtraData: "done",
-				}
-				content, err = json.Marshal(doneMsg)
-			}
-
-			if err != nil {
-				log.Printf("[ERROR] Failed to marshal done message: %v", err)
-				return
-			}
-
-			doneEvent := nostr.Event{
-				PubKey:    keypair.Npub,
-				CreatedAt: nostr.Timestamp(time.Now().Unix()),
-				Kind:      nostr.KindTextNote,
-				Content:   string(content),
-			}
-
-			_, priv, err := nip19.Decode(keypair.Nsec)
-			if err != nil {
-				log.Fatalf("[ERROR] Invalid private key: %v", err)
-			}
-
-			if err := doneEvent.Sign(priv.(string)); err != nil {
-				log.Printf("[ERROR] Error signing done event: %v", err)
-				return
-			}
-
-			log.Printf("[INFO] Publishing done event for action %s to %d relays", latest.Key, len(config.Relays))
+	// Dry runs never alert the operator
+	var alerts *Alerter
+	if !dryRun {
+		alerts = newAlerter(config, g.keypair, shutdown, g.verbose)
+	}
 
-			for _, r := range config.Relays {
-				go func(url string) {
-					log.Printf("[INFO] Publishing to relay %s", url)
-					if relay, err := nostr.RelayConnect(context.Background(), url); err == nil {
-						_ = relay.Publish(context.Background(), doneEvent)
-					} else {
-						log.Printf("[WARN] Relay publish error (%s): %v", url, err)
-					}
-				}(r)
-			}
+	// Redundant instances share a lease so only one of them executes
+	coordinator := newCoordinator(config)
+	if coordinator != nil {
+		log.Printf("[INFO] Coordinating execution as instance %q through %s", coordinator.id, config.Coordination.LeaseFile)
+	}
 
-			history.Add(latest.Key)
-			if err := history.Save(); err != nil {
-				log.Printf("[WARN] Error saving history: %v", err)
-			} else {
-				log.Printf("[INFO] Action %s saved to history", latest.Key)
-			}
-		} else {
-			log.Println("[INFO] Dry run - not saving action to history.")
-		}
-	} else {
-		log.Println("[INFO] No new eligible actions to perform.")
+	m := &Manager{
+		config:         config,
+		configProblems: validateConfig(config),
+		keypair:        g.keypair,
+		history:        history,
+		executor:       executor,
+		services:       services,
+		fleet:          fleet,
+		shutdown:       shutdown,
+		alerts:         alerts,
+		coordinator:    coordinator,
+		observe:        observe,
+		dryRun:         dryRun,
+		verbose:        g.verbose,
+		signalsFile:    signals,
 	}
+	os.Exit(m.run(daemon))
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaintenanceWindow limits executed actions to recurring periods, e.g. the
+// night from Saturday to Sunday. Actions due outside it wait for it to open.
+// A window opened on an allowed day may run past midnight.
+type MaintenanceWindow struct {
+	Days     []string      `yaml:"days,omitempty"`     // Weekdays the window opens on, e.g. ["sat", "sun"] (default every day)
+	Start    string        `yaml:"start,omitempty"`    // Time of day it opens, "HH:MM" (unset for no window)
+	Duration time.Duration `yaml:"duration,omitempty"` // How long it stays open, at most 24h
+	Timezone string        `yaml:"timezone,omitempty"` // IANA zone of days and start, e.g. "Europe/Berlin" (default UTC)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Enabled reports whether a window is configured
+func (w MaintenanceWindow) Enabled() bool {
+	return w.Start != ""
+}
+
+// Validate checks the days, start, duration, and timezone
+func (w MaintenanceWindow) Validate() error {
+	if !w.Enabled() {
+		if len(w.Days) > 0 || w.Duration != 0 || w.Timezone != "" {
+			return fmt.Errorf("start is required")
+		}
+		return nil
+	}
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("unknown day %q (must be sun, mon, tue, wed, thu, fri, or sat)", day)
+		}
+	}
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return fmt.Errorf("invalid start %q (must be HH:MM)", w.Start)
+	}
+	if w.Duration <= 0 || w.Duration > 24*time.Hour {
+		return fmt.Errorf("duration must be between 1s and 24h (got %v)", w.Duration)
+	}
+	if _, err := time.LoadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q: %v", w.Timezone, err)
+	}
+	return nil
+}
+
+// Next returns when the window is next open at or after now: now itself
+// while it is open. A window that does not validate is always open.
+func (w MaintenanceWindow) Next(now time.Time) time.Time {
+	start, err := time.Parse("15:04", w.Start)
+	loc, lerr := time.LoadLocation(w.Timezone)
+	if !w.Enabled() || err != nil || lerr != nil || w.Duration <= 0 {
+		return now
+	}
+	local := now.In(loc)
+	// The window opened yesterday may still be open; a week ahead always
+	// holds an allowed day
+	for offset := -1; offset <= 7; offset++ {
+		day := local.AddDate(0, 0, offset)
+		if !w.opensOn(day.Weekday()) {
+			continue
+		}
+		open := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		if now.Before(open) {
+			return open
+		}
+		if now.Before(open.Add(w.Duration)) {
+			return now
+		}
+	}
+	return now
+}
+
+// opensOn reports whether the window opens on weekday
+func (w MaintenanceWindow) opensOn(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if weekdays[strings.ToLower(day)] == weekday {
+			return true
+		}
+	}
+	return false
+}
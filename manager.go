@@ -0,0 +1,995 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Manager holds the state shared by every evaluation cycle
+type Manager struct {
+	config         Config              // Loaded configuration
+	keypair        Keypair             // Manager identity used to sign and decrypt
+	history        *History            // Actions already performed
+	executor       Executor            // Local executor backend (nil if disabled or in fleet mode)
+	services       map[string]Executor // Backends of co-hosted services, by name
+	fleet          *Fleet              // Remote hosts executing actions (nil unless fleet mode)
+	shutdown       *ShutdownHandler    // Coordinates graceful termination
+	health         *Health             // Probe state served in daemon mode (nil otherwise)
+	alerts         *Alerter            // Alert conditions and operator notifications (nil in dry runs)
+	dashboard      *Dashboard          // Web UI state served in daemon mode (nil otherwise)
+	stream         *EventStream        // Live event feed served in daemon mode (nil otherwise)
+	coordinator    *Coordinator        // Execution lease shared with redundant instances (nil if disabled)
+	lastRun        string              // Status of the most recent cycle
+	paused         atomic.Bool         // Cycles are skipped while set through the admin API
+	pollNow        chan struct{}       // Admin API requests to poll before the interval ends
+	lastHeartbeat  time.Time           // When the daemon last published a heartbeat
+	declined       []string            // Actions declined in overrides.yaml in the last cycle, reported in heartbeats
+	overrides      *Overrides          // overrides.yaml as last read without error (nil before the first cycle)
+	failures       *Failures           // failures.yaml as last read without error (nil before the first cycle)
+	approvals      *Approvals          // approvals.yaml as last read without error (nil before the first cycle)
+	audit          *AuditLog           // Audit log kept open across cycles (nil before the first cycle that records)
+	configProblems []configProblem     // Problems found when config.yaml was last read, exported as metrics
+	simulated      []*nostr.Event      // Synthetic events fed instead of polling relays (simulate only)
+	signalsFile    *SignalsFile        // Events read by hand instead of polling relays (nil unless --signals-file)
+	daemon         bool                // Keep polling; subscriptions stay live after EOSE
+	observe        bool                // Track signals and alert, but never execute or record actions
+	dryRun         bool                // Evaluate without executing or saving
+	verbose        bool                // Log events that are not signals
+}
+
+// run performs a single evaluation cycle, or in daemon mode keeps polling
+// relays every poll interval until shutdown is requested. It returns the
+// process exit code: the outcome of the single cycle, or success once the
+// daemon stops.
+func (m *Manager) run(daemon bool) int {
+	m.daemon = daemon
+	defer noisyLog.Flush()
+	if !daemon {
+		m.refreshRelays()
+		m.refreshAnnouncement()
+		m.runCycle()
+		return exitCode(m.lastRun)
+	}
+
+	log.Printf("[INFO] Running as daemon, polling every %v", m.config.PollInterval)
+	if m.config.HealthListen != "" {
+		m.health = newHealth(m.config.PollInterval)
+		m.health.Serve(m.config.HealthListen, m.shutdown)
+	}
+	if m.config.Dashboard.Listen != "" || m.config.Admin.Enabled {
+		m.dashboard = newDashboard(m.config, m.keypair)
+	}
+	if m.config.Dashboard.Listen != "" {
+		if m.config.Dashboard.AllowApprovals {
+			token, err := loadOrCreateAdminToken(m.config.ConfigPath)
+			if err != nil {
+				log.Fatalf("[ERROR] %v", err)
+			}
+			m.dashboard.token = token
+		}
+		m.dashboard.Serve(m.config.Dashboard.Listen, m.shutdown)
+	}
+	if m.config.EventStream.Listen != "" {
+		token, err := loadOrCreateAdminToken(m.config.ConfigPath)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		m.stream = newEventStream(token)
+		m.history.OnTransition(m.stream.Lifecycle)
+		m.stream.Serve(m.config.EventStream.Listen, m.shutdown)
+	}
+	if m.config.Admin.Enabled {
+		token, err := loadOrCreateAdminToken(m.config.ConfigPath)
+		if err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+		m.pollNow = make(chan struct{}, 1)
+		if err := (&AdminServer{m: m, token: token, stateDir: m.config.StatePath}).Serve(m.shutdown); err != nil {
+			log.Fatalf("[ERROR] %v", err)
+		}
+	}
+
+	reload := watchConfig(m.config.ConfigPath, m.shutdown)
+	for {
+		m.refreshRelays()
+		if m.paused.Load() {
+			log.Println("[INFO] Processing paused - skipping cycle")
+		} else {
+			m.runCycle()
+		}
+		m.heartbeat()
+		m.refreshAnnouncement()
+
+		if !m.wait(reload) {
+			log.Println("[INFO] Shutdown requested - daemon stopping")
+			return exitNoAction
+		}
+	}
+}
+
+// wait blocks until the next cycle is due: the poll interval elapsed, a poll
+// was requested through the admin API, or a reload changed the config. It
+// returns false once shutdown is requested.
+func (m *Manager) wait(reload <-chan string) bool {
+	next := time.After(m.config.PollInterval)
+	for {
+		select {
+		case <-m.shutdown.Context().Done():
+			return false
+		case <-m.pollNow:
+			return true
+		case <-next:
+			return true
+		case cause := <-reload:
+			// Relays and follows are subscribed afresh every cycle, so a
+			// reloaded config takes effect with an immediate poll
+			if m.reload(cause) {
+				return true
+			}
+		}
+	}
+}
+
+// runCycle polls the relays once, tallies signals, and acts on the selected
+// action
+func (m *Manager) runCycle() {
+	// Noisy conditions are summarized once their log window ends
+	noisyLog.configure(m.config.LogLimits, m.verbose)
+	noisyLog.FlushExpired()
+
+	// Renew the execution lease, and pick up actions the leader executed
+	// while this instance was on standby
+	leading := true
+	if !m.dryRun {
+		if m.coordinator.Sync(m.history) {
+			if err := m.history.Save(); err != nil {
+				log.Printf("[WARN] Error saving history: %v", err)
+			}
+		}
+		var holder string
+		if leading, holder = m.coordinator.Claim(); !leading {
+			log.Printf("[INFO] Instance %q holds the execution lease - observing only", holder)
+		}
+
+		// Retry done events that earlier runs could not get accepted
+		loadOutbox(m.config.StatePath).Flush(m.config, m.shutdown)
+	}
+
+	// Drop execution state left behind by an action that already reached history
+	pending := pendingExecution(m.config.StatePath, m.history)
+
+	// Run results are exported however the run ends
+	result := newRunResult()
+	errs, warnings := splitProblems(m.configProblems)
+	result.ConfigErrors, result.ConfigWarnings = len(errs), len(warnings)
+	defer m.finishCycle(result)
+
+	// A node resyncing after a reboot reports its progress until synced
+	result.Resync = m.monitorResync()
+
+	// The running node version lets no-op upgrades be skipped
+	nodeVersion := currentNodeVersion(m.config.Node)
+	if nodeVersion != nil {
+		result.NodeVersion = nodeVersion.Original()
+	}
+	if m.config.Telemetry.Enabled {
+		result.Telemetry = sampleTelemetry(m.config.Node, m.config.Telemetry, m.verbose)
+		log.Printf("[INFO] Node telemetry: %s", result.Telemetry.summary())
+	}
+
+	// The whole poll is bounded by total_timeout; each relay gets its own
+	// connect and read deadlines within it
+	ctx, cancel := context.WithTimeout(m.shutdown.Context(), m.config.TotalTimeout)
+	defer cancel()
+
+	// Candidate actions and the votes cast for them
+	tally := signal.NewEvaluator(m.config.Quorum, m.config.Cohort, m.config.ConflictPolicy)
+	tally.SetRoles(followRoles(m.config.Follows))
+	tally.SetNetwork(m.config.Network)
+	tally.SetMaxCandidates(m.config.Subscription.MaxCandidates)
+	tally.SetHistory(m.history)
+	tally.SetLatestSignalWins(m.config.LatestSignalWins)
+
+	// Every accepted signal is appended to the event log for later replay
+	eventLog := openEventLog(m.config.StatePath)
+
+	// Trust decisions are recorded in the hash-chained audit log
+	var audit *AuditLog
+	if !m.dryRun {
+		if m.audit == nil {
+			m.audit = openAuditLog(m.config.StatePath, m.keypair)
+		}
+		audit = m.audit
+	}
+
+	// Validated signals are republished to relays that missed them if gossip
+	// is enabled; dry runs and simulations publish nothing
+	var gossip *Gossip
+	if !m.dryRun && m.simulated == nil && m.signalsFile == nil {
+		gossip = loadGossip(m.config.Gossip, m.config.StatePath, m.verbose)
+	}
+
+	// When each follow last signaled, to spot keys gone silent
+	var participation *Participation
+	if !m.dryRun {
+		participation = loadParticipation(m.config.StatePath)
+	}
+
+	// Decode all npubs to hex pubkeys for filtering, leaving out revoked keys
+	revoked := revokedKeys(m.history)
+	hexFollows := activeFollows(m.config, revoked)
+	trusted := trustedSigners(m.config, hexFollows)
+
+	// Follows marked encrypted signal via DMs addressed to this manager
+	encrypted := encryptedFollows(m.config.Follows)
+	filters, err := signalFilters(m.config.Subscription, hexFollows, encrypted, m.keypair)
+	if err != nil {
+		log.Printf("[ERROR] Cannot subscribe to signals: %v", err)
+		result.LastStatus = statusError
+		return
+	}
+
+	// Every event received is checked, fed to the tally, and recorded
+	self, _ := m.keypair.publicKey()
+	accept := func(ev *nostr.Event, relayURL string) {
+		result.EventsSeen++
+		// The relay client already drops most of these; checking again
+		// leaves a record of every rejected vote
+		if ok, err := ev.CheckSignature(); err != nil || !ok {
+			noisyLog.Printf(noiseEvent, "signature:"+ev.PubKey, "[WARN] Ignoring event %s with invalid signature", ev.ID)
+			audit.Vote(ev, relayURL, "", errBadSignature)
+			return
+		}
+		// A gift wrap stands for the direct message inside, whose author
+		// signed the seal around it. The gift wrap is what gets recorded
+		// and streamed: it stays verifiable and keeps the message private.
+		raw := ev
+		if ev.Kind == nostr.KindGiftWrap {
+			dm, err := unwrapDM(ev, m.keypair)
+			if err != nil {
+				noisyLog.Printf(noiseEvent, "unwrap:"+relayURL, "[WARN] Ignoring gift wrap %s: %v", ev.ID, err)
+				audit.Vote(ev, relayURL, "", err)
+				return
+			}
+			if dm.PubKey == self {
+				return // the copy of a confirmation this manager sent
+			}
+			ev = dm
+		}
+		if !slices.Contains(hexFollows, ev.PubKey) {
+			noisyLog.Printf(noiseEvent, "unfollowed:"+ev.PubKey, "[WARN] Ignoring event %s from pubkey %s not in the follow list", ev.ID, ev.PubKey)
+			audit.Vote(ev, relayURL, "", errNotFollowed)
+			return
+		}
+		if signal.Expired(ev, time.Now()) {
+			noisyLog.Printf(noiseEvent, "expired:"+ev.PubKey, "[WARN] Ignoring expired event %s from pubkey %s", ev.ID, ev.PubKey)
+			audit.Vote(ev, relayURL, "", errExpired)
+			return
+		}
+		gossip.Delivered(ev, relayURL)
+		if ev.Kind == nostr.KindDeletion {
+			gossip.Deletion(ev)
+			for _, key := range retractSignals(tally, ev) {
+				audit.Retraction(ev, relayURL, key)
+			}
+			if err := eventLog.Append(ev, relayURL); err != nil {
+				log.Printf("[WARN] Failed to record event %s: %v", ev.ID, err)
+			}
+			return
+		}
+		content, err := signalContent(ev, encrypted)
+		if err != nil {
+			noisyLog.Printf(noiseEvent, "content:"+ev.PubKey, "[WARN] Ignoring event %s: %v", ev.ID, err)
+			audit.Vote(ev, relayURL, "", err)
+			return
+		}
+		if isHeartbeat(content) {
+			participation.Seen(ev, seenHeartbeat)
+			return
+		}
+		if err := checkPoW(ev, content, m.config.MinPowDifficulty); err != nil {
+			noisyLog.Printf(noiseEvent, "pow:"+ev.PubKey, "[WARN] Ignoring signal %s from pubkey %s: %v", ev.ID, ev.PubKey, err)
+			audit.Vote(ev, relayURL, "", err)
+			return
+		}
+		if signal.Address(ev) != "" {
+			keys, err := replaceSignals(tally, ev)
+			if err != nil {
+				audit.Vote(ev, relayURL, "", err)
+				return
+			}
+			for _, key := range keys {
+				audit.Replacement(ev, relayURL, key)
+			}
+		}
+		withdrawn := len(tally.Withdrawn())
+		action, err := addSignal(tally, ev, content, relayURL, trusted, m.verbose)
+		if err != nil {
+			switch {
+			case errors.Is(err, signal.ErrUnsupportedSchema):
+				result.UnknownSchema++
+			case errors.Is(err, signal.ErrCandidateLimit):
+				result.EventsDropped++
+			}
+			// Notes that are not signals at all are no trust decision
+			if !errors.Is(err, signal.ErrInvalidJSON) && !errors.Is(err, signal.ErrUnknownMessageType) {
+				audit.Vote(ev, relayURL, "", err)
+			}
+			return
+		}
+		participation.Seen(ev, seenSignal)
+		for _, w := range tally.Withdrawn()[withdrawn:] {
+			audit.Withdrawal(ev, relayURL, w.PubKey, w.Key)
+		}
+		if m.history.Has(action.Key) {
+			audit.Vote(ev, relayURL, action.Key, errStaleVote)
+		} else {
+			audit.Vote(ev, relayURL, action.Key, nil)
+			gossip.Validated(ev)
+			m.stream.Signal(raw, content, relayURL, action, len(tally.Votes[action.Key]), tally.Quorum())
+		}
+		if err := eventLog.Append(raw, relayURL); err != nil {
+			log.Printf("[WARN] Failed to record event %s: %v", ev.ID, err)
+		}
+	}
+
+	// Connect to each relay and subscribe to relevant events, keeping the
+	// outcome of each poll for the dashboard. Simulations feed their
+	// synthetic events instead, and --signals-file the events it reads.
+	var polls []RelayPoll
+	offline := m.simulated != nil || m.signalsFile != nil
+	switch {
+	case m.simulated != nil:
+		for _, ev := range m.simulated {
+			accept(ev, simulatedRelay)
+		}
+	case m.signalsFile != nil:
+		events, err := m.signalsFile.Events()
+		if err != nil {
+			log.Printf("[ERROR] %v", err)
+		}
+		log.Printf("[INFO] Read %d event(s) from %s", len(events), m.signalsFile.Label())
+		for _, ev := range events {
+			accept(ev, m.signalsFile.Label())
+		}
+	default:
+		polls = m.pollRelays(ctx, filters, len(hexFollows), result, accept)
+	}
+	if evicted := tally.Evicted(); len(evicted) > 0 {
+		log.Printf("[WARN] Evicted %d candidate(s) below quorum to stay within %d candidates (subscription.max_candidates), e.g. %s",
+			len(evicted), m.config.Subscription.MaxCandidates, evicted[0])
+		result.Evicted = len(evicted)
+	}
+	result.SignalsGossiped = gossip.Publish(m.config, m.shutdown)
+	result.Polls = polls
+	result.Candidates, result.Votes, result.Signers = tallyStats(tally)
+
+	if !offline {
+		m.health.PollDone(result.RelaysConnected, len(m.config.readRelays()))
+		m.alerts.RelaysPolled(result.RelaysConnected)
+	}
+	if result.RelaysConnected > 0 || offline {
+		m.alerts.SignersSilent(participation, hexFollows)
+	}
+	if err := participation.SaveIfChanged(); err != nil {
+		log.Printf("[WARN] Error saving signer participation: %v", err)
+	}
+
+	// Key revocations take effect before any other action is considered
+	if applied := applyRevocations(m.config, tally.Actions, tally.Votes, m.history, revoked, m.dryRun); len(applied) > 0 {
+		for _, key := range applied {
+			audit.Revocation(key, tally.Actions[key].Target, len(tally.Votes[key]), revokeQuorum(m.config))
+		}
+		remaining := 0
+		for _, pk := range hexFollows {
+			if !revoked[pk] {
+				remaining++
+			}
+		}
+		log.Printf("[WARN] Applied %d key revocation(s); %d follow(s) remain active", len(applied), remaining)
+		if remaining < m.config.Quorum {
+			log.Printf("[WARN] Quorum %d can no longer be reached with %d active follow(s)", m.config.Quorum, remaining)
+		}
+	}
+
+	// Actions the operator rejected are never selected
+	var approvals *Approvals
+	if m.config.RequireApproval {
+		approvals = m.loadApprovals()
+		approvals.Prune(m.history)
+		for _, key := range approvals.Rejected() {
+			if _, ok := tally.Actions[key]; ok {
+				log.Printf("[INFO] Ignoring action %s rejected by the operator", key)
+				tally.Drop(key)
+			}
+		}
+	}
+
+	// Actions for services this node does not host, or cannot execute, are
+	// never selected
+	executors := m.services
+	if m.observe {
+		executors = nil
+	} else if executors == nil {
+		executors = map[string]Executor{}
+	}
+	dropUnconfiguredServices(tally, m.config.Services, executors, m.history)
+
+	// Versions outside the operator's policy are never selected
+	dropPolicyViolations(tally, m.config.VersionPolicy, nodeVersion, m.history)
+
+	// Actions the operator declined in overrides.yaml are never selected
+	m.declined = dropDeclined(tally, m.loadOverrides(), m.history)
+
+	m.dashboard.PollDone(m.config, polls, tally, m.history, revoked)
+
+	// Candidates still gathering votes are tracked as detected
+	for _, key := range tally.BelowQuorum(m.history) {
+		if tally.Actions[key].Type != signal.TypeRevokeKey {
+			m.history.Transition(key, stateDetected, nil)
+		}
+	}
+	m.alerts.QuorumStuck(tally, m.history)
+
+	// Failing actions are retried with backoff until their breaker opens
+	failures := m.loadFailures()
+	failures.Prune(m.history)
+	m.alerts.ActionsBlocked(failures)
+
+	// Select the latest semver action meeting quorum and not already in history
+	latest, pendingCount := selectAction(tally, m.history)
+	result.ActionsPending = pendingCount
+
+	if latest != nil {
+		// Log lines, notifications, and events from here on carry the action ID
+		setAction(latest.Key)
+		defer setAction("")
+		result.LastAction = latest.Key
+		if m.history.Transition(latest.Key, stateQuorum, nil) {
+			audit.Quorum(latest, len(tally.Votes[latest.Key]), tally.Quorum())
+		}
+	}
+
+	if latest != nil && m.shutdown.Requested() {
+		log.Printf("[WARN] Shutdown requested - not starting action %s", latest.Key)
+		result.LastStatus = statusInterrupted
+		return
+	}
+
+	if latest != nil && !leading {
+		log.Printf("[INFO] Action %s is left to the instance holding the execution lease", latest.Key)
+		result.LastStatus = statusStandby
+		return
+	}
+
+	if latest != nil && m.observe {
+		log.Printf("[INFO] Action %s reached quorum with %d votes - observing only, not executing", latest.Key, len(tally.Votes[latest.Key]))
+		result.LastStatus = statusObserved
+		return
+	}
+
+	if latest != nil {
+		log.Printf("[INFO] Selected %s action %s with version %s and %d votes",
+			latest.Severity, latest.Key, latest.Version.Original(), len(tally.Votes[latest.Key]))
+		policy := m.config.severityPolicy(latest.Severity)
+		maxStagger := m.config.MaxStagger
+		if policy.SkipStagger {
+			maxStagger = 0
+		}
+
+		switch latest.Type {
+		case "upgrade":
+			if s := latest.Source; s != nil {
+				log.Printf("[UPGRADE ACTION] Version: %s Tag: %s Commit: %s Repo: %s", latest.Version.Original(), s.Tag, s.Commit, s.Repo)
+			} else {
+				log.Printf("[UPGRADE ACTION] Version: %s", latest.Version.Original())
+			}
+		case "reboot":
+			log.Printf("[REBOOT ACTION] Version: %s Genesis: %s", latest.Version.Original(), latest.Genesis)
+		case "rollback":
+			log.Printf("[ROLLBACK ACTION] Version: %s", latest.Version.Original())
+		}
+
+		// An upgrade or rollback to the version the node already runs needs no execution
+		noop := m.fleet == nil && (latest.Type == "upgrade" || latest.Type == "rollback") && latest.Service == "" &&
+			nodeVersion != nil && nodeVersion.Equal(latest.Version)
+		executor, executorConfig := m.executorFor(latest)
+		if noop {
+			log.Printf("[INFO] Node already runs %s - recording %s without executing", nodeVersion.Original(), latest.Key)
+		}
+
+		if !m.dryRun {
+			// Coordinators learn that the node saw the action before it
+			// runs; the expected time is unknown while approval is pending
+			if !noop && !m.history.Acknowledged(latest.Key) {
+				var expected time.Time
+				if approvals == nil {
+					expected = executionTime(latest, m.keypair.Npub, maxStagger, time.Now())
+				}
+				if acknowledgeAction(m.config, m.keypair, latest, expected, tally.Votes[latest.Key], m.shutdown) {
+					m.history.SetAcknowledged(latest.Key)
+				}
+			}
+
+			// A human has to acknowledge the action before anything runs
+			if approvals != nil && !noop {
+				// The file is read again under its lock: a decision may have
+				// been made since the cycle started
+				var approval *ApprovalEntry
+				if err := updateApprovals(m.config.StatePath, func(a *Approvals) error {
+					a.Prune(m.history)
+					approval = a.Queue(latest)
+					return nil
+				}); err != nil {
+					log.Printf("[WARN] Error saving approvals: %v", err)
+					approval = approvals.Queue(latest)
+				}
+				if approval.Status != approvalApproved {
+					log.Printf("[INFO] Action %s is awaiting approval since %s - not executing", latest.Key, approval.QueuedAt)
+					result.LastStatus = statusAwaitingApproval
+					return
+				}
+				log.Printf("[INFO] Action %s was approved at %s", latest.Key, approval.DecidedAt)
+			}
+
+			// Nodes execute at staggered times so the network doesn't restart at once
+			schedule := loadSchedule(m.config.StatePath)
+			schedule.Prune(m.history)
+			// A slot taken before the stagger was skipped is given up
+			if entry, ok := schedule.Entries[latest.Key]; ok && policy.SkipStagger && entry.Time().After(executionTime(latest, m.keypair.Npub, 0, time.Now())) {
+				schedule.Remove(latest.Key)
+			}
+			entry := schedule.Get(latest, m.keypair.Npub, maxStagger)
+			if executeAt := entry.Time(); !noop && time.Now().Before(executeAt) {
+				if !entry.Announced {
+					announceSchedule(m.config, m.keypair, latest, executeAt, tally.Votes[latest.Key], m.shutdown)
+					entry.Announced = true
+				}
+				if err := schedule.Save(); err != nil {
+					log.Printf("[WARN] Error saving schedule: %v", err)
+				}
+				log.Printf("[INFO] Action %s is scheduled for %s (in %v) - not due yet",
+					latest.Key, entry.ExecuteAt, time.Until(executeAt).Round(time.Second))
+				m.history.Transition(latest.Key, stateScheduled, nil)
+				result.LastStatus = statusScheduled
+				return
+			}
+
+			// The node settles after one action before the next starts
+			if cooldown := m.config.ActionCooldown; !noop && cooldown > 0 && !policy.SkipCooldown {
+				if next := m.history.LastPerformed().Add(cooldown); time.Now().Before(next) {
+					log.Printf("[INFO] Action %s waits out action_cooldown until %s (in %v)",
+						latest.Key, next.UTC().Format(time.RFC3339), time.Until(next).Round(time.Second))
+					m.history.Transition(latest.Key, stateScheduled, nil)
+					result.LastStatus = statusScheduled
+					return
+				}
+			}
+
+			// Actions only execute while the maintenance window is open
+			if window := m.config.MaintenanceWindow; !noop && window.Enabled() && !policy.SkipMaintenanceWindow {
+				if next := window.Next(time.Now()); time.Now().Before(next) {
+					log.Printf("[INFO] Action %s waits for the maintenance window until %s (in %v)",
+						latest.Key, next.UTC().Format(time.RFC3339), time.Until(next).Round(time.Second))
+					m.history.Transition(latest.Key, stateScheduled, nil)
+					result.LastStatus = statusScheduled
+					return
+				}
+			}
+
+			if entry := failures.Held(latest.Key, time.Now()); entry != nil {
+				if entry.Blocked() {
+					log.Printf("[WARN] Action %s is blocked after %d failure(s) - run 'qube-manager retry-action %s' once the cause is fixed", latest.Key, entry.Failures, latest.Key)
+					result.LastStatus = statusBlocked
+				} else {
+					log.Printf("[INFO] Action %s failed %d time(s) - next attempt at %s", latest.Key, entry.Failures, entry.RetryAt)
+					result.LastStatus = statusBackoff
+				}
+				return
+			}
+
+			actionDone := m.shutdown.Track("action " + latest.Key)
+			defer actionDone()
+			stopKeepAlive := m.coordinator.KeepAlive()
+			defer stopKeepAlive()
+			m.health.SetExecuting(latest.Key)
+			defer m.health.SetExecuting("")
+			var actionLog *ActionLog
+			if !noop {
+				m.history.Transition(latest.Key, stateExecuting, nil)
+
+				// Everything logged from pre-flight checks to verification
+				// also goes to the action's own log file
+				var err error
+				actionLog, err = openActionLog(m.config.StatePath, latest.Key, time.Now())
+				if err != nil {
+					log.Printf("[WARN] Failed to open action log: %v", err)
+				} else {
+					log.Printf("[INFO] Logging execution of %s to %s", latest.Key, actionLog.Path())
+					m.history.SetLogFile(latest.Key, actionLog.Path())
+					defer actionLog.Close()
+				}
+			}
+
+			var state *ExecutionState
+			var execErr error
+			switch {
+			case m.fleet != nil:
+				record := environmentRecorder(m.config, m.config.Executor, latest, actionLog, time.Now())
+				execErr = m.fleet.Execute(context.Background(), m.config.StatePath, latest, tally.Votes[latest.Key], m.config.Executor, record)
+			case executor != nil && !noop:
+				state, execErr = prepareExecution(executionStatePath(m.config.StatePath), executor, latest, tally.Votes[latest.Key])
+				if execErr == nil {
+					record := environmentRecorder(m.config, executorConfig, latest, actionLog, time.Now())
+					execErr = executeAction(context.Background(), executor, latest, state, executorConfig, record)
+				}
+			}
+			if execErr != nil {
+				log.Printf("[ERROR] Execution of %s failed: %v", latest.Key, execErr)
+				m.history.Transition(latest.Key, stateFailed, execErr)
+				audit.Execution(latest.Key, "failed", execErr)
+				m.alerts.Send("execution:"+latest.Key, Notification{
+					Event:  notifyExecutionFailed,
+					Text:   fmt.Sprintf("execution of %s failed: %v", latest.Key, execErr),
+					Action: latest,
+					Error:  execErr.Error(),
+				})
+				m.recordFailure(failures, latest.Key, execErr)
+				reportFailure(m.config, m.keypair, latest, execErr, tally.Votes[latest.Key], m.shutdown)
+				result.LastStatus = statusFailed
+				return
+			}
+
+			if !noop {
+				m.history.Transition(latest.Key, stateVerifying, nil)
+				if err := m.verifyAction(latest); err != nil {
+					log.Printf("[ERROR] Verification of %s failed: %v", latest.Key, err)
+					m.history.Transition(latest.Key, stateFailed, err)
+					audit.Execution(latest.Key, "failed", err)
+					m.alerts.Send("verification:"+latest.Key, Notification{
+						Event:  notifyVerificationFailed,
+						Text:   fmt.Sprintf("verification of %s failed: %v", latest.Key, err),
+						Action: latest,
+						Error:  err.Error(),
+					})
+					m.recordFailure(failures, latest.Key, err)
+					reportFailure(m.config, m.keypair, latest, err, tally.Votes[latest.Key], m.shutdown)
+					result.LastStatus = statusFailed
+					return
+				}
+			}
+
+			if err := completeAction(m.config, m.keypair, m.history, latest, tally.Votes[latest.Key], m.shutdown, m.verbose); err != nil {
+				log.Printf("[ERROR] %v", err)
+				m.history.Transition(latest.Key, stateFailed, err)
+				audit.Execution(latest.Key, "failed", err)
+				m.recordFailure(failures, latest.Key, err)
+				result.LastStatus = statusFailed
+				return
+			}
+			if noop {
+				audit.Execution(latest.Key, "skipped", nil)
+			} else {
+				audit.Execution(latest.Key, "executed", nil)
+			}
+			m.coordinator.Complete(latest.Key)
+			if latest.Type == signal.TypeReboot && !noop && m.fleet == nil && latest.Service == "" {
+				startResync(m.config, latest.Key)
+			}
+
+			// Candidates the executed action made obsolete are never executed
+			if outranked := tally.Outranked(latest, m.history); len(outranked) > 0 {
+				for _, key := range outranked {
+					log.Printf("[INFO] Recording %s as skipped - outranked by %s", key, latest.Key)
+					m.history.Add(key, nil)
+					audit.Execution(key, "skipped", fmt.Errorf("outranked by %s", latest.Key))
+					m.coordinator.Complete(key)
+				}
+				if err := m.history.Save(); err != nil {
+					log.Printf("[WARN] Error saving history: %v", err)
+				}
+			}
+			result.LastStatus = statusExecuted
+			m.alerts.Clear("execution:" + latest.Key)
+			m.alerts.Clear("verification:" + latest.Key)
+
+			schedule.Remove(latest.Key)
+			if err := schedule.Save(); err != nil {
+				log.Printf("[WARN] Error saving schedule: %v", err)
+			}
+			if failures.Reset(latest.Key) {
+				if err := failures.Save(); err != nil {
+					log.Printf("[WARN] Error saving failures: %v", err)
+				}
+			}
+
+			if state != nil {
+				if err := state.Clear(); err != nil {
+					log.Printf("[WARN] Failed to clear execution state: %v", err)
+				}
+			}
+			if m.fleet != nil {
+				m.fleet.Clear(m.config.StatePath)
+			}
+		} else {
+			if approvals != nil && !noop {
+				if approval, ok := approvals.Entries[latest.Key]; !ok || approval.Status != approvalApproved {
+					log.Printf("[INFO] Dry run - action %s would require operator approval", latest.Key)
+				}
+			}
+			executeAt := executionTime(latest, m.keypair.Npub, maxStagger, time.Now())
+			log.Printf("[INFO] Dry run - action would be due at %s", executeAt.UTC().Format(time.RFC3339))
+			if m.fleet != nil {
+				m.fleet.describe(latest)
+			} else if executor != nil && !noop {
+				if steps, err := executor.Steps(latest); err != nil {
+					log.Printf("[WARN] Dry run - %s executor cannot perform %s: %v", executor.Name(), latest.Key, err)
+				} else {
+					for i, line := range describeSteps(steps) {
+						log.Printf("[INFO] Dry run - step %d/%d would run %s", i+1, len(steps), line)
+					}
+				}
+			}
+			log.Println("[INFO] Dry run - not saving action to history.")
+			result.LastStatus = statusDryRun
+		}
+	} else {
+		log.Println("[INFO] No new eligible actions to perform.")
+		if pending != nil {
+			log.Printf("[WARN] Execution of %s is incomplete (status %s); run 'qube-manager resume-action' to finish it",
+				pending.Action, pending.Status)
+		}
+	}
+}
+
+// pollRelays connects to each relay, subscribes with filters, and passes
+// every event received to accept. It returns the outcome of each poll.
+func (m *Manager) pollRelays(ctx context.Context, filters nostr.Filters, follows int, result *RunResult, accept func(*nostr.Event, string)) []RelayPoll {
+	conns := newRelayConnections()
+	defer conns.CloseAll()
+
+	info := loadRelayInfo(m.config.StatePath, m.verbose)
+	var polls []RelayPoll
+	total := 0
+	for _, relayURL := range m.config.readRelays() {
+		if m.shutdown.Requested() {
+			log.Println("[INFO] Shutdown requested - not connecting to remaining relays")
+			break
+		}
+
+		if total >= m.config.Subscription.MaxEventsTotal {
+			log.Printf("[WARN] Read %d events from all relays - not polling remaining relays (raise subscription.max_events_total or scope the subscription with tags)",
+				m.config.Subscription.MaxEventsTotal)
+			break
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("[WARN] total_timeout of %v reached - not connecting to remaining relays", m.config.TotalTimeout)
+			break
+		}
+
+		// Limitations announced in the relay's NIP-11 document explain a
+		// subscription it refuses, or events it will not accept
+		caps := info.Check(ctx, relayURL, len(filters), m.config.Subscription.MaxEventsPerRelay, m.config.ConnectTimeout)
+
+		start := time.Now()
+		log.Printf("[INFO] Connecting to relay: %s", relayURL)
+		connectCtx, cancelConnect := context.WithTimeout(ctx, m.config.ConnectTimeout)
+		relay, err := conns.Connect(connectCtx, relayURL)
+		cancelConnect()
+		polls = append(polls, RelayPoll{URL: relayURL, ConnectMillis: time.Since(start).Milliseconds(), Warnings: caps.Warnings})
+		poll := &polls[len(polls)-1]
+		if err != nil {
+			noisyLog.Printf(noiseRelay, "connect:"+relayURL, "[WARN] Failed to connect to relay %s: %v (took %v)", relayURL, err, time.Since(start))
+			poll.Error = err.Error()
+			continue
+		}
+		log.Printf("[INFO] Connected to relay: %s (took %v)", relayURL, time.Since(start))
+
+		log.Printf("[INFO] Relay %s: following %d valid npubs", relayURL, follows)
+
+		// Subscribe to notes and DMs authored by followed pubkeys
+		subCtx, cancelSub := context.WithCancel(ctx)
+		sub, err := relay.Subscribe(subCtx, filters)
+		if err != nil {
+			noisyLog.Printf(noiseRelay, "subscribe:"+relayURL, "[ERROR] Subscription failed on %s: %v", relayURL, err)
+			for _, w := range caps.Warnings {
+				noisyLog.Printf(noiseRelay, "limits:"+relayURL+":"+w, "[ERROR] Relay %s %s", relayURL, w)
+			}
+			poll.Error = err.Error()
+			cancelSub()
+			conns.Close(relayURL)
+			continue
+		}
+		log.Printf("[INFO] Subscription successful on %s", relayURL)
+		result.RelaysConnected++
+		poll.Connected = true
+
+		// Read events and parse messages, up to the per-relay limit. Stored
+		// events are drained until EOSE; read_timeout bounds the silence
+		// between them rather than the whole backlog, so a relay streaming
+		// slowly is not cut off. Only the daemon keeps listening for live
+		// events after EOSE, for another read_timeout.
+		received := 0
+		eose := false
+		idle := time.NewTimer(m.config.ReadTimeout)
+	drain:
+		for {
+			var ev *nostr.Event
+			select {
+			case e, ok := <-sub.Events:
+				if !ok {
+					break drain
+				}
+				ev = e
+			case <-sub.EndOfStoredEvents:
+				eose = true
+				if !m.daemon {
+					if m.verbose {
+						log.Printf("[DEBUG] Relay %s sent EOSE after %d events", relayURL, received)
+					}
+					break drain
+				}
+				if m.verbose {
+					log.Printf("[DEBUG] Relay %s sent EOSE after %d events - listening for live events for %v", relayURL, received, m.config.ReadTimeout)
+				}
+				idle.Reset(m.config.ReadTimeout)
+				continue
+			case reason := <-sub.ClosedReason:
+				noisyLog.Printf(noiseRelay, "closed:"+relayURL, "[WARN] Relay %s closed the subscription: %s", relayURL, reason)
+				break drain
+			case <-idle.C:
+				if !eose {
+					noisyLog.Printf(noiseRelay, "silent:"+relayURL, "[WARN] Relay %s sent nothing for %v before EOSE - stopping after %d events", relayURL, m.config.ReadTimeout, received)
+				}
+				break drain
+			}
+			if !eose {
+				idle.Reset(m.config.ReadTimeout)
+			}
+
+			poll.Events++
+			if received++; received > m.config.Subscription.MaxEventsPerRelay {
+				log.Printf("[WARN] Relay %s sent more than %d events - ignoring the rest (raise subscription.max_events_per_relay or scope the subscription with tags)",
+					relayURL, m.config.Subscription.MaxEventsPerRelay)
+				result.EventsDropped++
+				poll.Capped = true
+				break
+			}
+			if total++; total > m.config.Subscription.MaxEventsTotal {
+				log.Printf("[WARN] Relays sent more than %d events in total - ignoring the rest (raise subscription.max_events_total or scope the subscription with tags)",
+					m.config.Subscription.MaxEventsTotal)
+				result.EventsDropped++
+				poll.Capped = true
+				break
+			}
+
+			accept(ev, relayURL)
+		}
+		idle.Stop()
+
+		// The subscription and connection are released before the next
+		// relay is polled, so a daemon never accumulates them across relays
+		// or cycles
+		log.Printf("[INFO] Closing subscription on %s", relayURL)
+		sub.Close()
+		cancelSub()
+		conns.Close(relayURL)
+		log.Printf("[INFO] Subscription on relay %s closed", relayURL)
+	}
+	return polls
+}
+
+// loadOverrides reads overrides.yaml for a cycle. A file that cannot be read
+// or parsed, e.g. while an operator edits it, is reported and the overrides
+// read last are kept, so a typo never stops a running daemon.
+func (m *Manager) loadOverrides() *Overrides {
+	o, err := loadOverrides(m.config.ConfigPath)
+	if err == nil {
+		m.overrides = o
+	} else if m.overrides != nil {
+		log.Printf("[ERROR] %v - keeping the overrides read before", err)
+	} else {
+		log.Printf("[ERROR] %v - declining no actions until it is fixed", err)
+		m.overrides = &Overrides{path: filepath.Join(m.config.ConfigPath, "overrides.yaml")}
+	}
+	return m.overrides
+}
+
+// loadFailures reads failures.yaml for a cycle, keeping the failures read
+// last if the file cannot be read or parsed, as loadOverrides does
+func (m *Manager) loadFailures() *Failures {
+	f, err := loadFailures(m.config.StatePath)
+	if err == nil {
+		m.failures = f
+	} else if m.failures != nil {
+		log.Printf("[ERROR] %v - keeping the failures read before", err)
+	} else {
+		log.Printf("[ERROR] %v - counting failures from scratch", err)
+		m.failures = &Failures{Entries: make(map[string]*FailureEntry), path: filepath.Join(m.config.StatePath, "failures.yaml")}
+	}
+	return m.failures
+}
+
+// loadApprovals reads approvals.yaml for a cycle, keeping the approvals read
+// last if the file cannot be read or parsed, as loadOverrides does. Without
+// them nothing is approved, so no action runs until the file is fixed.
+func (m *Manager) loadApprovals() *Approvals {
+	a, err := loadApprovals(m.config.StatePath)
+	if err == nil {
+		m.approvals = a
+	} else if m.approvals != nil {
+		log.Printf("[ERROR] %v - keeping the approvals read before", err)
+	} else {
+		log.Printf("[ERROR] %v - approving no actions until it is fixed", err)
+		m.approvals = &Approvals{Entries: make(map[string]*ApprovalEntry), path: filepath.Join(m.config.StatePath, "approvals.yaml")}
+	}
+	return m.approvals
+}
+
+// finishCycle persists lifecycle transitions and exports the result of a
+// cycle: as metrics, in the stats history for the stats command, and in
+// last_run.json for external schedulers
+func (m *Manager) finishCycle(result *RunResult) {
+	m.lastRun = result.LastStatus
+	result.ActionStates = m.history.ActiveStates()
+	result.Alerts = m.alerts.Firing(m.history)
+	m.dashboard.Refresh(m.history, result.LastStatus)
+	m.stream.Run(result.LastStatus, result.LastAction)
+	if !m.dryRun {
+		if err := m.history.SaveIfChanged(); err != nil {
+			log.Printf("[WARN] Error saving action lifecycle: %v", err)
+		}
+		exportRunSummary(m.config, result)
+		recordRunStats(m.config.StatePath, result)
+		publishKubernetesStatus(m.config, result)
+	}
+	exportRunResult(m.config, result)
+}
+
+// recordFailure counts a failed attempt of an action, delaying its next
+// attempt, and alerts the operator if the action is now blocked
+func (m *Manager) recordFailure(failures *Failures, key string, cause error) {
+	if failures.Record(key, cause, m.config.FailureBackoff, time.Now()) {
+		m.alerts.ActionsBlocked(failures)
+	}
+	if err := failures.Save(); err != nil {
+		log.Printf("[WARN] Error saving failures: %v", err)
+	}
+}
+
+// verifyAction checks the outcome of an executed action with
+// verifyNodeVersion. Fleet hosts are verified by their health step.
+func (m *Manager) verifyAction(action *signal.Action) error {
+	if m.fleet != nil {
+		return nil
+	}
+	return verifyNodeVersion(m.config, action)
+}
+
+// verifyNodeVersion checks that the local node runs the version an executed
+// upgrade or rollback installed. Without a version source there is nothing
+// to compare against. Other services report no version and are verified by
+// their own steps.
+func verifyNodeVersion(cfg Config, action *signal.Action) error {
+	if action.Service != "" || (action.Type != "upgrade" && action.Type != "rollback") {
+		return nil
+	}
+	v := currentNodeVersion(cfg.Node)
+	if v == nil {
+		return nil
+	}
+	if !v.Equal(action.Version) {
+		return fmt.Errorf("node reports version %s after executing %s", v.Original(), action.Key)
+	}
+	return nil
+}
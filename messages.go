@@ -3,106 +3,525 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"log"
 	"os"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
+	"github.com/hypercore-one/qube-manager/signal"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
 )
 
-// UpgradeMessage represents the "upgrade" message type
-type UpgradeMessage struct {
-	Type      string `json:"type"`                // Must be "upgrade"
-	Version   string `json:"version"`             // Semantic version string
-	ExtraData string `json:"extraData,omitempty"` // additional metadata or status
+// StatusMessage reports a node's progress on an action to coordinators
+type StatusMessage struct {
+	Type          string `json:"type"`                    // Must be "status"
+	SchemaVersion string `json:"schemaVersion,omitempty"` // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	Action        string `json:"action"`                  // Key of the action the status refers to
+	Status        string `json:"status"`                  // e.g. "executing" or "scheduled"
+	ExecuteAt     string `json:"executeAt,omitempty"`     // RFC3339 time execution is scheduled for
+	ExtraData     string `json:"extraData,omitempty"`     // additional metadata or status
+	Height        uint64 `json:"height,omitempty"`        // Momentum height reached, while resyncing after a reboot
+	TargetHeight  uint64 `json:"targetHeight,omitempty"`  // Network tip being resynced to
 }
 
-// RebootMessage represents the "reboot" message type
-type RebootMessage struct {
-	Type      string `json:"type"`                // Must be "reboot"
-	Version   string `json:"version"`             // Semantic version string
-	Genesis   string `json:"genesis"`             // URL string
-	ExtraData string `json:"extraData,omitempty"` // additional metadata or status
+// PendingMessage acknowledges that an action reached quorum on a node before
+// it runs, so coordinators can tell nodes that never saw the signals from
+// nodes that saw them but failed to execute
+type PendingMessage struct {
+	Type          string `json:"type"`                    // Must be "pending"
+	SchemaVersion string `json:"schemaVersion,omitempty"` // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	Action        string `json:"action"`                  // Key of the acknowledged action
+	ExecuteAt     string `json:"executeAt,omitempty"`     // RFC3339 time execution is expected; unset while awaiting approval
+	ExtraData     string `json:"extraData,omitempty"`     // additional metadata or status
 }
 
-func sendMessageCLI(configDir string) {
-	var (
-		msgType string
-		version string
-		genesis string
-		extra   string
-		dryRun  bool
-	)
-
-	flagSet := flag.NewFlagSet("send-message", flag.ExitOnError)
-	flagSet.StringVar(&msgType, "type", "", "Message type: 'upgrade' or 'reboot'")
-	flagSet.StringVar(&version, "version", "", "Semantic version (e.g. v1.2.3)")
-	flagSet.StringVar(&genesis, "genesis", "", "Genesis URL (required for 'reboot')")
-	flagSet.StringVar(&extra, "extra", "", "Extra data (optional)")
-	flagSet.BoolVar(&dryRun, "dry-run", false, "Print message instead of sending")
-	flagSet.Parse(os.Args[2:])
+// signalTags returns "e" tags replying to every signal that voted for an
+// action and "p" tags mentioning their signers, sorted by pubkey so the tag
+// order is stable across runs
+func signalTags(votes map[string]signal.Vote) nostr.Tags {
+	pubkeys := make([]string, 0, len(votes))
+	for pk := range votes {
+		pubkeys = append(pubkeys, pk)
+	}
+	slices.Sort(pubkeys)
+
+	tags := make(nostr.Tags, 0, 2*len(votes))
+	for _, pk := range pubkeys {
+		v := votes[pk]
+		tags = append(tags, nostr.Tag{"e", v.EventID, v.Relay, "reply", v.PubKey})
+	}
+	for _, pk := range pubkeys {
+		tags = append(tags, nostr.Tag{"p", pk})
+	}
+	return tags
+}
+
+// newStatusEvent builds the unsigned status event announcing progress on an
+// action, threaded to the signals that triggered it like the done event
+func newStatusEvent(action *signal.Action, status string, executeAt time.Time, votes map[string]signal.Vote) (nostr.Event, error) {
+	msg := StatusMessage{
+		Type:          "status",
+		SchemaVersion: signal.SchemaVersion,
+		Action:        action.Key,
+		Status:        status,
+	}
+	if !executeAt.IsZero() {
+		msg.ExecuteAt = executeAt.UTC().Format(time.RFC3339)
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	return nostr.Event{
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      nostr.KindTextNote,
+		Tags:      append(signalTags(votes), correlationTags()...),
+		Content:   string(content),
+	}, nil
+}
+
+// newPendingEvent builds the unsigned pending event acknowledging an action,
+// threaded to the signals that triggered it like the done event
+func newPendingEvent(action *signal.Action, executeAt time.Time, votes map[string]signal.Vote) (nostr.Event, error) {
+	msg := PendingMessage{
+		Type:          "pending",
+		SchemaVersion: signal.SchemaVersion,
+		Action:        action.Key,
+	}
+	if !executeAt.IsZero() {
+		msg.ExecuteAt = executeAt.UTC().Format(time.RFC3339)
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	return nostr.Event{
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      nostr.KindTextNote,
+		Tags:      append(signalTags(votes), correlationTags()...),
+		Content:   string(content),
+	}, nil
+}
+
+// newDoneEvent builds the unsigned done event for a completed action. It is
+// tagged as a reply to every signal that voted for the action ("e" tags) and
+// mentions their signers ("p" tags) so completions can be threaded back.
+func newDoneEvent(action *signal.Action, votes map[string]signal.Vote) (nostr.Event, error) {
+	return newOutcomeEvent(action, "done", votes)
+}
+
+// maxFailureSummary bounds the error summary of failure events
+const maxFailureSummary = 280
+
+// newFailureEvent builds the unsigned failure event for an action whose
+// execution or verification failed: the done event with extraData "failed"
+// and the first line of the error, so coordinators can tell stuck nodes from
+// nodes that never acted
+func newFailureEvent(action *signal.Action, cause error, votes map[string]signal.Vote) (nostr.Event, error) {
+	ev, err := newOutcomeEvent(action, "failed", votes)
+	if err != nil {
+		return ev, err
+	}
+	var content map[string]any
+	if err := json.Unmarshal([]byte(ev.Content), &content); err != nil {
+		return nostr.Event{}, err
+	}
+	summary, _, _ := strings.Cut(cause.Error(), "\n")
+	if r := []rune(summary); len(r) > maxFailureSummary {
+		summary = string(r[:maxFailureSummary-3]) + "..."
+	}
+	content["error"] = summary
+	data, err := json.Marshal(content)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	ev.Content = string(data)
+	return ev, nil
+}
+
+// severityField returns the severity to write into a message about action,
+// empty for routine actions so their content stays as before severities
+func severityField(action *signal.Action) string {
+	if action.Severity == signal.SeverityRoutine {
+		return ""
+	}
+	return action.Severity
+}
+
+// newOutcomeEvent builds the unsigned event reporting the outcome of an
+// action, its signal with extraData set to the outcome
+func newOutcomeEvent(action *signal.Action, extraData string, votes map[string]signal.Vote) (nostr.Event, error) {
+	var content []byte
+	var err error
+
+	switch action.Type {
+	case "upgrade":
+		msg := signal.UpgradeMessage{
+			Type:          "upgrade",
+			SchemaVersion: signal.SchemaVersion,
+			Version:       action.Version.Original(),
+			ExtraData:     extraData,
+		}
+		if s := action.Source; s != nil {
+			msg.Repo, msg.Tag, msg.CommitHash = s.Repo, s.Tag, s.Commit
+		}
+		msg.Binary, msg.ImageDigest, msg.Cohort, msg.Network = action.Artifact, action.ImageDigest, action.Cohort, action.Network
+		msg.Service, msg.Severity = action.Service, severityField(action)
+		content, err = json.Marshal(msg)
+	case "reboot":
+		msg := signal.RebootMessage{
+			Type:          "reboot",
+			SchemaVersion: signal.SchemaVersion,
+			Version:       action.Version.Original(),
+			Genesis:       action.Genesis,
+			ImageDigest:   action.ImageDigest,
+			Network:       action.Network,
+			Severity:      severityField(action),
+			ExtraData:     extraData,
+		}
+		if a := action.Artifact; a != nil {
+			msg.GenesisSHA256, msg.GenesisMirrors = a.SHA256, a.URLs[1:]
+		}
+		content, err = json.Marshal(msg)
+	case "rollback":
+		content, err = json.Marshal(signal.RollbackMessage{
+			Type:          "rollback",
+			SchemaVersion: signal.SchemaVersion,
+			Version:       action.Version.Original(),
+			Binary:        action.Artifact,
+			ImageDigest:   action.ImageDigest,
+			Network:       action.Network,
+			Service:       action.Service,
+			Severity:      severityField(action),
+			ExtraData:     extraData,
+		})
+	default:
+		err = fmt.Errorf("unknown action type %s", action.Type)
+	}
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	return nostr.Event{
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      nostr.KindTextNote,
+		Tags:      append(signalTags(votes), correlationTags()...),
+		Content:   string(content),
+	}, nil
+}
+
+// sendMessageOptions holds the flags of the send-message command
+type sendMessageOptions struct {
+	msgType   string
+	version   string
+	genesis   string
+	repo      string
+	tag       string
+	commit    string
+	cohort    string
+	network   string
+	severity  string
+	service   string
+	pubkey    string
+	reason    string
+	notBefore string
+	executeAt string
+	extra     string
+	to        string
+	cosigs    []string
+	urls      []string
+	sha256    string
+	image     string
+	dTag      string
+	expires   string
+	template  string
+	pow       int
+	dryRun    bool
+	yes       bool
+	randomKey bool
+}
+
+func newSendMessageCommand(g *globals) *cobra.Command {
+	var o sendMessageOptions
+	cmd := &cobra.Command{
+		Use:   "send-message",
+		Short: "Sign and publish an upgrade, reboot, rollback, or revoke-key signal",
+		Long: `Sign and publish an upgrade, reboot, rollback, or revoke-key signal.
+The event and the relays it is published to are shown for confirmation first;
+--yes skips the prompt, e.g. in scripts. --template reads the message fields
+from a JSON file, such as the content of the signal for an earlier release,
+and the flags given override them. --random-key signs with a throwaway key
+and prints its npub, to add to the follows of a test network's config.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if o.template != "" {
+				if err := applyMessageTemplate(o.template, &o, cmd.Flags().Changed); err != nil {
+					log.Fatalf("[ERROR] %v", err)
+				}
+			}
+			sendMessageCLI(g.configDir, g.stateDir, g.output, g.keypair, o)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&o.msgType, "type", "", "Message type: 'upgrade', 'reboot', 'rollback', or 'revoke-key'")
+	flags.StringVar(&o.version, "version", "", "Semantic version (e.g. v1.2.3)")
+	flags.StringVar(&o.genesis, "genesis", "", "Genesis URL (required for 'reboot')")
+	flags.StringVar(&o.repo, "repo", "", "Git repository nodes must build from (optional, 'upgrade' only; requires --commit)")
+	flags.StringVar(&o.tag, "tag", "", "Git tag nodes must check out (optional, 'upgrade' only; defaults to the version; requires --commit)")
+	flags.StringVar(&o.commit, "commit", "", "Commit hash the tag must resolve to (optional, 'upgrade' only)")
+	flags.StringVar(&o.cohort, "cohort", "", "Cohort of nodes the upgrade targets, e.g. 'canary' (optional, 'upgrade' only; all nodes if unset)")
+	flags.StringVar(&o.network, "network", "", "Network the signal applies to, e.g. 'hyperqube-mainnet' (optional, not 'revoke-key'; each node's own network if unset)")
+	flags.StringVar(&o.service, "service", "", "Co-hosted service the signal applies to, e.g. 'zenon' (optional, 'upgrade' and 'rollback' only; the hyperqube node if unset)")
+	flags.StringVar(&o.severity, "severity", "", "Severity: 'routine', 'security', or 'critical', which nodes may let skip safeguards such as staggering (optional, not 'revoke-key'; routine if unset)")
+	flags.StringVar(&o.notBefore, "not-before", "", "RFC3339 time before which nodes must not execute (optional)")
+	flags.StringVar(&o.executeAt, "execute-at", "", "RFC3339 time every node executes at, for a coordinated activation (optional, 'upgrade' and 'reboot' only)")
+	flags.StringVar(&o.pubkey, "pubkey", "", "npub of the signer key to revoke (required for 'revoke-key')")
+	flags.StringVar(&o.reason, "reason", "", "Reason for the revocation or rollback (optional, 'revoke-key' and 'rollback' only)")
+	flags.StringVar(&o.extra, "extra", "", "Extra data (optional)")
+	flags.StringVar(&o.to, "to", "", "npub of a manager to send the message to as a gift-wrapped encrypted DM, NIP-17 (optional)")
+	flags.StringArrayVar(&o.urls, "artifact-url", nil, "URL serving the release binary, or a genesis mirror for 'reboot' (optional, repeatable; requires --sha256)")
+	flags.StringVar(&o.sha256, "sha256", "", "SHA-256 of the release binary, or of the genesis file for 'reboot' (optional)")
+	flags.StringVar(&o.image, "image-digest", "", "Digest (sha256:...) of the container image tagged with the version, checked by the docker executor (optional, not 'revoke-key')")
+	flags.StringVar(&o.dTag, "d-tag", "", "Publish as an addressable event (NIP-33) with this d tag; a later message with the same d tag replaces it (optional, not with --to)")
+	flags.IntVar(&o.pow, "pow", -1, "Leading zero bits of NIP-13 proof of work to mine (defaults to min_pow_difficulty)")
+	flags.StringArrayVar(&o.cosigs, "cosig", nil, "Co-signature tag from 'qube-manager cosign' to attach (repeatable, for threshold mode)")
+	flags.StringVar(&o.expires, "expires", "", "RFC3339 time or duration from now (e.g. 72h) after which relays drop the signal and managers ignore it (optional)")
+	flags.StringVar(&o.template, "template", "", "JSON file with the message fields to use where no flag is given (optional)")
+	flags.BoolVar(&o.dryRun, "dry-run", false, "Print message instead of sending")
+	flags.BoolVarP(&o.yes, "yes", "y", false, "Publish without asking for confirmation (implied when stdin is not a terminal)")
+	flags.BoolVar(&o.randomKey, "random-key", false, "Sign with a new throwaway key instead of the identity's, printing its npub (for test networks)")
+	cmd.RegisterFlagCompletionFunc("type", cobra.FixedCompletions([]string{"upgrade", "reboot", "rollback", "revoke-key"}, cobra.ShellCompDirectiveNoFileComp))
+	return cmd
+}
+
+func sendMessageCLI(configDir, stateDir string, output string, kp Keypair, o sendMessageOptions) {
 
 	// Validate message type
-	if msgType != "upgrade" && msgType != "reboot" {
-		log.Fatalf("[ERROR] Invalid message type '%s'. Must be 'upgrade' or 'reboot'.", msgType)
+	if o.msgType != "upgrade" && o.msgType != "reboot" && o.msgType != "rollback" && o.msgType != "revoke-key" {
+		log.Fatalf("[ERROR] Invalid message type '%s'. Must be 'upgrade', 'reboot', 'rollback', or 'revoke-key'.", o.msgType)
 	}
 
 	// Validate version
-	if version == "" {
-		log.Fatal("[ERROR] Version is required.")
+	if o.msgType != "revoke-key" {
+		if o.version == "" {
+			log.Fatal("[ERROR] Version is required.")
+		}
+		if _, err := semver.NewVersion(o.version); err != nil {
+			log.Fatalf("[ERROR] Invalid semantic version '%s': %v", o.version, err)
+		}
+	}
+
+	// Validate the pinned source
+	if o.msgType != "upgrade" && (o.repo != "" || o.tag != "" || o.commit != "") {
+		log.Fatal("[ERROR] --repo, --tag, and --commit only apply to upgrade messages.")
+	}
+
+	// Validate the targeted cohort
+	if o.cohort != "" && o.msgType != "upgrade" {
+		log.Fatal("[ERROR] --cohort only applies to upgrade messages.")
+	}
+
+	// Validate the targeted network
+	if o.network != "" && o.msgType == "revoke-key" {
+		log.Fatal("[ERROR] --network does not apply to revoke-key messages.")
+	}
+	if o.service != "" && o.msgType != "upgrade" && o.msgType != "rollback" {
+		log.Fatal("[ERROR] --service only applies to upgrade and rollback messages.")
+	}
+	if o.service != "" && o.service != signal.DefaultService && !signal.ValidService(o.service) {
+		log.Fatalf("[ERROR] Invalid service '%s': use lowercase letters, digits, dashes, and underscores.", o.service)
+	}
+	if o.severity != "" && o.msgType == "revoke-key" {
+		log.Fatal("[ERROR] --severity does not apply to revoke-key messages.")
+	}
+	if o.severity != "" && !signal.ValidSeverity(o.severity) {
+		log.Fatalf("[ERROR] Invalid severity '%s'. Must be 'routine', 'security', or 'critical'.", o.severity)
+	}
+	if o.image != "" && o.msgType == "revoke-key" {
+		log.Fatal("[ERROR] --image-digest does not apply to revoke-key messages.")
+	}
+
+	// Validate the announced artifact
+	if o.msgType == "revoke-key" && (len(o.urls) > 0 || o.sha256 != "") {
+		log.Fatal("[ERROR] --artifact-url and --sha256 do not apply to revoke-key messages.")
+	}
+	var binary *signal.Artifact
+	if o.msgType != "reboot" && (len(o.urls) > 0 || o.sha256 != "") {
+		binary = &signal.Artifact{URLs: o.urls, SHA256: o.sha256}
+	}
+
+	// Validate notBefore
+	if _, err := signal.ParseNotBefore(o.notBefore); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	// Validate executeAt
+	if o.executeAt != "" && o.msgType != "upgrade" && o.msgType != "reboot" {
+		log.Fatal("[ERROR] --execute-at only applies to upgrade and reboot messages.")
+	}
+	if _, err := signal.ParseExecuteAt(o.executeAt); err != nil {
+		log.Fatalf("[ERROR] %v", err)
 	}
-	if _, err := semver.NewVersion(version); err != nil {
-		log.Fatalf("[ERROR] Invalid semantic version '%s': %v", version, err)
+
+	// Validate the expiration
+	expires, err := parseExpires(o.expires, time.Now())
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if at, _ := signal.ParseExecuteAt(o.executeAt); !expires.IsZero() && !at.IsZero() && !expires.After(at) {
+		log.Fatal("[ERROR] --expires must be later than --execute-at, or nodes drop the signal before they execute it.")
+	}
+	var eventTags nostr.Tags
+	if !expires.IsZero() {
+		eventTags = append(eventTags, expirationTag(expires))
+	}
+
+	// Validate the revoked key
+	if o.msgType == "revoke-key" {
+		if kind, _, err := nip19.Decode(o.pubkey); err != nil || kind != "npub" {
+			log.Fatalf("[ERROR] A valid npub is required for revoke-key messages (got '%s').", o.pubkey)
+		}
 	}
 
 	// Validate genesis for reboot
-	if msgType == "reboot" && genesis == "" {
+	if o.msgType == "reboot" && o.genesis == "" {
 		log.Fatal("[ERROR] Genesis URL is required for reboot messages.")
 	}
 
+	// Validate the DM recipient
+	var recipient string
+	if o.to != "" {
+		kind, pk, err := nip19.Decode(o.to)
+		if err != nil || kind != "npub" {
+			log.Fatalf("[ERROR] Invalid --to npub '%s'.", o.to)
+		}
+		recipient = pk.(string)
+	}
+	if o.dTag != "" && recipient != "" {
+		log.Fatal("[ERROR] --d-tag does not apply to encrypted messages sent with --to.")
+	}
+
 	// Build message content
 	var content []byte
-	var err error
-	switch msgType {
+	switch o.msgType {
 	case "upgrade":
-		content, err = json.Marshal(UpgradeMessage{
-			Type:      "upgrade",
-			Version:   version,
-			ExtraData: extra,
+		content, err = json.Marshal(signal.UpgradeMessage{
+			Type:          "upgrade",
+			SchemaVersion: signal.SchemaVersion,
+			Version:       o.version,
+			Repo:          o.repo,
+			Tag:           o.tag,
+			CommitHash:    o.commit,
+			Binary:        binary,
+			ImageDigest:   o.image,
+			Cohort:        o.cohort,
+			Network:       o.network,
+			Service:       o.service,
+			Severity:      o.severity,
+			NotBefore:     o.notBefore,
+			ExecuteAt:     o.executeAt,
+			ExtraData:     o.extra,
 		})
 	case "reboot":
-		content, err = json.Marshal(RebootMessage{
-			Type:      "reboot",
-			Version:   version,
-			Genesis:   genesis,
-			ExtraData: extra,
+		content, err = json.Marshal(signal.RebootMessage{
+			Type:           "reboot",
+			SchemaVersion:  signal.SchemaVersion,
+			Version:        o.version,
+			Genesis:        o.genesis,
+			GenesisSHA256:  o.sha256,
+			GenesisMirrors: o.urls,
+			ImageDigest:    o.image,
+			Network:        o.network,
+			Severity:       o.severity,
+			NotBefore:      o.notBefore,
+			ExecuteAt:      o.executeAt,
+			ExtraData:      o.extra,
+		})
+	case "rollback":
+		content, err = json.Marshal(signal.RollbackMessage{
+			Type:          "rollback",
+			SchemaVersion: signal.SchemaVersion,
+			Version:       o.version,
+			Binary:        binary,
+			ImageDigest:   o.image,
+			Network:       o.network,
+			Service:       o.service,
+			Severity:      o.severity,
+			NotBefore:     o.notBefore,
+			Reason:        o.reason,
+			ExtraData:     o.extra,
+		})
+	case "revoke-key":
+		content, err = json.Marshal(signal.RevokeKeyMessage{
+			Type:          "revoke-key",
+			SchemaVersion: signal.SchemaVersion,
+			PubKey:        o.pubkey,
+			Reason:        o.reason,
+			ExtraData:     o.extra,
 		})
 	}
 	if err != nil {
 		log.Fatalf("[ERROR] Failed to marshal message: %v", err)
 	}
 
-	if dryRun {
+	// Validate the pinned source and artifact the same way managers will
+	if _, err := signal.Parse(string(content)); err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	cosigTags, err := parseCosigTags(o.cosigs, string(content))
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	eventTags = append(eventTags, cosigTags...)
+
+	if o.dryRun {
 		log.Println("[DRY RUN] Prepared message to publish:")
+		if output == outputJSON {
+			kind := nostr.KindTextNote
+			if recipient != "" {
+				kind = nostr.KindDirectMessage
+			} else if o.dTag != "" {
+				kind = addressableSignalKind
+				eventTags = append(eventTags, nostr.Tag{"d", o.dTag})
+			}
+			printJSON(struct {
+				Kind    int             `json:"kind"`
+				To      string          `json:"to,omitempty"`
+				Content json.RawMessage `json:"content"`
+				Tags    nostr.Tags      `json:"tags,omitempty"`
+			}{kind, o.to, content, eventTags})
+			return
+		}
 		fmt.Println(string(content))
 		return
 	}
 
-	log.Printf("[INFO] Loading keypair from config directory: %s", configDir)
-	kp := loadOrCreateKeypair(configDir)
+	// Test scenarios sign with a key of their own, never a production one
+	if o.randomKey {
+		kp = generateKeypair()
+		log.Printf("[WARN] Signing with throwaway key %s; its private key is not saved", kp.Npub)
+		fmt.Printf("Signing key: %s\n", kp.Npub)
+	}
+
 	_, privKey, err := nip19.Decode(kp.Nsec)
 	if err != nil {
 		log.Fatalf("[ERROR] Invalid private key: %v", err)
 	}
 
-	cfg := loadConfig(configDir)
-	if len(cfg.Relays) == 0 {
-		log.Println("[WARN] No relays configured; message will not be sent.")
+	cfg := loadConfig(configDir, stateDir)
+	if len(cfg.writeRelays()) == 0 {
+		log.Println("[WARN] No write relays configured; message will not be sent.")
 		return
 	}
 
@@ -112,7 +531,50 @@ func sendMessageCLI(configDir string) {
 		Kind:      nostr.KindTextNote,
 		Content:   string(content),
 	}
-	if err := ev.Sign(privKey.(string)); err != nil {
+	if recipient != "" {
+		// The direct message is gift-wrapped once its tags are final
+		ev, err = newDirectMessage(kp, recipient, string(content), ev.CreatedAt)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to build message: %v", err)
+		}
+	}
+	if o.dTag != "" {
+		ev.Kind = addressableSignalKind
+		ev.Tags = append(ev.Tags, nostr.Tag{"d", o.dTag})
+	}
+	ev.Tags = append(ev.Tags, eventTags...)
+	if recipient == "" {
+		// Tag public signals so managers scoping their subscription by tag see them
+		ev.Tags = append(ev.Tags, cfg.Subscription.eventTags()...)
+	}
+	relays := cfg.writeRelays()
+	if !o.yes {
+		if !confirmPublish(ev, string(content), relays, os.Stdin, os.Stdout) {
+			log.Println("[INFO] Not published.")
+			return
+		}
+	}
+
+	if o.pow < 0 {
+		o.pow = cfg.MinPowDifficulty
+	}
+	if o.pow > 0 {
+		// The nonce is mined over the final pubkey and tags, which the
+		// signature must not change
+		ev.PubKey, _ = nostr.GetPublicKey(privKey.(string))
+		log.Printf("[INFO] Mining proof of work of %d bits", o.pow)
+		nonce, err := nip13.DoWork(context.Background(), ev, o.pow)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to mine proof of work: %v", err)
+		}
+		ev.Tags = append(ev.Tags, nonce)
+	}
+	if recipient != "" {
+		log.Printf("[INFO] Encrypting message for %s", o.to)
+		if ev, err = wrapDM(kp, ev, recipient); err != nil {
+			log.Fatalf("[ERROR] Failed to encrypt message: %v", err)
+		}
+	} else if err := ev.Sign(privKey.(string)); err != nil {
 		log.Fatalf("[ERROR] Failed to sign event: %v", err)
 	}
 
@@ -120,7 +582,7 @@ func sendMessageCLI(configDir string) {
 	defer cancel()
 
 	var wg sync.WaitGroup
-	for _, relayURL := range cfg.Relays {
+	for _, relayURL := range relays {
 		wg.Add(1)
 		go func(url string) {
 			defer wg.Done()
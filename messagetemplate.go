@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// MessageTemplate is a release template read by 'send-message --template':
+// the content of a signal message, e.g. one published for an earlier
+// release, plus when the event expires. Flags given on the command line
+// take precedence over the template.
+type MessageTemplate struct {
+	Type           string           `json:"type"`
+	Version        string           `json:"version,omitempty"`
+	Genesis        string           `json:"genesis,omitempty"`
+	GenesisSHA256  string           `json:"genesisSha256,omitempty"`
+	GenesisMirrors []string         `json:"genesisMirrors,omitempty"`
+	Repo           string           `json:"repo,omitempty"`
+	Tag            string           `json:"tag,omitempty"`
+	CommitHash     string           `json:"commitHash,omitempty"`
+	Binary         *signal.Artifact `json:"binary,omitempty"`
+	ImageDigest    string           `json:"imageDigest,omitempty"`
+	Cohort         string           `json:"cohort,omitempty"`
+	Network        string           `json:"network,omitempty"`
+	Service        string           `json:"service,omitempty"`
+	Severity       string           `json:"severity,omitempty"`
+	PubKey         string           `json:"pubkey,omitempty"`
+	Reason         string           `json:"reason,omitempty"`
+	NotBefore      string           `json:"notBefore,omitempty"`
+	ExecuteAt      string           `json:"executeAt,omitempty"`
+	ExtraData      string           `json:"extraData,omitempty"`
+	SchemaVersion  string           `json:"schemaVersion,omitempty"` // Ignored; messages carry the manager's schema revision
+	Expires        string           `json:"expires,omitempty"`       // As --expires
+}
+
+// applyMessageTemplate fills the options whose flag was not given from the
+// template file at path. Unknown fields are rejected so a misspelled field
+// is not silently left out of the signal.
+func applyMessageTemplate(path string, o *sendMessageOptions, given func(flag string) bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read template: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var t MessageTemplate
+	if err := dec.Decode(&t); err != nil {
+		return fmt.Errorf("invalid template %s: %w", path, err)
+	}
+
+	set := func(flag string, dst *string, value string) {
+		if !given(flag) && value != "" {
+			*dst = value
+		}
+	}
+	set("type", &o.msgType, t.Type)
+	set("version", &o.version, t.Version)
+	set("genesis", &o.genesis, t.Genesis)
+	set("repo", &o.repo, t.Repo)
+	set("tag", &o.tag, t.Tag)
+	set("commit", &o.commit, t.CommitHash)
+	set("image-digest", &o.image, t.ImageDigest)
+	set("cohort", &o.cohort, t.Cohort)
+	set("network", &o.network, t.Network)
+	set("service", &o.service, t.Service)
+	set("severity", &o.severity, t.Severity)
+	set("pubkey", &o.pubkey, t.PubKey)
+	set("reason", &o.reason, t.Reason)
+	set("not-before", &o.notBefore, t.NotBefore)
+	set("execute-at", &o.executeAt, t.ExecuteAt)
+	set("extra", &o.extra, t.ExtraData)
+	set("expires", &o.expires, t.Expires)
+
+	// Reboots announce the genesis hash and mirrors, other types the binary
+	sha, urls := t.GenesisSHA256, t.GenesisMirrors
+	if t.Binary != nil {
+		sha, urls = t.Binary.SHA256, t.Binary.URLs
+	}
+	set("sha256", &o.sha256, sha)
+	if !given("artifact-url") && len(urls) > 0 {
+		o.urls = urls
+	}
+	return nil
+}
+
+// parseExpires resolves --expires, an RFC3339 time or a duration from now,
+// to the Unix time of the NIP-40 expiration tag
+func parseExpires(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	var at time.Time
+	if d, err := time.ParseDuration(value); err == nil {
+		at = now.Add(d)
+	} else if at, err = time.Parse(time.RFC3339, value); err != nil {
+		return time.Time{}, fmt.Errorf("invalid --expires %q: use an RFC3339 time or a duration such as 72h", value)
+	}
+	if !at.After(now) {
+		return time.Time{}, fmt.Errorf("--expires %s is not in the future", at.UTC().Format(time.RFC3339))
+	}
+	return at, nil
+}
+
+// expirationTag returns the NIP-40 tag making relays drop the event, and
+// managers ignore it, after at
+func expirationTag(at time.Time) nostr.Tag {
+	return nostr.Tag{"expiration", strconv.FormatInt(at.Unix(), 10)}
+}
+
+// confirmPublish shows the event about to be signed, with the plaintext
+// content of encrypted messages, and the relays it goes to, and asks the
+// operator to confirm on in. Without a terminal to ask on, as in scripts, the
+// event is published without asking.
+func confirmPublish(ev nostr.Event, content string, relays []string, in *os.File, out io.Writer) bool {
+	if fi, err := in.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return true
+	}
+
+	fmt.Fprintf(out, "Kind:    %d\n", ev.Kind)
+	fmt.Fprintf(out, "Content: %s\n", content)
+	if ev.Kind == nostr.KindDirectMessage {
+		fmt.Fprintln(out, "         (gift-wrapped and encrypted for the recipient tagged below)")
+	}
+	for _, tag := range ev.Tags {
+		fmt.Fprintf(out, "Tag:     %s\n", strings.Join(tag, " "))
+	}
+	for _, r := range relays {
+		fmt.Fprintf(out, "Relay:   %s\n", r)
+	}
+	fmt.Fprintf(out, "Publish this event to %d relay(s)? [y/N] ", len(relays))
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
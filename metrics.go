@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Action status values reported in run results
+const (
+	statusNone             = "none"              // no eligible action this run
+	statusExecuted         = "executed"          // action executed and recorded in history
+	statusFailed           = "failed"            // execution or done event creation failed
+	statusDryRun           = "dry_run"           // action selected but not executed (dry run)
+	statusInterrupted      = "interrupted"       // shutdown requested before the action started
+	statusScheduled        = "scheduled"         // action selected but not due yet (stagger, notBefore, or action_cooldown)
+	statusAwaitingApproval = "awaiting_approval" // action reached quorum but the operator has not approved it
+	statusStandby          = "standby"           // action reached quorum but another instance holds the execution lease
+	statusObserved         = "observed"          // action reached quorum but this manager only observes
+	statusBackoff          = "backoff"           // action failed recently and waits before its next attempt
+	statusBlocked          = "blocked"           // action failed failure_backoff.max_failures times and waits for 'retry-action'
+	statusError            = "error"             // the cycle stopped on an error before any action was selected
+)
+
+// actionStatuses lists every status so each one is exported as a 0/1 gauge
+var actionStatuses = []string{statusNone, statusExecuted, statusFailed, statusDryRun, statusInterrupted, statusScheduled, statusAwaitingApproval, statusStandby, statusObserved, statusBackoff, statusBlocked, statusError}
+
+// RunResult summarizes a single run for metrics export
+type RunResult struct {
+	Started         time.Time         // When the run began
+	RelaysConnected int               // Relays that accepted a subscription
+	ConnectionsOpen int64             // Relay connections still open when the run ended
+	ConnectionsMade int64             // Relay connections opened since the process started
+	ActionsPending  int               // Candidate actions seen that are not yet in history
+	LastAction      string            // Key of the selected action, empty if none
+	LastStatus      string            // One of the status* constants
+	NodeVersion     string            // Detected node version, empty if unknown
+	ActionStates    map[string]string // Lifecycle state of each action not yet done
+	Telemetry       *Telemetry        // Sampled node stats (nil if telemetry is disabled)
+	Alerts          map[string]bool   // Whether each alert condition holds (nil in dry runs)
+	SignalsGossiped int               // Validated signals republished to relays that missed them
+	UnknownSchema   int               // Signals ignored for a message schema revision this manager cannot parse
+	EventsSeen      int               // Events received from the relays
+	EventsDropped   int               // Events received but not processed because an event or candidate limit was reached
+	Evicted         int               // Candidates below quorum evicted to stay within max_candidates
+	Candidates      int               // Candidate actions tallied
+	Votes           int               // Votes cast for the candidates
+	Signers         int               // Distinct signers that voted
+	Polls           []RelayPoll       // Outcome of each relay poll
+	Resync          *ResyncState      // Resync followed after a reboot (nil if none)
+	ConfigErrors    int               // Problems that got config.yaml rejected when it was last read
+	ConfigWarnings  int               // Likely mistakes in config.yaml when it was last read
+}
+
+// newRunResult starts a result for a run beginning now
+func newRunResult() *RunResult {
+	return &RunResult{Started: time.Now(), LastStatus: statusNone}
+}
+
+// writeMetricsTextfile writes the run result in Prometheus textfile collector
+// format. The file is replaced atomically so node_exporter never reads a
+// partially written file.
+func writeMetricsTextfile(path string, r *RunResult) error {
+	var buf bytes.Buffer
+
+	writeGauge := func(name, help string, value float64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+
+	writeGauge("qube_manager_last_run_timestamp_seconds", "Unix time the last run started.", float64(r.Started.Unix()))
+	name := "qube_manager_run_info"
+	fmt.Fprintf(&buf, "# HELP %s ID of the manager process, as in its logs and events.\n# TYPE %s gauge\n%s{run_id=%q} 1\n", name, name, name, runID)
+	writeGauge("qube_manager_last_run_duration_seconds", "Duration of the last run.", time.Since(r.Started).Seconds())
+	writeGauge("qube_manager_relays_connected", "Relays that accepted a subscription in the last run.", float64(r.RelaysConnected))
+	writeGauge("qube_manager_relay_connections_open", "Relay connections still open when the last run ended.", float64(r.ConnectionsOpen))
+	name = "qube_manager_relay_connections_opened_total"
+	fmt.Fprintf(&buf, "# HELP %s Relay connections opened since the process started.\n# TYPE %s counter\n%s %d\n", name, name, name, r.ConnectionsMade)
+	writeGauge("qube_manager_signals_unsupported_schema", "Signals ignored in the last run because their message schema revision is not supported.", float64(r.UnknownSchema))
+	writeGauge("qube_manager_signals_gossiped", "Validated signals republished to relays that missed them in the last run.", float64(r.SignalsGossiped))
+	writeGauge("qube_manager_events_dropped", "Events received in the last run but not processed because an event or candidate limit was reached.", float64(r.EventsDropped))
+	writeGauge("qube_manager_candidates_evicted", "Candidates below quorum evicted in the last run to stay within the candidate limit.", float64(r.Evicted))
+	writeGauge("qube_manager_actions_pending", "Candidate actions seen that are not yet in history.", float64(r.ActionsPending))
+
+	name = "qube_manager_config_problems"
+	fmt.Fprintf(&buf, "# HELP %s Problems found when config.yaml was last read; errors mean a reload was rejected.\n# TYPE %s gauge\n", name, name)
+	fmt.Fprintf(&buf, "%s{severity=\"error\"} %d\n%s{severity=\"warning\"} %d\n", name, r.ConfigErrors, name, r.ConfigWarnings)
+
+	if r.NodeVersion != "" {
+		name := "qube_manager_node_version_info"
+		fmt.Fprintf(&buf, "# HELP %s Node version detected in the last run.\n# TYPE %s gauge\n%s{version=%q} 1\n", name, name, name, r.NodeVersion)
+	}
+
+	if t := r.Telemetry; t != nil {
+		if t.MomentumHeight > 0 {
+			writeGauge("qube_manager_node_momentum_height", "Height of the node's frontier momentum.", float64(t.MomentumHeight))
+		}
+		if t.Peers != nil {
+			writeGauge("qube_manager_node_peers", "Peers connected to the node.", float64(*t.Peers))
+		}
+		if t.DiskTotalBytes > 0 {
+			writeGauge("qube_manager_node_disk_used_bytes", "Used space on the node data directory's filesystem.", float64(t.DiskUsedBytes))
+			writeGauge("qube_manager_node_disk_total_bytes", "Size of the node data directory's filesystem.", float64(t.DiskTotalBytes))
+		}
+		if t.UptimeSeconds > 0 {
+			writeGauge("qube_manager_node_uptime_seconds", "Time since the node process started.", float64(t.UptimeSeconds))
+		}
+	}
+
+	if r.Resync != nil {
+		writeGauge("qube_manager_resync_height", "Height the node reached while resyncing after a reboot.", float64(r.Resync.Height))
+		writeGauge("qube_manager_resync_target_height", "Network tip the node is resyncing to after a reboot.", float64(r.Resync.Target))
+	}
+
+	name = "qube_manager_last_action_status"
+	fmt.Fprintf(&buf, "# HELP %s Status of the action selected in the last run.\n# TYPE %s gauge\n", name, name)
+	var lastActionID string
+	if r.LastAction != "" {
+		lastActionID = actionCorrelationID(r.LastAction)
+	}
+	for _, status := range actionStatuses {
+		value := 0
+		if status == r.LastStatus {
+			value = 1
+		}
+		fmt.Fprintf(&buf, "%s{action=%q,action_id=%q,status=%q} %d\n", name, r.LastAction, lastActionID, status, value)
+	}
+
+	if r.Alerts != nil {
+		name = "qube_manager_alert_firing"
+		fmt.Fprintf(&buf, "# HELP %s Whether an alert condition held at the end of the last run.\n# TYPE %s gauge\n", name, name)
+		for _, alert := range alertConditions {
+			value := 0
+			if r.Alerts[alert] {
+				value = 1
+			}
+			fmt.Fprintf(&buf, "%s{alert=%q} %d\n", name, alert, value)
+		}
+	}
+
+	name = "qube_manager_action_state"
+	fmt.Fprintf(&buf, "# HELP %s Lifecycle state of actions not yet done.\n# TYPE %s gauge\n", name, name)
+	keys := make([]string, 0, len(r.ActionStates))
+	for key := range r.ActionStates {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		id := actionCorrelationID(key)
+		for _, state := range lifecycleStates {
+			if state == stateDone {
+				continue
+			}
+			value := 0
+			if state == r.ActionStates[key] {
+				value = 1
+			}
+			fmt.Fprintf(&buf, "%s{action=%q,action_id=%q,state=%q} %d\n", name, key, id, state, value)
+		}
+	}
+
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// exportRunResult writes the metrics textfile if one is configured
+func exportRunResult(cfg Config, r *RunResult) {
+	if cfg.MetricsTextfile == "" {
+		return
+	}
+	r.ConnectionsOpen = openRelayConnections.Load()
+	r.ConnectionsMade = openedRelayConnections.Load()
+	if err := writeMetricsTextfile(cfg.MetricsTextfile, r); err != nil {
+		log.Printf("[WARN] Failed to write metrics textfile %s: %v", cfg.MetricsTextfile, err)
+		return
+	}
+	log.Printf("[INFO] Metrics written to %s", cfg.MetricsTextfile)
+}
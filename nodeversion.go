@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// NodeConfig tells the manager how to find out which node version is running
+type NodeConfig struct {
+	RPCURL      string `yaml:"rpc_url,omitempty"`      // HTTP JSON-RPC endpoint of the node, e.g. http://127.0.0.1:35997
+	Binary      string `yaml:"binary,omitempty"`       // Node binary to run with --version
+	VersionFile string `yaml:"version_file,omitempty"` // File written by the deployment repo containing the installed version
+}
+
+// versionPattern matches the first semantic version in free-form output
+var versionPattern = regexp.MustCompile(`v?\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?`)
+
+// detectNodeVersion determines the running node version, trying the RPC
+// endpoint, the binary's --version output, and the deployment metadata file
+// in that order. It returns the version and the source it came from.
+func detectNodeVersion(cfg NodeConfig) (*semver.Version, string, error) {
+	if cfg.RPCURL == "" && cfg.Binary == "" && cfg.VersionFile == "" {
+		return nil, "", errors.New("no version source configured")
+	}
+
+	var errs []error
+	if cfg.RPCURL != "" {
+		v, err := versionFromRPC(cfg.RPCURL)
+		if err == nil {
+			return v, "rpc", nil
+		}
+		errs = append(errs, fmt.Errorf("rpc: %w", err))
+	}
+	if cfg.Binary != "" {
+		v, err := versionFromBinary(cfg.Binary)
+		if err == nil {
+			return v, "binary", nil
+		}
+		errs = append(errs, fmt.Errorf("binary: %w", err))
+	}
+	if cfg.VersionFile != "" {
+		v, err := versionFromFile(cfg.VersionFile)
+		if err == nil {
+			return v, "file", nil
+		}
+		errs = append(errs, fmt.Errorf("file: %w", err))
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// nodeRPC calls a JSON-RPC method on the node and decodes its result into out
+func nodeRPC(url, method string, out any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	body, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  []any{},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("invalid JSON-RPC response: %w", err)
+	}
+	if response.Error != nil {
+		return errors.New(response.Error.Message)
+	}
+	if err := json.Unmarshal(response.Result, out); err != nil {
+		return fmt.Errorf("invalid %s result: %w", method, err)
+	}
+	return nil
+}
+
+// versionFromRPC queries the node's stats.processInfo JSON-RPC method
+func versionFromRPC(url string) (*semver.Version, error) {
+	var info struct {
+		Version string `json:"version"`
+	}
+	if err := nodeRPC(url, "stats.processInfo", &info); err != nil {
+		return nil, err
+	}
+	return semver.NewVersion(info.Version)
+}
+
+// versionFromBinary runs the node binary with --version and parses its output
+func versionFromBinary(binary string) (*semver.Version, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, binary, "--version").CombinedOutput()
+	if err != nil {
+		return nil, err
+	}
+	return findVersion(out)
+}
+
+// versionFromFile reads the installed version from deployment metadata
+func versionFromFile(path string) (*semver.Version, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return findVersion(data)
+}
+
+// findVersion extracts the first semantic version from text
+func findVersion(text []byte) (*semver.Version, error) {
+	match := versionPattern.Find(text)
+	if match == nil {
+		return nil, errors.New("no version found")
+	}
+	return semver.NewVersion(string(match))
+}
+
+// currentNodeVersion detects the node version for a run, logging the result.
+// It returns nil if no source is configured or detection failed.
+func currentNodeVersion(cfg NodeConfig) *semver.Version {
+	v, source, err := detectNodeVersion(cfg)
+	if err != nil {
+		if cfg.RPCURL != "" || cfg.Binary != "" || cfg.VersionFile != "" {
+			log.Printf("[WARN] Could not detect node version: %v", err)
+		}
+		return nil
+	}
+	log.Printf("[INFO] Detected node version %s (via %s)", v.Original(), source)
+	return v
+}
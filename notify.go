@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// defaultNotifyTemplate renders notifications of channels without a template
+const defaultNotifyTemplate = "qube-manager {{.Npub}}: {{.Text}}"
+
+// Notification events
+const (
+	notifyExecutionFailed    = "execution_failed"
+	notifyVerificationFailed = "verification_failed"
+	notifyRelaysLost         = "relays_lost"
+	notifyRelaysRestored     = "relays_restored"
+	notifyQuorumStuck        = "quorum_stuck"
+	notifySignerSilent       = "signer_silent"
+	notifyActionBlocked      = "action_blocked"
+	notifyResyncStalled      = "resync_stalled"
+)
+
+// Notification is something the operator is told about. Channel templates
+// render its fields, e.g. "{{.Event}} on {{.Npub}}: {{with .Action}}{{.Key}}{{end}}".
+type Notification struct {
+	Event    string         // What happened, e.g. "execution_failed"
+	Text     string         // Human-readable description
+	Action   *signal.Action // Action concerned, or nil
+	Error    string         // Failure reason, if any
+	Npub     string         // This manager's public key
+	Time     time.Time      // When the notification was raised
+	RunID    string         // ID of this manager process
+	ActionID string         // ID of the action concerned, or of the action being handled, if any
+}
+
+// NotifierConfig configures one notification channel
+type NotifierConfig struct {
+	Type     string            `yaml:"type"`               // Backend: "nostr", "webhook", or another registered backend
+	Template string            `yaml:"template,omitempty"` // Go template of the message body (default "qube-manager {{.Npub}}: {{.Text}}")
+	Npub     string            `yaml:"npub,omitempty"`     // Recipient of encrypted DMs, for the nostr backend
+	URL      string            `yaml:"url,omitempty"`      // Endpoint, for HTTP backends
+	Options  map[string]string `yaml:"options,omitempty"`  // Backend-specific settings
+}
+
+// Notifier delivers rendered notifications over one channel
+type Notifier interface {
+	Name() string                             // Backend name for logging
+	Notify(n Notification, body string) error // Delivers body, rendered from n
+}
+
+// NotifierFactory builds a notifier backend from its channel config. It is
+// also called to validate the config, so it must not contact anything.
+type NotifierFactory func(nc NotifierConfig, cfg Config, kp Keypair, shutdown *ShutdownHandler) (Notifier, error)
+
+// notifierBackends holds the notifier backends by type
+var notifierBackends = map[string]NotifierFactory{}
+
+// registerNotifier makes a notifier backend available under name. Backends
+// register themselves from an init function in their own file.
+func registerNotifier(name string, factory NotifierFactory) {
+	notifierBackends[name] = factory
+}
+
+// notifyChannel is a notifier together with its body template
+type notifyChannel struct {
+	notifier Notifier
+	tmpl     *template.Template
+}
+
+// newNotifyChannel builds the channel configured by nc
+func newNotifyChannel(nc NotifierConfig, cfg Config, kp Keypair, shutdown *ShutdownHandler) (notifyChannel, error) {
+	factory, ok := notifierBackends[nc.Type]
+	if !ok {
+		names := make([]string, 0, len(notifierBackends))
+		for name := range notifierBackends {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return notifyChannel{}, fmt.Errorf("unknown notifier type %q (expected %s)", nc.Type, strings.Join(names, ", "))
+	}
+	text := nc.Template
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	tmpl, err := template.New(nc.Type).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return notifyChannel{}, fmt.Errorf("invalid template: %w", err)
+	}
+	notifier, err := factory(nc, cfg, kp, shutdown)
+	if err != nil {
+		return notifyChannel{}, err
+	}
+	return notifyChannel{notifier: notifier, tmpl: tmpl}, nil
+}
+
+// render returns the message body for n
+func (c notifyChannel) render(n Notification) (string, error) {
+	var body strings.Builder
+	if err := c.tmpl.Execute(&body, n); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+// notifierConfigs returns the configured channels, with a nostr channel to
+// alert_npub first if it is set
+func notifierConfigs(cfg Config) []NotifierConfig {
+	if cfg.AlertNpub == "" {
+		return cfg.Notifiers
+	}
+	return append([]NotifierConfig{{Type: "nostr", Npub: cfg.AlertNpub}}, cfg.Notifiers...)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func init() {
+	registerNotifier("nostr", newNostrNotifier)
+	registerNotifier("nostr_event", newNostrEventNotifier)
+}
+
+// NostrNotifier sends notifications as gift-wrapped DMs (NIP-17) over the
+// configured relays
+type NostrNotifier struct {
+	cfg       Config
+	kp        Keypair
+	npub      string
+	recipient string // hex pubkey of npub
+	shutdown  *ShutdownHandler
+}
+
+func newNostrNotifier(nc NotifierConfig, cfg Config, kp Keypair, shutdown *ShutdownHandler) (Notifier, error) {
+	kind, pk, err := nip19.Decode(nc.Npub)
+	if err != nil || kind != "npub" {
+		return nil, fmt.Errorf("invalid npub %q", nc.Npub)
+	}
+	return &NostrNotifier{cfg: cfg, kp: kp, npub: nc.Npub, recipient: pk.(string), shutdown: shutdown}, nil
+}
+
+func (n *NostrNotifier) Name() string { return "nostr" }
+
+// Notify publishes body as a gift-wrapped DM to the recipient and fails unless
+// a relay accepted it
+func (n *NostrNotifier) Notify(_ Notification, body string) error {
+	dm, err := newEncryptedDM(n.kp, n.recipient, body, nil, nostr.Now())
+	if err != nil {
+		return fmt.Errorf("failed to encrypt alert: %w", err)
+	}
+
+	log.Printf("[INFO] Sending alert to %s", n.npub)
+	if publishToRelays(n.cfg.writeRelays(), dm, n.cfg.ConnectTimeout, n.cfg.ShutdownGracePeriod, n.shutdown)() == 0 {
+		return errors.New("alert was not accepted by any relay")
+	}
+	return nil
+}
+
+// AlertMessage is the content of a public alert event, so coordinators
+// watching the fleet learn about failing managers
+type AlertMessage struct {
+	Type          string `json:"type"`                    // Must be "alert"
+	SchemaVersion string `json:"schemaVersion,omitempty"` // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	Event         string `json:"event"`                   // What happened, e.g. "relays_lost"
+	Text          string `json:"text"`                    // Rendered notification
+	Action        string `json:"action,omitempty"`        // Key of the action concerned, if any
+	Error         string `json:"error,omitempty"`         // Failure reason, if any
+}
+
+// NostrEventNotifier publishes notifications as public alert events signed
+// by the manager. The events carry the subscription tags so coordinators can
+// scope their queries like signals.
+type NostrEventNotifier struct {
+	cfg      Config
+	kp       Keypair
+	shutdown *ShutdownHandler
+}
+
+func newNostrEventNotifier(_ NotifierConfig, cfg Config, kp Keypair, shutdown *ShutdownHandler) (Notifier, error) {
+	return &NostrEventNotifier{cfg: cfg, kp: kp, shutdown: shutdown}, nil
+}
+
+func (n *NostrEventNotifier) Name() string { return "nostr_event" }
+
+// Notify publishes an alert event with body as its text and fails unless a
+// relay accepted it
+func (n *NostrEventNotifier) Notify(note Notification, body string) error {
+	msg := AlertMessage{Type: "alert", SchemaVersion: signal.SchemaVersion, Event: note.Event, Text: body, Error: note.Error}
+	if note.Action != nil {
+		msg.Action = note.Action.Key
+	}
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ev := nostr.Event{
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      nostr.KindTextNote,
+		Tags:      append(n.cfg.Subscription.eventTags(), correlationTags()...),
+		Content:   string(content),
+	}
+	if err := signEvent(n.kp, &ev); err != nil {
+		return fmt.Errorf("failed to sign alert event: %w", err)
+	}
+
+	log.Printf("[INFO] Publishing %s alert event", note.Event)
+	if publishToRelays(n.cfg.writeRelays(), ev, n.cfg.ConnectTimeout, n.cfg.ShutdownGracePeriod, n.shutdown)() == 0 {
+		return errors.New("alert event was not accepted by any relay")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func init() {
+	registerNotifier("webhook", newWebhookNotifier)
+}
+
+// webhookTimeout bounds a single webhook delivery
+const webhookTimeout = 10 * time.Second
+
+// WebhookNotifier posts notifications to an HTTP endpoint. By default the
+// body is JSON with the rendered message in "text", which chat services
+// such as Slack and Mattermost accept; with the option format: text the
+// rendered message is posted as is, e.g. for ntfy.
+type WebhookNotifier struct {
+	url           string
+	format        string // "json" or "text"
+	authorization string // Authorization header value, if any
+}
+
+func newWebhookNotifier(nc NotifierConfig, _ Config, _ Keypair, _ *ShutdownHandler) (Notifier, error) {
+	u, err := url.ParseRequestURI(nc.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webhook url %q (expected an http or https URL)", redactURL(nc.URL))
+	}
+	format := nc.Options["format"]
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "text" {
+		return nil, fmt.Errorf("webhook format must be \"json\" or \"text\" (got %q)", format)
+	}
+	return &WebhookNotifier{url: nc.URL, format: format, authorization: nc.Options["authorization"]}, nil
+}
+
+func (w *WebhookNotifier) Name() string { return "webhook" }
+
+// Notify posts body and fails unless the endpoint answers with a 2xx status
+func (w *WebhookNotifier) Notify(n Notification, body string) error {
+	payload, contentType := []byte(body), "text/plain; charset=utf-8"
+	if w.format == "json" {
+		doc := map[string]any{"text": body, "event": n.Event, "npub": n.Npub, "time": n.Time.UTC().Format(time.RFC3339), "run_id": n.RunID}
+		if n.Action != nil {
+			doc["action"] = n.Action.Key
+		}
+		if n.ActionID != "" {
+			doc["action_id"] = n.ActionID
+		}
+		if n.Error != "" {
+			doc["error"] = n.Error
+		}
+		var err error
+		if payload, err = json.Marshal(doc); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if w.authorization != "" {
+		req.Header.Set("Authorization", w.authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The URL often embeds a token, so errors name it redacted
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactURL(urlErr.URL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// OutboxEntry is a signed event waiting for enough relays to accept it
+type OutboxEntry struct {
+	Event    nostr.Event `json:"event"`              // Signed event to publish
+	Label    string      `json:"label"`              // What the event is, for logs
+	QueuedAt string      `json:"queued_at"`          // ISO8601 timestamp the event was queued
+	Attempts int         `json:"attempts"`           // Publish attempts so far
+	Accepted []string    `json:"accepted,omitempty"` // Relays that accepted the event
+}
+
+// Outbox persists events that must reach relays, such as done events, so
+// they are retried on later runs until publish_min_relays relays accepted
+// them
+type Outbox struct {
+	Entries []*OutboxEntry `json:"entries"`
+	path    string         // outbox file path (not in JSON)
+}
+
+// loadOutbox reads the outbox file, returning an empty outbox if missing
+func loadOutbox(stateDir string) *Outbox {
+	o := &Outbox{path: filepath.Join(stateDir, "outbox.json")}
+
+	data, err := os.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return o
+	} else if err != nil {
+		log.Fatalf("[ERROR] Failed to read outbox file %s: %v", o.path, err)
+	}
+	if err := json.Unmarshal(data, o); err != nil {
+		log.Fatalf("[ERROR] Failed to parse outbox file %s: %v", o.path, err)
+	}
+	return o
+}
+
+// Save writes the outbox back to its file
+func (o *Outbox) Save() error {
+	data, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(o.path, data, 0644)
+}
+
+// Add queues a signed event unless it is queued already
+func (o *Outbox) Add(ev nostr.Event, label string) {
+	for _, e := range o.Entries {
+		if e.Event.ID == ev.ID {
+			return
+		}
+	}
+	o.Entries = append(o.Entries, &OutboxEntry{
+		Event:    ev,
+		Label:    label,
+		QueuedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// Flush publishes every queued event to the relays that have not accepted it
+// yet, drops the events accepted by enough relays, and saves the outbox
+func (o *Outbox) Flush(cfg Config, shutdown *ShutdownHandler) {
+	if len(o.Entries) == 0 {
+		return
+	}
+	relays := cfg.writeRelays()
+	required := min(cfg.PublishMinRelays, len(relays))
+
+	waits := make([]func() []string, len(o.Entries))
+	for i, e := range o.Entries {
+		var pending []string
+		for _, r := range relays {
+			if !slices.Contains(e.Accepted, r) {
+				pending = append(pending, r)
+			}
+		}
+		e.Attempts++
+		waits[i] = publishEvent(pending, e.Event, cfg.ConnectTimeout, cfg.ShutdownGracePeriod, shutdown)
+	}
+
+	var queued []*OutboxEntry
+	for i, e := range o.Entries {
+		e.Accepted = append(e.Accepted, waits[i]()...)
+		if required > 0 && len(e.Accepted) >= required {
+			log.Printf("[INFO] The %s was accepted by %d/%d relays", e.Label, len(e.Accepted), len(relays))
+			continue
+		}
+		log.Printf("[WARN] The %s was accepted by %d of the %d relay(s) required after %d attempt(s) - retrying next run", e.Label, len(e.Accepted), cfg.PublishMinRelays, e.Attempts)
+		queued = append(queued, e)
+	}
+	o.Entries = queued
+
+	if err := o.Save(); err != nil {
+		log.Printf("[WARN] Error saving outbox: %v", err)
+	}
+}
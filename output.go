@@ -0,0 +1,23 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// Output formats for command results, selected with the global --output flag.
+// In JSON mode logs go to stderr so stdout carries only the result.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// printJSON writes a command result to stdout as indented JSON
+func printJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to encode JSON output: %v", err)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/hypercore-one/qube-manager/signal"
+	"gopkg.in/yaml.v3"
+)
+
+// Overrides lets an individual operator decline network actions the signers
+// agreed on, without editing history or stopping the manager. The file sits
+// next to config.yaml and is read every run, so edits take effect on the
+// next cycle of a running daemon.
+type Overrides struct {
+	Skip       []string        `yaml:"skip,omitempty"`        // Actions never executed, e.g. "upgrade:v1.2.3" or a full action key
+	PinVersion string          `yaml:"pin_version,omitempty"` // Only version upgrades and rollbacks may move the node to
+	path       string          // overrides file path (not in YAML)
+	pin        *semver.Version // parsed PinVersion, nil if unset
+}
+
+// loadOverrides reads the overrides file, returning no overrides if missing
+func loadOverrides(configDir string) (*Overrides, error) {
+	o := &Overrides{path: filepath.Join(configDir, "overrides.yaml")}
+
+	data, err := os.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return o, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read overrides file %s: %w", o.path, err)
+	}
+	if err := yaml.Unmarshal(data, o); err != nil {
+		return nil, fmt.Errorf("failed to parse overrides file %s: %w", o.path, err)
+	}
+	if o.PinVersion != "" {
+		if o.pin, err = semver.NewVersion(o.PinVersion); err != nil {
+			return nil, fmt.Errorf("invalid pin_version %q in %s: %w", o.PinVersion, o.path, err)
+		}
+	}
+	return o, nil
+}
+
+// Declined returns why the operator declined the action with the given key,
+// or "" if the overrides allow it. A skip entry matches the key with or
+// without its network prefix, and with any commit, artifact, or genesis
+// suffix, so "upgrade:v1.2.3" covers every variant of that upgrade of the
+// hyperqube node; actions of a co-hosted service are only matched by entries
+// naming it, e.g. "upgrade:v1.2.3#service:zenon". The pin only applies to
+// the hyperqube node. Key revocations are never declined.
+func (o *Overrides) Declined(key string) string {
+	if strings.HasPrefix(key, signal.RevokeKeyPrefix) {
+		return ""
+	}
+	// The network prefix ends before the type; reboot keys carry a genesis
+	// URL with slashes of its own
+	bare := key
+	if slash := strings.Index(key, "/"); slash >= 0 && slash < strings.Index(key, ":") {
+		bare = key[slash+1:]
+	}
+	for _, skip := range o.Skip {
+		if matchesActionKey(key, skip) || matchesActionKey(bare, skip) {
+			return fmt.Sprintf("skipped in %s", filepath.Base(o.path))
+		}
+	}
+
+	if o.pin == nil || strings.Contains(bare, "#service:") {
+		return ""
+	}
+	typ, rest, _ := strings.Cut(bare, ":")
+	if typ != signal.TypeUpgrade && typ != signal.TypeRollback {
+		return ""
+	}
+	if end := strings.IndexAny(rest, "@#:"); end >= 0 {
+		rest = rest[:end]
+	}
+	if v, err := semver.NewVersion(rest); err == nil && !v.Equal(o.pin) {
+		return fmt.Sprintf("pinned to %s in %s", o.PinVersion, filepath.Base(o.path))
+	}
+	return ""
+}
+
+// matchesActionKey reports whether key is entry or entry followed by a
+// commit, artifact, or genesis suffix, for the same service
+func matchesActionKey(key, entry string) bool {
+	key, service := cutServiceSuffix(key)
+	entry, entryService := cutServiceSuffix(entry)
+	if service != entryService {
+		return false
+	}
+	rest, ok := strings.CutPrefix(key, entry)
+	return ok && (rest == "" || strings.ContainsAny(rest[:1], "@#:"))
+}
+
+// cutServiceSuffix splits the "#service:" suffix naming a co-hosted service
+// off an action key
+func cutServiceSuffix(key string) (string, string) {
+	if i := strings.LastIndex(key, "#service:"); i >= 0 {
+		return key[:i], key[i:]
+	}
+	return key, ""
+}
+
+// dropDeclined removes candidates the operator declined from the evaluator,
+// logging why each one is never executed. It returns the declined keys.
+func dropDeclined(e *signal.Evaluator, overrides *Overrides, history signal.History) []string {
+	var declined []string
+	for key := range e.Actions {
+		if history.Has(key) {
+			continue
+		}
+		if reason := overrides.Declined(key); reason != "" {
+			log.Printf("[INFO] Ignoring action %s declined by the operator: %s (votes %d/%d)", key, reason, len(e.Votes[key]), e.Quorum())
+			e.Drop(key)
+			declined = append(declined, key)
+		}
+	}
+	slices.Sort(declined)
+	return declined
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultSignerSilenceAlertAfter is how long a follow may publish nothing
+// while others signal before the operator is alerted
+const defaultSignerSilenceAlertAfter = 30 * 24 * time.Hour
+
+// What a signer was last seen publishing
+const (
+	seenSignal    = "signal"
+	seenHeartbeat = "heartbeat"
+)
+
+// SignerSeen is the latest sign of life from a followed signer
+type SignerSeen struct {
+	LastSeen string `yaml:"last_seen"` // ISO8601 creation time of the newest event
+	Via      string `yaml:"via"`       // "signal" or "heartbeat"
+}
+
+// Participation persists when each followed signer last published a valid
+// signal or heartbeat. A key that stopped signaling still counts toward the
+// follows, so it silently raises the share of the others needed for quorum.
+type Participation struct {
+	Since   string                 `yaml:"since"`   // ISO8601 time tracking began; never-seen signers are silent since then
+	Signers map[string]*SignerSeen `yaml:"signers"` // key: hex pubkey
+	path    string                 // participation file path (not in YAML)
+	changed bool
+}
+
+// loadParticipation reads the participation file, starting to track now if
+// it is missing
+func loadParticipation(stateDir string) *Participation {
+	p := &Participation{
+		Since:   time.Now().UTC().Format(time.RFC3339),
+		Signers: make(map[string]*SignerSeen),
+		path:    filepath.Join(stateDir, "participation.yaml"),
+	}
+
+	data, err := os.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		p.changed = true
+		return p
+	} else if err != nil {
+		log.Printf("[WARN] Failed to read participation file %s: %v", p.path, err)
+		return p
+	}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		log.Printf("[WARN] Failed to parse participation file %s: %v", p.path, err)
+	}
+	if p.Signers == nil {
+		p.Signers = make(map[string]*SignerSeen)
+	}
+	return p
+}
+
+// Seen records an event from a followed signer if it is newer than the last
+// one seen. It is a no-op on a nil Participation.
+func (p *Participation) Seen(ev *nostr.Event, via string) {
+	if p == nil {
+		return
+	}
+	at := ev.CreatedAt.Time().UTC()
+	if s := p.Signers[ev.PubKey]; s != nil {
+		if last, err := time.Parse(time.RFC3339, s.LastSeen); err == nil && !at.After(last) {
+			return
+		}
+	}
+	p.Signers[ev.PubKey] = &SignerSeen{LastSeen: at.Format(time.RFC3339), Via: via}
+	p.changed = true
+}
+
+// LastSeen returns when the signer was last seen, or the time tracking began
+// if it never was, and whether it was seen
+func (p *Participation) LastSeen(pubkey string) (time.Time, bool) {
+	if s := p.Signers[pubkey]; s != nil {
+		if t, err := time.Parse(time.RFC3339, s.LastSeen); err == nil {
+			return t, true
+		}
+	}
+	since, _ := time.Parse(time.RFC3339, p.Since)
+	return since, false
+}
+
+// Silent returns the follows that published nothing for longer than after
+// while at least one other follow published within it; when every follow is
+// quiet there is simply nothing to sign
+func (p *Participation) Silent(follows []string, after time.Duration) []string {
+	var silent []string
+	active := false
+	for _, pk := range follows {
+		last, seen := p.LastSeen(pk)
+		if time.Since(last) > after {
+			silent = append(silent, pk)
+		} else if seen {
+			active = true
+		}
+	}
+	if !active {
+		return nil
+	}
+	return silent
+}
+
+// SaveIfChanged writes the participation file if a signer was seen since it
+// was loaded. It is a no-op on a nil Participation.
+func (p *Participation) SaveIfChanged() error {
+	if p == nil || !p.changed {
+		return nil
+	}
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(p.path, data, 0644); err != nil {
+		return err
+	}
+	p.changed = false
+	return nil
+}
+
+// isHeartbeat reports whether content is a heartbeat message
+func isHeartbeat(content string) bool {
+	var msg struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal([]byte(content), &msg) == nil && msg.Type == "heartbeat"
+}
+
+// SignersSilent alerts the operator about follows that published no signal
+// or heartbeat for longer than signer_silence_alert_after while others did,
+// and forgets the alerts of follows heard from again
+func (a *Alerter) SignersSilent(p *Participation, follows []string) {
+	if a == nil || p == nil {
+		return
+	}
+	silent := make(map[string]bool)
+	for _, pk := range p.Silent(follows, a.cfg.SignerSilenceAlertAfter) {
+		silent[pk] = true
+		npub, err := nip19.EncodePublicKey(pk)
+		if err != nil {
+			npub = pk
+		}
+		last, seen := p.LastSeen(pk)
+		text := fmt.Sprintf("signer %s has published nothing since %s while other signers are active", npub, last.Format(time.RFC3339))
+		if !seen {
+			text = fmt.Sprintf("signer %s has published nothing since tracking began at %s while other signers are active", npub, p.Since)
+		}
+		a.Send("silent:"+pk, Notification{Event: notifySignerSilent, Text: text})
+	}
+	a.silent = len(silent)
+	for _, pk := range follows {
+		if !silent[pk] {
+			a.Clear("silent:" + pk)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// errUnsupportedPlatform marks executor settings that cannot run on the
+// platform the manager runs on. The manager then observes signals without
+// executing actions instead of refusing to start.
+var errUnsupportedPlatform = fmt.Errorf("not supported on %s", runtime.GOOS)
+
+// checkPlatform checks that the local executor can run on this platform:
+// systemd exists only on Linux, and the steps the Docker backend and backups
+// run as POSIX sh scripts need an sh on PATH on Windows (e.g. from Git for
+// Windows). Fleet executors run on the remote hosts and are not checked.
+func checkPlatform(ex Executor, cfg ExecutorConfig) error {
+	switch ex.(type) {
+	case *SystemdExecutor:
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("systemd executor is %w", errUnsupportedPlatform)
+		}
+	case *DockerExecutor:
+		if err := requireShell("docker executor"); err != nil {
+			return err
+		}
+	}
+	if cfg.Backup.Enabled {
+		return requireShell("backup")
+	}
+	return nil
+}
+
+// requireShell fails on Windows unless sh is on PATH
+func requireShell(what string) error {
+	if runtime.GOOS != "windows" {
+		return nil
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		return fmt.Errorf("%s without sh on PATH is %w", what, errUnsupportedPlatform)
+	}
+	return nil
+}
+
+// isUnsupportedPlatform reports whether err means execution is impossible on
+// this platform rather than misconfigured
+func isUnsupportedPlatform(err error) bool {
+	return errors.Is(err, errUnsupportedPlatform)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// VersionPolicy bounds the versions the manager executes, whatever signers
+// announce. Candidates outside the policy are reported but never executed.
+type VersionPolicy struct {
+	AllowPrerelease *bool  `yaml:"allow_prerelease,omitempty"` // Accept pre-release versions such as v1.0.0-rc1 (default true)
+	MinVersion      string `yaml:"min_version,omitempty"`      // Lowest version to execute
+	MaxMajorJump    int    `yaml:"max_major_jump,omitempty"`   // Most major versions above the running node to move in one action (0 for unlimited)
+	Constraint      string `yaml:"constraint,omitempty"`       // Semver constraint versions must satisfy, e.g. ">=0.0.7 <2.0.0"
+}
+
+// Validate checks that the minimum version and constraint parse
+func (p VersionPolicy) Validate() error {
+	if p.MinVersion != "" {
+		if _, err := semver.NewVersion(p.MinVersion); err != nil {
+			return fmt.Errorf("invalid min_version %q: %v", p.MinVersion, err)
+		}
+	}
+	if p.Constraint != "" {
+		if _, err := semver.NewConstraint(p.Constraint); err != nil {
+			return fmt.Errorf("invalid constraint %q: %v", p.Constraint, err)
+		}
+	}
+	if p.MaxMajorJump < 0 {
+		return fmt.Errorf("max_major_jump must not be negative (got %d)", p.MaxMajorJump)
+	}
+	return nil
+}
+
+// Check returns why v falls outside the policy, or nil if it is allowed.
+// The major version jump is only checked when the running node version is
+// known.
+func (p VersionPolicy) Check(v, running *semver.Version) error {
+	if p.AllowPrerelease != nil && !*p.AllowPrerelease && v.Prerelease() != "" {
+		return fmt.Errorf("pre-release %s is not allowed", v.Original())
+	}
+	if p.MinVersion != "" {
+		if min, err := semver.NewVersion(p.MinVersion); err == nil && v.LessThan(min) {
+			return fmt.Errorf("%s is below min_version %s", v.Original(), p.MinVersion)
+		}
+	}
+	if p.MaxMajorJump > 0 && running != nil && v.Major() > running.Major()+uint64(p.MaxMajorJump) {
+		return fmt.Errorf("%s is more than %d major version(s) above the running %s", v.Original(), p.MaxMajorJump, running.Original())
+	}
+	if p.Constraint != "" {
+		if c, err := semver.NewConstraint(p.Constraint); err == nil && !c.Check(v) {
+			return fmt.Errorf("%s does not satisfy %q", v.Original(), p.Constraint)
+		}
+	}
+	return nil
+}
+
+// dropPolicyViolations removes candidates outside the version policy from
+// the evaluator, logging why each one is never executed. running is the
+// node version, or nil if unknown. The policy only governs the hyperqube
+// node, so actions of other services are kept.
+func dropPolicyViolations(e *signal.Evaluator, policy VersionPolicy, running *semver.Version, history signal.History) {
+	for key, a := range e.Actions {
+		if a.Version == nil || a.Service != "" || history.Has(key) {
+			continue
+		}
+		if err := policy.Check(a.Version, running); err != nil {
+			log.Printf("[WARN] Ignoring action %s outside version_policy: %v (votes %d/%d)", key, err, len(e.Votes[key]), e.Quorum())
+			e.Drop(key)
+		}
+	}
+}
+
+// SeverityPolicy lists the safeguards actions of one severity skip. Times
+// signed into the signal, notBefore and executeAt, failure backoff, and
+// operator approval are always honored.
+type SeverityPolicy struct {
+	SkipStagger           bool `yaml:"skip_stagger,omitempty"`            // Execute as soon as due instead of in this node's max_stagger slot
+	SkipCooldown          bool `yaml:"skip_cooldown,omitempty"`           // Execute without waiting out action_cooldown after the previous action
+	SkipMaintenanceWindow bool `yaml:"skip_maintenance_window,omitempty"` // Execute outside maintenance_window
+}
+
+// severityPolicy returns the safeguards actions of severity skip, none for
+// severities not configured
+func (c Config) severityPolicy(severity string) SeverityPolicy {
+	return c.SeverityPolicy[severity]
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// killProcessGroup is only implemented on Unix systems; elsewhere cancelling
+// the context kills the command itself
+func killProcessGroup(cmd *exec.Cmd) {}
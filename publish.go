@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// signEvent signs ev with the manager's private key
+func signEvent(kp Keypair, ev *nostr.Event) error {
+	_, priv, err := nip19.Decode(kp.Nsec)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %w", err)
+	}
+	return ev.Sign(priv.(string))
+}
+
+// publishToRelays publishes ev to every relay concurrently and returns a wait
+// function that blocks until all attempts finished, reporting how many relays
+// accepted the event. Each connection must open within connectTimeout.
+// Publishes are tracked by the shutdown handler so a shutdown waits for them
+// within the grace period.
+func publishToRelays(relays []string, ev nostr.Event, connectTimeout, timeout time.Duration, shutdown *ShutdownHandler) func() int {
+	wait := publishEvent(relays, ev, connectTimeout, timeout, shutdown)
+	return func() int { return len(wait()) }
+}
+
+// publishEvent works like publishToRelays, but its wait function returns the
+// URLs of the relays that accepted the event
+func publishEvent(relays []string, ev nostr.Event, connectTimeout, timeout time.Duration, shutdown *ShutdownHandler) func() []string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	conns := newRelayConnections()
+	var publishes sync.WaitGroup
+	var mu sync.Mutex
+	var accepted []string
+	for _, r := range relays {
+		publishes.Add(1)
+		publishDone := shutdown.Track("publish to " + r)
+		go func(url string) {
+			defer publishes.Done()
+			defer publishDone()
+			log.Printf("[INFO] Publishing to relay %s", url)
+			connectCtx, cancelConnect := context.WithTimeout(ctx, connectTimeout)
+			relay, err := conns.Connect(connectCtx, url)
+			cancelConnect()
+			if err != nil {
+				noisyLog.Printf(noiseRelay, "publish:"+url, "[WARN] Relay publish error (%s): %v", url, err)
+				return
+			}
+			defer conns.Close(url)
+			if err := relay.Publish(ctx, ev); err != nil {
+				noisyLog.Printf(noiseRelay, "publish:"+url, "[WARN] Relay publish error (%s): %v", url, err)
+				return
+			}
+			mu.Lock()
+			accepted = append(accepted, url)
+			mu.Unlock()
+		}(r)
+	}
+
+	return func() []string {
+		publishes.Wait()
+		conns.CloseAll()
+		cancel()
+		return accepted
+	}
+}
+
+// confirmationEvents builds and signs the events replying to votes with the
+// content built by build. Signers who voted publicly get a public event
+// threaded to their signals; encrypted follows get the same content as a
+// gift-wrapped DM so private fleets leave no public trace, and this manager
+// a copy of each. The public event is nil if every vote was encrypted.
+func confirmationEvents(cfg Config, kp Keypair, votes map[string]signal.Vote, build func(map[string]signal.Vote) (nostr.Event, error)) (*nostr.Event, []nostr.Event, error) {
+	public, private := splitVotes(votes, encryptedFollows(cfg.Follows))
+
+	var publicEv *nostr.Event
+	if len(public) > 0 || len(private) == 0 {
+		ev, err := build(public)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := signEvent(kp, &ev); err != nil {
+			return nil, nil, err
+		}
+		publicEv = &ev
+	}
+
+	var dms []nostr.Event
+	self, err := kp.publicKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	for pk, v := range private {
+		ev, err := build(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		rumor, err := newDirectMessage(kp, pk, ev.Content, ev.CreatedAt)
+		if err != nil {
+			return nil, nil, err
+		}
+		rumor.Tags = append(rumor.Tags, nostr.Tag{"e", v.EventID, v.Relay, "reply"})
+		// A copy wrapped for this manager lets it recover the confirmation
+		// after losing its state, as it can from public done events
+		for _, to := range []string{pk, self} {
+			dm, err := wrapDM(kp, rumor, to)
+			if err != nil {
+				log.Printf("[WARN] Failed to encrypt confirmation for %s: %v", pk, err)
+				break
+			}
+			dms = append(dms, dm)
+		}
+	}
+	return publicEv, dms, nil
+}
+
+// publishConfirmation publishes the confirmation events for votes built by
+// build once, without queueing them for retries. The returned wait function
+// reports how many relays accepted the public event.
+func publishConfirmation(cfg Config, kp Keypair, votes map[string]signal.Vote, build func(map[string]signal.Vote) (nostr.Event, error), shutdown *ShutdownHandler) (func() int, error) {
+	public, dms, err := confirmationEvents(cfg, kp, votes, build)
+	if err != nil {
+		return nil, err
+	}
+
+	var waits []func() int
+	if public != nil {
+		waits = append(waits, publishToRelays(cfg.writeRelays(), *public, cfg.ConnectTimeout, cfg.ShutdownGracePeriod, shutdown))
+	}
+	if len(dms) > 0 {
+		log.Printf("[INFO] Sending %d encrypted confirmation(s)", len(dms))
+	}
+	var dmWaits []func() int
+	for _, dm := range dms {
+		dmWaits = append(dmWaits, publishToRelays(cfg.writeRelays(), dm, cfg.ConnectTimeout, cfg.ShutdownGracePeriod, shutdown))
+	}
+
+	return func() int {
+		for _, w := range dmWaits {
+			if w() == 0 {
+				log.Println("[WARN] An encrypted confirmation was not accepted by any relay")
+			}
+		}
+		accepted := 0
+		for _, w := range waits {
+			accepted += w()
+		}
+		return accepted
+	}, nil
+}
+
+// completeAction publishes the done event for an executed action, unless
+// publish_done is off, and records the action in history
+func completeAction(cfg Config, kp Keypair, history *History, action *signal.Action, votes map[string]signal.Vote, shutdown *ShutdownHandler, verbose bool) error {
+	if cfg.PublishDone != nil && !*cfg.PublishDone {
+		log.Printf("[INFO] publish_done is off - not publishing a done event for %s", action.Key)
+		saveCompleted(history, action.Key, votes)
+		return nil
+	}
+
+	// The height the node reached lets coordinators verify it resumed syncing
+	var height nostr.Tag
+	if cfg.Telemetry.Enabled {
+		t := sampleTelemetry(cfg.Node, cfg.Telemetry, verbose)
+		log.Printf("[INFO] Node telemetry after %s: %s", action.Key, t.summary())
+		height = heightTag(t)
+	}
+
+	public, dms, err := confirmationEvents(cfg, kp, votes, func(v map[string]signal.Vote) (nostr.Event, error) {
+		ev, err := newDoneEvent(action, v)
+		if err != nil {
+			return ev, err
+		}
+		ev.Tags = append(ev.Tags, quorumTag(len(votes), cfg.Quorum))
+		if height != nil {
+			ev.Tags = append(ev.Tags, height)
+		}
+		return ev, cfg.DoneTemplate.apply(&ev)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build done event: %w", err)
+	}
+
+	// Done events are queued before anything else so a confirmation that no
+	// relay accepts is retried on later runs instead of being lost
+	outbox := loadOutbox(cfg.StatePath)
+	if public != nil {
+		outbox.Add(*public, "done event for "+action.Key)
+	}
+	for _, dm := range dms {
+		outbox.Add(dm, "encrypted done event for "+action.Key)
+	}
+	if err := outbox.Save(); err != nil {
+		log.Printf("[WARN] Error saving outbox: %v", err)
+	}
+
+	saveCompleted(history, action.Key, votes)
+
+	log.Printf("[INFO] Publishing done event for action %s to %d relays", action.Key, len(cfg.writeRelays()))
+	outbox.Flush(cfg, shutdown)
+	return nil
+}
+
+// reportFailure publishes the failure event for an action whose execution or
+// verification failed, unless publish_done is off. It is built like the done
+// event, done_template included, but not queued for retries: a later attempt
+// reports its own outcome.
+func reportFailure(cfg Config, kp Keypair, action *signal.Action, cause error, votes map[string]signal.Vote, shutdown *ShutdownHandler) {
+	if cfg.PublishDone != nil && !*cfg.PublishDone {
+		return
+	}
+	wait, err := publishConfirmation(cfg, kp, votes, func(v map[string]signal.Vote) (nostr.Event, error) {
+		ev, err := newFailureEvent(action, cause, v)
+		if err != nil {
+			return ev, err
+		}
+		return ev, cfg.DoneTemplate.apply(&ev)
+	}, shutdown)
+	if err != nil {
+		log.Printf("[WARN] Failed to build failure event: %v", err)
+		return
+	}
+	accepted := wait()
+	log.Printf("[INFO] Failure event for %s accepted by %d/%d relays", action.Key, accepted, len(cfg.writeRelays()))
+}
+
+// saveCompleted records a performed action in history with the votes that
+// carried it to quorum
+func saveCompleted(history *History, key string, votes map[string]signal.Vote) {
+	history.Add(key, votes)
+	if err := history.Save(); err != nil {
+		log.Printf("[WARN] Error saving history: %v", err)
+	} else {
+		log.Printf("[INFO] Action %s saved to history", key)
+	}
+}
+
+// announceSchedule publishes an "executing at" status event so coordinators
+// can see when this node will perform the action, or a "scheduled" one for
+// actions announced with a network-wide executeAt
+func announceSchedule(cfg Config, kp Keypair, action *signal.Action, executeAt time.Time, votes map[string]signal.Vote, shutdown *ShutdownHandler) {
+	log.Printf("[INFO] Announcing execution of %s at %s", action.Key, executeAt.UTC().Format(time.RFC3339))
+	wait, err := publishConfirmation(cfg, kp, votes, func(v map[string]signal.Vote) (nostr.Event, error) {
+		return newStatusEvent(action, scheduleStatus(action), executeAt, v)
+	}, shutdown)
+	if err != nil {
+		log.Printf("[WARN] Failed to build status event: %v", err)
+		return
+	}
+	accepted := wait()
+	log.Printf("[INFO] Status event for %s accepted by %d/%d relays", action.Key, accepted, len(cfg.writeRelays()))
+}
+
+// acknowledgeAction publishes a pending event announcing that the action
+// reached quorum on this node and when it is expected to run. It returns
+// whether any relay accepted it.
+func acknowledgeAction(cfg Config, kp Keypair, action *signal.Action, executeAt time.Time, votes map[string]signal.Vote, shutdown *ShutdownHandler) bool {
+	log.Printf("[INFO] Acknowledging action %s", action.Key)
+	wait, err := publishConfirmation(cfg, kp, votes, func(v map[string]signal.Vote) (nostr.Event, error) {
+		return newPendingEvent(action, executeAt, v)
+	}, shutdown)
+	if err != nil {
+		log.Printf("[WARN] Failed to build pending event: %v", err)
+		return false
+	}
+	accepted := wait()
+	log.Printf("[INFO] Pending event for %s accepted by %d/%d relays", action.Key, accepted, len(cfg.writeRelays()))
+	return accepted > 0
+}
+
+// scheduleStatus returns the status announced for a scheduled action
+func scheduleStatus(action *signal.Action) string {
+	if !action.ExecuteAt.IsZero() {
+		return "scheduled"
+	}
+	return "executing"
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// quorumTag returns the tag of a done event recording how many votes the
+// action was performed on and the quorum it needed, e.g. ["quorum", "3", "2"].
+// With the "e" and "p" tags of the votes it makes the event a quorum proof:
+// anyone can fetch the signals and count their signers.
+func quorumTag(votes, quorum int) nostr.Tag {
+	return nostr.Tag{"quorum", strconv.Itoa(votes), strconv.Itoa(quorum)}
+}
+
+// parseQuorumTag returns the vote count and quorum recorded in ev
+func parseQuorumTag(ev *nostr.Event) (int, int, bool) {
+	tag := ev.Tags.GetFirst([]string{"quorum", ""})
+	if tag == nil || len(*tag) < 3 {
+		return 0, 0, false
+	}
+	votes, err1 := strconv.Atoi((*tag)[1])
+	quorum, err2 := strconv.Atoi((*tag)[2])
+	return votes, quorum, err1 == nil && err2 == nil
+}
+
+// isDoneEvent reports whether ev is a done event published by a manager
+func isDoneEvent(ev *nostr.Event) bool {
+	var msg struct {
+		ExtraData string `json:"extraData"`
+	}
+	return json.Unmarshal([]byte(ev.Content), &msg) == nil && msg.ExtraData == "done"
+}
+
+// fetchEvents queries relays for the events with ids, trying each relay
+// until all are found, and returns those found by ID
+func fetchEvents(ids, relays []string, timeout time.Duration) map[string]*nostr.Event {
+	found := make(map[string]*nostr.Event)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, url := range relays {
+		missing := slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return found[id] != nil })
+		if len(missing) == 0 {
+			break
+		}
+		relay, err := nostr.RelayConnect(ctx, url)
+		if err != nil {
+			log.Printf("[WARN] Could not connect to relay %s: %v", url, err)
+			continue
+		}
+		events, err := relay.QuerySync(ctx, nostr.Filter{IDs: missing})
+		relay.Close()
+		if err != nil {
+			log.Printf("[WARN] Query failed on relay %s: %v", url, err)
+			continue
+		}
+		for _, ev := range events {
+			if slices.Contains(missing, ev.ID) {
+				found[ev.ID] = ev
+			}
+		}
+	}
+	return found
+}
+
+// matchDoneSignal returns the key of the action a done event with content
+// done completed and the key the signal with content voted votes for. Fields
+// a done template omitted are taken from the signal, and a signal naming no
+// network is keyed under the done event's network, as the node's evaluator
+// keyed it; the action was voted for if both keys are equal.
+func matchDoneSignal(done, voted string) (string, string, error) {
+	var doneFields, votedFields map[string]any
+	if err := json.Unmarshal([]byte(done), &doneFields); err != nil {
+		return "", "", err
+	}
+	if err := json.Unmarshal([]byte(voted), &votedFields); err != nil {
+		return "", "", err
+	}
+	for f, v := range votedFields {
+		if _, ok := doneFields[f]; !ok {
+			doneFields[f] = v
+		}
+	}
+	merged, err := json.Marshal(doneFields)
+	if err != nil {
+		return "", "", err
+	}
+	completed, err := signal.Parse(string(merged))
+	if err != nil {
+		return "", "", err
+	}
+	action, err := signal.Parse(voted)
+	if err != nil {
+		return "", "", err
+	}
+	key := action.Key
+	if action.Network == "" && completed.Network != "" && action.Type != signal.TypeRevokeKey {
+		key = signal.NetworkKey(completed.Network, key)
+	}
+	return completed.Key, key, nil
+}
+
+// reportDoneChecks verifies the quorum proof of a done event: it fetches
+// every signal the event replies to, checks each is validly signed by the
+// signer it names, directly or by co-signature, and votes for the action
+// performed, and counts the distinct signers against the recorded quorum and
+// against this config's follows and quorum. It returns true if both quorums
+// are met.
+func reportDoneChecks(ev *nostr.Event, cfg Config, extraRelay string, timeout time.Duration) bool {
+	ok := true
+	check := func(passed bool, format string, args ...any) {
+		mark := "PASS"
+		if !passed {
+			mark = "FAIL"
+			ok = false
+		}
+		fmt.Printf("[%s] %s\n", mark, fmt.Sprintf(format, args...))
+	}
+
+	fmt.Printf("Event:   %s (done event)\n", ev.ID)
+	if npub, err := nip19.EncodePublicKey(ev.PubKey); err == nil {
+		fmt.Printf("Node:    %s\n", npub)
+	}
+	fmt.Printf("Created: %s\n", ev.CreatedAt.Time().UTC().Format(time.RFC3339))
+
+	check(ev.CheckID(), "event id matches content hash")
+	sigOK, err := ev.CheckSignature()
+	check(err == nil && sigOK, "signature valid")
+
+	// A done template may omit fields the action key needs; they are
+	// completed from each signal below
+	if action, err := signal.Parse(ev.Content); err == nil {
+		fmt.Printf("Action:  %s\n", action.Key)
+	} else {
+		fmt.Printf("Action:  incomplete (%v), completed from the signals\n", err)
+	}
+
+	votes, quorum, tagged := parseQuorumTag(ev)
+	check(tagged, "event records its vote count and quorum")
+	if tagged {
+		fmt.Printf("Claimed: %d vote(s), quorum %d\n", votes, quorum)
+	}
+
+	// The signals are the "e" tags, each naming the signer it counts for: a
+	// co-signed signal is replied to once per co-signer. Relay hints are
+	// tried first.
+	type reply struct{ id, signer string }
+	var replies []reply
+	var ids, relays []string
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != "e" {
+			continue
+		}
+		r := reply{id: tag[1]}
+		if len(tag) > 4 {
+			r.signer = tag[4]
+		}
+		replies = append(replies, r)
+		if !slices.Contains(ids, tag[1]) {
+			ids = append(ids, tag[1])
+		}
+		if len(tag) > 2 && tag[2] != "" && !slices.Contains(relays, tag[2]) {
+			relays = append(relays, tag[2])
+		}
+	}
+	if extraRelay != "" && !slices.Contains(relays, extraRelay) {
+		relays = append(relays, extraRelay)
+	}
+	for _, r := range cfg.readRelays() {
+		if !slices.Contains(relays, r) {
+			relays = append(relays, r)
+		}
+	}
+	if len(replies) == 0 {
+		check(false, "event replies to the signals it acted on (none with done_template.omit_signal_tags)")
+		return false
+	}
+	fetched := fetchEvents(ids, relays, timeout)
+
+	follows := decodeFollows(cfg.Follows)
+	proven := make(map[string]bool)
+	trusted := make(map[string]bool)
+	for _, r := range replies {
+		sig := fetched[r.id]
+		if sig == nil {
+			check(false, "signal %s found on a relay", r.id)
+			continue
+		}
+		valid, err := sig.CheckSignature()
+		if !sig.CheckID() || err != nil || !valid {
+			check(false, "signal %s is validly signed", r.id)
+			continue
+		}
+		signer := r.signer
+		if signer == "" {
+			signer = sig.PubKey
+		}
+		if signers, _ := signal.Cosigners(sig); !slices.Contains(signers, signer) {
+			check(false, "signal %s is signed by the signer its tag names", r.id)
+			continue
+		}
+		doneKey, votedKey, err := matchDoneSignal(ev.Content, sig.Content)
+		if err != nil {
+			check(false, "signal %s votes for the action performed: %v", r.id, err)
+			continue
+		}
+		if doneKey != votedKey {
+			check(false, "signal %s votes for %s, not %s", r.id, votedKey, doneKey)
+			continue
+		}
+		npub, _ := nip19.EncodePublicKey(signer)
+		check(true, "signal %s by %s votes for %s", r.id, npub, doneKey)
+		proven[signer] = true
+		if slices.Contains(follows, signer) {
+			trusted[signer] = true
+		}
+	}
+
+	if tagged {
+		check(len(proven) >= quorum, "%d distinct signer(s) proven of the recorded quorum %d", len(proven), quorum)
+		if hidden := votes - len(replies); hidden > 0 {
+			fmt.Printf("Note:    %d vote(s) were encrypted and cannot be verified publicly\n", hidden)
+		}
+	}
+	check(len(trusted) >= cfg.Quorum, "%d proven signer(s) are in this config's follows, quorum %d", len(trusted), cfg.Quorum)
+	return ok
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// recoverHistory queries the relays for the done events this manager
+// published and records the actions they confirm that history is missing.
+// Every done event is signed by this manager's key, so they survive a wiped
+// state dir as long as the key is restored. It returns the number of entries
+// recovered.
+func recoverHistory(cfg Config, kp Keypair, h *History) int {
+	pk, err := kp.publicKey()
+	if err != nil {
+		log.Printf("[WARN] Cannot recover history: %v", err)
+		return 0
+	}
+	// Encrypted confirmations are found through the copies gift-wrapped for
+	// this manager; kind 4 DMs are done events of earlier versions
+	filters := nostr.Filters{
+		{Authors: []string{pk}, Kinds: []int{nostr.KindTextNote, nostr.KindEncryptedDirectMessage}},
+		{Kinds: []int{nostr.KindGiftWrap}, Tags: nostr.TagMap{"p": []string{pk}}},
+	}
+
+	answered := 0
+	recovered := 0
+	// Done events were published to the write relays, but read relays may
+	// carry them too
+	relays := cfg.relayURLs()
+	for _, url := range relays {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+		relay, err := nostr.RelayConnect(ctx, url)
+		if err != nil {
+			cancel()
+			log.Printf("[WARN] Could not connect to relay %s to recover history: %v", url, err)
+			continue
+		}
+		var events []*nostr.Event
+		for _, filter := range filters {
+			var page []*nostr.Event
+			if page, err = queryAllPages(ctx, relay, filter); err != nil {
+				break
+			}
+			events = append(events, page...)
+		}
+		relay.Close()
+		cancel()
+		if err != nil {
+			log.Printf("[WARN] History query failed on relay %s: %v", url, err)
+			continue
+		}
+		answered++
+
+		for _, ev := range events {
+			key, at, ok := doneKey(ev, kp, pk)
+			if !ok {
+				continue
+			}
+			if h.Recover(key, at.Time()) {
+				recovered++
+			}
+		}
+	}
+
+	if answered == 0 && len(relays) > 0 {
+		log.Println("[WARN] No relay answered - history could not be recovered from done events")
+	} else {
+		log.Printf("[INFO] Recovered %d history entries from done events on %d/%d relays", recovered, answered, len(relays))
+	}
+	return recovered
+}
+
+// recoveryPageSize is the number of events asked for per history query
+const recoveryPageSize = 500
+
+// queryAllPages returns every event on relay matching filter. Relays cap how
+// many events one query returns, so queries page back in time with Until
+// until a page brings no event not seen before.
+func queryAllPages(ctx context.Context, relay *nostr.Relay, filter nostr.Filter) ([]*nostr.Event, error) {
+	filter.Limit = recoveryPageSize
+	seen := make(map[string]bool)
+	var all []*nostr.Event
+	for {
+		events, err := relay.QuerySync(ctx, filter)
+		if err != nil {
+			return all, err
+		}
+		var oldest nostr.Timestamp
+		added := 0
+		for _, ev := range events {
+			if seen[ev.ID] {
+				continue
+			}
+			seen[ev.ID] = true
+			all = append(all, ev)
+			added++
+			if oldest == 0 || ev.CreatedAt < oldest {
+				oldest = ev.CreatedAt
+			}
+		}
+		if added == 0 {
+			return all, nil
+		}
+		// The oldest second is asked for again, as more events may share it
+		filter.Until = &oldest
+	}
+}
+
+// doneKey returns the key of the action a done event published by this
+// manager (pk) confirms, and when it was created. Gift-wrapped copies are
+// opened and must hold a message from this manager, whose time replaces the
+// random one of the gift wrap; kind 4 done events are decrypted with the key
+// shared with their recipient.
+func doneKey(ev *nostr.Event, kp Keypair, pk string) (string, nostr.Timestamp, bool) {
+	content, at := ev.Content, ev.CreatedAt
+	switch ev.Kind {
+	case nostr.KindGiftWrap:
+		dm, err := unwrapDM(ev, kp)
+		if err != nil || dm.PubKey != pk {
+			return "", 0, false
+		}
+		content, at = dm.Content, dm.CreatedAt
+	case nostr.KindEncryptedDirectMessage:
+		var err error
+		if content, err = decryptSentDM(ev, kp); err != nil {
+			return "", 0, false
+		}
+	}
+
+	var msg struct {
+		ExtraData string `json:"extraData"`
+	}
+	if json.Unmarshal([]byte(content), &msg) != nil || msg.ExtraData != "done" {
+		return "", 0, false
+	}
+	action, err := signal.Parse(content)
+	if err != nil {
+		return "", 0, false
+	}
+	return action.Key, at, true
+}
+
+// decryptSentDM decrypts a direct message the keypair sent, using the key it
+// shares with the recipient named in the p tag
+func decryptSentDM(ev *nostr.Event, kp Keypair) (string, error) {
+	sk, err := kp.secretKey()
+	if err != nil {
+		return "", err
+	}
+	tag := ev.Tags.Find("p")
+	if tag == nil {
+		return "", errors.New("direct message names no recipient")
+	}
+	recipient := tag[1]
+
+	if strings.Contains(ev.Content, "?iv=") {
+		shared, err := nip04.ComputeSharedSecret(recipient, sk)
+		if err != nil {
+			return "", err
+		}
+		return nip04.Decrypt(ev.Content, shared)
+	}
+
+	key, err := nip44.GenerateConversationKey(recipient, sk)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Decrypt(ev.Content, key)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of relay discovery
+const (
+	defaultDiscoveryMaxRelays       = 5
+	defaultDiscoveryRefreshInterval = 6 * time.Hour
+)
+
+// RelayDiscoveryConfig configures how relays beyond the configured ones are
+// found: the configured read relays bootstrap a query for the NIP-65 relay
+// lists (kind 10002) of the follows, or of a single coordinator key. In YAML
+// it is either a bare boolean or a mapping with the options.
+type RelayDiscoveryConfig struct {
+	Enabled         bool          `yaml:"enabled"`                    // Merge discovered relays with the configured ones
+	Coordinator     string        `yaml:"coordinator,omitempty"`      // npub whose relay list is used instead of the follows' lists
+	MaxRelays       int           `yaml:"max_relays,omitempty"`       // Most discovered relays used besides the configured ones (default 5)
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"` // How often the relay lists are fetched again (default 6h)
+}
+
+// UnmarshalYAML accepts both the bare boolean form and the mapping form
+func (c *RelayDiscoveryConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&c.Enabled)
+	}
+	type plain RelayDiscoveryConfig
+	return node.Decode((*plain)(c))
+}
+
+// applyDefaults fills in unset discovery settings
+func (c *RelayDiscoveryConfig) applyDefaults() {
+	if c.MaxRelays == 0 {
+		c.MaxRelays = defaultDiscoveryMaxRelays
+	}
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = defaultDiscoveryRefreshInterval
+	}
+}
+
+// Validate checks the discovery settings
+func (c RelayDiscoveryConfig) Validate() error {
+	if c.MaxRelays < 0 {
+		return fmt.Errorf("max_relays must not be negative (got %d)", c.MaxRelays)
+	}
+	if c.Coordinator != "" {
+		if kind, _, err := nip19.Decode(c.Coordinator); err != nil || kind != "npub" {
+			return fmt.Errorf("invalid coordinator npub %q", c.Coordinator)
+		}
+	}
+	return nil
+}
+
+// DiscoveredRelays records the relays last discovered, so a restart or a
+// run that cannot reach the bootstrap relays keeps using them
+type DiscoveredRelays struct {
+	Relays    []Relay  `json:"relays"`     // Discovered relays, most recommended first
+	Source    string   `json:"source"`     // "follows" or the coordinator npub
+	EventIDs  []string `json:"event_ids"`  // Relay list events the relays were taken from
+	FetchedAt string   `json:"fetched_at"` // RFC3339 time the relay lists were fetched
+}
+
+// stale reports whether the relay lists should be fetched again
+func (d *DiscoveredRelays) stale(cfg RelayDiscoveryConfig, now time.Time) bool {
+	fetched, err := time.Parse(time.RFC3339, d.FetchedAt)
+	return err != nil || d.Source != discoverySource(cfg) || now.Sub(fetched) >= cfg.RefreshInterval
+}
+
+// discoveredRelaysPath returns where the discovered relays are recorded
+func discoveredRelaysPath(stateDir string) string {
+	return filepath.Join(stateDir, "discovered_relays.json")
+}
+
+// loadDiscoveredRelays reads the relays last discovered, nil if none were
+// recorded or the record is unreadable
+func loadDiscoveredRelays(stateDir string) *DiscoveredRelays {
+	data, err := os.ReadFile(discoveredRelaysPath(stateDir))
+	if err != nil {
+		return nil
+	}
+	var d DiscoveredRelays
+	if err := json.Unmarshal(data, &d); err != nil {
+		log.Printf("[WARN] Ignoring unreadable %s: %v", discoveredRelaysPath(stateDir), err)
+		return nil
+	}
+	return &d
+}
+
+// save records the discovered relays in the state directory
+func (d *DiscoveredRelays) save(stateDir string) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(discoveredRelaysPath(stateDir), data, 0644)
+}
+
+// discoverySource names whose relay lists are used
+func discoverySource(cfg RelayDiscoveryConfig) string {
+	if cfg.Coordinator != "" {
+		return cfg.Coordinator
+	}
+	return "follows"
+}
+
+// discoveryAuthors returns the hex keys whose relay lists are used
+func discoveryAuthors(cfg Config) []string {
+	if cfg.RelayDiscovery.Coordinator == "" {
+		return decodeFollows(cfg.Follows)
+	}
+	_, pk, err := nip19.Decode(cfg.RelayDiscovery.Coordinator)
+	if err != nil {
+		return nil
+	}
+	return []string{pk.(string)}
+}
+
+// discoverRelays queries the configured read relays for the newest relay
+// list of every author and ranks the relays they name by how many authors
+// recommend them. Following NIP-65, a relay an author writes to is read
+// from, and a relay an author reads from is published to. Relays already
+// configured are left out.
+func discoverRelays(cfg Config) (*DiscoveredRelays, error) {
+	authors := discoveryAuthors(cfg)
+	if len(authors) == 0 {
+		return nil, fmt.Errorf("no valid keys to discover relays from")
+	}
+	static := cfg
+	static.Discovered = nil
+	bootstrap := static.readRelays()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.TotalTimeout)
+	defer cancel()
+
+	// Relay lists are replaceable: only the newest one of each author counts
+	newest := make(map[string]*nostr.Event)
+	reached := 0
+	for _, u := range bootstrap {
+		connectCtx, cancelConnect := context.WithTimeout(ctx, cfg.ConnectTimeout)
+		relay, err := nostr.RelayConnect(connectCtx, u)
+		cancelConnect()
+		if err != nil {
+			noisyLog.Printf(noiseRelay, u, "[WARN] Could not connect to relay %s: %v", u, err)
+			continue
+		}
+		queryCtx, cancelQuery := context.WithTimeout(ctx, cfg.ReadTimeout)
+		events, err := relay.QuerySync(queryCtx, nostr.Filter{Kinds: []int{nostr.KindRelayListMetadata}, Authors: authors})
+		cancelQuery()
+		relay.Close()
+		if err != nil {
+			noisyLog.Printf(noiseRelay, u, "[WARN] Relay list query failed on relay %s: %v", u, err)
+			continue
+		}
+		reached++
+		for _, ev := range events {
+			if !slices.Contains(authors, ev.PubKey) || ev.Kind != nostr.KindRelayListMetadata {
+				continue
+			}
+			if ok, err := ev.CheckSignature(); err != nil || !ok {
+				continue
+			}
+			if cur := newest[ev.PubKey]; cur == nil || ev.CreatedAt > cur.CreatedAt {
+				newest[ev.PubKey] = ev
+			}
+		}
+	}
+	if reached == 0 {
+		return nil, fmt.Errorf("no bootstrap relay could be queried (%d tried)", len(bootstrap))
+	}
+
+	type candidate struct {
+		relay      Relay
+		reads      bool
+		writes     bool
+		recommends int
+	}
+	var candidates []*candidate
+	d := &DiscoveredRelays{Source: discoverySource(cfg.RelayDiscovery), FetchedAt: time.Now().UTC().Format(time.RFC3339)}
+	for _, pk := range authors {
+		ev := newest[pk]
+		if ev == nil {
+			continue
+		}
+		d.EventIDs = append(d.EventIDs, ev.ID)
+		counted := make(map[string]bool) // an author recommends each relay once
+		for _, tag := range ev.Tags {
+			if len(tag) < 2 || tag[0] != "r" || !validDiscoveredURL(tag[1]) || static.configuredRelay(tag[1]) {
+				continue
+			}
+			u := nostr.NormalizeURL(tag[1])
+			i := slices.IndexFunc(candidates, func(c *candidate) bool { return c.relay.URL == u })
+			if i < 0 {
+				candidates = append(candidates, &candidate{relay: Relay{URL: u}})
+				i = len(candidates) - 1
+			}
+			c := candidates[i]
+			if !counted[u] {
+				counted[u] = true
+				c.recommends++
+			}
+			marker := ""
+			if len(tag) > 2 {
+				marker = tag[2]
+			}
+			c.reads = c.reads || marker != "read"
+			c.writes = c.writes || marker != "write"
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b *candidate) int { return b.recommends - a.recommends })
+
+	for _, c := range candidates[:min(len(candidates), cfg.RelayDiscovery.MaxRelays)] {
+		switch {
+		case !c.writes:
+			c.relay.Role = relayRead
+		case !c.reads:
+			c.relay.Role = relayWrite
+		}
+		d.Relays = append(d.Relays, c.relay)
+	}
+	return d, nil
+}
+
+// validDiscoveredURL reports whether a relay list names a usable relay
+func validDiscoveredURL(raw string) bool {
+	u, err := url.ParseRequestURI(raw)
+	return err == nil && (u.Scheme == "wss" || u.Scheme == "ws") && u.Host != ""
+}
+
+// refreshRelays merges the discovered relays into the running config,
+// fetching the relay lists again once refresh_interval has passed. If they
+// cannot be fetched, the relays last discovered stay in use.
+func (m *Manager) refreshRelays() {
+	cfg := m.config.RelayDiscovery
+	if !cfg.Enabled {
+		m.config.Discovered = nil
+		return
+	}
+
+	d := loadDiscoveredRelays(m.config.StatePath)
+	if (d == nil || d.stale(cfg, time.Now())) && m.signalsFile == nil {
+		fetched, err := discoverRelays(m.config)
+		if err != nil {
+			noisyLog.Printf(noiseRelay, "discovery", "[WARN] Relay discovery failed, keeping the relays last discovered: %v", err)
+		} else {
+			log.Printf("[INFO] Discovered %d relay(s) from %d relay list(s) of %s", len(fetched.Relays), len(fetched.EventIDs), fetched.Source)
+			d = fetched
+			if !m.dryRun {
+				if err := d.save(m.config.StatePath); err != nil {
+					log.Printf("[WARN] Failed to record discovered relays: %v", err)
+				}
+			}
+		}
+	}
+	if d == nil {
+		m.config.Discovered = nil
+		return
+	}
+
+	// Relays added to the config since, and a lowered max_relays, apply to
+	// the recorded set as well
+	var relays []Relay
+	for _, r := range d.Relays {
+		if len(relays) < cfg.MaxRelays && !m.config.configuredRelay(r.URL) {
+			relays = append(relays, r)
+		}
+	}
+	if changes := describeRelayChanges(m.config.Discovered, relays); len(changes) > 0 {
+		for _, c := range changes {
+			log.Printf("[INFO] Relay discovery: %s", c)
+		}
+	}
+	m.config.Discovered = relays
+}
+
+// discoverRelaysCLI fetches the relay lists now and prints the relays that
+// would be used besides the configured ones, without recording them. It
+// returns false if no bootstrap relay could be queried.
+func discoverRelaysCLI(configDir, stateDir, output string, max int) bool {
+	cfg := loadConfig(configDir, stateDir)
+	if max > 0 {
+		cfg.RelayDiscovery.MaxRelays = max
+	}
+	d, err := discoverRelays(cfg)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		return false
+	}
+
+	if output == outputJSON {
+		printJSON(d)
+		return true
+	}
+	fmt.Printf("Relay lists of %s: %d found\n", d.Source, len(d.EventIDs))
+	if len(d.Relays) == 0 {
+		fmt.Println("No relays discovered beyond the configured ones")
+	}
+	for _, r := range d.Relays {
+		role := r.Role
+		if role == "" {
+			role = relayBoth
+		}
+		fmt.Printf("  %s (%s)\n", r.URL, role)
+	}
+	if !cfg.RelayDiscovery.Enabled {
+		fmt.Println("relay_discovery is off; these relays are not used")
+	}
+	return true
+}
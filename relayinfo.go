@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How long a relay's NIP-11 document is trusted before it is fetched again,
+// and how long to wait before retrying a relay that did not serve one
+const (
+	relayInfoTTL   = 24 * time.Hour
+	relayInfoRetry = time.Hour
+)
+
+// RelayLimits are the NIP-11 limitations that matter to the manager
+type RelayLimits struct {
+	MaxSubscriptions int  `json:"max_subscriptions,omitempty"`  // Open subscriptions per connection
+	MaxFilters       int  `json:"max_filters,omitempty"`        // Filters per subscription
+	MaxLimit         int  `json:"max_limit,omitempty"`          // Events returned per filter
+	MinPowDifficulty int  `json:"min_pow_difficulty,omitempty"` // Proof of work required on published events
+	AuthRequired     bool `json:"auth_required,omitempty"`      // NIP-42 authentication required
+	PaymentRequired  bool `json:"payment_required,omitempty"`   // Only paying keys may publish
+	RestrictedWrites bool `json:"restricted_writes,omitempty"`  // Only some keys or events may be published
+}
+
+// RelayCapabilities is what a relay announced in its NIP-11 document, and
+// where that conflicts with how the manager uses it
+type RelayCapabilities struct {
+	Name      string      `json:"name,omitempty"`     // Relay name
+	Software  string      `json:"software,omitempty"` // Software and version
+	Limits    RelayLimits `json:"limitation"`         // Announced limitations
+	FetchedAt string      `json:"fetched_at"`         // RFC3339 time the document was fetched or the fetch failed
+	Error     string      `json:"error,omitempty"`    // Why the document could not be fetched
+	Warnings  []string    `json:"warnings,omitempty"` // Limitations conflicting with the manager's needs
+}
+
+// stale reports whether the document should be fetched again
+func (c *RelayCapabilities) stale(now time.Time) bool {
+	fetched, err := time.Parse(time.RFC3339, c.FetchedAt)
+	if err != nil {
+		return true
+	}
+	if c.Error != "" {
+		return now.Sub(fetched) > relayInfoRetry
+	}
+	return now.Sub(fetched) > relayInfoTTL
+}
+
+// RelayInfo caches the capabilities of the configured relays in the state
+// dir, so their NIP-11 documents are fetched about once a day
+type RelayInfo struct {
+	Relays  map[string]*RelayCapabilities `json:"relays"` // Relay URL -> capabilities
+	path    string                        // relay info file path (not in JSON)
+	verbose bool                          // Log relays without a NIP-11 document
+}
+
+// loadRelayInfo reads the relay info file, returning an empty cache if it is
+// missing or unreadable
+func loadRelayInfo(stateDir string, verbose bool) *RelayInfo {
+	ri := &RelayInfo{
+		Relays:  make(map[string]*RelayCapabilities),
+		path:    filepath.Join(stateDir, "relays.json"),
+		verbose: verbose,
+	}
+	data, err := os.ReadFile(ri.path)
+	if os.IsNotExist(err) {
+		return ri
+	} else if err != nil {
+		log.Printf("[WARN] Failed to read relay info file %s: %v", ri.path, err)
+		return ri
+	}
+	if err := json.Unmarshal(data, ri); err != nil {
+		log.Printf("[WARN] Failed to parse relay info file %s: %v", ri.path, err)
+	}
+	if ri.Relays == nil {
+		ri.Relays = make(map[string]*RelayCapabilities)
+	}
+	return ri
+}
+
+// Check returns the capabilities of a relay, fetching its NIP-11 document
+// first if the cached one is stale. The limitations conflicting with a
+// subscription of filters filters asking for maxEvents events are logged
+// when the document is fetched, so a daemon warns about them once a day.
+func (ri *RelayInfo) Check(ctx context.Context, url string, filters, maxEvents int, timeout time.Duration) *RelayCapabilities {
+	caps := ri.Relays[url]
+	fresh := caps == nil || caps.stale(time.Now())
+	if fresh {
+		fetched := fetchRelayCapabilities(ctx, url, timeout)
+		caps = &fetched
+		ri.Relays[url] = caps
+		if caps.Error != "" && ri.verbose {
+			log.Printf("[DEBUG] Relay %s serves no NIP-11 document: %s", url, caps.Error)
+		}
+	}
+
+	// The subscription may have changed since the document was fetched
+	caps.Warnings = relayWarnings(caps.Limits, filters, maxEvents)
+	if fresh {
+		for _, w := range caps.Warnings {
+			log.Printf("[WARN] Relay %s %s", url, w)
+		}
+		if err := ri.save(); err != nil {
+			log.Printf("[WARN] Error saving relay info file: %v", err)
+		}
+	}
+	return caps
+}
+
+// save writes the relay info file
+func (ri *RelayInfo) save() error {
+	data, err := json.MarshalIndent(ri, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ri.path, data, 0644)
+}
+
+// fetchRelayCapabilities fetches a relay's NIP-11 document. A relay that
+// serves none is recorded with the error.
+func fetchRelayCapabilities(ctx context.Context, url string, timeout time.Duration) RelayCapabilities {
+	caps := RelayCapabilities{FetchedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, relayInfoURL(url), nil)
+	if err != nil {
+		caps.Error = err.Error()
+		return caps
+	}
+	req.Header.Set("Accept", "application/nostr+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		caps.Error = err.Error()
+		return caps
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		caps.Error = resp.Status
+		return caps
+	}
+
+	var doc struct {
+		Name       string      `json:"name"`
+		Software   string      `json:"software"`
+		Version    string      `json:"version"`
+		Limitation RelayLimits `json:"limitation"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		caps.Error = fmt.Sprintf("invalid NIP-11 document: %v", err)
+		return caps
+	}
+	caps.Name, caps.Software, caps.Limits = doc.Name, doc.Software, doc.Limitation
+	if doc.Version != "" {
+		caps.Software += " " + doc.Version
+	}
+	return caps
+}
+
+// relayInfoURL returns the HTTP URL a relay serves its NIP-11 document at
+func relayInfoURL(relay string) string {
+	switch {
+	case strings.HasPrefix(relay, "wss://"):
+		return "https://" + strings.TrimPrefix(relay, "wss://")
+	case strings.HasPrefix(relay, "ws://"):
+		return "http://" + strings.TrimPrefix(relay, "ws://")
+	}
+	return relay
+}
+
+// relayWarnings returns the limitations that keep a relay from serving the
+// manager's subscription of filters filters asking for maxEvents events, or
+// from accepting the done, status, and heartbeat events it publishes
+func relayWarnings(l RelayLimits, filters, maxEvents int) []string {
+	var warnings []string
+	if l.MaxSubscriptions > 0 && l.MaxSubscriptions < filters {
+		warnings = append(warnings, fmt.Sprintf("allows %d subscriptions per connection; the manager subscribes with %d filters, which relays counting each filter as a subscription refuse", l.MaxSubscriptions, filters))
+	}
+	if l.MaxFilters > 0 && l.MaxFilters < filters {
+		warnings = append(warnings, fmt.Sprintf("allows %d filters per subscription; the manager subscribes with %d, so the subscription may be refused", l.MaxFilters, filters))
+	}
+	if l.MaxLimit > 0 && l.MaxLimit < maxEvents {
+		warnings = append(warnings, fmt.Sprintf("returns at most %d events per filter, fewer than max_events_per_relay (%d); older signals may be missed", l.MaxLimit, maxEvents))
+	}
+	if l.AuthRequired {
+		warnings = append(warnings, "requires NIP-42 authentication, which the manager does not perform; subscriptions may be refused")
+	}
+	if l.PaymentRequired {
+		warnings = append(warnings, "requires payment; events this manager publishes are rejected unless its key is paid for")
+	}
+	if l.RestrictedWrites {
+		warnings = append(warnings, "restricts writes; events this manager publishes may be rejected")
+	}
+	if l.MinPowDifficulty > 0 {
+		warnings = append(warnings, fmt.Sprintf("requires %d bits of proof of work; the done, status, and heartbeat events this manager publishes carry none", l.MinPowDifficulty))
+	}
+	return warnings
+}
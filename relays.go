@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
+)
+
+// relayTestKind is the ephemeral event kind published by 'relays test';
+// relays acknowledge ephemeral events without storing them
+const relayTestKind = 29333
+
+// RelayTestResult reports the connectivity checks run against one relay
+type RelayTestResult struct {
+	URL            string      `json:"url"`                       // Relay URL
+	Role           string      `json:"role,omitempty"`            // Configured role if not both
+	Name           string      `json:"name,omitempty"`            // Name from the NIP-11 document
+	Software       string      `json:"software,omitempty"`        // Software and version from the NIP-11 document
+	InfoError      string      `json:"info_error,omitempty"`      // Why the NIP-11 document could not be fetched
+	Limits         RelayLimits `json:"limitation"`                // Limitations from the NIP-11 document
+	Warnings       []string    `json:"warnings,omitempty"`        // Limitations conflicting with the manager's needs
+	Connected      bool        `json:"connected"`                 // Websocket opened
+	ConnectMillis  int64       `json:"connect_ms,omitempty"`      // Time to open the websocket
+	ConnectError   string      `json:"connect_error,omitempty"`   // Why the connection failed
+	Subscribed     bool        `json:"subscribed"`                // Signal subscription reached end of stored events
+	LatencyMillis  int64       `json:"latency_ms,omitempty"`      // Round trip from REQ to EOSE
+	Events         int         `json:"events"`                    // Stored events matching the signal filters
+	SubscribeError string      `json:"subscribe_error,omitempty"` // Why the subscription failed or was closed
+	Published      bool        `json:"published"`                 // Relay accepted an event signed by this manager
+	PublishError   string      `json:"publish_error,omitempty"`   // Why the relay rejected the event
+}
+
+// OK reports whether the relay passed every check that was run. Write
+// relays are not subscribed to and read relays are not published to.
+func (r RelayTestResult) OK(publish bool) bool {
+	role := Relay{URL: r.URL, Role: r.Role}
+	return r.Connected && (r.Subscribed || !role.reads()) && (r.Published || !publish || !role.writes())
+}
+
+func newRelaysCommand(g *globals) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "relays",
+		Short: "Check the configured relays",
+		Args:  cobra.NoArgs,
+	}
+
+	var (
+		timeout time.Duration
+		publish bool
+	)
+	test := &cobra.Command{
+		Use:   "test",
+		Short: "Check every configured relay accepts connections, subscriptions, and events",
+		Long: `Check every configured relay accepts connections, subscriptions, and events.
+Only relays with the read or both role are subscribed to, and the test event
+is only published to relays with the write or both role.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !testRelaysCLI(g.configDir, g.stateDir, g.keypair, g.output, timeout, publish) {
+				os.Exit(1)
+			}
+		},
+	}
+	test.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "Time allowed for each relay's checks")
+	test.Flags().BoolVar(&publish, "publish", true, "Publish an ephemeral test event to check the relay accepts this manager's events")
+
+	var max int
+	discover := &cobra.Command{
+		Use:   "discover",
+		Short: "List the relays recommended in the relay lists of the follows or coordinator",
+		Long: `List the relays recommended in the NIP-65 relay lists (kind 10002) of the
+follows, or of relay_discovery.coordinator, as found on the configured read
+relays. Relays already configured are left out. With relay_discovery enabled
+the manager merges these relays with the configured ones by itself.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !discoverRelaysCLI(g.configDir, g.stateDir, g.output, max) {
+				os.Exit(1)
+			}
+		},
+	}
+	discover.Flags().IntVar(&max, "max", 0, "Most relays to list (default relay_discovery.max_relays)")
+
+	cmd.AddCommand(test, discover)
+	return cmd
+}
+
+// testRelaysCLI checks every configured relay and prints a report. It
+// returns true if all relays passed.
+func testRelaysCLI(configDir, stateDir string, kp Keypair, output string, timeout time.Duration, publish bool) bool {
+	cfg := loadConfig(configDir, stateDir)
+	if len(cfg.Relays) == 0 {
+		log.Println("[WARN] No relays configured.")
+		return false
+	}
+
+	hexFollows := decodeFollows(cfg.Follows)
+	filters, err := signalFilters(cfg.Subscription, hexFollows, encryptedFollows(cfg.Follows), kp)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	var probe *nostr.Event
+	if publish {
+		_, sk, err := nip19.Decode(kp.Nsec)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid private key: %v", err)
+		}
+		probe = &nostr.Event{
+			CreatedAt: nostr.Now(),
+			Kind:      relayTestKind,
+			Content:   "qube-manager relay test",
+		}
+		if err := probe.Sign(sk.(string)); err != nil {
+			log.Fatalf("[ERROR] Failed to sign test event: %v", err)
+		}
+	}
+
+	results := make([]RelayTestResult, len(cfg.Relays))
+	var wg sync.WaitGroup
+	for i, relay := range cfg.Relays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = testRelay(relay, filters, cfg.Subscription.MaxEventsPerRelay, probe, cfg.ConnectTimeout, timeout)
+		}()
+	}
+	wg.Wait()
+
+	passed := true
+	for _, r := range results {
+		passed = passed && r.OK(publish)
+	}
+
+	if output == outputJSON {
+		printJSON(results)
+		return passed
+	}
+
+	for _, r := range results {
+		status := "PASS"
+		if !r.OK(publish) {
+			status = "FAIL"
+		}
+		if r.Role != "" {
+			fmt.Printf("[%s] %s (%s only)\n", status, r.URL, r.Role)
+		} else {
+			fmt.Printf("[%s] %s\n", status, r.URL)
+		}
+		if r.InfoError != "" {
+			fmt.Printf("  info:      unavailable (%s)\n", r.InfoError)
+		} else {
+			fmt.Printf("  info:      %s (%s)\n", r.Name, r.Software)
+		}
+		for _, w := range r.Warnings {
+			fmt.Printf("  warning:   %s\n", w)
+		}
+		if !r.Connected {
+			fmt.Printf("  connect:   failed (%s)\n", r.ConnectError)
+			continue
+		}
+		fmt.Printf("  connect:   %dms\n", r.ConnectMillis)
+		if r.Role != relayWrite {
+			if r.Subscribed {
+				fmt.Printf("  subscribe: %d signal event(s), round trip %dms\n", r.Events, r.LatencyMillis)
+			} else {
+				fmt.Printf("  subscribe: failed (%s)\n", r.SubscribeError)
+			}
+		}
+		if publish && r.Role != relayRead {
+			if r.Published {
+				fmt.Println("  publish:   accepted")
+			} else {
+				fmt.Printf("  publish:   rejected (%s)\n", r.PublishError)
+			}
+		}
+	}
+	return passed
+}
+
+// testRelay fetches the relay's NIP-11 document, connects, runs the signal
+// subscription until end of stored events if the relay is read from, and
+// publishes the probe event if one is given and the relay is written to
+func testRelay(cfg Relay, filters nostr.Filters, maxEvents int, probe *nostr.Event, connectTimeout, timeout time.Duration) RelayTestResult {
+	url := cfg.URL
+	r := RelayTestResult{URL: url}
+	if cfg.Role != relayBoth {
+		r.Role = cfg.Role
+	}
+	if !cfg.reads() {
+		filters, maxEvents = nil, 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	caps := fetchRelayCapabilities(ctx, url, timeout)
+	r.Name, r.Software, r.InfoError, r.Limits = caps.Name, caps.Software, caps.Error, caps.Limits
+	r.Warnings = relayWarnings(caps.Limits, len(filters), maxEvents)
+
+	start := time.Now()
+	connectCtx, cancelConnect := context.WithTimeout(ctx, connectTimeout)
+	relay, err := nostr.RelayConnect(connectCtx, url)
+	cancelConnect()
+	if err != nil {
+		r.ConnectError = err.Error()
+		return r
+	}
+	defer relay.Close()
+	r.Connected = true
+	r.ConnectMillis = time.Since(start).Milliseconds()
+
+	if !cfg.reads() {
+		// Write relays are not subscribed to
+	} else if len(filters) == 0 {
+		r.SubscribeError = "no follows to subscribe to"
+	} else {
+		start = time.Now()
+		sub, err := relay.Subscribe(ctx, filters)
+		if err != nil {
+			r.SubscribeError = err.Error()
+		} else {
+		drain:
+			for {
+				select {
+				case <-sub.Events:
+					r.Events++
+				case <-sub.EndOfStoredEvents:
+					r.Subscribed = true
+					r.LatencyMillis = time.Since(start).Milliseconds()
+					break drain
+				case reason := <-sub.ClosedReason:
+					r.SubscribeError = "closed by relay: " + reason
+					break drain
+				case <-ctx.Done():
+					r.SubscribeError = "no end of stored events before timeout"
+					break drain
+				}
+			}
+			sub.Unsub()
+		}
+	}
+
+	if probe != nil && cfg.writes() {
+		if err := relay.Publish(ctx, *probe); err != nil {
+			r.PublishError = err.Error()
+		} else {
+			r.Published = true
+		}
+	}
+	return r
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configWatchInterval is how often the daemon checks config.yaml for changes
+const configWatchInterval = 5 * time.Second
+
+// reloadableSettings are the config.yaml keys a running daemon picks up on
+// reload; changes to any other setting take effect after a restart
+var reloadableSettings = []string{
+	"relays",
+	"follows",
+	"quorum",
+	"cohort",
+	"poll_interval",
+	"subscription",
+	"connect_timeout",
+	"read_timeout",
+	"total_timeout",
+	"publish_min_relays",
+	"conflict_policy",
+	"latest_signal_wins",
+	"min_pow_difficulty",
+	"gossip",
+	"publish_done",
+	"done_template",
+	"failure_backoff",
+	"run_summary",
+	"log_limits",
+	"announce",
+	"resync",
+	"relay_discovery",
+	"severity_policy",
+	"action_cooldown",
+}
+
+// watchConfig returns a channel that receives the cause of a reload whenever
+// SIGHUP arrives or the modification time of config.yaml changes. Watching
+// stops when shutdown is requested.
+func watchConfig(configDir string, shutdown *ShutdownHandler) <-chan string {
+	path := filepath.Join(configDir, "config.yaml")
+	reload := make(chan string, 1)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+
+		modTime := configModTime(path)
+		for {
+			var cause string
+			select {
+			case <-shutdown.Context().Done():
+				return
+			case <-hup:
+				cause = "SIGHUP"
+			case <-ticker.C:
+				if configModTime(path).Equal(modTime) {
+					continue
+				}
+				cause = "change to " + path
+			}
+			modTime = configModTime(path)
+
+			// A reload already pending covers this one
+			select {
+			case reload <- cause:
+			default:
+			}
+		}
+	}()
+	return reload
+}
+
+// configModTime returns the modification time of path, or the zero time if
+// it cannot be read
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// reload re-reads config.yaml and applies the reloadable settings between
+// cycles. An invalid file is rejected as a whole and the running config is
+// kept.
+func (m *Manager) reload(cause string) bool {
+	log.Printf("[INFO] Reloading config after %s", cause)
+
+	cfg, err := readConfig(m.config.ConfigPath, m.config.StatePath)
+	if err != nil {
+		m.configProblems = []configProblem{{Field: "config.yaml", Message: err.Error()}}
+		log.Printf("[ERROR] Config reload failed, keeping the running config: %v", err)
+		return false
+	}
+	m.configProblems = validateConfig(cfg)
+	problems, warnings := splitProblems(m.configProblems)
+	for _, p := range warnings {
+		log.Printf("[WARN] Config: %s", p)
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("[ERROR] Invalid config: %s", p)
+		}
+		log.Printf("[ERROR] Config reload rejected with %d problem(s), keeping the running config", len(problems))
+		return false
+	}
+
+	next, changes, restart := mergeReloadable(m.config, cfg)
+	if len(restart) > 0 {
+		log.Printf("[WARN] Changes to %s take effect after a restart", strings.Join(restart, ", "))
+	}
+	if len(changes) == 0 {
+		log.Println("[INFO] Config reload: no reloadable settings changed")
+		return false
+	}
+	for _, c := range changes {
+		log.Printf("[INFO] Config reload: %s", c)
+	}
+	m.config = next
+	return true
+}
+
+// mergeReloadable returns the running config with the reloadable settings
+// taken from loaded, a description of every change applied, and the keys of
+// changed settings that need a restart
+func mergeReloadable(running, loaded Config) (Config, []string, []string) {
+	next := running
+	var changes, restart []string
+
+	nextValue := reflect.ValueOf(&next).Elem()
+	loadedValue := reflect.ValueOf(loaded)
+	for i := 0; i < nextValue.NumField(); i++ {
+		name, _, _ := strings.Cut(nextValue.Type().Field(i).Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		current, updated := nextValue.Field(i), loadedValue.Field(i)
+		if reflect.DeepEqual(current.Interface(), updated.Interface()) {
+			continue
+		}
+		if !slices.Contains(reloadableSettings, name) {
+			restart = append(restart, name)
+			continue
+		}
+
+		var described []string
+		switch name {
+		case "relays":
+			described = describeRelayChanges(running.Relays, loaded.Relays)
+		case "follows":
+			described = describeFollowChanges(running.Follows, loaded.Follows)
+		}
+		if len(described) == 0 {
+			described = []string{fmt.Sprintf("%s changed from %v to %v", name, current.Interface(), updated.Interface())}
+		}
+		changes = append(changes, described...)
+		current.Set(updated)
+	}
+	return next, changes, restart
+}
+
+// describeListChange lists the entries added to and removed from a setting
+func describeListChange(what string, before, after []string) []string {
+	var changes []string
+	for _, v := range after {
+		if !slices.Contains(before, v) {
+			changes = append(changes, fmt.Sprintf("added %s %s", what, v))
+		}
+	}
+	for _, v := range before {
+		if !slices.Contains(after, v) {
+			changes = append(changes, fmt.Sprintf("removed %s %s", what, v))
+		}
+	}
+	return changes
+}
+
+// describeRelayChanges lists added and removed relays and relays whose role
+// changed
+func describeRelayChanges(before, after []Relay) []string {
+	urls := func(relays []Relay) []string {
+		out := make([]string, len(relays))
+		for i, r := range relays {
+			out[i] = r.URL
+		}
+		return out
+	}
+	changes := describeListChange("relay", urls(before), urls(after))
+
+	for _, r := range after {
+		i := slices.IndexFunc(before, func(o Relay) bool { return o.URL == r.URL })
+		if i >= 0 && (before[i].reads() != r.reads() || before[i].writes() != r.writes()) {
+			role := r.Role
+			if role == "" {
+				role = relayBoth
+			}
+			changes = append(changes, fmt.Sprintf("relay %s now has the %s role", r.URL, role))
+		}
+	}
+	return changes
+}
+
+// describeFollowChanges lists added and removed follows and follows that
+// switched between public and encrypted signals
+func describeFollowChanges(before, after []Follow) []string {
+	npubs := func(follows []Follow) []string {
+		out := make([]string, len(follows))
+		for i, f := range follows {
+			out[i] = f.NPub
+		}
+		return out
+	}
+	changes := describeListChange("follow", npubs(before), npubs(after))
+
+	for _, f := range after {
+		i := slices.IndexFunc(before, func(o Follow) bool { return o.NPub == f.NPub })
+		if i >= 0 && before[i].Encrypted != f.Encrypted {
+			mode := "public notes"
+			if f.Encrypted {
+				mode = "encrypted DMs"
+			}
+			changes = append(changes, fmt.Sprintf("follow %s now signals via %s", f.NPub, mode))
+		}
+	}
+	return changes
+}
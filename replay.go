@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/spf13/cobra"
+)
+
+func newReplayCommand(g *globals) *cobra.Command {
+	var (
+		from          string
+		until         string
+		ignoreHistory bool
+	)
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Re-run quorum evaluation against the event log",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if from == "" {
+				from = eventLogPath(g.stateDir)
+			}
+			replayCLI(g.configDir, g.stateDir, g.verbose, g.keypair, from, until, ignoreHistory)
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Event log (JSON Lines) to replay (default events.jsonl in the state dir)")
+	cmd.Flags().StringVar(&until, "until", "", "Only replay events created at or before this RFC3339 time")
+	cmd.Flags().BoolVar(&ignoreHistory, "ignore-history", false, "Evaluate as if no action had been performed yet")
+	return cmd
+}
+
+// replayCLI re-runs quorum evaluation against events stored in the event log
+// without touching relays, history, or the executor
+func replayCLI(configDir, stateDir string, verbose bool, kp Keypair, from, until string, ignoreHistory bool) {
+	var cutoff time.Time
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			log.Fatalf("[ERROR] Invalid --until time '%s': %v", until, err)
+		}
+		cutoff = t
+	}
+
+	cfg := loadConfig(configDir, stateDir)
+
+	// Replay works on an in-memory copy so history is never modified
+	history := &History{Entries: make(map[string]HistoryEntry)}
+	if !ignoreHistory {
+		stored := loadHistory(stateDir)
+		for k, v := range stored.Entries {
+			history.Entries[k] = v
+		}
+		history.Network, history.Compacted, history.Tombstones = stored.Network, stored.Compacted, stored.Tombstones
+		history.SetNetwork(cfg.Network)
+	}
+
+	entries, err := readEventLog(from)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read event log: %v", err)
+	}
+	log.Printf("[INFO] Replaying %d event(s) from %s", len(entries), from)
+
+	// Signatures are checked up front: gift wraps are replaced by the direct
+	// messages inside, whose timestamps, unlike theirs, are real
+	valid := entries[:0]
+	for _, e := range entries {
+		if ok, err := e.Event.CheckSignature(); err != nil || !ok {
+			log.Printf("[WARN] Skipping event %s with invalid signature", e.Event.ID)
+			continue
+		}
+		if e.Event.Kind == nostr.KindGiftWrap {
+			dm, err := unwrapDM(e.Event, kp)
+			if err != nil {
+				log.Printf("[WARN] Skipping gift wrap %s: %v", e.Event.ID, err)
+				continue
+			}
+			e.Event = dm
+		}
+		valid = append(valid, e)
+	}
+	entries = valid
+
+	// Events are replayed in creation order, as a relay would deliver them
+	slices.SortStableFunc(entries, func(a, b LoggedEvent) int {
+		return nostr.CompareEvent(*a.Event, *b.Event)
+	})
+
+	revoked := revokedKeys(history)
+	follows := activeFollows(cfg, revoked)
+	trusted := trustedSigners(cfg, follows)
+	encrypted := encryptedFollows(cfg.Follows)
+	tally := signal.NewEvaluator(cfg.Quorum, cfg.Cohort, cfg.ConflictPolicy)
+	tally.SetRoles(followRoles(cfg.Follows))
+	tally.SetNetwork(cfg.Network)
+	tally.SetLatestSignalWins(cfg.LatestSignalWins)
+
+	for _, e := range entries {
+		ev := e.Event
+		if !cutoff.IsZero() && ev.CreatedAt.Time().After(cutoff) {
+			continue
+		}
+		if !slices.Contains(follows, ev.PubKey) {
+			log.Printf("[INFO] Skipping event %s from pubkey %s not in the follow list", ev.ID, ev.PubKey)
+			continue
+		}
+		if ev.Kind == nostr.KindDeletion {
+			retractSignals(tally, ev)
+			continue
+		}
+		content, err := signalContent(ev, encrypted)
+		if err != nil {
+			log.Printf("[WARN] Skipping event %s: %v", ev.ID, err)
+			continue
+		}
+		if err := checkPoW(ev, content, cfg.MinPowDifficulty); err != nil {
+			log.Printf("[WARN] Skipping signal %s: %v", ev.ID, err)
+			continue
+		}
+		if signal.Address(ev) != "" {
+			if _, err := replaceSignals(tally, ev); err != nil {
+				continue
+			}
+		}
+		addSignal(tally, ev, content, e.Relay, trusted, verbose)
+	}
+
+	applyRevocations(cfg, tally.Actions, tally.Votes, history, revoked, true)
+	dropPolicyViolations(tally, cfg.VersionPolicy, nil, history)
+
+	for key, vset := range tally.Votes {
+		log.Printf("[INFO] Candidate %s: %d/%d vote(s)", key, len(vset), cfg.Quorum)
+	}
+
+	latest, pending := selectAction(tally, history)
+	log.Printf("[INFO] %d candidate action(s) not yet in history", pending)
+	if latest == nil {
+		log.Println("[INFO] Replay result: no eligible action.")
+		return
+	}
+	log.Printf("[INFO] Replay result: would select %s with %d vote(s)", latest.Key, len(tally.Votes[latest.Key]))
+}
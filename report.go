@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
+)
+
+// NodeReport is the latest progress a manager published in reply to this
+// key's signals
+type NodeReport struct {
+	Node       string `json:"node"`                 // npub of the reporting manager
+	Action     string `json:"action"`               // Key of the action reported on
+	Status     string `json:"status"`               // "pending", "done", "failed", or the published status, e.g. "executing"
+	Error      string `json:"error,omitempty"`      // Why the action failed, for failed reports
+	ExecuteAt  string `json:"execute_at,omitempty"` // Announced execution time for scheduled actions
+	ReportedAt string `json:"reported_at"`          // RFC3339 creation time of the event
+	EventID    string `json:"event_id"`             // Event the report was taken from
+}
+
+// parseReport extracts the action and status from a pending, status, done,
+// or failure event. It returns false for any other event.
+func parseReport(ev *nostr.Event) (NodeReport, bool) {
+	var msg struct {
+		Type      string `json:"type"`
+		Action    string `json:"action"`
+		Status    string `json:"status"`
+		ExecuteAt string `json:"executeAt"`
+		ExtraData string `json:"extraData"`
+		Error     string `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(ev.Content), &msg); err != nil {
+		return NodeReport{}, false
+	}
+
+	r := NodeReport{
+		Node:       ev.PubKey,
+		ReportedAt: ev.CreatedAt.Time().UTC().Format(time.RFC3339),
+		EventID:    ev.ID,
+	}
+	if npub, err := nip19.EncodePublicKey(ev.PubKey); err == nil {
+		r.Node = npub
+	}
+
+	switch {
+	case msg.Type == "status" && msg.Action != "":
+		r.Action, r.Status, r.ExecuteAt = msg.Action, msg.Status, msg.ExecuteAt
+	case msg.Type == "pending" && msg.Action != "":
+		r.Action, r.Status, r.ExecuteAt = msg.Action, "pending", msg.ExecuteAt
+	case msg.ExtraData == "done":
+		action, err := signal.Parse(ev.Content)
+		if err != nil {
+			return NodeReport{}, false
+		}
+		r.Action, r.Status = action.Key, "done"
+	case msg.ExtraData == "failed":
+		action, err := signal.Parse(ev.Content)
+		if err != nil {
+			return NodeReport{}, false
+		}
+		r.Action, r.Status, r.Error = action.Key, "failed", msg.Error
+	default:
+		return NodeReport{}, false
+	}
+	return r, true
+}
+
+func newReportCommand(g *globals) *cobra.Command {
+	var (
+		since   time.Duration
+		timeout time.Duration
+		watch   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Print the latest status report of each node that acted on this key's signals",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			reportCLI(g.configDir, g.stateDir, g.keypair, g.output, since, timeout, watch)
+		},
+	}
+	cmd.Flags().DurationVar(&since, "since", 7*24*time.Hour, "Only include reports published within this period")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "Time to wait for relays")
+	cmd.Flags().BoolVar(&watch, "watch", false, "Show a live terminal dashboard updated as nodes report, until q is pressed")
+	return cmd
+}
+
+// reportCLI queries relays for pending, status, and done events that managers
+// published in reply to this key's signals and prints the latest report of
+// each node, or watches them live
+func reportCLI(configDir, stateDir string, kp Keypair, output string, since, timeout time.Duration, watch bool) {
+	cfg := loadConfig(configDir, stateDir)
+	pk, err := kp.publicKey()
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if watch {
+		if output == outputJSON {
+			log.Fatal("[ERROR] --watch shows a terminal dashboard and cannot output JSON")
+		}
+		reportWatchCLI(cfg, pk, since)
+		return
+	}
+
+	start := nostr.Timestamp(time.Now().Add(-since).Unix())
+	filter := nostr.Filter{
+		Kinds: []int{nostr.KindTextNote},
+		Tags:  nostr.TagMap{"p": []string{pk}},
+		Since: &start,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	latest := make(map[string]*nostr.Event)
+	for _, url := range cfg.readRelays() {
+		relay, err := nostr.RelayConnect(ctx, url)
+		if err != nil {
+			log.Printf("[WARN] Could not connect to relay %s: %v", url, err)
+			continue
+		}
+		events, err := relay.QuerySync(ctx, filter)
+		relay.Close()
+		if err != nil {
+			log.Printf("[WARN] Query failed on relay %s: %v", url, err)
+			continue
+		}
+		log.Printf("[INFO] Relay %s returned %d event(s)", url, len(events))
+		for _, ev := range events {
+			if _, ok := parseReport(ev); !ok {
+				continue
+			}
+			if prev, ok := latest[ev.PubKey]; !ok || ev.CreatedAt > prev.CreatedAt {
+				latest[ev.PubKey] = ev
+			}
+		}
+	}
+
+	reports := make([]NodeReport, 0, len(latest))
+	for _, ev := range latest {
+		r, _ := parseReport(ev)
+		reports = append(reports, r)
+	}
+	slices.SortFunc(reports, func(a, b NodeReport) int { return strings.Compare(a.Node, b.Node) })
+
+	if output == outputJSON {
+		printJSON(reports)
+		return
+	}
+	if len(reports) == 0 {
+		fmt.Println("No node has reported on your signals.")
+		return
+	}
+	for _, r := range reports {
+		status := r.Status
+		if r.ExecuteAt != "" {
+			status += " at " + r.ExecuteAt
+		}
+		fmt.Printf("%-63s %-30s %-35s %s\n", r.Node, r.Action, status, r.ReportedAt)
+		if r.Error != "" {
+			fmt.Printf("    error: %s\n", r.Error)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Timing of the live report
+const (
+	reportWatchRetry = 30 * time.Second // Pause before reconnecting to a relay
+	reportWatchTick  = time.Second      // Redraw interval for relative times
+	reportBarWidth   = 30               // Width of the adoption progress bar
+)
+
+// watchedNode is what the live report knows about one node
+type watchedNode struct {
+	npub     string
+	report   NodeReport      // latest pending, status, or done report
+	reportAt nostr.Timestamp // creation time of the report
+	version  string          // node version from the newest heartbeat
+	beatAt   nostr.Timestamp // creation time of the newest heartbeat
+	done     map[string]bool // action keys the node reported done
+}
+
+// reportWatch collects the latest signal of the key and the reports and
+// heartbeats of every node that acted on its signals
+type reportWatch struct {
+	pk       string                  // hex key whose signals are tracked
+	latest   *signal.Action          // newest signal published by the key
+	latestAt nostr.Timestamp         // creation time of that signal
+	nodes    map[string]*watchedNode // by hex pubkey
+	relays   map[string]string       // relay URL -> connection state
+}
+
+func newReportWatch(pk string, relays []string) *reportWatch {
+	w := &reportWatch{pk: pk, nodes: make(map[string]*watchedNode), relays: make(map[string]string)}
+	for _, url := range relays {
+		w.relays[url] = "connecting"
+	}
+	return w
+}
+
+// add records ev, whichever relay delivered it first
+func (w *reportWatch) add(ev *nostr.Event) {
+	if ev.PubKey == w.pk {
+		var msg struct {
+			ExtraData string `json:"extraData"`
+		}
+		if json.Unmarshal([]byte(ev.Content), &msg) != nil || msg.ExtraData == "done" || msg.ExtraData == "failed" {
+			return
+		}
+		if action, err := signal.Parse(ev.Content); err == nil && ev.CreatedAt > w.latestAt {
+			w.latest, w.latestAt = action, ev.CreatedAt
+		}
+		return
+	}
+
+	if isHeartbeat(ev.Content) {
+		n := w.nodes[ev.PubKey]
+		var msg HeartbeatMessage
+		if n == nil || ev.CreatedAt <= n.beatAt || json.Unmarshal([]byte(ev.Content), &msg) != nil {
+			return
+		}
+		n.version, n.beatAt = msg.NodeVersion, ev.CreatedAt
+		return
+	}
+
+	r, ok := reportTo(ev, w.pk)
+	if !ok {
+		return
+	}
+	n := w.nodes[ev.PubKey]
+	if n == nil {
+		n = &watchedNode{npub: r.Node, done: make(map[string]bool)}
+		w.nodes[ev.PubKey] = n
+	}
+	if r.Status == "done" {
+		n.done[r.Action] = true
+	}
+	if ev.CreatedAt > n.reportAt {
+		n.report, n.reportAt = r, ev.CreatedAt
+	}
+}
+
+// reportTo parses ev as a report in reply to signals of pk. Reports nodes
+// send to other keys arrive with their heartbeats and are skipped.
+func reportTo(ev *nostr.Event, pk string) (NodeReport, bool) {
+	if ev.PubKey == pk || !ev.Tags.ContainsAny("p", []string{pk}) {
+		return NodeReport{}, false
+	}
+	return parseReport(ev)
+}
+
+// view renders the live report for a terminal width columns wide
+func (w *reportWatch) view(now time.Time, width int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "qube-manager report  %s  %s  (q to quit)\n\n", now.Format("15:04:05"), shortNpub(w.pk))
+
+	nodes := slices.SortedFunc(maps.Values(w.nodes), func(a, b *watchedNode) int { return strings.Compare(a.npub, b.npub) })
+
+	if w.latest == nil {
+		b.WriteString("Latest signal: none published in the period\n")
+	} else {
+		adopted := 0
+		for _, n := range nodes {
+			if n.done[w.latest.Key] {
+				adopted++
+			}
+		}
+		fmt.Fprintf(&b, "Latest signal: %s (%s)\n", w.latest.Key, formatAge(now, w.latestAt))
+		fmt.Fprintf(&b, "Adoption:      %s %d/%d node(s)\n", progressBar(adopted, len(nodes), reportBarWidth), adopted, len(nodes))
+	}
+
+	versions := make(map[string]int)
+	for _, n := range nodes {
+		versions[cmp.Or(n.version, "unknown")]++
+	}
+	var dist []string
+	for _, v := range slices.SortedFunc(maps.Keys(versions), func(a, b string) int {
+		return cmp.Or(versions[b]-versions[a], strings.Compare(a, b))
+	}) {
+		dist = append(dist, fmt.Sprintf("%s ×%d", v, versions[v]))
+	}
+	if len(dist) > 0 {
+		fmt.Fprintf(&b, "Versions:      %s\n", strings.Join(dist, "  "))
+	}
+	b.WriteString("\n")
+
+	if len(nodes) == 0 {
+		b.WriteString("No node has reported on your signals yet.\n")
+	} else {
+		statusWidth := max(20, width-22-10-12-3)
+		fmt.Fprintf(&b, "%-22s %-*s %-10s %s\n", "NODE", statusWidth, "STATUS", "VERSION", "REPORTED")
+		for _, n := range nodes {
+			status := n.report.Status + " " + n.report.Action
+			if n.report.ExecuteAt != "" {
+				status += " at " + n.report.ExecuteAt
+			}
+			if n.report.Error != "" {
+				status += ": " + n.report.Error
+			}
+			fmt.Fprintf(&b, "%-22s %-*s %-10s %s\n", shortNpub(n.npub), statusWidth, truncate(status, statusWidth), cmp.Or(n.version, "-"), formatAge(now, n.reportAt))
+		}
+	}
+
+	b.WriteString("\n")
+	for _, url := range slices.Sorted(maps.Keys(w.relays)) {
+		fmt.Fprintf(&b, "%s: %s\n", url, truncate(w.relays[url], max(20, width-len(url)-2)))
+	}
+	return b.String()
+}
+
+// progressBar draws done out of total as a bar width characters wide
+func progressBar(done, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = done * width / total
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", width-filled)
+}
+
+// shortNpub abbreviates an npub or hex key for narrow columns
+func shortNpub(key string) string {
+	if npub, err := nip19.EncodePublicKey(key); err == nil {
+		key = npub
+	}
+	if len(key) <= 22 {
+		return key
+	}
+	return key[:13] + "…" + key[len(key)-8:]
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// formatAge describes how long before now ts was
+func formatAge(now time.Time, ts nostr.Timestamp) string {
+	d := now.Sub(ts.Time()).Round(time.Second)
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	}
+}
+
+// Messages delivered to the live report
+type (
+	reportEventMsg struct{ ev *nostr.Event }   // event received from a relay
+	relayStateMsg  struct{ url, state string } // relay connection changed
+	reportTickMsg  time.Time                   // time to redraw
+)
+
+// reportModel is the bubbletea model of the live report
+type reportModel struct {
+	watch *reportWatch
+	width int
+	now   time.Time
+}
+
+func reportTick() tea.Cmd {
+	return tea.Tick(reportWatchTick, func(t time.Time) tea.Msg { return reportTickMsg(t) })
+}
+
+func (m reportModel) Init() tea.Cmd {
+	return reportTick()
+}
+
+func (m reportModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "esc", "ctrl+c":
+			return m, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+	case reportEventMsg:
+		m.watch.add(msg.ev)
+	case relayStateMsg:
+		m.watch.relays[msg.url] = msg.state
+	case reportTickMsg:
+		m.now = time.Time(msg)
+		return m, reportTick()
+	}
+	return m, nil
+}
+
+func (m reportModel) View() string {
+	return m.watch.view(m.now, m.width)
+}
+
+// reportWatchCLI shows the report as a live terminal dashboard, updated as
+// reports and heartbeats arrive, until the user quits. Logs only go to the
+// log file while the dashboard owns the terminal.
+func reportWatchCLI(cfg Config, pk string, since time.Duration) {
+	setLogOutput(logFileWriter(cfg.StatePath))
+
+	start := nostr.Timestamp(time.Now().Add(-since).Unix())
+	base := nostr.Filters{
+		{Kinds: []int{nostr.KindTextNote}, Tags: nostr.TagMap{"p": []string{pk}}, Since: &start},
+		{Kinds: []int{nostr.KindTextNote}, Authors: []string{pk}, Since: &start},
+	}
+
+	model := reportModel{watch: newReportWatch(pk, cfg.readRelays()), width: 120, now: time.Now()}
+	p := tea.NewProgram(model, tea.WithAltScreen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan *nostr.Event, 256)
+	var wg sync.WaitGroup
+	var authors []chan []string
+	for _, url := range cfg.readRelays() {
+		ch := make(chan []string, 1)
+		authors = append(authors, ch)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchRelay(ctx, url, base, ch, events, func(state string) {
+				p.Send(relayStateMsg{url: url, state: state})
+			})
+		}()
+	}
+
+	// Heartbeats are subscribed to for every node that reported, so each
+	// relay resubscribes whenever a new node turns up
+	go func() {
+		nodes := make(map[string]bool)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-events:
+				if _, ok := reportTo(ev, pk); ok && !nodes[ev.PubKey] {
+					nodes[ev.PubKey] = true
+					list := slices.Sorted(maps.Keys(nodes))
+					for _, ch := range authors {
+						select {
+						case <-ch:
+						default:
+						}
+						ch <- list
+					}
+				}
+				p.Send(reportEventMsg{ev: ev})
+			}
+		}
+	}()
+
+	if _, err := p.Run(); err != nil {
+		log.Printf("[ERROR] Live report failed: %v", err)
+	}
+	cancel()
+	wg.Wait()
+}
+
+// watchRelay streams the events matching base, and the live heartbeats of the
+// nodes last received on authors, from a relay into events, reconnecting
+// after failures until ctx is cancelled
+func watchRelay(ctx context.Context, url string, base nostr.Filters, authors <-chan []string, events chan<- *nostr.Event, state func(string)) {
+	var nodes []string
+	for ctx.Err() == nil {
+		err := streamRelay(ctx, url, base, &nodes, authors, events, state)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[WARN] Live report lost relay %s: %v", url, err)
+		state(fmt.Sprintf("%v - retrying in %v", err, reportWatchRetry))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(reportWatchRetry):
+		}
+	}
+}
+
+// streamRelay holds one connection to a relay for watchRelay
+func streamRelay(ctx context.Context, url string, base nostr.Filters, nodes *[]string, authors <-chan []string, events chan<- *nostr.Event, state func(string)) error {
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	sub, err := relay.Subscribe(ctx, base)
+	if err != nil {
+		return err
+	}
+	defer sub.Unsub()
+	state("connected")
+
+	var beats *nostr.Subscription
+	subscribeBeats := func() error {
+		if beats != nil {
+			beats.Unsub()
+		}
+		beats = nil
+		if len(*nodes) == 0 {
+			return nil
+		}
+		// Only live heartbeats; stored ones would flash stale states
+		now := nostr.Now()
+		beats, err = relay.Subscribe(ctx, nostr.Filters{{Kinds: []int{nostr.KindTextNote}, Authors: *nodes, Since: &now}})
+		return err
+	}
+	if err := subscribeBeats(); err != nil {
+		return err
+	}
+
+	for {
+		var beatEvents chan *nostr.Event
+		if beats != nil {
+			beatEvents = beats.Events
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-relay.Context().Done():
+			return fmt.Errorf("connection closed")
+		case reason := <-sub.ClosedReason:
+			return fmt.Errorf("subscription closed: %s", reason)
+		case list := <-authors:
+			*nodes = list
+			if err := subscribeBeats(); err != nil {
+				return err
+			}
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return fmt.Errorf("subscription ended")
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return nil
+			}
+		case ev, ok := <-beatEvents:
+			if !ok {
+				beats = nil
+				continue
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
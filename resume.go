@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newResumeActionCommand(g *globals) *cobra.Command {
+	var discard, dryRun bool
+	cmd := &cobra.Command{
+		Use:   "resume-action",
+		Short: "Finish an incomplete execution from its failed step, or discard it",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			resumeActionCLI(g.configDir, g.stateDir, g.keypair, discard, dryRun, g.verbose)
+		},
+	}
+	cmd.Flags().BoolVar(&discard, "discard", false, "Discard the incomplete execution instead of resuming it")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the remaining steps without running them")
+	return cmd
+}
+
+// resumeActionCLI finishes an incomplete execution from its failed step, or
+// discards the persisted state so the action can be started from scratch
+func resumeActionCLI(configDir, stateDir string, kp Keypair, discard, dryRun, verbose bool) {
+	cfg := loadConfig(configDir, stateDir)
+	history := loadHistory(stateDir)
+
+	if cfg.Fleet.Enabled() {
+		log.Println("[INFO] Fleet mode: incomplete hosts resume automatically on the next run while the action keeps quorum.")
+		return
+	}
+
+	state := pendingExecution(stateDir, history)
+	if state == nil {
+		log.Println("[INFO] No incomplete execution to resume.")
+		return
+	}
+
+	for i, step := range state.Steps {
+		log.Printf("[INFO] Step %d/%d: %s status=%s attempts=%d %s", i+1, len(state.Steps), step.Name, step.Status, step.Attempts, step.Error)
+	}
+
+	if discard {
+		if err := state.Clear(); err != nil {
+			log.Fatalf("[ERROR] Failed to discard execution state: %v", err)
+		}
+		log.Printf("[INFO] Discarded execution state for %s", state.Action)
+		return
+	}
+	if dryRun {
+		log.Printf("[INFO] Dry run - not resuming %s", state.Action)
+		return
+	}
+
+	// Actions of co-hosted services resume with the service's backend
+	exCfg := cfg.Executor
+	if state.Service != "" {
+		var ok bool
+		if exCfg, ok = cfg.Services[state.Service]; !ok {
+			log.Fatalf("[ERROR] Service %s is no longer configured; cannot resume execution.", state.Service)
+		}
+	}
+	executor, err := newExecutor(exCfg)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if executor == nil {
+		log.Fatal("[ERROR] No executor configured; cannot resume execution.")
+	}
+	if executor.Name() != state.Executor {
+		log.Printf("[WARN] Execution was started with the %s executor but %s is configured", state.Executor, executor.Name())
+	}
+
+	action, err := state.CandidateAction()
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	setAction(action.Key)
+
+	shutdown := newShutdownHandler(cfg.ShutdownGracePeriod)
+	shutdown.OnFlush(func() {
+		if err := history.Flush(); err != nil {
+			log.Printf("[WARN] Error flushing history during shutdown: %v", err)
+		}
+	})
+	actionDone := shutdown.Track("action " + action.Key)
+	defer actionDone()
+
+	// Failures count toward the action's backoff like those of a daemon run
+	audit := openAuditLog(stateDir, kp)
+	fail := func(format string, err error) {
+		audit.Execution(action.Key, "failed", err)
+		history.Transition(action.Key, stateFailed, err)
+		if err := history.Save(); err != nil {
+			log.Printf("[WARN] Error saving history: %v", err)
+		}
+		if failures, ferr := loadFailures(stateDir); ferr != nil {
+			log.Printf("[WARN] %v", ferr)
+		} else {
+			failures.Record(action.Key, err, cfg.FailureBackoff, time.Now())
+			if err := failures.Save(); err != nil {
+				log.Printf("[WARN] Error saving failures: %v", err)
+			}
+		}
+		log.Fatalf(format, action.Key, err)
+	}
+	record := environmentRecorder(cfg, exCfg, action, nil, time.Now())
+	if err := executeAction(context.Background(), executor, action, state, exCfg, record); err != nil {
+		fail("[ERROR] Execution of %s failed: %v", err)
+	}
+	history.Transition(action.Key, stateVerifying, nil)
+	if err := verifyNodeVersion(cfg, action); err != nil {
+		fail("[ERROR] Verification of %s failed: %v", err)
+	}
+	if err := completeAction(cfg, kp, history, action, state.Votes, shutdown, verbose); err != nil {
+		fail("[ERROR] Completing %s failed: %v", err)
+	}
+	audit.Execution(action.Key, "executed", nil)
+	if failures, err := loadFailures(stateDir); err != nil {
+		log.Printf("[WARN] %v", err)
+	} else if failures.Reset(action.Key) {
+		if err := failures.Save(); err != nil {
+			log.Printf("[WARN] Error saving failures: %v", err)
+		}
+	}
+	if err := state.Clear(); err != nil {
+		log.Printf("[WARN] Failed to clear execution state: %v", err)
+	}
+	log.Printf("[INFO] Resumed action %s completed", action.Key)
+}
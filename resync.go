@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Defaults of resync monitoring
+const (
+	defaultResyncProgressInterval = 15 * time.Minute
+	defaultResyncStallAfter       = time.Hour
+)
+
+// Statuses published while a node resyncs after a reboot
+const (
+	statusResyncing = "resyncing"
+	statusSynced    = "synced"
+)
+
+// Sync states reported by the node's stats.syncInfo method
+const (
+	syncStateDone = 2
+)
+
+// ResyncConfig configures how the node's resync after a reboot is followed.
+// Progress is read from node.rpc_url; without it resyncs are not monitored.
+type ResyncConfig struct {
+	ProgressInterval time.Duration `yaml:"progress_interval,omitempty"` // How often progress events are published while resyncing (default 15m)
+	StallAfter       time.Duration `yaml:"stall_after,omitempty"`       // How long the height may not advance before the operator is alerted (default 1h)
+}
+
+// applyDefaults fills in unset resync settings
+func (c *ResyncConfig) applyDefaults() {
+	if c.ProgressInterval <= 0 {
+		c.ProgressInterval = defaultResyncProgressInterval
+	}
+	if c.StallAfter <= 0 {
+		c.StallAfter = defaultResyncStallAfter
+	}
+}
+
+// ResyncState tracks the resync following a reboot across runs and
+// restarts, until the node caught up with the network
+type ResyncState struct {
+	Action      string `json:"action"`                 // Key of the reboot action
+	StartedAt   string `json:"started_at"`             // ISO8601 time the reboot completed
+	Height      uint64 `json:"height"`                 // Height last reported by the node
+	Target      uint64 `json:"target"`                 // Network tip last reported by the node
+	ProgressAt  string `json:"progress_at"`            // ISO8601 time the height last advanced
+	PublishedAt string `json:"published_at,omitempty"` // ISO8601 time progress was last published
+	Failures    int    `json:"failures,omitempty"`     // Consecutive runs the node's RPC could not be read
+	path        string // resync file path (not in JSON)
+}
+
+// SyncInfo is the result of the node's stats.syncInfo method
+type SyncInfo struct {
+	State         int    `json:"state"`
+	CurrentHeight uint64 `json:"currentHeight"`
+	TargetHeight  uint64 `json:"targetHeight"`
+}
+
+// Synced reports whether the node caught up with the network tip
+func (s SyncInfo) Synced() bool {
+	return s.State == syncStateDone && s.CurrentHeight > 0 && s.CurrentHeight >= s.TargetHeight
+}
+
+// Percent returns how far the node caught up, 0 if the tip is unknown
+func (r *ResyncState) Percent() float64 {
+	if r.Target == 0 {
+		return 0
+	}
+	return min(100, float64(r.Height)*100/float64(r.Target))
+}
+
+// resyncPath returns where the resync in progress is tracked
+func resyncPath(stateDir string) string {
+	return filepath.Join(stateDir, "resync.json")
+}
+
+// loadResync reads the resync in progress, nil if there is none
+func loadResync(stateDir string) *ResyncState {
+	path := resyncPath(stateDir)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	r := &ResyncState{path: path}
+	if err := json.Unmarshal(data, r); err != nil {
+		log.Printf("[WARN] Ignoring unreadable %s: %v", path, err)
+		return nil
+	}
+	return r
+}
+
+// Save writes the resync state back to its file
+func (r *ResyncState) Save() error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data, 0644)
+}
+
+// startResync begins following the resync after the reboot action with key
+// completed. A reboot executed again, e.g. after a failed attempt, starts
+// over.
+func startResync(cfg Config, key string) {
+	if cfg.Node.RPCURL == "" {
+		log.Printf("[INFO] node.rpc_url is not set - resync progress after %s is not monitored", key)
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	r := &ResyncState{Action: key, StartedAt: now, ProgressAt: now, path: resyncPath(cfg.StatePath)}
+	if err := r.Save(); err != nil {
+		log.Printf("[WARN] Failed to save resync state: %v", err)
+		return
+	}
+	log.Printf("[INFO] Monitoring node resync after %s", key)
+}
+
+// monitorResync reads the node's sync progress while a resync is followed
+// and publishes it every progress_interval, and once more when the node is
+// synced. The node's RPC is often down while it restarts, so failures to read
+// it are retried on later runs and only alerted once the height has not
+// advanced for stall_after. It returns the state, nil once the node synced.
+func (m *Manager) monitorResync() *ResyncState {
+	r := loadResync(m.config.StatePath)
+	if r == nil || m.dryRun {
+		return r
+	}
+	cfg := m.config.Resync
+	now := time.Now().UTC()
+
+	var info SyncInfo
+	if err := nodeRPC(m.config.Node.RPCURL, "stats.syncInfo", &info); err != nil {
+		r.Failures++
+		noisyLog.Printf(noiseRelay, "resync", "[WARN] Resync progress after %s unavailable (attempt %d): %v", r.Action, r.Failures, err)
+	} else {
+		r.Failures = 0
+		if info.CurrentHeight > r.Height {
+			r.ProgressAt = now.Format(time.RFC3339)
+		}
+		r.Height, r.Target = info.CurrentHeight, max(info.TargetHeight, info.CurrentHeight)
+	}
+
+	alertKey := "resync:" + r.Action
+	if info.Synced() {
+		started, _ := time.Parse(time.RFC3339, r.StartedAt)
+		log.Printf("[INFO] Node resynced to height %d after %s, %v after the reboot", r.Height, r.Action, now.Sub(started).Round(time.Second))
+		m.publishResync(r, statusSynced)
+		m.alerts.Clear(alertKey)
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] Failed to remove resync state: %v", err)
+		}
+		return nil
+	}
+
+	if r.Failures == 0 {
+		log.Printf("[INFO] Node resyncing after %s: height %d/%d (%.1f%%)", r.Action, r.Height, r.Target, r.Percent())
+		if last, err := time.Parse(time.RFC3339, r.PublishedAt); err != nil || now.Sub(last) >= cfg.ProgressInterval {
+			if m.publishResync(r, statusResyncing) {
+				r.PublishedAt = now.Format(time.RFC3339)
+			}
+		}
+	}
+	if progress, err := time.Parse(time.RFC3339, r.ProgressAt); err == nil && now.Sub(progress) >= cfg.StallAfter {
+		text := fmt.Sprintf("node resync after %s has not advanced past height %d for %v", r.Action, r.Height, now.Sub(progress).Round(time.Minute))
+		if r.Failures > 0 {
+			text = fmt.Sprintf("node RPC unreachable for %d run(s) while resyncing after %s, last height %d", r.Failures, r.Action, r.Height)
+		}
+		log.Printf("[WARN] Resync stalled: %s", text)
+		m.alerts.Send(alertKey, Notification{Event: notifyResyncStalled, Text: text})
+	} else {
+		m.alerts.Clear(alertKey)
+	}
+
+	if err := r.Save(); err != nil {
+		log.Printf("[WARN] Failed to save resync state: %v", err)
+	}
+	return r
+}
+
+// publishResync publishes a status event with the resync progress, threaded
+// to the signals that carried the reboot like its done event. It reports
+// whether any relay accepted it.
+func (m *Manager) publishResync(r *ResyncState, status string) bool {
+	if m.config.PublishDone != nil && !*m.config.PublishDone {
+		return false
+	}
+	votes := make(map[string]signal.Vote)
+	if entry, ok := m.history.Entries[r.Action]; ok {
+		for _, v := range entry.Votes {
+			votes[v.PubKey] = v
+		}
+	}
+	wait, err := publishConfirmation(m.config, m.keypair, votes, func(v map[string]signal.Vote) (nostr.Event, error) {
+		return newResyncEvent(r, status, v)
+	}, m.shutdown)
+	if err != nil {
+		log.Printf("[WARN] Failed to build resync progress event: %v", err)
+		return false
+	}
+	accepted := wait()
+	log.Printf("[INFO] Resync progress (%s) accepted by %d/%d relays", status, accepted, len(m.config.writeRelays()))
+	return accepted > 0
+}
+
+// newResyncEvent builds the unsigned status event reporting resync progress
+func newResyncEvent(r *ResyncState, status string, votes map[string]signal.Vote) (nostr.Event, error) {
+	content, err := json.Marshal(StatusMessage{
+		Type:          "status",
+		SchemaVersion: signal.SchemaVersion,
+		Action:        r.Action,
+		Status:        status,
+		Height:        r.Height,
+		TargetHeight:  r.Target,
+	})
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	return nostr.Event{
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      nostr.KindTextNote,
+		Tags:      append(signalTags(votes), correlationTags()...),
+		Content:   string(content),
+	}, nil
+}
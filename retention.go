@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// HistoryRetention bounds how many entries history.yaml keeps in full, so
+// long-lived nodes do not parse an ever-growing file on every run. Upgrades
+// and reboots beyond it are compacted to their bare key, which still marks
+// exactly that action as performed; a re-pinned artifact or a new genesis
+// has another key and stays eligible. Rollbacks and key revocations are
+// kept, without their votes.
+type HistoryRetention struct {
+	MaxAge     time.Duration `yaml:"max_age,omitempty"`     // Entries performed longer ago are compacted, e.g. 17520h for two years (kept if unset)
+	MaxEntries int           `yaml:"max_entries,omitempty"` // Entries beyond the newest this many are compacted (unbounded if unset)
+}
+
+// Enabled reports whether any limit is set
+func (r HistoryRetention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxEntries > 0
+}
+
+// Validate checks the limits
+func (r HistoryRetention) Validate() error {
+	if r.MaxAge < 0 || r.MaxEntries < 0 {
+		return fmt.Errorf("max_age and max_entries must not be negative")
+	}
+	return nil
+}
+
+// SetRetention makes every later Save compact the entries beyond r
+func (h *History) SetRetention(r HistoryRetention) {
+	h.retention = r
+}
+
+// compact applies the retention policy. Entries beyond it lose their votes;
+// upgrades and reboots are then recorded by key alone and removed, along
+// with their lifecycle. Lifecycles of actions that never completed are
+// removed once they are older than max_age. It returns the number of
+// entries removed.
+func (h *History) compact(now time.Time) int {
+	if !h.retention.Enabled() {
+		return 0
+	}
+
+	keys := make([]string, 0, len(h.Entries))
+	for key := range h.Entries {
+		keys = append(keys, key)
+	}
+	// Newest first, so max_entries keeps the most recent
+	slices.SortFunc(keys, func(a, b string) int {
+		if c := strings.Compare(h.Entries[b].PerformedAt, h.Entries[a].PerformedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+
+	removed := 0
+	for i, key := range keys {
+		entry := h.Entries[key]
+		if !h.beyondRetention(i, entry.PerformedAt, now) {
+			continue
+		}
+		if _, _, ok := compactionTrack(key); !ok {
+			entry.Votes = nil
+			h.Entries[key] = entry
+			continue
+		}
+		if i, found := slices.BinarySearch(h.Tombstones, key); !found {
+			h.Tombstones = slices.Insert(h.Tombstones, i, key)
+		}
+		delete(h.Entries, key)
+		delete(h.Lifecycle, key)
+		removed++
+	}
+
+	if h.retention.MaxAge > 0 {
+		for key, lc := range h.Lifecycle {
+			if h.Has(key) || lc.State == stateExecuting || lc.State == stateVerifying {
+				continue
+			}
+			if at, err := time.Parse(time.RFC3339, lc.UpdatedAt); err == nil && now.Sub(at) > h.retention.MaxAge {
+				delete(h.Lifecycle, key)
+			}
+		}
+	}
+	if removed > 0 {
+		log.Printf("[INFO] Compacted %d history entries beyond history_retention", removed)
+	}
+	return removed
+}
+
+// beyondRetention reports whether the entry at index i, counting from the
+// newest, performed at performedAt falls outside the retention policy.
+// Entries with an unreadable time are kept.
+func (h *History) beyondRetention(i int, performedAt string, now time.Time) bool {
+	if h.retention.MaxEntries > 0 && i >= h.retention.MaxEntries {
+		return true
+	}
+	if h.retention.MaxAge <= 0 {
+		return false
+	}
+	at, err := time.Parse(time.RFC3339, performedAt)
+	return err == nil && now.Sub(at) > h.retention.MaxAge
+}
+
+// compacted reports whether key was compacted out of the entries, or is an
+// upgrade or reboot at or below the highest version earlier releases
+// compacted for its network, type, and service
+func (h *History) compacted(key string) bool {
+	if _, found := slices.BinarySearch(h.Tombstones, key); found {
+		return true
+	}
+	if len(h.Compacted) == 0 {
+		return false
+	}
+	track, v, ok := compactionTrack(key)
+	if !ok {
+		return false
+	}
+	highest, err := semver.NewVersion(h.Compacted[track])
+	return err == nil && !v.GreaterThan(highest)
+}
+
+// rolledBack lowers the versions compacted by earlier releases on the
+// network of a performed rollback to the version it returned to, so an
+// upgrade above it signaled after the rollback is not taken as performed.
+// Upgrades signaled before the rollback stay superseded by it.
+func (h *History) rolledBack(key string) {
+	network, rest := splitNetworkKey(key)
+	version, ok := strings.CutPrefix(rest, signal.TypeRollback+":")
+	if !ok || len(h.Compacted) == 0 {
+		return
+	}
+	if end := strings.IndexAny(version, "@#:"); end >= 0 {
+		version = version[:end]
+	}
+	to, err := semver.NewVersion(version)
+	if err != nil {
+		return
+	}
+	for track, highest := range h.Compacted {
+		trackNetwork, _ := splitNetworkKey(track + ":")
+		if v, err := semver.NewVersion(highest); trackNetwork == network && err == nil && v.GreaterThan(to) {
+			log.Printf("[INFO] Lowering compacted %s from %s to %s after the rollback", track, highest, to.Original())
+			h.Compacted[track] = to.Original()
+		}
+	}
+}
+
+// splitNetworkKey splits the network prefix off an action key
+func splitNetworkKey(key string) (network, rest string) {
+	if slash := strings.Index(key, "/"); slash >= 0 && slash < strings.Index(key, ":") {
+		return key[:slash], key[slash+1:]
+	}
+	return "", key
+}
+
+// compactionTrack returns the network, type, and service of an upgrade or
+// reboot key, e.g. "hyperqube-mainnet/upgrade#service:zenon", and its
+// version. Rollbacks and key revocations have no track: a rollback to a low
+// version must not mark later rollbacks as performed.
+func compactionTrack(key string) (string, *semver.Version, bool) {
+	network, rest := splitNetworkKey(key)
+	typ, rest, _ := strings.Cut(rest, ":")
+	if typ != signal.TypeUpgrade && typ != signal.TypeReboot {
+		return "", nil, false
+	}
+	version := rest
+	if end := strings.IndexAny(rest, "@#:"); end >= 0 {
+		version = rest[:end]
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return "", nil, false
+	}
+	track := signal.NetworkKey(network, typ)
+	if typ == signal.TypeUpgrade {
+		if i := strings.LastIndex(rest, "#service:"); i >= 0 {
+			track += rest[i:]
+		}
+	}
+	return track, v, true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// revokeQuorum returns the number of follows that must sign a revocation:
+// the configured revoke_quorum, or a two-thirds super-majority by default
+func revokeQuorum(cfg Config) int {
+	if cfg.RevokeQuorum > 0 {
+		return cfg.RevokeQuorum
+	}
+	return (2*len(cfg.Follows) + 2) / 3
+}
+
+// revokedKeys returns the hex pubkeys revoked by revocations recorded in history
+func revokedKeys(history *History) map[string]bool {
+	revoked := make(map[string]bool)
+	for key := range history.Entries {
+		if !strings.HasPrefix(key, signal.RevokeKeyPrefix) {
+			continue
+		}
+		npub := strings.TrimPrefix(key, signal.RevokeKeyPrefix)
+		if _, pk, err := nip19.Decode(npub); err == nil {
+			revoked[pk.(string)] = true
+		}
+	}
+	return revoked
+}
+
+// activeFollows returns the hex pubkeys of follows that have not been revoked
+func activeFollows(cfg Config, revoked map[string]bool) []string {
+	var active []string
+	for _, pk := range decodeFollows(cfg.Follows) {
+		if revoked[pk] {
+			log.Printf("[INFO] Ignoring revoked follow %s", pk)
+			continue
+		}
+		active = append(active, pk)
+	}
+	return active
+}
+
+// applyRevocations records every revoke-key action signed by a super-majority
+// of follows, adds the target to revoked, and removes the target's votes from
+// all other candidates. The target's own vote never counts toward its
+// revocation. In dry-run mode revocations only take effect in memory. It
+// returns the keys of newly applied revocations.
+func applyRevocations(cfg Config, actions map[string]*signal.Action, votes map[string]map[string]signal.Vote, history *History, revoked map[string]bool, dryRun bool) []string {
+	required := revokeQuorum(cfg)
+	var applied []string
+
+	for key, a := range actions {
+		if a.Type != "revoke-key" || history.Has(key) {
+			continue
+		}
+
+		count := 0
+		for pk := range votes[key] {
+			if pk != a.Target && !revoked[pk] {
+				count++
+			}
+		}
+		if count < required {
+			log.Printf("[INFO] Skipping revocation %s - votes %d/%d (below super-majority)", key, count, required)
+			continue
+		}
+
+		log.Printf("[WARN] Revoking signer key %s - signed by %d/%d follows", a.Target, count, required)
+		revoked[a.Target] = true
+		if !dryRun {
+			history.Add(key, votes[key])
+		}
+		applied = append(applied, key)
+	}
+
+	if len(applied) == 0 {
+		return nil
+	}
+
+	// Invalidate pending votes from revoked keys
+	for key, vset := range votes {
+		for pk := range vset {
+			if revoked[pk] {
+				log.Printf("[INFO] Dropping vote for %s from revoked key %s", key, pk)
+				delete(vset, pk)
+			}
+		}
+	}
+
+	if dryRun {
+		log.Println("[INFO] Dry run - not saving revocations to history.")
+	} else if err := history.Save(); err != nil {
+		log.Printf("[WARN] Error saving history after revocation: %v", err)
+	}
+	return applied
+}
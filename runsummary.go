@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultRunSummaryTimeout bounds posting a run summary
+const defaultRunSummaryTimeout = 10 * time.Second
+
+// RunSummaryConfig posts the summary of every run, also written to
+// last_run.json in the state dir, to an HTTP endpoint, e.g. for a scheduler
+// that retries sooner after a failed run
+type RunSummaryConfig struct {
+	URL           string        `yaml:"url,omitempty"`           // Endpoint the summary is POSTed to as JSON (none if unset)
+	Authorization string        `yaml:"authorization,omitempty"` // Authorization header value, if any
+	Timeout       time.Duration `yaml:"timeout,omitempty"`       // Bound on a single POST (default 10s)
+}
+
+// applyDefaults fills in unset run summary settings
+func (c *RunSummaryConfig) applyDefaults() {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultRunSummaryTimeout
+	}
+}
+
+// Validate checks the endpoint URL
+func (c RunSummaryConfig) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	if u, err := url.ParseRequestURI(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid url %q (expected an http or https URL)", c.URL)
+	}
+	return nil
+}
+
+// RunSummary is the machine-readable outcome of one run
+type RunSummary struct {
+	RunID           string      `json:"run_id"`                 // ID of the manager process, as in its logs
+	Started         string      `json:"started"`                // ISO8601 time the run began
+	Finished        string      `json:"finished"`               // ISO8601 time the run ended
+	DurationMillis  int64       `json:"duration_ms"`            // How long the run took
+	Status          string      `json:"status"`                 // One of the status* constants
+	ExitCode        int         `json:"exit_code"`              // Exit code of a single run with this status
+	Action          string      `json:"action,omitempty"`       // Key of the selected action, if any
+	ActionID        string      `json:"action_id,omitempty"`    // ID of the selected action, as in logs and events
+	NodeVersion     string      `json:"node_version,omitempty"` // Detected node version
+	RelaysContacted int         `json:"relays_contacted"`       // Relays polled
+	RelaysConnected int         `json:"relays_connected"`       // Relays that accepted a subscription
+	EventsSeen      int         `json:"events"`                 // Events received from the relays
+	EventsDropped   int         `json:"events_dropped"`         // Events not processed because a limit was reached
+	Candidates      int         `json:"candidates"`             // Candidate actions tallied
+	Votes           int         `json:"votes"`                  // Votes cast for the candidates
+	Signers         int         `json:"signers"`                // Distinct signers that voted
+	ActionsPending  int         `json:"actions_pending"`        // Candidates not yet in history
+	Relays          []RelayPoll `json:"relays,omitempty"`       // Outcome of each relay poll
+}
+
+// newRunSummary summarizes a run ending now
+func newRunSummary(r *RunResult) RunSummary {
+	s := RunSummary{
+		RunID:           runID,
+		Started:         r.Started.UTC().Format(time.RFC3339),
+		Finished:        time.Now().UTC().Format(time.RFC3339),
+		DurationMillis:  time.Since(r.Started).Milliseconds(),
+		Status:          r.LastStatus,
+		ExitCode:        exitCode(r.LastStatus),
+		Action:          r.LastAction,
+		NodeVersion:     r.NodeVersion,
+		RelaysContacted: len(r.Polls),
+		RelaysConnected: r.RelaysConnected,
+		EventsSeen:      r.EventsSeen,
+		EventsDropped:   r.EventsDropped,
+		Candidates:      r.Candidates,
+		Votes:           r.Votes,
+		Signers:         r.Signers,
+		ActionsPending:  r.ActionsPending,
+		Relays:          r.Polls,
+	}
+	if r.LastAction != "" {
+		s.ActionID = actionCorrelationID(r.LastAction)
+	}
+	return s
+}
+
+// lastRunPath returns the location of the latest run summary
+func lastRunPath(stateDir string) string {
+	return filepath.Join(stateDir, "last_run.json")
+}
+
+// exportRunSummary writes the summary of a finished run to last_run.json,
+// replaced atomically so readers never see a partial file, and posts it to
+// the configured endpoint
+func exportRunSummary(cfg Config, r *RunResult) {
+	data, err := json.MarshalIndent(newRunSummary(r), "", "  ")
+	if err != nil {
+		log.Printf("[WARN] Failed to encode run summary: %v", err)
+		return
+	}
+
+	path := lastRunPath(cfg.StatePath)
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		log.Printf("[WARN] Failed to write run summary %s: %v", path, err)
+	}
+
+	if cfg.RunSummary.URL == "" {
+		return
+	}
+	if err := postRunSummary(cfg.RunSummary, data); err != nil {
+		log.Printf("[WARN] Failed to post run summary to %s: %v", cfg.RunSummary.URL, err)
+		return
+	}
+	log.Printf("[INFO] Run summary posted to %s", cfg.RunSummary.URL)
+}
+
+// postRunSummary POSTs the JSON summary and fails unless the endpoint
+// answers with a 2xx status
+func postRunSummary(c RunSummaryConfig, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Authorization != "" {
+		req.Header.Set("Authorization", c.Authorization)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary
+// file in the same directory
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"gopkg.in/yaml.v3"
+)
+
+// ScheduledAction records when a selected action becomes due on this node
+type ScheduledAction struct {
+	ExecuteAt string `yaml:"execute_at"` // RFC3339 time the action may run
+	Announced bool   `yaml:"announced"`  // Whether the "executing at" status event was published
+}
+
+// Time returns the parsed execution time
+func (a *ScheduledAction) Time() time.Time {
+	t, _ := time.Parse(time.RFC3339, a.ExecuteAt)
+	return t
+}
+
+// Schedule persists when selected actions are due so staggered or deferred
+// executions are honored across runs
+type Schedule struct {
+	Entries map[string]*ScheduledAction `yaml:"entries"` // key: action key
+	path    string                      // schedule file path (not in YAML)
+}
+
+// loadSchedule reads the schedule file, returning an empty schedule if missing
+func loadSchedule(stateDir string) *Schedule {
+	s := &Schedule{
+		Entries: make(map[string]*ScheduledAction),
+		path:    filepath.Join(stateDir, "schedule.yaml"),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s
+	} else if err != nil {
+		log.Fatalf("[ERROR] Failed to read schedule file %s: %v", s.path, err)
+	}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		log.Fatalf("[ERROR] Failed to parse schedule file %s: %v", s.path, err)
+	}
+	if s.Entries == nil {
+		s.Entries = make(map[string]*ScheduledAction)
+	}
+	return s
+}
+
+// Save writes the schedule back to the YAML file
+func (s *Schedule) Save() error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// Get returns the schedule entry for an action, creating one due at the
+// node's staggered execution time if the action was not scheduled yet. An
+// action whose signers announced a different executeAt since it was
+// scheduled, or a notBefore later than the scheduled time, is rescheduled,
+// and announced again.
+func (s *Schedule) Get(action *signal.Action, npub string, maxStagger time.Duration) *ScheduledAction {
+	at := executionTime(action, npub, maxStagger, time.Now())
+	entry, ok := s.Entries[action.Key]
+	// The zero time leaves only notBefore and the stagger offset, the
+	// earliest the entry may be due
+	early := entry != nil && entry.Time().Before(executionTime(action, npub, maxStagger, time.Time{}))
+	if ok && !early && (action.ExecuteAt.IsZero() || at.Equal(entry.Time())) {
+		return entry
+	}
+
+	entry = &ScheduledAction{ExecuteAt: at.UTC().Format(time.RFC3339)}
+	s.Entries[action.Key] = entry
+	if early && action.ExecuteAt.IsZero() {
+		log.Printf("[INFO] Rescheduled action %s for %s after its signers moved notBefore", action.Key, entry.ExecuteAt)
+	} else if ok {
+		log.Printf("[INFO] Rescheduled action %s for %s announced by its signers", action.Key, entry.ExecuteAt)
+	} else {
+		log.Printf("[INFO] Scheduled action %s for %s", action.Key, entry.ExecuteAt)
+	}
+	return entry
+}
+
+// Remove drops an action from the schedule once it has been performed
+func (s *Schedule) Remove(key string) {
+	delete(s.Entries, key)
+}
+
+// Prune drops entries for actions that have since been recorded in history
+func (s *Schedule) Prune(history *History) {
+	for key := range s.Entries {
+		if history.Has(key) {
+			delete(s.Entries, key)
+		}
+	}
+}
+
+// staggerDelay returns this node's deterministic offset within maxStagger,
+// derived from hash(npub + action key) so nodes spread out evenly while each
+// node always picks the same slot for a given action
+func staggerDelay(npub, key string, maxStagger time.Duration) time.Duration {
+	seconds := uint64(maxStagger / time.Second)
+	if seconds == 0 {
+		return 0
+	}
+	sum := sha256.Sum256([]byte(npub + key))
+	return time.Duration(binary.BigEndian.Uint64(sum[:8])%seconds) * time.Second
+}
+
+// executionTime returns when the action may run: the later of now and the
+// signers' notBefore, plus this node's stagger offset. Actions announced
+// with executeAt run at that time on every node, without stagger, unless
+// notBefore is later; an executeAt already past when quorum is reached means
+// right away.
+func executionTime(action *signal.Action, npub string, maxStagger time.Duration, now time.Time) time.Time {
+	if !action.ExecuteAt.IsZero() {
+		at := action.ExecuteAt
+		if action.NotBefore.After(at) {
+			at = action.NotBefore
+		}
+		return at.Truncate(time.Second)
+	}
+
+	start := now
+	if action.NotBefore.After(start) {
+		start = action.NotBefore
+	}
+	return start.Add(staggerDelay(npub, action.Key, maxStagger)).Truncate(time.Second)
+}
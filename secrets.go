@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config files may be committed to git with secrets encrypted for age
+// recipients: config.yaml values as armored age ciphertext, or config.yaml
+// and keys.json as a whole with age or SOPS. They are decrypted on load with
+// the age identity file, through the age and sops command line tools.
+const (
+	ageArmorHeader  = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageValueTag     = "!age"                      // YAML tag marking an encrypted value
+	ageIdentityEnv  = "QUBE_MANAGER_AGE_IDENTITY" // Overrides the identity file path
+	ageIdentityFile = "age.key"                   // Identity file in the config dir
+)
+
+// ageIdentity returns the age identity file used to decrypt config files:
+// the file named by $QUBE_MANAGER_AGE_IDENTITY, or age.key in configDir if it
+// exists, or "" if there is none
+func ageIdentity(configDir string) string {
+	if path := os.Getenv(ageIdentityEnv); path != "" {
+		return path
+	}
+	path := filepath.Join(configDir, ageIdentityFile)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	return ""
+}
+
+// readConfigData returns config.yaml with every encrypted value decrypted.
+// Errors do not name the file; callers add it.
+func readConfigData(configDir string) ([]byte, error) {
+	path := filepath.Join(configDir, "config.yaml")
+	data, err := readSecretFile(path, configDir)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(data, []byte(ageArmorHeader)) {
+		return data, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := decryptValues(&doc, configDir); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(&doc)
+}
+
+// readSecretFile returns the contents of path, decrypted if the whole file
+// was encrypted with age or SOPS
+func readSecretFile(path, configDir string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte(ageArmorHeader)):
+		return ageDecrypt(data, configDir)
+	case isSOPSFile(data):
+		return sopsDecrypt(path, configDir)
+	}
+	return data, nil
+}
+
+// isSOPSFile reports whether data is a YAML or JSON document encrypted by
+// SOPS, which keeps its metadata in a top-level "sops" key
+func isSOPSFile(data []byte) bool {
+	var doc map[string]any
+	if yaml.Unmarshal(data, &doc) != nil {
+		return false
+	}
+	_, ok := doc["sops"]
+	return ok
+}
+
+// decryptValues replaces every scalar holding age ciphertext, tagged !age or
+// recognized by its armor, with its plaintext. The plaintext is YAML, so a
+// whole list or mapping can be encrypted as one value.
+func decryptValues(node *yaml.Node, configDir string) error {
+	if isAgeValue(node) {
+		plain, err := ageDecrypt([]byte(node.Value), configDir)
+		if err != nil {
+			return fmt.Errorf("encrypted value on line %d: %w", node.Line, err)
+		}
+		var value yaml.Node
+		if err := yaml.Unmarshal(plain, &value); err != nil {
+			return fmt.Errorf("encrypted value on line %d is not valid YAML: %w", node.Line, err)
+		}
+		if len(value.Content) == 0 {
+			*node = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Line: node.Line}
+			return nil
+		}
+		line := node.Line
+		*node = *value.Content[0]
+		node.Line = line
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := decryptValues(child, configDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isAgeValue reports whether node is a scalar holding age ciphertext, tagged
+// !age or recognized by its armor
+func isAgeValue(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && (node.Tag == ageValueTag || strings.HasPrefix(strings.TrimSpace(node.Value), ageArmorHeader))
+}
+
+// ageDecrypt decrypts armored age ciphertext with the age identity file
+func ageDecrypt(ciphertext []byte, configDir string) ([]byte, error) {
+	identity := ageIdentity(configDir)
+	if identity == "" {
+		return nil, fmt.Errorf("encrypted with age but no identity file found (set %s or create %s)", ageIdentityEnv, filepath.Join(configDir, ageIdentityFile))
+	}
+	cmd := exec.Command("age", "--decrypt", "--identity", identity)
+	cmd.Stdin = bytes.NewReader(ciphertext)
+	return runAgeTool(cmd)
+}
+
+// ageEncrypt encrypts plaintext to the recipient of the age identity file,
+// armored like the files ageDecrypt reads
+func ageEncrypt(plaintext []byte, configDir string) ([]byte, error) {
+	identity := ageIdentity(configDir)
+	if identity == "" {
+		return nil, fmt.Errorf("no age identity file found (set %s or create %s)", ageIdentityEnv, filepath.Join(configDir, ageIdentityFile))
+	}
+	cmd := exec.Command("age", "--encrypt", "--armor", "--identity", identity)
+	cmd.Stdin = bytes.NewReader(plaintext)
+	return runAgeTool(cmd)
+}
+
+// sopsDecrypt decrypts a SOPS file, handing it the age identity file if
+// there is one; otherwise SOPS falls back to its own key sources
+func sopsDecrypt(path, configDir string) ([]byte, error) {
+	format := "yaml"
+	if filepath.Ext(path) == ".json" {
+		format = "json"
+	}
+	cmd := exec.Command("sops", "--decrypt", "--input-type", format, "--output-type", format, path)
+	if identity := ageIdentity(configDir); identity != "" {
+		cmd.Env = append(os.Environ(), "SOPS_AGE_KEY_FILE="+identity)
+	}
+	return runAgeTool(cmd)
+}
+
+// runDecrypt runs a decryption tool and returns its output, reporting what
+// it printed to stderr on failure
+func runAgeTool(cmd *exec.Cmd) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", cmd.Args[0], err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	return out, nil
+}
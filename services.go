@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/hypercore-one/qube-manager/signal"
+)
+
+// validateServices checks the backends of co-hosted services. The hyperqube
+// node itself is configured under executor.
+func validateServices(cfg Config) []configProblem {
+	var problems []configProblem
+	add := func(field, format string, args ...any) {
+		problems = append(problems, configProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if len(cfg.Services) > 0 && cfg.Fleet.Enabled() {
+		add("services", "are not supported in fleet mode")
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Services)) {
+		field := "services." + name
+		ex := cfg.Services[name]
+		switch {
+		case name == signal.DefaultService:
+			add(field, "the %s node is configured under executor", signal.DefaultService)
+		case !signal.ValidService(name):
+			add(field, "invalid service name (lowercase letters, digits, dashes, and underscores)")
+		case ex.Type == "":
+			add(field+".type", "is required")
+		case ex.Type == "helper":
+			add(field+".type", "the executor helper only performs actions for the %s node", signal.DefaultService)
+		default:
+			if _, err := newExecutor(ex); err != nil {
+				add(field, "%v", err)
+			}
+		}
+	}
+	return problems
+}
+
+// newServiceExecutors returns the backend of every configured service.
+// Services whose backend cannot run on this platform are left out with a
+// warning, so their actions are never executed.
+func newServiceExecutors(cfg Config) (map[string]Executor, error) {
+	services := make(map[string]Executor)
+	for name, ex := range cfg.Services {
+		executor, err := newExecutor(ex)
+		if isUnsupportedPlatform(err) {
+			log.Printf("[WARN] Service %s: %v - its actions will not be executed", name, err)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("service %s: %w", name, err)
+		}
+		services[name] = executor
+	}
+	return services, nil
+}
+
+// dropUnconfiguredServices removes candidates for services this node does
+// not host from the evaluator, so they are neither executed nor recorded.
+// Unless executors is nil, as when only observing, candidates for services
+// whose backend could not be set up are removed too: recording them would
+// report an action done that never ran.
+func dropUnconfiguredServices(e *signal.Evaluator, services map[string]ExecutorConfig, executors map[string]Executor, history signal.History) {
+	for key, a := range e.Actions {
+		if a.Service == "" || history.Has(key) {
+			continue
+		}
+		if _, ok := services[a.Service]; !ok {
+			log.Printf("[INFO] Ignoring action %s for service %s, which is not configured in services", key, a.Service)
+			e.Drop(key)
+		} else if _, ok := executors[a.Service]; executors != nil && !ok {
+			log.Printf("[WARN] Ignoring action %s for service %s, whose executor cannot run on this host", key, a.Service)
+			e.Drop(key)
+		}
+	}
+}
+
+// executorFor returns the backend and its settings that perform action: the
+// configured service's, or the node executor's for the hyperqube node
+func (m *Manager) executorFor(action *signal.Action) (Executor, ExecutorConfig) {
+	if action.Service == "" {
+		return m.executor, m.config.Executor
+	}
+	return m.services[action.Service], m.config.Services[action.Service]
+}
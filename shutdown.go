@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ShutdownHandler coordinates graceful termination on SIGINT/SIGTERM.
+// Once a signal arrives its context is cancelled so no new events are
+// accepted, and in-flight work gets up to the grace period to finish.
+type ShutdownHandler struct {
+	ctx         context.Context    // cancelled when a termination signal is received
+	cancel      context.CancelFunc // cancels ctx
+	grace       time.Duration      // how long in-flight work may run after a signal
+	inflight    sync.WaitGroup     // tracks running actions and pending publishes
+	requested   atomic.Bool        // set once a termination signal was received
+	interrupted atomic.Bool        // set when in-flight work was abandoned
+
+	mu      sync.Mutex
+	flushes []func() // run before a forced exit, e.g. to persist history
+}
+
+// newShutdownHandler installs the signal handler and returns a handler whose
+// context is cancelled on the first SIGINT or SIGTERM.
+func newShutdownHandler(grace time.Duration) *ShutdownHandler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &ShutdownHandler{
+		ctx:    ctx,
+		cancel: cancel,
+		grace:  grace,
+	}
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go s.watch(sigCh)
+
+	return s
+}
+
+// Context returns a context that is cancelled when shutdown is requested
+func (s *ShutdownHandler) Context() context.Context {
+	return s.ctx
+}
+
+// Requested reports whether a termination signal has been received
+func (s *ShutdownHandler) Requested() bool {
+	return s.requested.Load()
+}
+
+// Interrupted reports whether in-flight work was abandoned during shutdown
+func (s *ShutdownHandler) Interrupted() bool {
+	return s.interrupted.Load()
+}
+
+// Track marks a unit of work as in flight; the returned func marks it done
+func (s *ShutdownHandler) Track(name string) func() {
+	s.inflight.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.inflight.Done()
+			if s.Requested() {
+				log.Printf("[INFO] In-flight work finished during shutdown: %s", name)
+			}
+		})
+	}
+}
+
+// OnFlush registers a function that is run before a forced exit
+func (s *ShutdownHandler) OnFlush(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushes = append(s.flushes, fn)
+}
+
+// Wait blocks until all tracked work is done or the timeout expires.
+// It returns false if work was still in flight when the timeout expired.
+func (s *ShutdownHandler) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// flush runs all registered flush functions
+func (s *ShutdownHandler) flush() {
+	s.mu.Lock()
+	fns := append([]func(){}, s.flushes...)
+	s.mu.Unlock()
+
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// watch waits for a termination signal, cancels the context, and enforces
+// the grace period for in-flight work. A second signal forces an exit.
+func (s *ShutdownHandler) watch(sigCh <-chan os.Signal) {
+	sig := <-sigCh
+	s.requested.Store(true)
+	backgroundLog.Printf("[WARN] Received %s, no longer accepting new events (grace period %v)", sig, s.grace)
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		// Main flow finishes on its own and exits normally
+		return
+	case sig := <-sigCh:
+		backgroundLog.Printf("[ERROR] Received second %s, forcing exit with work still in flight", sig)
+	case <-time.After(s.grace):
+		backgroundLog.Printf("[ERROR] Grace period of %v expired with work still in flight", s.grace)
+	}
+
+	s.interrupted.Store(true)
+	s.flush()
+	os.Exit(exitInterrupted)
+}
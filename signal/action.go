@@ -0,0 +1,154 @@
+// Package signal parses upgrade, reboot, and key revocation signals published
+// by followed signers and evaluates which action has reached quorum.
+package signal
+
+import (
+	"slices"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Action types carried by signals
+const (
+	TypeUpgrade   = "upgrade"
+	TypeReboot    = "reboot"
+	TypeRollback  = "rollback"
+	TypeRevokeKey = "revoke-key"
+)
+
+// Severities of upgrade, reboot, and rollback signals. Nodes may take a
+// faster path for security and critical actions, as their config allows.
+const (
+	SeverityRoutine  = "routine"
+	SeveritySecurity = "security"
+	SeverityCritical = "critical"
+)
+
+// severities lists the severities from lowest to highest
+var severities = []string{SeverityRoutine, SeveritySecurity, SeverityCritical}
+
+// ValidSeverity reports whether s names a severity
+func ValidSeverity(s string) bool {
+	return SeverityRank(s) >= 0
+}
+
+// SeverityRank orders severities from routine (0) to critical, -1 if s
+// names none
+func SeverityRank(s string) int {
+	for i, sev := range severities {
+		if s == sev {
+			return i
+		}
+	}
+	return -1
+}
+
+// RevokeKeyPrefix prefixes the keys of revoke-key actions, which double as
+// history keys of applied key revocations
+const RevokeKeyPrefix = "revoke-key:"
+
+// Action holds details of a potential action to perform
+type Action struct {
+	Version *semver.Version // Parsed semantic version (nil for revoke-key)
+	Type    string          // "upgrade", "reboot", "rollback", or "revoke-key"
+	Key     string          // Unique history key
+	Genesis string          // Genesis URL for reboot, empty for upgrade
+	Target  string          // Hex pubkey revoked by a revoke-key action
+	Source  *Source         // Code an upgrade must be built from (nil if unpinned)
+
+	// Binary (upgrade, rollback) or genesis (reboot) to download from the
+	// announced mirrors and check against its hash (nil if none announced)
+	Artifact *Artifact
+
+	// Digest of the container image tagged with the version, checked by
+	// container backends after pulling ("" if none announced)
+	ImageDigest string
+
+	// Cohort of nodes an upgrade targets (empty if it targets every node).
+	// Signals for different cohorts share a key, so a node that upgraded as
+	// a canary never repeats the upgrade for the stable cohort.
+	Cohort string
+
+	// Network or chain the action applies to. It prefixes the key, so
+	// history entries of different networks never collide.
+	Network string
+
+	// Co-hosted service an upgrade or rollback applies to ("" for the
+	// hyperqube node). It suffixes the key, so each service has its own
+	// history and its actions never supersede another service's.
+	Service string
+
+	// How urgent signers consider the action: "routine", "security", or
+	// "critical" (routine if unset, and for revoke-key)
+	Severity string
+
+	NotBefore time.Time // Earliest execution time announced by signers (zero if none)
+	ExecuteAt time.Time // Network-wide activation time announced by signers (zero if none)
+	SignedAt  time.Time // Creation time of the newest signal voting for the action
+}
+
+// Source pins the exact code an upgrade is built from, as signed in the
+// upgrade message
+type Source struct {
+	Repo   string `yaml:"repo,omitempty"` // Git repository URL (executor default if empty)
+	Tag    string `yaml:"tag"`            // Git tag to check out
+	Commit string `yaml:"commit"`         // Full commit hash the tag must resolve to
+}
+
+// Artifact is a file nodes download while executing an action, announced
+// with the mirrors that serve it and its SHA-256 hash
+type Artifact struct {
+	URLs   []string `yaml:"urls" json:"urls"`     // Equivalent download URLs, tried in order
+	SHA256 string   `yaml:"sha256" json:"sha256"` // Lowercase hex SHA-256 of the file
+}
+
+// Vote records the event through which a followed signer supported an
+// action. Sig is the signer's signature over the event ID, or for co-signers
+// of a threshold event their co-signature over the content digest, so the
+// vote can be checked against the event later.
+type Vote struct {
+	EventID  string       `yaml:"event_id" json:"event_id"`                     // ID of the signal event
+	PubKey   string       `yaml:"pubkey" json:"pubkey"`                         // Hex pubkey of the signer
+	Relay    string       `yaml:"relay" json:"relay"`                           // Relay the event was first received from
+	Relays   []string     `yaml:"relays,omitempty" json:"relays,omitempty"`     // Every relay the event was received from
+	Sig      string       `yaml:"sig,omitempty" json:"sig,omitempty"`           // Hex Schnorr signature by the signer
+	Cosigned bool         `yaml:"cosigned,omitempty" json:"cosigned,omitempty"` // Sig is a co-signature over the content digest
+	Event    *SignedEvent `yaml:"event,omitempty" json:"event,omitempty"`       // The signal event as signed, so content, tags, and created_at can be checked too
+}
+
+// SignedEvent is a nostr event in its NIP-01 form, kept with a vote so the
+// vote can be shown to be for its action long after relays dropped the event
+type SignedEvent struct {
+	ID        string     `yaml:"id" json:"id"`
+	PubKey    string     `yaml:"pubkey" json:"pubkey"`
+	CreatedAt int64      `yaml:"created_at" json:"created_at"`
+	Kind      int        `yaml:"kind" json:"kind"`
+	Tags      [][]string `yaml:"tags" json:"tags"`
+	Content   string     `yaml:"content" json:"content"`
+	Sig       string     `yaml:"sig" json:"sig"`
+}
+
+// NewSignedEvent copies ev into its stored form
+func NewSignedEvent(ev *nostr.Event) *SignedEvent {
+	se := &SignedEvent{ID: ev.ID, PubKey: ev.PubKey, CreatedAt: int64(ev.CreatedAt), Kind: ev.Kind, Content: ev.Content, Sig: ev.Sig}
+	for _, tag := range ev.Tags {
+		se.Tags = append(se.Tags, slices.Clone(tag))
+	}
+	return se
+}
+
+// Event returns the stored event as a nostr event, e.g. to check its signature
+func (se *SignedEvent) Event() *nostr.Event {
+	ev := &nostr.Event{ID: se.ID, PubKey: se.PubKey, CreatedAt: nostr.Timestamp(se.CreatedAt), Kind: se.Kind, Content: se.Content, Sig: se.Sig}
+	for _, tag := range se.Tags {
+		ev.Tags = append(ev.Tags, nostr.Tag(slices.Clone(tag)))
+	}
+	return ev
+}
+
+// History reports whether an action has already been performed
+type History interface {
+	Has(key string) bool
+}
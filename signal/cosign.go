@@ -0,0 +1,104 @@
+package signal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// CosigTag is the tag name carrying a co-signature over a signal's content
+const CosigTag = "cosig"
+
+// cosigDomain separates co-signature digests from any other use of the keys
+const cosigDomain = "qube-manager-cosig:"
+
+// CosignDigest returns the message co-signers sign: the SHA-256 of the
+// signal content with a domain prefix. Signing the content rather than the
+// event ID lets signatures be collected before the event is assembled.
+func CosignDigest(content string) [32]byte {
+	return sha256.Sum256([]byte(cosigDomain + content))
+}
+
+// Cosign returns a ["cosig", pubkey, signature] tag co-signing content with
+// the hex secret key sk
+func Cosign(content string, sk string) (nostr.Tag, error) {
+	raw, err := hex.DecodeString(sk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %w", err)
+	}
+	priv, pub := btcec.PrivKeyFromBytes(raw)
+
+	digest := CosignDigest(content)
+	sig, err := schnorr.Sign(priv, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return nostr.Tag{CosigTag, hex.EncodeToString(schnorr.SerializePubKey(pub)), hex.EncodeToString(sig.Serialize())}, nil
+}
+
+// Cosigners returns the distinct pubkeys with a valid signature over the
+// event's content: the author, whose event signature the relay verified, and
+// every valid co-signature tag. Invalid co-signatures are returned as errors
+// alongside the valid signers.
+func Cosigners(ev *nostr.Event) ([]string, []error) {
+	signers := []string{ev.PubKey}
+	seen := map[string]bool{ev.PubKey: true}
+	digest := CosignDigest(ev.Content)
+
+	var errs []error
+	for _, tag := range ev.Tags {
+		if len(tag) < 3 || tag[0] != CosigTag {
+			continue
+		}
+		pk := tag[1]
+		if seen[pk] {
+			continue
+		}
+		if err := verifyCosig(pk, tag[2], digest); err != nil {
+			errs = append(errs, fmt.Errorf("co-signature by %s: %w", pk, err))
+			continue
+		}
+		seen[pk] = true
+		signers = append(signers, pk)
+	}
+	return signers, errs
+}
+
+// cosignature returns the valid co-signature of pubkey carried by ev, if any
+func cosignature(ev *nostr.Event, pubkey string) string {
+	digest := CosignDigest(ev.Content)
+	for _, tag := range ev.Tags {
+		if len(tag) >= 3 && tag[0] == CosigTag && tag[1] == pubkey && verifyCosig(pubkey, tag[2], digest) == nil {
+			return tag[2]
+		}
+	}
+	return ""
+}
+
+// verifyCosig checks a BIP-340 signature by the hex pubkey over digest
+func verifyCosig(pubkey, signature string, digest [32]byte) error {
+	pkBytes, err := hex.DecodeString(pubkey)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	pk, err := schnorr.ParsePubKey(pkBytes)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if !sig.Verify(digest[:], pk) {
+		return fmt.Errorf("signature does not verify")
+	}
+	return nil
+}
@@ -0,0 +1,658 @@
+package signal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Evaluator accumulates candidate actions and the votes cast for them and
+// selects the action that reached quorum. It performs no I/O; callers decide
+// which events to feed it and what to log.
+type Evaluator struct {
+	Actions  map[string]*Action         // Candidate actions keyed by unique history keys
+	Votes    map[string]map[string]Vote // Action key -> pubkey -> vote for this action
+	quorum   int                        // Votes an action needs to be selected
+	cohort   string                     // Cohort of the evaluating node
+	network  string                     // Network of the evaluating node (empty if unset)
+	conflict string                     // How upgrade and reboot candidates that both reached quorum rank
+	roles    map[string]string          // Pubkey -> the only role its votes count for (both if absent)
+
+	authors      map[string]string          // Signal event ID -> pubkey of its author
+	retracted    map[string]bool            // Deletions seen, keyed by deleting pubkey and event ID
+	latest       map[string]*nostr.Event    // Address of an addressable signal -> its newest version
+	deletedUntil map[string]nostr.Timestamp // Address -> creation time up to which its versions were deleted
+
+	latestWins bool                    // Only a signer's newest signal of each type counts
+	newest     map[string]newestSignal // Signer and type (see trackKey) -> newest signal seen
+	withdrawn  []Withdrawal            // Votes withdrawn by newer signals, in order
+
+	maxCandidates int      // Candidates tracked at once (unbounded if 0)
+	history       History  // Performed actions, which do not count toward maxCandidates (none if nil)
+	evicted       []string // Keys of candidates evicted to make room, oldest first
+}
+
+// newestSignal identifies the newest signal of a signer for one type of
+// action, whose vote is the only one counted under SetLatestSignalWins
+type newestSignal struct {
+	CreatedAt nostr.Timestamp // Creation time of the signal event
+	EventID   string          // ID of the signal event
+	Key       string          // Key of the action it votes for
+}
+
+// Withdrawal records a vote withdrawn because its signer published a newer
+// signal for a conflicting action
+type Withdrawal struct {
+	PubKey string // Hex pubkey of the signer
+	Key    string // Key of the action that lost the vote
+	By     string // Key of the action the newer signal votes for
+}
+
+// Conflict policies deciding between upgrade and reboot candidates that
+// reach quorum together
+const (
+	ConflictHighestVersion = "highest_version" // the highest version wins, a reboot at the same version
+	ConflictRebootWins     = "reboot_wins"     // a reboot wins over upgrades, whatever their version
+	ConflictInOrder        = "in_order"        // all are executed, lowest version first
+)
+
+// Follow roles. A quorum needs a vote from a signer able to propose and one
+// able to approve; signers without a role can do both.
+const (
+	RoleProposer = "proposer" // proposes actions, e.g. core developers
+	RoleApprover = "approver" // approves proposed actions, e.g. the pillar council
+)
+
+// NewEvaluator returns an empty evaluator requiring quorum votes per action
+// for a node in cohort, ranking conflicting candidates by the conflict
+// policy (ConflictHighestVersion if empty)
+func NewEvaluator(quorum int, cohort, conflict string) *Evaluator {
+	if conflict == "" {
+		conflict = ConflictHighestVersion
+	}
+	return &Evaluator{
+		Actions:  make(map[string]*Action),
+		Votes:    make(map[string]map[string]Vote),
+		quorum:   quorum,
+		cohort:   cohort,
+		conflict: conflict,
+
+		authors:      make(map[string]string),
+		retracted:    make(map[string]bool),
+		latest:       make(map[string]*nostr.Event),
+		deletedUntil: make(map[string]nostr.Timestamp),
+		newest:       make(map[string]newestSignal),
+	}
+}
+
+// SetRoles restricts signers to a role, keyed by pubkey. Without roles any
+// quorum votes suffice.
+func (e *Evaluator) SetRoles(roles map[string]string) {
+	e.roles = roles
+}
+
+// SetNetwork sets the network of the evaluating node. Signals for another
+// network are rejected with ErrOtherNetwork, and actions of signals naming
+// no network are keyed under this one. Without a network only signals naming
+// none are accepted, so a node never acts on another network's signals.
+func (e *Evaluator) SetNetwork(network string) {
+	e.network = network
+}
+
+// SetLatestSignalWins makes only the newest signal of each signer count per
+// type of action and service. A newer signal for a different action, e.g.
+// v1.3.1 after v1.3.0, withdraws the signer's earlier vote, and an older one
+// arriving later is rejected with ErrWithdrawn, so a stale lower version
+// cannot reach quorum after its signers moved on. Key revocations are never
+// withdrawn.
+func (e *Evaluator) SetLatestSignalWins(enabled bool) {
+	e.latestWins = enabled
+}
+
+// Withdrawn returns the votes withdrawn by newer signals of their signers,
+// in the order they were withdrawn
+func (e *Evaluator) Withdrawn() []Withdrawal {
+	return e.withdrawn
+}
+
+// SetMaxCandidates bounds the number of candidate actions tracked at once,
+// so signals streamed by a misbehaving relay cannot grow the tally without
+// limit. A signal for a new action beyond the limit evicts the candidate
+// below quorum whose newest signal was created longest ago; if every
+// candidate reached quorum, the signal is rejected with ErrCandidateLimit.
+// Zero means unbounded.
+func (e *Evaluator) SetMaxCandidates(n int) {
+	e.maxCandidates = n
+}
+
+// SetHistory sets the actions already performed. Their candidates do not
+// count toward the candidate limit, so old signals still on relays cannot
+// take the room of new ones.
+func (e *Evaluator) SetHistory(history History) {
+	e.history = history
+}
+
+// Evicted returns the keys of the candidates evicted to stay within the
+// candidate limit, oldest first
+func (e *Evaluator) Evicted() []string {
+	return e.evicted
+}
+
+// AddEvent parses the signal carried by ev and records its author's vote.
+// A signer voting for the same action more than once counts once. It returns
+// the action voted for, or an error wrapping ErrInvalidJSON or
+// ErrUnknownMessageType for events that are not signals.
+//
+// relay is the relay ev was received from, recorded in the vote as evidence
+// of where the signal was seen. The returned action lets callers log and
+// record what each event voted for without looking it up again.
+func (e *Evaluator) AddEvent(ev *nostr.Event, relay string) (*Action, error) {
+	return e.add(ev, relay, []string{ev.PubKey})
+}
+
+// AddCosignedEvent parses the signal carried by ev and, if at least quorum
+// trusted signers signed it, records a vote for each of them. Signatures are
+// never combined across events: a single event must carry the threshold.
+func (e *Evaluator) AddCosignedEvent(ev *nostr.Event, relay string, trusted map[string]bool) (*Action, error) {
+	if _, err := Parse(ev.Content); err != nil {
+		return nil, err
+	}
+
+	signers, errs := Cosigners(ev)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	valid := signers[:0]
+	for _, pk := range signers {
+		if trusted[pk] {
+			valid = append(valid, pk)
+		}
+	}
+	if len(valid) < e.quorum {
+		return nil, fmt.Errorf("%w: %d/%d trusted signatures", ErrBelowThreshold, len(valid), e.quorum)
+	}
+	return e.add(ev, relay, valid)
+}
+
+// add records a vote by every signer for the action ev signals. Signals
+// targeting another cohort or network are rejected with ErrOtherCohort or
+// ErrOtherNetwork.
+func (e *Evaluator) add(ev *nostr.Event, relay string, signers []string) (*Action, error) {
+	parsed, err := Parse(ev.Content)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Cohort != "" && parsed.Cohort != e.cohort {
+		return nil, fmt.Errorf("%w: signal targets %q, node is in %q", ErrOtherCohort, parsed.Cohort, e.cohort)
+	}
+	if parsed.Network != "" && e.network == "" {
+		return nil, fmt.Errorf("%w: signal targets %q, node has no network configured", ErrOtherNetwork, parsed.Network)
+	}
+	if parsed.Network != "" && parsed.Network != e.network {
+		return nil, fmt.Errorf("%w: signal targets %q, node is on %q", ErrOtherNetwork, parsed.Network, e.network)
+	}
+	if parsed.Network == "" && e.network != "" && parsed.Type != TypeRevokeKey {
+		parsed.Network, parsed.Key = e.network, NetworkKey(e.network, parsed.Key)
+	}
+	if e.retracted[ev.PubKey+":"+ev.ID] {
+		return nil, fmt.Errorf("%w: signal %s was deleted by its author", ErrRetracted, ev.ID)
+	}
+	if addr := Address(ev); addr != "" {
+		if ev.CreatedAt <= e.deletedUntil[addr] {
+			return nil, fmt.Errorf("%w: signal %s was deleted by its author", ErrRetracted, ev.ID)
+		}
+		if latest, ok := e.latest[addr]; !ok {
+			e.latest[addr] = ev
+		} else if latest.ID != ev.ID {
+			return nil, fmt.Errorf("%w: %s replaces signal %s", ErrSuperseded, latest.ID, ev.ID)
+		}
+	}
+	// Signers who published a newer signal of the same type moved on
+	if e.latestWins && parsed.Type != TypeRevokeKey {
+		var current []string
+		for _, pk := range signers {
+			if !e.outdated(pk, parsed, ev) {
+				current = append(current, pk)
+			}
+		}
+		if len(current) == 0 {
+			return nil, fmt.Errorf("%w: signal %s is older than a conflicting signal by the same signer", ErrWithdrawn, ev.ID)
+		}
+		signers = current
+	}
+	if _, exists := e.Actions[parsed.Key]; !exists && !e.makeRoom() {
+		return nil, fmt.Errorf("%w: %d candidates reached quorum", ErrCandidateLimit, len(e.Actions))
+	}
+
+	e.authors[ev.ID] = ev.PubKey
+
+	action, exists := e.Actions[parsed.Key]
+	if !exists {
+		action = parsed
+		e.Actions[parsed.Key] = action
+	}
+
+	// Signers may disagree on notBefore; honor the latest one
+	if parsed.NotBefore.After(action.NotBefore) {
+		action.NotBefore = parsed.NotBefore
+	}
+	// and on executeAt, where the latest keeps every node waiting for it
+	if parsed.ExecuteAt.After(action.ExecuteAt) {
+		action.ExecuteAt = parsed.ExecuteAt
+	}
+	// and on severity, where the lowest keeps a single signer from
+	// rushing nodes past their safeguards
+	if SeverityRank(parsed.Severity) < SeverityRank(action.Severity) {
+		action.Severity = parsed.Severity
+	}
+	if signedAt := ev.CreatedAt.Time(); signedAt.After(action.SignedAt) {
+		action.SignedAt = signedAt
+	}
+
+	if e.latestWins && action.Type != TypeRevokeKey {
+		for _, pk := range signers {
+			e.supersedeVote(pk, action, ev)
+		}
+	}
+
+	if e.Votes[action.Key] == nil {
+		e.Votes[action.Key] = make(map[string]Vote)
+	}
+	signed := NewSignedEvent(ev)
+	for _, pk := range signers {
+		vote := Vote{EventID: ev.ID, PubKey: pk, Relay: relay, Sig: ev.Sig, Event: signed}
+		if pk != ev.PubKey {
+			vote.Sig, vote.Cosigned = cosignature(ev, pk), true
+		}
+		// The same event delivered by another relay adds the relay
+		if prev, ok := e.Votes[action.Key][pk]; ok && prev.EventID == ev.ID {
+			vote.Relay, vote.Relays = prev.Relay, prev.Relays
+		}
+		if !slices.Contains(vote.Relays, relay) {
+			vote.Relays = append(vote.Relays, relay)
+		}
+		e.Votes[action.Key][pk] = vote
+	}
+	return action, nil
+}
+
+// trackKey identifies the actions among which only a signer's newest signal
+// counts: those of the same type for the same service
+func trackKey(pk string, a *Action) string {
+	return pk + ":" + a.Type + ":" + a.Service
+}
+
+// outdated reports whether pk already published a signal for another action
+// of the same type that is newer than ev. Of two signals created in the same
+// second the lowest event ID wins, as for replaceable events.
+func (e *Evaluator) outdated(pk string, a *Action, ev *nostr.Event) bool {
+	prev, ok := e.newest[trackKey(pk, a)]
+	if !ok || prev.Key == a.Key {
+		return false
+	}
+	return prev.CreatedAt > ev.CreatedAt || (prev.CreatedAt == ev.CreatedAt && prev.EventID < ev.ID)
+}
+
+// supersedeVote records ev as pk's newest signal for actions like a and
+// withdraws pk's vote for the action its previous newest signal voted for.
+// Candidates left without votes are dropped.
+func (e *Evaluator) supersedeVote(pk string, a *Action, ev *nostr.Event) {
+	track := trackKey(pk, a)
+	prev, ok := e.newest[track]
+	if ok && prev.Key == a.Key && prev.CreatedAt > ev.CreatedAt {
+		return
+	}
+	e.newest[track] = newestSignal{CreatedAt: ev.CreatedAt, EventID: ev.ID, Key: a.Key}
+	if !ok || prev.Key == a.Key {
+		return
+	}
+	if _, voted := e.Votes[prev.Key][pk]; !voted {
+		return
+	}
+	delete(e.Votes[prev.Key], pk)
+	if len(e.Votes[prev.Key]) == 0 {
+		e.Drop(prev.Key)
+	}
+	e.withdrawn = append(e.withdrawn, Withdrawal{PubKey: pk, Key: prev.Key, By: a.Key})
+}
+
+// makeRoom evicts the candidate below quorum whose newest signal is the
+// oldest if the candidate limit is reached, not counting performed actions.
+// It returns false if every counted candidate reached quorum.
+func (e *Evaluator) makeRoom() bool {
+	if e.maxCandidates <= 0 {
+		return true
+	}
+	tracked := 0
+	victim := ""
+	for key, a := range e.Actions {
+		if e.history != nil && e.history.Has(key) {
+			continue
+		}
+		tracked++
+		if e.reached(key) {
+			continue
+		}
+		if victim == "" || a.SignedAt.Before(e.Actions[victim].SignedAt) || (a.SignedAt.Equal(e.Actions[victim].SignedAt) && key < victim) {
+			victim = key
+		}
+	}
+	if tracked < e.maxCandidates {
+		return true
+	}
+	if victim == "" {
+		return false
+	}
+	e.Drop(victim)
+	e.evicted = append(e.evicted, victim)
+	return true
+}
+
+// Retract applies a NIP-09 deletion: every vote cast through a signal event
+// the deletion references, by ID or by address, is removed, and the event is
+// ignored if it arrives later. Only the author of a signal can retract it.
+// Candidates left without votes are dropped. It returns the keys of the
+// actions that lost votes.
+func (e *Evaluator) Retract(deletion *nostr.Event) []string {
+	var keys []string
+	for _, tag := range deletion.Tags {
+		if len(tag) < 2 || (tag[0] != "e" && tag[0] != "a") {
+			continue
+		}
+		id := tag[1]
+		if tag[0] == "a" {
+			// An address deletes the versions created up to the deletion,
+			// and only its author can delete it
+			if !strings.Contains(tag[1], ":"+deletion.PubKey+":") {
+				continue
+			}
+			if deletion.CreatedAt > e.deletedUntil[tag[1]] {
+				e.deletedUntil[tag[1]] = deletion.CreatedAt
+			}
+			latest, ok := e.latest[tag[1]]
+			if !ok || latest.CreatedAt > deletion.CreatedAt {
+				continue
+			}
+			id = latest.ID
+		}
+		if author, ok := e.authors[id]; ok && author != deletion.PubKey {
+			continue
+		}
+		e.retracted[deletion.PubKey+":"+id] = true
+		keys = append(keys, e.removeVotes(id)...)
+	}
+	return keys
+}
+
+// Address returns the NIP-33 address of an addressable event, made of its
+// kind, author, and d tag, or "" for any other event
+func Address(ev *nostr.Event) string {
+	if !nostr.IsAddressableKind(ev.Kind) {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s:%s", ev.Kind, ev.PubKey, ev.Tags.GetD())
+}
+
+// Expired reports whether ev carries a NIP-40 expiration tag at or before
+// now. Relays should already drop such events; managers ignore them too.
+func Expired(ev *nostr.Event, now time.Time) bool {
+	tag := ev.Tags.GetFirst([]string{"expiration"})
+	if tag == nil {
+		return false
+	}
+	at, err := strconv.ParseInt(tag.Value(), 10, 64)
+	return err == nil && !now.Before(time.Unix(at, 0))
+}
+
+// Replace records ev as the newest version of its address, so votes cast
+// through an earlier version are removed and the earlier version is rejected
+// with ErrSuperseded if it arrives later. Of two versions the one created
+// last wins, and the lowest event ID breaks ties, as NIP-01 prescribes for
+// replaceable events. Addressable events must pass through Replace before
+// they are added. It returns the keys of the actions that lost votes.
+func (e *Evaluator) Replace(ev *nostr.Event) ([]string, error) {
+	addr := Address(ev)
+	if addr == "" {
+		return nil, nil
+	}
+	latest, ok := e.latest[addr]
+	switch {
+	case !ok:
+		e.latest[addr] = ev
+		return nil, nil
+	case latest.ID == ev.ID:
+		return nil, nil
+	case latest.CreatedAt > ev.CreatedAt || (latest.CreatedAt == ev.CreatedAt && latest.ID < ev.ID):
+		return nil, fmt.Errorf("%w: %s replaces signal %s", ErrSuperseded, latest.ID, ev.ID)
+	}
+	e.latest[addr] = ev
+	return e.removeVotes(latest.ID), nil
+}
+
+// removeVotes removes every vote cast through the signal event id, dropping
+// candidates left without votes. It returns the keys of the actions that
+// lost votes.
+func (e *Evaluator) removeVotes(id string) []string {
+	var keys []string
+	for key, vset := range e.Votes {
+		removed := false
+		for pk, v := range vset {
+			if v.EventID == id {
+				delete(vset, pk)
+				removed = true
+			}
+		}
+		if !removed {
+			continue
+		}
+		keys = append(keys, key)
+		if len(vset) == 0 {
+			e.Drop(key)
+		}
+	}
+	return keys
+}
+
+// Drop removes a candidate action and its votes
+func (e *Evaluator) Drop(key string) {
+	delete(e.Actions, key)
+	delete(e.Votes, key)
+}
+
+// Select returns the action to perform among candidates that reached quorum
+// and are not in history. Rollbacks outrank upgrades and reboots, and
+// candidates superseded by a rollback are never selected. Upgrades and
+// reboots rank by the conflict policy: by default the highest semantic
+// version wins. Ties at the same version go to the candidate with more
+// votes, then to a reboot over an upgrade (a reboot deploys the version as
+// well) unless executing in order, and finally to the lowest key so the
+// choice never depends on map order. Actions for the hyperqube node rank
+// above those of other services, whose versions are not comparable.
+// Revoke-key actions are never selected; callers apply them separately.
+func (e *Evaluator) Select(history History) *Action {
+	var best *Action
+	for _, a := range e.ready(history) {
+		if best == nil || e.less(best, a) {
+			best = a
+		}
+	}
+	return best
+}
+
+// Outranked returns the keys of upgrades and reboots that reached quorum
+// together with the selected action and lost to it under the conflict
+// policy: those at or below its version, which executing it makes obsolete.
+// Upgrades above a reboot that won under ConflictRebootWins stay eligible,
+// and nothing is outranked under ConflictInOrder or by a rollback. Actions
+// of other services are never outranked.
+func (e *Evaluator) Outranked(selected *Action, history History) []string {
+	if selected == nil || selected.Type == TypeRollback || e.conflict == ConflictInOrder {
+		return nil
+	}
+	var keys []string
+	for _, a := range e.ready(history) {
+		if a.Key != selected.Key && a.Type != TypeRollback && a.Service == selected.Service && !a.Version.GreaterThan(selected.Version) {
+			keys = append(keys, a.Key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ready returns the eligible candidates that reached quorum and were not
+// superseded by a rollback
+func (e *Evaluator) ready(history History) []*Action {
+	superseded := e.superseded()
+	var ready []*Action
+	for _, a := range e.Actions {
+		if e.eligible(a, history) && e.reached(a.Key) && !superseded[a.Key] {
+			ready = append(ready, a)
+		}
+	}
+	return ready
+}
+
+// Superseded returns the keys of eligible candidates that a rollback which
+// reached quorum walked back
+func (e *Evaluator) Superseded(history History) []string {
+	var keys []string
+	for key := range e.superseded() {
+		if e.eligible(e.Actions[key], history) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// superseded marks upgrades and reboots to a version above a quorum-reached
+// rollback's target that were last signaled before the rollback. The rolled
+// back release is thereby never reinstalled from stale signals, while fixes
+// announced after the rollback stay eligible. Rollbacks already in history
+// still count so the release stays walked back. A rollback only supersedes
+// actions of its own service.
+func (e *Evaluator) superseded() map[string]bool {
+	marked := make(map[string]bool)
+	for _, r := range e.Actions {
+		if r.Type != TypeRollback || !e.reached(r.Key) {
+			continue
+		}
+		for _, a := range e.Actions {
+			if (a.Type != TypeUpgrade && a.Type != TypeReboot) || a.Service != r.Service {
+				continue
+			}
+			if a.Version.GreaterThan(r.Version) && a.SignedAt.Before(r.SignedAt) {
+				marked[a.Key] = true
+			}
+		}
+	}
+	return marked
+}
+
+// Pending returns the number of candidates not in history, whether or not
+// they reached quorum
+func (e *Evaluator) Pending(history History) int {
+	pending := 0
+	for _, a := range e.Actions {
+		if e.eligible(a, history) {
+			pending++
+		}
+	}
+	return pending
+}
+
+// BelowQuorum returns the keys of eligible candidates lacking votes, or
+// lacking a vote from one of the roles
+func (e *Evaluator) BelowQuorum(history History) []string {
+	var keys []string
+	for _, a := range e.Actions {
+		if e.eligible(a, history) && !e.reached(a.Key) {
+			keys = append(keys, a.Key)
+		}
+	}
+	return keys
+}
+
+// MissingRoles returns the roles no signer voting for the action with key
+// holds, in the order proposer, approver. It is empty without roles.
+func (e *Evaluator) MissingRoles(key string) []string {
+	if len(e.roles) == 0 {
+		return nil
+	}
+	var proposed, approved bool
+	for pk := range e.Votes[key] {
+		switch e.roles[pk] {
+		case RoleProposer:
+			proposed = true
+		case RoleApprover:
+			approved = true
+		default:
+			proposed, approved = true, true
+		}
+	}
+	var missing []string
+	if !proposed {
+		missing = append(missing, RoleProposer)
+	}
+	if !approved {
+		missing = append(missing, RoleApprover)
+	}
+	return missing
+}
+
+// reached reports whether the action with key has quorum votes covering
+// both roles
+func (e *Evaluator) reached(key string) bool {
+	return len(e.Votes[key]) >= e.quorum && len(e.MissingRoles(key)) == 0
+}
+
+// Quorum returns the number of votes an action needs
+func (e *Evaluator) Quorum() int {
+	return e.quorum
+}
+
+// eligible reports whether a is an upgrade, reboot, or rollback not yet
+// performed
+func (e *Evaluator) eligible(a *Action, history History) bool {
+	return a.Type != TypeRevokeKey && !history.Has(a.Key)
+}
+
+// less reports whether a ranks below b in Select's ordering
+func (e *Evaluator) less(a, b *Action) bool {
+	if ra, rb := a.Type == TypeRollback, b.Type == TypeRollback; ra != rb {
+		return rb
+	}
+	// Versions of different services are not comparable; the node goes
+	// first, then services by name
+	if a.Service != b.Service {
+		return b.Service < a.Service
+	}
+	rollback := a.Type == TypeRollback
+	if e.conflict == ConflictRebootWins && !rollback && a.Type != b.Type {
+		return b.Type == TypeReboot
+	}
+	if c := a.Version.Compare(b.Version); c != 0 {
+		// In order, the lowest version goes first
+		if e.conflict == ConflictInOrder && !rollback {
+			return c > 0
+		}
+		return c < 0
+	}
+	if va, vb := len(e.Votes[a.Key]), len(e.Votes[b.Key]); va != vb {
+		return va < vb
+	}
+	if a.Type != b.Type {
+		// In order, the upgrade goes first so the reboot's resync runs on
+		// the final binary
+		if e.conflict == ConflictInOrder {
+			return b.Type == TypeUpgrade
+		}
+		return b.Type == TypeReboot
+	}
+	return strings.Compare(a.Key, b.Key) > 0
+}
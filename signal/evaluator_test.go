@@ -0,0 +1,497 @@
+package signal
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand"
+	"reflect"
+	"slices"
+	"testing"
+	"testing/quick"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// history is a set of performed action keys
+type history map[string]bool
+
+func (h history) Has(key string) bool { return h[key] }
+
+// signal is an event fed to the evaluator in a test case
+type signal struct {
+	id      string
+	pubkey  string
+	content string
+	at      nostr.Timestamp
+}
+
+func (s signal) event() *nostr.Event {
+	return &nostr.Event{ID: s.id, PubKey: s.pubkey, Content: s.content, CreatedAt: s.at, Kind: nostr.KindTextNote}
+}
+
+const (
+	upgrade120  = `{"type":"upgrade","version":"v1.2.0"}`
+	upgrade130  = `{"type":"upgrade","version":"v1.3.0"}`
+	reboot120   = `{"type":"reboot","version":"v1.2.0","genesis":"https://example.com/genesis.json"}`
+	rollback110 = `{"type":"rollback","version":"v1.1.0"}`
+	revokeKey   = `{"type":"revoke-key","pubkey":"npub1sr47j9awvw2xa0m4w770dr2rl7ylzq4xt9k5rel3h4h58sc3mjysx6pj64"}`
+)
+
+func TestEvaluatorQuorum(t *testing.T) {
+	tests := []struct {
+		name    string
+		quorum  int
+		signals []signal
+		history history
+		want    string // key of the selected action, "" for none
+	}{
+		{
+			name:    "below quorum",
+			quorum:  2,
+			signals: []signal{{"e1", "alice", upgrade120, 1}},
+		},
+		{
+			name:    "quorum reached",
+			quorum:  2,
+			signals: []signal{{"e1", "alice", upgrade120, 1}, {"e2", "bob", upgrade120, 2}},
+			want:    "upgrade:v1.2.0",
+		},
+		{
+			name:    "one signer counts once",
+			quorum:  2,
+			signals: []signal{{"e1", "alice", upgrade120, 1}, {"e2", "alice", upgrade120, 2}},
+		},
+		{
+			name:    "same event from two relays counts once",
+			quorum:  2,
+			signals: []signal{{"e1", "alice", upgrade120, 1}, {"e1", "alice", upgrade120, 1}},
+		},
+		{
+			name:    "highest version wins",
+			quorum:  1,
+			signals: []signal{{"e1", "alice", upgrade120, 1}, {"e2", "bob", upgrade130, 2}},
+			want:    "upgrade:v1.3.0",
+		},
+		{
+			name:    "reboot wins a tie at the same version",
+			quorum:  1,
+			signals: []signal{{"e1", "alice", upgrade120, 1}, {"e2", "bob", reboot120, 2}},
+			want:    "reboot:v1.2.0:https://example.com/genesis.json",
+		},
+		{
+			name:    "performed actions are not selected",
+			quorum:  1,
+			signals: []signal{{"e1", "alice", upgrade120, 1}, {"e2", "bob", upgrade130, 2}},
+			history: history{"upgrade:v1.3.0": true},
+			want:    "upgrade:v1.2.0",
+		},
+		{
+			name:    "revoke-key is never selected",
+			quorum:  1,
+			signals: []signal{{"e1", "alice", revokeKey, 1}},
+		},
+		{
+			name:    "rollback outranks upgrades",
+			quorum:  1,
+			signals: []signal{{"e1", "alice", upgrade130, 1}, {"e2", "bob", rollback110, 2}},
+			want:    "rollback:v1.1.0",
+		},
+		{
+			name:    "rollback supersedes upgrades signaled before it",
+			quorum:  1,
+			signals: []signal{{"e1", "alice", upgrade130, 1}, {"e2", "bob", rollback110, 2}},
+			history: history{"rollback:v1.1.0": true},
+		},
+		{
+			name:    "upgrades signaled after a rollback stay eligible",
+			quorum:  1,
+			signals: []signal{{"e1", "bob", rollback110, 1}, {"e2", "alice", upgrade130, 2}},
+			history: history{"rollback:v1.1.0": true},
+			want:    "upgrade:v1.3.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEvaluator(tt.quorum, "stable", "")
+			for _, s := range tt.signals {
+				if _, err := e.AddEvent(s.event(), "wss://relay.example"); err != nil {
+					t.Fatalf("AddEvent(%s): %v", s.id, err)
+				}
+			}
+			got := ""
+			if a := e.Select(tt.history); a != nil {
+				got = a.Key
+			}
+			if got != tt.want {
+				t.Errorf("Select() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluatorLatestSignalWins(t *testing.T) {
+	tests := []struct {
+		name      string
+		signals   []signal
+		wantVotes map[string]int
+		wantErr   error // error of the last signal
+	}{
+		{
+			name:      "newer signal withdraws the earlier vote",
+			signals:   []signal{{"e1", "alice", upgrade120, 1}, {"e2", "alice", upgrade130, 2}},
+			wantVotes: map[string]int{"upgrade:v1.3.0": 1},
+		},
+		{
+			name:      "older signal arriving later is rejected",
+			signals:   []signal{{"e2", "alice", upgrade130, 2}, {"e1", "alice", upgrade120, 1}},
+			wantVotes: map[string]int{"upgrade:v1.3.0": 1},
+			wantErr:   ErrWithdrawn,
+		},
+		{
+			name:      "other signers keep their votes",
+			signals:   []signal{{"e1", "alice", upgrade120, 1}, {"e2", "bob", upgrade120, 1}, {"e3", "alice", upgrade130, 2}},
+			wantVotes: map[string]int{"upgrade:v1.2.0": 1, "upgrade:v1.3.0": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEvaluator(1, "stable", "")
+			e.SetLatestSignalWins(true)
+			var err error
+			for _, s := range tt.signals {
+				_, err = e.AddEvent(s.event(), "wss://relay.example")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("last AddEvent error = %v, want %v", err, tt.wantErr)
+			}
+			checkVotes(t, e, tt.wantVotes)
+		})
+	}
+}
+
+func TestEvaluatorRetract(t *testing.T) {
+	tests := []struct {
+		name      string
+		deletion  *nostr.Event
+		wantVotes map[string]int
+	}{
+		{
+			name:      "author retracts their vote",
+			deletion:  &nostr.Event{PubKey: "alice", Kind: nostr.KindDeletion, Tags: nostr.Tags{{"e", "e1"}}},
+			wantVotes: map[string]int{"upgrade:v1.2.0": 1},
+		},
+		{
+			name:      "others cannot retract a vote",
+			deletion:  &nostr.Event{PubKey: "mallory", Kind: nostr.KindDeletion, Tags: nostr.Tags{{"e", "e1"}}},
+			wantVotes: map[string]int{"upgrade:v1.2.0": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEvaluator(2, "stable", "")
+			for _, s := range []signal{{"e1", "alice", upgrade120, 1}, {"e2", "bob", upgrade120, 2}} {
+				if _, err := e.AddEvent(s.event(), "wss://relay.example"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			e.Retract(tt.deletion)
+			checkVotes(t, e, tt.wantVotes)
+
+			// A retracted signal arriving again stays retracted
+			_, err := e.AddEvent(signal{"e1", "alice", upgrade120, 1}.event(), "wss://other.example")
+			if retracted := tt.wantVotes["upgrade:v1.2.0"] < 2; retracted != errors.Is(err, ErrRetracted) {
+				t.Errorf("AddEvent of the deleted signal: err = %v", err)
+			}
+		})
+	}
+}
+
+func TestEvaluatorTargeting(t *testing.T) {
+	tests := []struct {
+		name    string
+		cohort  string
+		network string
+		content string
+		wantKey string
+		wantErr error
+	}{
+		{
+			name:    "signal for the node's cohort",
+			cohort:  "canary",
+			content: `{"type":"upgrade","version":"v1.2.0","cohort":"canary"}`,
+			wantKey: "upgrade:v1.2.0",
+		},
+		{
+			name:    "signal for another cohort",
+			cohort:  "stable",
+			content: `{"type":"upgrade","version":"v1.2.0","cohort":"canary"}`,
+			wantErr: ErrOtherCohort,
+		},
+		{
+			name:    "signal without cohort targets every node",
+			cohort:  "canary",
+			content: upgrade120,
+			wantKey: "upgrade:v1.2.0",
+		},
+		{
+			name:    "signal for the node's network",
+			cohort:  "stable",
+			network: "hyperqube-mainnet",
+			content: `{"type":"upgrade","version":"v1.2.0","network":"hyperqube-mainnet"}`,
+			wantKey: "hyperqube-mainnet/upgrade:v1.2.0",
+		},
+		{
+			name:    "signal for another network",
+			cohort:  "stable",
+			network: "hyperqube-mainnet",
+			content: `{"type":"upgrade","version":"v1.2.0","network":"hyperqube-testnet"}`,
+			wantErr: ErrOtherNetwork,
+		},
+		{
+			name:    "signal naming a network on a node without one",
+			cohort:  "stable",
+			content: `{"type":"upgrade","version":"v1.2.0","network":"hyperqube-testnet"}`,
+			wantErr: ErrOtherNetwork,
+		},
+		{
+			name:    "signal without network is keyed under the node's",
+			cohort:  "stable",
+			network: "hyperqube-mainnet",
+			content: upgrade120,
+			wantKey: "hyperqube-mainnet/upgrade:v1.2.0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEvaluator(1, tt.cohort, "")
+			e.SetNetwork(tt.network)
+			a, err := e.AddEvent(signal{"e1", "alice", tt.content, 1}.event(), "wss://relay.example")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AddEvent error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && a.Key != tt.wantKey {
+				t.Errorf("key = %q, want %q", a.Key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestEvaluatorCandidateLimit(t *testing.T) {
+	upgrade140 := `{"type":"upgrade","version":"v1.4.0"}`
+	tests := []struct {
+		name      string
+		quorum    int
+		signals   []signal
+		history   history
+		wantVotes map[string]int
+		wantErr   error // error of the last signal
+	}{
+		{
+			name:      "oldest signal is evicted, not the first delivered",
+			quorum:    2,
+			signals:   []signal{{"e1", "alice", upgrade120, 20}, {"e2", "bob", upgrade130, 10}, {"e3", "carol", upgrade140, 30}},
+			wantVotes: map[string]int{"upgrade:v1.2.0": 1, "upgrade:v1.4.0": 1},
+		},
+		{
+			name:      "performed actions do not take room",
+			quorum:    2,
+			signals:   []signal{{"e1", "alice", upgrade120, 10}, {"e2", "bob", upgrade130, 20}, {"e3", "carol", upgrade140, 30}},
+			history:   history{"upgrade:v1.2.0": true},
+			wantVotes: map[string]int{"upgrade:v1.2.0": 1, "upgrade:v1.3.0": 1, "upgrade:v1.4.0": 1},
+		},
+		{
+			name:      "candidates at quorum are never evicted",
+			quorum:    1,
+			signals:   []signal{{"e1", "alice", upgrade120, 10}, {"e2", "bob", upgrade130, 20}, {"e3", "carol", upgrade140, 30}},
+			wantVotes: map[string]int{"upgrade:v1.2.0": 1, "upgrade:v1.3.0": 1},
+			wantErr:   ErrCandidateLimit,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewEvaluator(tt.quorum, "stable", "")
+			e.SetMaxCandidates(2)
+			e.SetHistory(tt.history)
+			var err error
+			for _, s := range tt.signals {
+				_, err = e.AddEvent(s.event(), "wss://relay.example")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("last AddEvent error = %v, want %v", err, tt.wantErr)
+			}
+			checkVotes(t, e, tt.wantVotes)
+		})
+	}
+}
+
+// checkVotes compares the vote count of every candidate with want
+func checkVotes(t *testing.T, e *Evaluator, want map[string]int) {
+	t.Helper()
+	for key, n := range want {
+		if got := len(e.Votes[key]); got != n {
+			t.Errorf("votes for %s = %d, want %d", key, got, n)
+		}
+	}
+	for key := range e.Actions {
+		if _, ok := want[key]; !ok {
+			t.Errorf("unexpected candidate %s with %d vote(s)", key, len(e.Votes[key]))
+		}
+	}
+}
+
+// signalSet is a random batch of signals for property tests: signers vote
+// for upgrades and reboots sharing versions, a rollback, and key revocations,
+// often more than once
+type signalSet struct {
+	signals []signal
+	history history
+}
+
+// propertyContents are the signals a signalSet draws from; the upgrades and
+// reboots at v1.2.0 and v1.3.0 tie on version
+var propertyContents = []string{
+	upgrade120,
+	upgrade130,
+	reboot120,
+	`{"type":"reboot","version":"v1.3.0","genesis":"https://example.com/genesis.json"}`,
+	rollback110,
+	revokeKey,
+}
+
+// Generate implements quick.Generator
+func (signalSet) Generate(r *rand.Rand, size int) reflect.Value {
+	signers := []string{"alice", "bob", "carol", "dave", "erin"}
+	var set signalSet
+	for range 1 + r.Intn(size+1) {
+		pk := signers[r.Intn(len(signers))]
+		c := r.Intn(len(propertyContents))
+		at := nostr.Timestamp(1 + r.Intn(5))
+		set.signals = append(set.signals, signal{fmt.Sprintf("%s-%d-%d", pk, c, at), pk, propertyContents[c], at})
+	}
+	set.history = history{}
+	for _, key := range []string{"upgrade:v1.2.0", "upgrade:v1.3.0", "rollback:v1.1.0"} {
+		if r.Intn(4) == 0 {
+			set.history[key] = true
+		}
+	}
+	return reflect.ValueOf(set)
+}
+
+// evaluate feeds signals to a new evaluator, each from relay, and returns it
+func evaluate(t *testing.T, quorum int, conflict string, signals []signal, relay func(int) string) *Evaluator {
+	t.Helper()
+	e := NewEvaluator(quorum, "stable", conflict)
+	for i, s := range signals {
+		if _, err := e.AddEvent(s.event(), relay(i)); err != nil {
+			t.Fatalf("AddEvent(%s): %v", s.id, err)
+		}
+	}
+	return e
+}
+
+// selected returns the key of the action Select picks, "" for none
+func selected(e *Evaluator, h history) string {
+	if a := e.Select(h); a != nil {
+		return a.Key
+	}
+	return ""
+}
+
+// voteCounts returns the number of votes of every candidate
+func voteCounts(e *Evaluator) map[string]int {
+	counts := make(map[string]int)
+	for key, votes := range e.Votes {
+		counts[key] = len(votes)
+	}
+	return counts
+}
+
+var conflictPolicies = []string{ConflictHighestVersion, ConflictRebootWins, ConflictInOrder}
+
+func oneRelay(int) string { return "wss://relay.example" }
+
+func TestEvaluatorOrderIndependent(t *testing.T) {
+	for _, conflict := range conflictPolicies {
+		t.Run(conflict, func(t *testing.T) {
+			prop := func(set signalSet, seed int64, quorum uint8) bool {
+				q := 1 + int(quorum%3)
+				want := evaluate(t, q, conflict, set.signals, oneRelay)
+
+				shuffled := slices.Clone(set.signals)
+				rand.New(rand.NewSource(seed)).Shuffle(len(shuffled), func(i, j int) {
+					shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+				})
+				got := evaluate(t, q, conflict, shuffled, oneRelay)
+				return selected(got, set.history) == selected(want, set.history) &&
+					maps.Equal(voteCounts(got), voteCounts(want))
+			}
+			if err := quick.Check(prop, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestEvaluatorDuplicatesNeverAddVotes(t *testing.T) {
+	prop := func(set signalSet) bool {
+		e := evaluate(t, 1, "", set.signals, oneRelay)
+
+		// Every event again from another relay, and a second event from
+		// each signer for every action it voted for
+		var dups []signal
+		dups = append(dups, set.signals...)
+		dups = append(dups, set.signals...)
+		for _, s := range set.signals {
+			dups = append(dups, signal{s.id + "-again", s.pubkey, s.content, s.at + 1})
+		}
+		got := evaluate(t, 1, "", dups, func(i int) string { return fmt.Sprintf("wss://relay%d.example", i%3) })
+
+		signers := make(map[string]map[string]bool)
+		for key, votes := range e.Votes {
+			signers[key] = make(map[string]bool)
+			for pk := range votes {
+				signers[key][pk] = true
+			}
+		}
+		for key, votes := range got.Votes {
+			if len(votes) != len(signers[key]) {
+				return false
+			}
+			for pk := range votes {
+				if !signers[key][pk] {
+					return false
+				}
+			}
+		}
+		return maps.Equal(voteCounts(got), voteCounts(e))
+	}
+	if err := quick.Check(prop, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEvaluatorSelectDeterministic(t *testing.T) {
+	for _, conflict := range conflictPolicies {
+		t.Run(conflict, func(t *testing.T) {
+			prop := func(set signalSet, quorum uint8) bool {
+				q := 1 + int(quorum%3)
+				e := evaluate(t, q, conflict, set.signals, oneRelay)
+				want := selected(e, set.history)
+				// Map iteration order differs between calls and evaluators
+				for range 10 {
+					if selected(e, set.history) != want {
+						return false
+					}
+					if selected(evaluate(t, q, conflict, set.signals, oneRelay), set.history) != want {
+						return false
+					}
+				}
+				return true
+			}
+			if err := quick.Check(prop, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
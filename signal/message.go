@@ -0,0 +1,518 @@
+package signal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// UpgradeMessage represents the "upgrade" message type
+type UpgradeMessage struct {
+	Type          string    `json:"type"`                    // Must be "upgrade"
+	SchemaVersion string    `json:"schemaVersion,omitempty"` // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	Version       string    `json:"version"`                 // Semantic version string
+	Repo          string    `json:"repo,omitempty"`          // Git repository to build from (executor default if empty)
+	Tag           string    `json:"tag,omitempty"`           // Git tag to check out (defaults to the version)
+	CommitHash    string    `json:"commitHash,omitempty"`    // Commit the tag must resolve to; required if repo or tag is set
+	Binary        *Artifact `json:"binary,omitempty"`        // Release binary mirrors and hash (optional)
+	ImageDigest   string    `json:"imageDigest,omitempty"`   // Digest of the container image tagged with the version (optional)
+	Cohort        string    `json:"cohort,omitempty"`        // Only nodes in this cohort act on the signal (all nodes if empty)
+	Network       string    `json:"network,omitempty"`       // Only nodes on this network act on the signal (the node's network if empty)
+	Service       string    `json:"service,omitempty"`       // Co-hosted component to upgrade (the hyperqube node if empty)
+	Severity      string    `json:"severity,omitempty"`      // "routine", "security", or "critical" (routine if empty)
+	NotBefore     string    `json:"notBefore,omitempty"`     // RFC3339 time before which nodes must not execute
+	ExecuteAt     string    `json:"executeAt,omitempty"`     // RFC3339 time every node executes at, for a coordinated activation
+	ExtraData     string    `json:"extraData,omitempty"`     // additional metadata or status
+}
+
+// RebootMessage represents the "reboot" message type
+type RebootMessage struct {
+	Type           string   `json:"type"`                     // Must be "reboot"
+	SchemaVersion  string   `json:"schemaVersion,omitempty"`  // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	Version        string   `json:"version"`                  // Semantic version string
+	Genesis        string   `json:"genesis"`                  // URL string
+	GenesisSHA256  string   `json:"genesisSha256,omitempty"`  // SHA-256 of the genesis file (optional)
+	GenesisMirrors []string `json:"genesisMirrors,omitempty"` // Further URLs serving the same genesis; requires genesisSha256
+	ImageDigest    string   `json:"imageDigest,omitempty"`    // Digest of the container image tagged with the version (optional)
+	Network        string   `json:"network,omitempty"`        // Only nodes on this network act on the signal (the node's network if empty)
+	Severity       string   `json:"severity,omitempty"`       // "routine", "security", or "critical" (routine if empty)
+	NotBefore      string   `json:"notBefore,omitempty"`      // RFC3339 time before which nodes must not execute
+	ExecuteAt      string   `json:"executeAt,omitempty"`      // RFC3339 time every node executes at, for a coordinated activation
+	ExtraData      string   `json:"extraData,omitempty"`      // additional metadata or status
+}
+
+// RollbackMessage represents the "rollback" message type, which walks nodes
+// back to an earlier release after a bad one
+type RollbackMessage struct {
+	Type          string    `json:"type"`                    // Must be "rollback"
+	SchemaVersion string    `json:"schemaVersion,omitempty"` // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	Version       string    `json:"version"`                 // Semantic version to revert to
+	Binary        *Artifact `json:"binary,omitempty"`        // Release binary mirrors and hash (optional)
+	ImageDigest   string    `json:"imageDigest,omitempty"`   // Digest of the container image tagged with the version (optional)
+	Network       string    `json:"network,omitempty"`       // Only nodes on this network act on the signal (the node's network if empty)
+	Service       string    `json:"service,omitempty"`       // Co-hosted component to roll back (the hyperqube node if empty)
+	Severity      string    `json:"severity,omitempty"`      // "routine", "security", or "critical" (routine if empty)
+	NotBefore     string    `json:"notBefore,omitempty"`     // RFC3339 time before which nodes must not execute
+	Reason        string    `json:"reason,omitempty"`        // Human-readable explanation
+	ExtraData     string    `json:"extraData,omitempty"`     // additional metadata or status
+}
+
+// RevokeKeyMessage represents the "revoke-key" message type
+type RevokeKeyMessage struct {
+	Type          string `json:"type"`                    // Must be "revoke-key"
+	SchemaVersion string `json:"schemaVersion,omitempty"` // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	PubKey        string `json:"pubkey"`                  // npub of the compromised signer key
+	Reason        string `json:"reason,omitempty"`        // Human-readable explanation
+	ExtraData     string `json:"extraData,omitempty"`     // additional metadata or status
+}
+
+// ErrUnknownMessageType is returned by Parse for well-formed JSON that is not
+// an upgrade, reboot, rollback, or revoke-key message
+var ErrUnknownMessageType = errors.New("unknown message type")
+
+// ErrInvalidJSON is returned by Parse when the content is not JSON at all
+var ErrInvalidJSON = errors.New("content is not valid JSON")
+
+// ErrBelowThreshold is returned for co-signed events that carry fewer
+// trusted signatures than the threshold
+var ErrBelowThreshold = errors.New("not enough signatures")
+
+// ErrOtherCohort is returned for signals targeting a cohort the evaluating
+// node is not in
+var ErrOtherCohort = errors.New("signal targets another cohort")
+
+// ErrOtherNetwork is returned for signals targeting a network the evaluating
+// node is not on
+var ErrOtherNetwork = errors.New("signal targets another network")
+
+// ErrRetracted is returned for signals their author deleted with a NIP-09
+// deletion event
+var ErrRetracted = errors.New("signal retracted")
+
+// ErrSuperseded is returned for addressable signal events (NIP-33) whose
+// author already published a newer version at the same address
+var ErrSuperseded = errors.New("signal superseded by a newer version")
+
+// ErrWithdrawn is returned, when only a signer's latest signal counts, for
+// signals older than a conflicting signal by the same signer
+var ErrWithdrawn = errors.New("vote withdrawn by a newer signal")
+
+// ErrCandidateLimit is returned for signals of a new action when the
+// evaluator tracks as many candidates as it may and all of them reached
+// quorum, so none can be evicted
+var ErrCandidateLimit = errors.New("candidate limit reached")
+
+// Parse parses signal content and returns the action it votes for. Semantic
+// versions, genesis URLs, and revoked npubs are validated here so every
+// consumer applies the same rules.
+func Parse(content string) (*Action, error) {
+	// Detect the schema revision and message type early
+	var meta struct {
+		Type          string
+		SchemaVersion any `json:"schemaVersion"`
+	}
+	if err := json.Unmarshal([]byte(content), &meta); err != nil {
+		return nil, ErrInvalidJSON
+	}
+
+	parse, err := parserFor(meta.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return parse(meta.Type, content)
+}
+
+// parseV1 parses a message of schema revision 1
+func parseV1(msgType, content string) (*Action, error) {
+	switch msgType {
+	case TypeUpgrade:
+		var msg UpgradeMessage
+		if err := json.Unmarshal([]byte(content), &msg); err != nil {
+			return nil, fmt.Errorf("failed to parse upgrade message: %w", err)
+		}
+
+		v, err := semver.NewVersion(msg.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version in upgrade: %s", msg.Version)
+		}
+
+		notBefore, err := ParseNotBefore(msg.NotBefore)
+		if err != nil {
+			return nil, err
+		}
+		executeAt, err := ParseExecuteAt(msg.ExecuteAt)
+		if err != nil {
+			return nil, err
+		}
+
+		source, err := parseSource(msg, v)
+		if err != nil {
+			return nil, err
+		}
+
+		binary, err := parseArtifact(msg.Binary, "binary")
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Cohort != "" && !ValidCohort(msg.Cohort) {
+			return nil, fmt.Errorf("invalid cohort in upgrade: %q", msg.Cohort)
+		}
+		if msg.Network != "" && !ValidNetwork(msg.Network) {
+			return nil, fmt.Errorf("invalid network in upgrade: %q", msg.Network)
+		}
+		image, err := parseImageDigest(msg.ImageDigest, "upgrade")
+		if err != nil {
+			return nil, err
+		}
+		service, err := parseService(msg.Service, "upgrade")
+		if err != nil {
+			return nil, err
+		}
+		severity, err := parseSeverity(msg.Severity, "upgrade")
+		if err != nil {
+			return nil, err
+		}
+
+		// Pinned upgrades are keyed by commit so votes for different code
+		// under the same version never add up
+		key := fmt.Sprintf("upgrade:%s", v.Original())
+		if source != nil {
+			key += "@" + source.Commit
+		}
+
+		return &Action{
+			Type:        TypeUpgrade,
+			Version:     v,
+			Key:         NetworkKey(msg.Network, key+artifactKeySuffix(binary)+imageKeySuffix(image)+serviceKeySuffix(service)),
+			Source:      source,
+			Artifact:    binary,
+			ImageDigest: image,
+			Cohort:      msg.Cohort,
+			Network:     msg.Network,
+			Service:     service,
+			Severity:    severity,
+			NotBefore:   notBefore,
+			ExecuteAt:   executeAt,
+		}, nil
+
+	case TypeReboot:
+		var msg RebootMessage
+		if err := json.Unmarshal([]byte(content), &msg); err != nil {
+			return nil, fmt.Errorf("failed to parse reboot message: %w", err)
+		}
+
+		if _, err := url.ParseRequestURI(msg.Genesis); err != nil {
+			return nil, fmt.Errorf("invalid genesis URL in reboot: %s", msg.Genesis)
+		}
+
+		v, err := semver.NewVersion(msg.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version in reboot: %s", msg.Version)
+		}
+
+		notBefore, err := ParseNotBefore(msg.NotBefore)
+		if err != nil {
+			return nil, err
+		}
+		executeAt, err := ParseExecuteAt(msg.ExecuteAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Network != "" && !ValidNetwork(msg.Network) {
+			return nil, fmt.Errorf("invalid network in reboot: %q", msg.Network)
+		}
+		image, err := parseImageDigest(msg.ImageDigest, "reboot")
+		if err != nil {
+			return nil, err
+		}
+		severity, err := parseSeverity(msg.Severity, "reboot")
+		if err != nil {
+			return nil, err
+		}
+
+		// Mirrors are only safe to use when the file can be checked
+		var genesis *Artifact
+		if msg.GenesisSHA256 != "" || len(msg.GenesisMirrors) > 0 {
+			genesis, err = parseArtifact(&Artifact{
+				URLs:   append([]string{msg.Genesis}, msg.GenesisMirrors...),
+				SHA256: msg.GenesisSHA256,
+			}, "genesis")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return &Action{
+			Type:        TypeReboot,
+			Version:     v,
+			Key:         NetworkKey(msg.Network, fmt.Sprintf("reboot:%s:%s", v.Original(), msg.Genesis)+artifactKeySuffix(genesis)+imageKeySuffix(image)),
+			Genesis:     msg.Genesis,
+			Artifact:    genesis,
+			ImageDigest: image,
+			Network:     msg.Network,
+			Severity:    severity,
+			NotBefore:   notBefore,
+			ExecuteAt:   executeAt,
+		}, nil
+
+	case TypeRollback:
+		var msg RollbackMessage
+		if err := json.Unmarshal([]byte(content), &msg); err != nil {
+			return nil, fmt.Errorf("failed to parse rollback message: %w", err)
+		}
+
+		v, err := semver.NewVersion(msg.Version)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic version in rollback: %s", msg.Version)
+		}
+
+		notBefore, err := ParseNotBefore(msg.NotBefore)
+		if err != nil {
+			return nil, err
+		}
+
+		binary, err := parseArtifact(msg.Binary, "binary")
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Network != "" && !ValidNetwork(msg.Network) {
+			return nil, fmt.Errorf("invalid network in rollback: %q", msg.Network)
+		}
+		image, err := parseImageDigest(msg.ImageDigest, "rollback")
+		if err != nil {
+			return nil, err
+		}
+		service, err := parseService(msg.Service, "rollback")
+		if err != nil {
+			return nil, err
+		}
+		severity, err := parseSeverity(msg.Severity, "rollback")
+		if err != nil {
+			return nil, err
+		}
+
+		return &Action{
+			Type:        TypeRollback,
+			Version:     v,
+			Key:         NetworkKey(msg.Network, fmt.Sprintf("rollback:%s", v.Original())+artifactKeySuffix(binary)+imageKeySuffix(image)+serviceKeySuffix(service)),
+			Artifact:    binary,
+			ImageDigest: image,
+			Network:     msg.Network,
+			Service:     service,
+			Severity:    severity,
+			NotBefore:   notBefore,
+		}, nil
+
+	case TypeRevokeKey:
+		var msg RevokeKeyMessage
+		if err := json.Unmarshal([]byte(content), &msg); err != nil {
+			return nil, fmt.Errorf("failed to parse revoke-key message: %w", err)
+		}
+
+		kind, pk, err := nip19.Decode(msg.PubKey)
+		if err != nil || kind != "npub" {
+			return nil, fmt.Errorf("invalid npub in revoke-key: %s", msg.PubKey)
+		}
+
+		return &Action{
+			Type:     TypeRevokeKey,
+			Key:      RevokeKeyPrefix + msg.PubKey,
+			Target:   pk.(string),
+			Severity: SeverityRoutine,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMessageType, msgType)
+	}
+}
+
+// parseSource validates the provenance fields of an upgrade message. It
+// returns nil if the message pins no source.
+func parseSource(msg UpgradeMessage, v *semver.Version) (*Source, error) {
+	if msg.Repo == "" && msg.Tag == "" && msg.CommitHash == "" {
+		return nil, nil
+	}
+	if !commitHashPattern.MatchString(msg.CommitHash) {
+		return nil, fmt.Errorf("invalid commitHash in upgrade: %q (a full hex commit hash is required with repo or tag)", msg.CommitHash)
+	}
+
+	source := &Source{
+		Repo:   msg.Repo,
+		Tag:    msg.Tag,
+		Commit: strings.ToLower(msg.CommitHash),
+	}
+	if source.Tag == "" {
+		source.Tag = v.Original()
+	}
+	if strings.HasPrefix(source.Tag, "-") || strings.HasPrefix(source.Repo, "-") {
+		return nil, fmt.Errorf("invalid repo or tag in upgrade: %s %s", source.Repo, source.Tag)
+	}
+	return source, nil
+}
+
+// parseArtifact validates an announced artifact, returning nil if none was
+// announced. Every URL must be absolute http(s) and the hash a full
+// SHA-256.
+func parseArtifact(a *Artifact, field string) (*Artifact, error) {
+	if a == nil {
+		return nil, nil
+	}
+	if !sha256Pattern.MatchString(a.SHA256) {
+		return nil, fmt.Errorf("invalid %s sha256: %q (a full hex SHA-256 is required)", field, a.SHA256)
+	}
+	if len(a.URLs) == 0 {
+		return nil, fmt.Errorf("%s announces no urls", field)
+	}
+	for _, raw := range a.URLs {
+		u, err := url.ParseRequestURI(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid %s url: %s", field, raw)
+		}
+	}
+	return &Artifact{URLs: a.URLs, SHA256: strings.ToLower(a.SHA256)}, nil
+}
+
+// artifactKeySuffix extends an action key with the artifact hash so votes for
+// different files under the same version never add up
+func artifactKeySuffix(a *Artifact) string {
+	if a == nil {
+		return ""
+	}
+	return "#sha256:" + a.SHA256
+}
+
+// parseImageDigest validates an announced container image digest, returning
+// it lowercased, or "" if none was announced
+func parseImageDigest(digest, msgType string) (string, error) {
+	if digest == "" {
+		return "", nil
+	}
+	if !imageDigestPattern.MatchString(digest) {
+		return "", fmt.Errorf("invalid imageDigest in %s: %q (sha256:<64 hex digits> is required)", msgType, digest)
+	}
+	return strings.ToLower(digest), nil
+}
+
+// imageKeySuffix extends an action key with the image digest so votes for
+// different images under the same version never add up
+func imageKeySuffix(digest string) string {
+	if digest == "" {
+		return ""
+	}
+	return "#image:" + digest
+}
+
+// DefaultService names the hyperqube node, the service signals without a
+// service field apply to
+const DefaultService = "hyperqube"
+
+// parseService validates the service an upgrade or rollback targets,
+// returning "" for the hyperqube node so naming it explicitly yields the same
+// key as leaving it out
+func parseService(service, msgType string) (string, error) {
+	if service == "" || service == DefaultService {
+		return "", nil
+	}
+	if !ValidService(service) {
+		return "", fmt.Errorf("invalid service in %s: %q", msgType, service)
+	}
+	return service, nil
+}
+
+// parseSeverity validates the severity of a message, routine if it names
+// none. Severity is not part of the key: signers who disagree on it still
+// vote for the same action.
+func parseSeverity(severity, msgType string) (string, error) {
+	if severity == "" {
+		return SeverityRoutine, nil
+	}
+	if !ValidSeverity(severity) {
+		return "", fmt.Errorf("invalid severity in %s: %q (must be %s, %s, or %s)", msgType, severity, SeverityRoutine, SeveritySecurity, SeverityCritical)
+	}
+	return severity, nil
+}
+
+// serviceKeySuffix extends an action key with the service it targets, so
+// each co-hosted component keeps its own history
+func serviceKeySuffix(service string) string {
+	if service == "" {
+		return ""
+	}
+	return "#service:" + service
+}
+
+// ValidService reports whether name can identify a co-hosted service, e.g.
+// "zenon": the same characters as a cohort name
+func ValidService(name string) bool {
+	return cohortPattern.MatchString(name)
+}
+
+// ValidCohort reports whether name can label a cohort of nodes: lowercase
+// letters, digits, dashes, and underscores, starting with a letter or digit
+func ValidCohort(name string) bool {
+	return cohortPattern.MatchString(name)
+}
+
+// cohortPattern matches cohort names
+var cohortPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidNetwork reports whether name can identify a network or chain, e.g.
+// "hyperqube-mainnet": lowercase letters, digits, dots, dashes, and
+// underscores, starting with a letter or digit
+func ValidNetwork(name string) bool {
+	return networkPattern.MatchString(name)
+}
+
+// networkPattern matches network identifiers
+var networkPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// NetworkKey namespaces an action key by the network the action applies to,
+// so the same action on two networks never shares a history entry. Keys of
+// actions without a network are returned unchanged.
+func NetworkKey(network, key string) string {
+	if network == "" {
+		return key
+	}
+	return network + "/" + key
+}
+
+// sha256Pattern matches a hex SHA-256 digest
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// imageDigestPattern matches an OCI image digest
+var imageDigestPattern = regexp.MustCompile(`^sha256:[0-9a-fA-F]{64}$`)
+
+// commitHashPattern matches full SHA-1 and SHA-256 git commit hashes
+var commitHashPattern = regexp.MustCompile(`^([0-9a-fA-F]{40}|[0-9a-fA-F]{64})$`)
+
+// ParseNotBefore parses an optional RFC3339 notBefore field
+func ParseNotBefore(value string) (time.Time, error) {
+	return parseTimestamp(value, "notBefore")
+}
+
+// ParseExecuteAt parses an optional RFC3339 executeAt field
+func ParseExecuteAt(value string) (time.Time, error) {
+	return parseTimestamp(value, "executeAt")
+}
+
+// parseTimestamp parses an optional RFC3339 timestamp field
+func parseTimestamp(value, field string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s timestamp: %s", field, value)
+	}
+	return t, nil
+}
@@ -0,0 +1,69 @@
+package signal
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SchemaVersion is the message schema revision this package writes. Minor
+// revisions only add optional fields, which older managers ignore; a new
+// major revision changes the meaning of existing fields.
+const SchemaVersion = "1.0"
+
+// ErrUnsupportedSchema is returned by Parse for messages written in a major
+// schema revision this package cannot parse
+var ErrUnsupportedSchema = errors.New("unsupported message schema")
+
+// schemaParsers parses message content of each known major schema revision
+// into the action it votes for. Messages without schemaVersion predate it
+// and are parsed as revision 1.
+var schemaParsers = map[int]func(msgType, content string) (*Action, error){
+	1: parseV1,
+}
+
+// SupportedSchemas returns the major schema revisions Parse understands
+func SupportedSchemas() []int {
+	majors := make([]int, 0, len(schemaParsers))
+	for major := range schemaParsers {
+		majors = append(majors, major)
+	}
+	slices.Sort(majors)
+	return majors
+}
+
+// schemaMajor returns the major revision of a "MAJOR.MINOR" schemaVersion
+// field; a missing field means 1
+func schemaMajor(value any) (int, error) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return 1, nil
+	case string:
+		s = v
+	default:
+		return 0, fmt.Errorf("invalid schemaVersion: %v (must be a \"MAJOR.MINOR\" string)", value)
+	}
+	majorPart, _, _ := strings.Cut(s, ".")
+	major, err := strconv.Atoi(majorPart)
+	if err != nil || major < 1 {
+		return 0, fmt.Errorf("invalid schemaVersion: %q", s)
+	}
+	return major, nil
+}
+
+// parserFor returns the parser of the schema revision a message declares
+func parserFor(value any) (func(msgType, content string) (*Action, error), error) {
+	major, err := schemaMajor(value)
+	if err != nil {
+		return nil, err
+	}
+	parse, ok := schemaParsers[major]
+	if !ok {
+		return nil, fmt.Errorf("%w: schemaVersion %v has major revision %d, this manager understands %v - upgrade qube-manager to follow these signals",
+			ErrUnsupportedSchema, value, major, SupportedSchemas())
+	}
+	return parse, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/jedisct1/go-minisign"
+)
+
+// SignatureConfig configures verification of a detached signature over a
+// downloaded release artifact against keys trusted by the operator
+type SignatureConfig struct {
+	Type       string   `yaml:"type"`          // "minisign" or "gpg"; empty disables verification
+	URL        string   `yaml:"url,omitempty"` // Signature URL, "{version}" is replaced; defaults to the artifact URL plus .minisig or .asc
+	PublicKeys []string `yaml:"public_keys"`   // Trusted minisign public keys or ASCII-armored GPG public keys
+}
+
+// Enabled reports whether signature verification is configured
+func (c SignatureConfig) Enabled() bool {
+	return c.Type != ""
+}
+
+// Validate checks the signature type and that every trusted key parses
+func (c SignatureConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if len(c.PublicKeys) == 0 {
+		return errors.New("signature.public_keys must list at least one trusted key")
+	}
+
+	switch c.Type {
+	case "minisign":
+		_, err := c.minisignKeys()
+		return err
+	case "gpg":
+		_, err := c.gpgKeyring()
+		return err
+	default:
+		return fmt.Errorf("unknown signature type %q (expected minisign or gpg)", c.Type)
+	}
+}
+
+// signatureURL returns where the signature for the artifact at artifactURL
+// is published
+func (c SignatureConfig) signatureURL(artifactURL, version string) string {
+	if c.URL != "" {
+		return strings.ReplaceAll(c.URL, "{version}", version)
+	}
+	if c.Type == "gpg" {
+		return artifactURL + ".asc"
+	}
+	return artifactURL + ".minisig"
+}
+
+// minisignKeys parses the trusted minisign public keys
+func (c SignatureConfig) minisignKeys() ([]minisign.PublicKey, error) {
+	keys := make([]minisign.PublicKey, 0, len(c.PublicKeys))
+	for i, s := range c.PublicKeys {
+		pk, err := minisign.NewPublicKey(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid minisign public key %d: %w", i+1, err)
+		}
+		keys = append(keys, pk)
+	}
+	return keys, nil
+}
+
+// gpgKeyring parses the trusted ASCII-armored GPG public keys
+func (c SignatureConfig) gpgKeyring() (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	for i, s := range c.PublicKeys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPG public key %d: %w", i+1, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+	return keyring, nil
+}
+
+// verifyArtifact downloads the detached signature for the artifact stored at
+// path and checks it against the trusted keys
+func verifyArtifact(ctx context.Context, cfg SignatureConfig, path, artifactURL, version string) error {
+	sigURL := cfg.signatureURL(artifactURL, version)
+	log.Printf("[INFO] Fetching %s signature from %s", cfg.Type, sigURL)
+	sig, err := fetchSignature(ctx, sigURL)
+	if err != nil {
+		return err
+	}
+
+	artifact, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch cfg.Type {
+	case "minisign":
+		err = verifyMinisign(cfg, artifact, sig)
+	case "gpg":
+		err = verifyGPG(cfg, artifact, sig)
+	default:
+		err = fmt.Errorf("unknown signature type %q", cfg.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("signature verification of %s failed: %w", path, err)
+	}
+	return nil
+}
+
+// fetchSignature downloads a signature file, which is small enough to keep
+// in memory
+func fetchSignature(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download of signature %s failed: %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// verifyMinisign accepts the artifact if any trusted key made the signature
+func verifyMinisign(cfg SignatureConfig, artifact, sig []byte) error {
+	keys, err := cfg.minisignKeys()
+	if err != nil {
+		return err
+	}
+	signature, err := minisign.DecodeSignature(string(sig))
+	if err != nil {
+		return fmt.Errorf("invalid minisign signature: %w", err)
+	}
+
+	for _, pk := range keys {
+		if ok, err := pk.Verify(artifact, signature); err == nil && ok {
+			log.Printf("[INFO] Minisign signature verified (key id %X)", pk.KeyId)
+			return nil
+		}
+	}
+	return errors.New("signature was not made by any trusted minisign key")
+}
+
+// verifyGPG accepts the artifact if any trusted key made the signature,
+// which may be ASCII-armored or binary
+func verifyGPG(cfg SignatureConfig, artifact, sig []byte) error {
+	keyring, err := cfg.gpgKeyring()
+	if err != nil {
+		return err
+	}
+
+	var signer *openpgp.Entity
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN PGP")) {
+		signer, err = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(artifact), bytes.NewReader(sig), nil)
+	} else {
+		signer, err = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(artifact), bytes.NewReader(sig), nil)
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] GPG signature verified (key %s)", signer.PrimaryKey.KeyIdString())
+	return nil
+}
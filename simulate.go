@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// simulatedRelay stands in for the relay URL of synthetic events
+const simulatedRelay = "simulation"
+
+// Scenario describes a rehearsal: throwaway signers standing in for the
+// follows, and the events they publish
+type Scenario struct {
+	Quorum      int              `yaml:"quorum,omitempty"`          // Votes an action needs (configured quorum if unset)
+	SignalMode  string           `yaml:"signal_mode,omitempty"`     // Trust model (configured mode if unset)
+	Cohort      string           `yaml:"cohort,omitempty"`          // Rollout cohort of the simulated node (configured cohort if unset)
+	Network     string           `yaml:"network,omitempty"`         // Network of the simulated node (configured network if unset)
+	Conflict    string           `yaml:"conflict_policy,omitempty"` // Conflict policy between upgrades and reboots (configured policy if unset)
+	NodeVersion string           `yaml:"node_version,omitempty"`    // Version the simulated node runs (unknown if unset)
+	Signers     []ScenarioSigner `yaml:"signers"`                   // Follows of the simulated manager
+	Events      []ScenarioEvent  `yaml:"events"`                    // Events fed to the manager, in order
+}
+
+// ScenarioSigner is a follow of the simulated manager
+type ScenarioSigner struct {
+	Name string `yaml:"name"`           // Label events refer to the signer by
+	Nsec string `yaml:"nsec,omitempty"` // Fixed throwaway key (generated if unset)
+	Role string `yaml:"role,omitempty"` // Follow role: "proposer", "approver", or both if unset
+}
+
+// ScenarioEvent is a synthetic event signed by a scenario signer. It carries
+// a signal, given as message or raw content, or a NIP-09 deletion of
+// earlier events.
+type ScenarioEvent struct {
+	Name      string         `yaml:"name,omitempty"`      // Label later events can delete this event by
+	Signer    string         `yaml:"signer"`              // Name of the signer publishing the event
+	Message   map[string]any `yaml:"message,omitempty"`   // Signal content, encoded as JSON
+	Content   string         `yaml:"content,omitempty"`   // Raw content, e.g. for malformed signals
+	Cosigners []string       `yaml:"cosigners,omitempty"` // Signers co-signing the content, for threshold mode
+	Deletes   []string       `yaml:"deletes,omitempty"`   // Names of earlier events this event deletes
+	DTag      string         `yaml:"d_tag,omitempty"`     // Publish as an addressable event replacing earlier ones with the same d tag
+	PoW       int            `yaml:"pow,omitempty"`       // Leading zero bits of NIP-13 proof of work to mine
+	Age       time.Duration  `yaml:"age,omitempty"`       // How long before the simulation the event was created
+}
+
+func newSimulateCommand(g *globals) *cobra.Command {
+	var scenarioPath string
+	cmd := &cobra.Command{
+		Use:   "simulate --scenario file.yaml",
+		Short: "Run one evaluation cycle against synthetic events from a scenario file",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			simulateCLI(g.configDir, g.stateDir, g.verbose, g.keypair, scenarioPath)
+		},
+	}
+	cmd.Flags().StringVar(&scenarioPath, "scenario", "", "Scenario file (YAML) with the signers and events to simulate")
+	cmd.MarkFlagRequired("scenario")
+	cmd.MarkFlagFilename("scenario", "yaml", "yml")
+	return cmd
+}
+
+// simulateCLI runs one evaluation cycle against synthetic events from a
+// scenario file, through executor dry-run, without touching relays, the
+// node, or any state in the state dir
+func simulateCLI(configDir, stateDir string, verbose bool, kp Keypair, scenarioPath string) {
+	data, err := os.ReadFile(scenarioPath)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to read scenario: %v", err)
+	}
+	var scenario Scenario
+	if err := yaml.Unmarshal(data, &scenario); err != nil {
+		log.Fatalf("[ERROR] Failed to parse scenario %s: %v", scenarioPath, err)
+	}
+
+	signers, err := scenarioSigners(scenario.Signers)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	events, err := scenarioEvents(scenario.Events, signers, time.Now())
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	// State written during the cycle lands in a scratch dir
+	scratch, err := os.MkdirTemp("", "qube-manager-simulate-")
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	// log.Fatalf and os.Exit skip deferred calls, so failures below remove
+	// the scratch dir themselves
+	cfg, err := simulationConfig(loadConfig(configDir, stateDir), scenario, signers, scratch)
+	if err != nil {
+		os.RemoveAll(scratch)
+		log.Fatalf("[ERROR] %v", err)
+	}
+	if problems, _ := splitProblems(validateConfig(cfg)); len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("[ERROR] Invalid simulation config: %s", p)
+		}
+		os.RemoveAll(scratch)
+		os.Exit(exitConfigError)
+	}
+
+	m := &Manager{
+		config:    cfg,
+		keypair:   kp,
+		history:   &History{Entries: make(map[string]HistoryEntry), path: filepath.Join(scratch, "history.yaml")},
+		shutdown:  newShutdownHandler(cfg.ShutdownGracePeriod),
+		simulated: events,
+		dryRun:    true,
+		verbose:   verbose,
+	}
+	if cfg.Fleet.Enabled() {
+		m.fleet, err = newFleet(cfg.Fleet, cfg.Executor)
+	} else {
+		m.executor, err = newExecutor(cfg.Executor)
+		if err == nil {
+			m.services, err = newServiceExecutors(cfg)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(scratch)
+		log.Fatalf("[ERROR] %v", err)
+	}
+
+	log.Printf("[INFO] Simulating %d event(s) from %d signer(s), quorum=%d", len(events), len(signers), cfg.Quorum)
+	m.runCycle()
+	log.Printf("[INFO] Simulation result: %s", m.lastRun)
+}
+
+// scenarioSigners returns a keypair for every signer, keyed by name
+func scenarioSigners(defs []ScenarioSigner) (map[string]Keypair, error) {
+	if len(defs) == 0 {
+		return nil, fmt.Errorf("scenario defines no signers")
+	}
+	signers := make(map[string]Keypair, len(defs))
+	for i, d := range defs {
+		if d.Name == "" {
+			return nil, fmt.Errorf("signers[%d]: name is required", i)
+		}
+		if _, ok := signers[d.Name]; ok {
+			return nil, fmt.Errorf("signers[%d]: duplicate signer %q", i, d.Name)
+		}
+		kp := generateKeypair()
+		if d.Nsec != "" {
+			kp = Keypair{Nsec: d.Nsec, Npub: npubFromNsec(d.Nsec)}
+			if kp.Npub == "" {
+				return nil, fmt.Errorf("signers[%d]: invalid nsec", i)
+			}
+		}
+		signers[d.Name] = kp
+	}
+	return signers, nil
+}
+
+// scenarioEvents builds and signs the scenario's events
+func scenarioEvents(defs []ScenarioEvent, signers map[string]Keypair, now time.Time) ([]*nostr.Event, error) {
+	if len(defs) == 0 {
+		return nil, fmt.Errorf("scenario defines no events")
+	}
+	named := make(map[string]string) // event name -> event ID
+	var events []*nostr.Event
+	for i, d := range defs {
+		kp, ok := signers[d.Signer]
+		if !ok {
+			return nil, fmt.Errorf("events[%d]: unknown signer %q", i, d.Signer)
+		}
+
+		ev := &nostr.Event{
+			CreatedAt: nostr.Timestamp(now.Add(-d.Age).Unix()),
+			Kind:      nostr.KindTextNote,
+			Content:   d.Content,
+		}
+		switch {
+		case len(d.Deletes) > 0:
+			ev.Kind = nostr.KindDeletion
+			for _, name := range d.Deletes {
+				id, ok := named[name]
+				if !ok {
+					return nil, fmt.Errorf("events[%d]: deletes unknown event %q", i, name)
+				}
+				ev.Tags = append(ev.Tags, nostr.Tag{"e", id})
+			}
+		case d.Message != nil:
+			content, err := json.Marshal(d.Message)
+			if err != nil {
+				return nil, fmt.Errorf("events[%d]: %w", i, err)
+			}
+			ev.Content = string(content)
+		}
+		if d.DTag != "" && ev.Kind == nostr.KindTextNote {
+			ev.Kind = addressableSignalKind
+			ev.Tags = append(ev.Tags, nostr.Tag{"d", d.DTag})
+		}
+
+		for _, name := range d.Cosigners {
+			cosigner, ok := signers[name]
+			if !ok {
+				return nil, fmt.Errorf("events[%d]: unknown cosigner %q", i, name)
+			}
+			sk, err := cosigner.secretKey()
+			if err != nil {
+				return nil, err
+			}
+			tag, err := signal.Cosign(ev.Content, sk)
+			if err != nil {
+				return nil, fmt.Errorf("events[%d]: %w", i, err)
+			}
+			ev.Tags = append(ev.Tags, tag)
+		}
+
+		sk, err := kp.secretKey()
+		if err != nil {
+			return nil, err
+		}
+		if d.PoW > 0 {
+			ev.PubKey, _ = nostr.GetPublicKey(sk)
+			nonce, err := nip13.DoWork(context.Background(), *ev, d.PoW)
+			if err != nil {
+				return nil, fmt.Errorf("events[%d]: %w", i, err)
+			}
+			ev.Tags = append(ev.Tags, nonce)
+		}
+		if err := ev.Sign(sk); err != nil {
+			return nil, fmt.Errorf("events[%d]: failed to sign: %w", i, err)
+		}
+		if d.Name != "" {
+			named[d.Name] = ev.ID
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// simulationConfig returns the config the simulated manager runs with: the
+// configured executor and policies, with the scenario's signers as follows,
+// no outputs outside the scratch dir, and the node version from the scenario
+func simulationConfig(cfg Config, scenario Scenario, signers map[string]Keypair, scratch string) (Config, error) {
+	cfg.ConfigPath = scratch
+	cfg.StatePath = scratch
+	cfg.Executor.Artifacts.Dir = filepath.Join(scratch, "artifacts")
+	cfg.Services = maps.Clone(cfg.Services)
+	for name, ex := range cfg.Services {
+		ex.Artifacts.Dir = cfg.Executor.Artifacts.Dir
+		cfg.Services[name] = ex
+	}
+	cfg.Follows = nil
+	for _, s := range scenario.Signers {
+		cfg.Follows = append(cfg.Follows, Follow{NPub: signers[s.Name].Npub, Role: s.Role})
+	}
+	if scenario.Quorum > 0 {
+		cfg.Quorum = scenario.Quorum
+	}
+	if scenario.SignalMode != "" {
+		cfg.SignalMode = scenario.SignalMode
+	}
+	if scenario.Cohort != "" {
+		cfg.Cohort = scenario.Cohort
+	}
+	if scenario.Network != "" {
+		cfg.Network = scenario.Network
+	}
+	if scenario.Conflict != "" {
+		cfg.ConflictPolicy = scenario.Conflict
+	}
+
+	cfg.MetricsTextfile = ""
+	cfg.RunSummary.URL = ""
+	cfg.Telemetry.Enabled = false
+	cfg.Node = NodeConfig{}
+	if scenario.NodeVersion != "" {
+		path := filepath.Join(scratch, "node-version")
+		if err := os.WriteFile(path, []byte(scenario.NodeVersion), 0644); err != nil {
+			return Config{}, err
+		}
+		cfg.Node.VersionFile = path
+	}
+	return cfg, nil
+}
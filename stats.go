@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/spf13/cobra"
+)
+
+// statsRetention is how long run stats are kept in the stats file
+const statsRetention = 90 * 24 * time.Hour
+
+// RunStats is a single line of the stats file: a compact summary of one run
+type RunStats struct {
+	Started         string       `json:"started"`          // ISO8601 time the run began
+	DurationMillis  int64        `json:"duration_ms"`      // How long the run took
+	Status          string       `json:"status"`           // One of the status* constants
+	RelaysConnected int          `json:"relays_connected"` // Relays that accepted a subscription
+	EventsSeen      int          `json:"events"`           // Events received from the relays
+	Candidates      int          `json:"candidates"`       // Candidate actions in the tally
+	Votes           int          `json:"votes"`            // Votes cast for the candidates
+	Signers         int          `json:"signers"`          // Distinct signers that voted
+	Relays          []RelayStats `json:"relays,omitempty"` // Outcome of each relay poll
+}
+
+// RelayStats is the outcome of polling one relay in a run
+type RelayStats struct {
+	URL           string `json:"url"`                  // Relay URL
+	Connected     bool   `json:"connected"`            // Subscription was established
+	ConnectMillis int64  `json:"connect_ms,omitempty"` // Time to open the connection
+	Events        int    `json:"events"`               // Events received
+}
+
+// tallyStats counts the candidate actions in a tally, the votes cast for
+// them, and the distinct signers that voted
+func tallyStats(tally *signal.Evaluator) (candidates, votes, signers int) {
+	voted := make(map[string]bool)
+	for key := range tally.Actions {
+		candidates++
+		for pk := range tally.Votes[key] {
+			votes++
+			voted[pk] = true
+		}
+	}
+	return candidates, votes, len(voted)
+}
+
+// statsPath returns the location of the stats file
+func statsPath(stateDir string) string {
+	return filepath.Join(stateDir, "stats.jsonl")
+}
+
+// recordRunStats appends the summary of a finished run to the stats file,
+// first dropping runs older than statsRetention once the oldest one expired
+func recordRunStats(stateDir string, r *RunResult) {
+	s := RunStats{
+		Started:         r.Started.UTC().Format(time.RFC3339),
+		DurationMillis:  time.Since(r.Started).Milliseconds(),
+		Status:          r.LastStatus,
+		RelaysConnected: r.RelaysConnected,
+		EventsSeen:      r.EventsSeen,
+		Candidates:      r.Candidates,
+		Votes:           r.Votes,
+		Signers:         r.Signers,
+	}
+	for _, p := range r.Polls {
+		s.Relays = append(s.Relays, RelayStats{URL: p.URL, Connected: p.Connected, ConnectMillis: p.ConnectMillis, Events: p.Events})
+	}
+
+	path := statsPath(stateDir)
+	if err := pruneRunStats(path, time.Now().Add(-statsRetention)); err != nil {
+		log.Printf("[WARN] Failed to prune stats file %s: %v", path, err)
+	}
+
+	line, err := json.Marshal(s)
+	if err != nil {
+		log.Printf("[WARN] Failed to encode run stats: %v", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("[WARN] Failed to open stats file %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("[WARN] Failed to record run stats: %v", err)
+	}
+}
+
+// pruneRunStats rewrites the stats file without the runs started before
+// cutoff. Only the first line is read unless it has expired.
+func pruneRunStats(path string, cutoff time.Time) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var first RunStats
+	scanner := bufio.NewScanner(f)
+	if scanner.Scan() {
+		json.Unmarshal(scanner.Bytes(), &first)
+	}
+	f.Close()
+	if started, err := time.Parse(time.RFC3339, first.Started); err != nil || !started.Before(cutoff) {
+		return nil
+	}
+
+	runs, err := readRunStats(path, cutoff)
+	if err != nil {
+		return err
+	}
+	var buf strings.Builder
+	for _, s := range runs {
+		line, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(buf.String()), 0644)
+}
+
+// readRunStats reads the runs started at or after since from the stats file,
+// skipping malformed lines
+func readRunStats(path string, since time.Time) ([]RunStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []RunStats
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var s RunStats
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			continue
+		}
+		started, err := time.Parse(time.RFC3339, s.Started)
+		if err != nil || started.Before(since) {
+			continue
+		}
+		runs = append(runs, s)
+	}
+	return runs, scanner.Err()
+}
+
+// StatsPeriod aggregates the runs started within one bucket of the window
+type StatsPeriod struct {
+	Start           string  `json:"start"`            // ISO8601 start of the bucket
+	Runs            int     `json:"runs"`             // Runs started in the bucket
+	Failed          int     `json:"failed"`           // Runs whose action failed
+	RelaysConnected float64 `json:"relays_connected"` // Average relays connected per run
+	Events          float64 `json:"events"`           // Average events received per run
+	Candidates      float64 `json:"candidates"`       // Average candidate actions per run
+	Signers         float64 `json:"signers"`          // Average distinct signers per run
+	DurationMillis  float64 `json:"duration_ms"`      // Average run duration
+}
+
+// RelayTrend summarizes how one relay fared across the window
+type RelayTrend struct {
+	URL           string  `json:"url"`        // Relay URL
+	Polls         int     `json:"polls"`      // Runs that polled the relay
+	Connected     int     `json:"connected"`  // Polls that established a subscription
+	Events        float64 `json:"events"`     // Average events per successful poll
+	ConnectMillis float64 `json:"connect_ms"` // Average connection time per successful poll
+}
+
+// StatsReport is the output of the stats command
+type StatsReport struct {
+	Since   string        `json:"since"`   // ISO8601 start of the window
+	Runs    int           `json:"runs"`    // Runs in the window
+	Periods []StatsPeriod `json:"periods"` // Per-bucket aggregates, oldest first
+	Relays  []RelayTrend  `json:"relays"`  // Per-relay aggregates, sorted by URL
+}
+
+// buildStatsReport aggregates runs into buckets of the given length
+func buildStatsReport(runs []RunStats, since time.Time, bucket time.Duration) StatsReport {
+	report := StatsReport{Since: since.UTC().Format(time.RFC3339), Runs: len(runs), Periods: []StatsPeriod{}, Relays: []RelayTrend{}}
+
+	periods := make(map[int64]*StatsPeriod)
+	relays := make(map[string]*RelayTrend)
+	for _, s := range runs {
+		started, _ := time.Parse(time.RFC3339, s.Started)
+		start := started.Truncate(bucket)
+		p, ok := periods[start.Unix()]
+		if !ok {
+			p = &StatsPeriod{Start: start.UTC().Format(time.RFC3339)}
+			periods[start.Unix()] = p
+		}
+		p.Runs++
+		if s.Status == statusFailed {
+			p.Failed++
+		}
+		p.RelaysConnected += float64(s.RelaysConnected)
+		p.Events += float64(s.EventsSeen)
+		p.Candidates += float64(s.Candidates)
+		p.Signers += float64(s.Signers)
+		p.DurationMillis += float64(s.DurationMillis)
+
+		for _, r := range s.Relays {
+			t, ok := relays[r.URL]
+			if !ok {
+				t = &RelayTrend{URL: r.URL}
+				relays[r.URL] = t
+			}
+			t.Polls++
+			if r.Connected {
+				t.Connected++
+				t.Events += float64(r.Events)
+				t.ConnectMillis += float64(r.ConnectMillis)
+			}
+		}
+	}
+
+	for _, p := range periods {
+		n := float64(p.Runs)
+		p.RelaysConnected /= n
+		p.Events /= n
+		p.Candidates /= n
+		p.Signers /= n
+		p.DurationMillis /= n
+		report.Periods = append(report.Periods, *p)
+	}
+	slices.SortFunc(report.Periods, func(a, b StatsPeriod) int { return strings.Compare(a.Start, b.Start) })
+
+	for _, t := range relays {
+		if t.Connected > 0 {
+			t.Events /= float64(t.Connected)
+			t.ConnectMillis /= float64(t.Connected)
+		}
+		report.Relays = append(report.Relays, *t)
+	}
+	slices.SortFunc(report.Relays, func(a, b RelayTrend) int { return strings.Compare(a.URL, b.URL) })
+	return report
+}
+
+// parseStatsDuration parses a duration that may also be given in days,
+// e.g. "7d"
+func parseStatsDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return d, nil
+}
+
+func newStatsCommand(g *globals) *cobra.Command {
+	var since, bucket string
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Print trends of past runs: relays, events, candidates, and signer participation",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			statsCLI(g.stateDir, g.output, since, bucket)
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "7d", "Only include runs started within this period (e.g. 24h, 7d)")
+	cmd.Flags().StringVar(&bucket, "bucket", "", "Length of each period in the trend (default 1h up to 2d, 1d beyond)")
+	return cmd
+}
+
+// statsCLI prints the runs recorded in the stats file within the window,
+// aggregated per period and per relay
+func statsCLI(stateDir, output, sinceFlag, bucketFlag string) {
+	window, err := parseStatsDuration(sinceFlag)
+	if err != nil {
+		log.Fatalf("[ERROR] --since: %v", err)
+	}
+	bucket := 24 * time.Hour
+	if window <= 48*time.Hour {
+		bucket = time.Hour
+	}
+	if bucketFlag != "" {
+		if bucket, err = parseStatsDuration(bucketFlag); err != nil {
+			log.Fatalf("[ERROR] --bucket: %v", err)
+		}
+	}
+
+	since := time.Now().Add(-window).Truncate(time.Second)
+	runs, err := readRunStats(statsPath(stateDir), since)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("[ERROR] Failed to read stats file: %v", err)
+	}
+	report := buildStatsReport(runs, since, bucket)
+
+	if output == outputJSON {
+		printJSON(report)
+		return
+	}
+	if report.Runs == 0 {
+		fmt.Printf("No runs recorded since %s.\n", report.Since)
+		return
+	}
+
+	fmt.Printf("%d run(s) since %s\n\n", report.Runs, report.Since)
+	fmt.Printf("%-20s %5s %6s %7s %8s %10s %8s %9s\n", "PERIOD", "RUNS", "FAILED", "RELAYS", "EVENTS", "CANDIDATES", "SIGNERS", "DURATION")
+	for _, p := range report.Periods {
+		fmt.Printf("%-20s %5d %6d %7.1f %8.1f %10.1f %8.1f %9s\n", p.Start, p.Runs, p.Failed, p.RelaysConnected, p.Events, p.Candidates, p.Signers,
+			(time.Duration(p.DurationMillis) * time.Millisecond).Round(time.Millisecond))
+	}
+
+	if len(report.Relays) > 0 {
+		fmt.Printf("\n%-40s %7s %9s %8s %10s\n", "RELAY", "POLLS", "CONNECTED", "EVENTS", "CONNECT")
+		for _, r := range report.Relays {
+			fmt.Printf("%-40s %7d %8.0f%% %8.1f %10s\n", r.URL, r.Polls, 100*float64(r.Connected)/float64(r.Polls), r.Events,
+				(time.Duration(r.ConnectMillis) * time.Millisecond).Round(time.Millisecond))
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
+)
+
+// HistoryRecord is a performed action as reported by the history and status
+// commands
+type HistoryRecord struct {
+	Action      string        `json:"action"`             // Action key
+	PerformedAt string        `json:"performed_at"`       // ISO8601 time the action was recorded
+	LogFile     string        `json:"log_file,omitempty"` // Execution log, relative to the state dir
+	Votes       []signal.Vote `json:"votes,omitempty"`    // Votes that carried the action to quorum
+}
+
+// ExecutionStatus summarizes an incomplete execution
+type ExecutionStatus struct {
+	Action     string `json:"action"`               // Action key
+	Status     string `json:"status"`               // running or failed
+	Executor   string `json:"executor"`             // Executor backend name
+	StepsDone  int    `json:"steps_done"`           // Steps completed so far
+	StepsTotal int    `json:"steps_total"`          // Steps in the executor plan
+	Step       string `json:"step,omitempty"`       // First step not yet done
+	Error      string `json:"error,omitempty"`      // Last error of that step
+	UpdatedAt  string `json:"updated_at,omitempty"` // ISO8601 time of the last change
+}
+
+// ScheduledStatus is an action waiting for its execution time
+type ScheduledStatus struct {
+	Action    string `json:"action"`     // Action key
+	ExecuteAt string `json:"execute_at"` // RFC3339 time the action may run
+}
+
+// FailingStatus is an action whose attempts keep failing
+type FailingStatus struct {
+	Action   string `json:"action"`             // Action key
+	Failures int    `json:"failures"`           // Failed attempts since the last reset
+	RetryAt  string `json:"retry_at,omitempty"` // RFC3339 time of the next attempt
+	Blocked  bool   `json:"blocked,omitempty"`  // Not retried until 'qube-manager retry-action'
+	Error    string `json:"error,omitempty"`    // Reason of the latest failure
+}
+
+// DeclinedStatus is an action the operator declined through overrides.yaml
+type DeclinedStatus struct {
+	Action string `json:"action"` // Action key
+	Reason string `json:"reason"` // Override that declines it
+}
+
+// ActionState is the lifecycle state of an action not yet done
+type ActionState struct {
+	Action  string `json:"action"`             // Action key
+	State   string `json:"state"`              // Current lifecycle state
+	Since   string `json:"since"`              // ISO8601 time the state was entered
+	Error   string `json:"error,omitempty"`    // Last failure reason, if any
+	LogFile string `json:"log_file,omitempty"` // Log of the latest execution attempt, relative to the state dir
+}
+
+// SignerStatus is when a followed signer was last heard from
+type SignerStatus struct {
+	Npub     string `json:"npub"`                // Signer npub
+	LastSeen string `json:"last_seen,omitempty"` // ISO8601 creation time of its newest signal or heartbeat
+	Via      string `json:"via,omitempty"`       // "signal" or "heartbeat"
+	Silent   bool   `json:"silent"`              // Silent beyond signer_silence_alert_after while others signal
+}
+
+// NodeStatus is the local view of this manager reported by the status command
+type NodeStatus struct {
+	Npub             string            `json:"npub"`                        // This manager's public key
+	NodeVersion      string            `json:"node_version,omitempty"`      // Detected node version
+	Executor         string            `json:"executor,omitempty"`          // Configured executor backend
+	FleetHosts       int               `json:"fleet_hosts,omitempty"`       // Hosts managed in fleet mode
+	ObserveOnly      bool              `json:"observe_only,omitempty"`      // Actions are tracked but never executed
+	Relays           int               `json:"relays"`                      // Relays configured
+	Follows          int               `json:"follows"`                     // Signers followed
+	Quorum           int               `json:"quorum"`                      // Votes needed for an action
+	LastAction       *HistoryRecord    `json:"last_action,omitempty"`       // Most recently performed action
+	Actions          []ActionState     `json:"actions,omitempty"`           // Lifecycle of actions not yet done
+	Execution        *ExecutionStatus  `json:"execution,omitempty"`         // Incomplete execution, if any
+	Scheduled        []ScheduledStatus `json:"scheduled,omitempty"`         // Actions waiting for their execution time
+	AwaitingApproval []string          `json:"awaiting_approval,omitempty"` // Actions queued for operator approval
+	Failing          []FailingStatus   `json:"failing,omitempty"`           // Actions retried with backoff or blocked
+	Skip             []string          `json:"skip,omitempty"`              // Actions skipped in overrides.yaml
+	PinVersion       string            `json:"pin_version,omitempty"`       // Version pinned in overrides.yaml
+	Declined         []DeclinedStatus  `json:"declined,omitempty"`          // Known actions the overrides decline
+	Outbox           []string          `json:"outbox,omitempty"`            // Events still waiting for enough relays to accept them
+	Signers          []SignerStatus    `json:"signers,omitempty"`           // When each active follow was last heard from
+}
+
+// historyRecords returns the history entries oldest first
+func historyRecords(h *History) []HistoryRecord {
+	records := make([]HistoryRecord, 0, len(h.Entries))
+	for key, entry := range h.Entries {
+		r := HistoryRecord{Action: key, PerformedAt: entry.PerformedAt, Votes: entry.Votes}
+		if lc := h.Lifecycle[key]; lc != nil {
+			r.LogFile = lc.LogFile
+		}
+		records = append(records, r)
+	}
+	slices.SortFunc(records, func(a, b HistoryRecord) int {
+		if c := strings.Compare(a.PerformedAt, b.PerformedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Action, b.Action)
+	})
+	return records
+}
+
+func newHistoryCommand(g *globals) *cobra.Command {
+	var evidence bool
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Print every performed action, oldest first",
+		Long: `Print every performed action, oldest first.
+Actions this manager executed are recorded with the votes that carried them to
+quorum: the signal event IDs, the signers' pubkeys and signatures, and the
+relays the events were received from. --output json exports them for audits.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			historyCLI(g.stateDir, g.output, evidence)
+		},
+	}
+	cmd.Flags().BoolVar(&evidence, "evidence", false, "Also print the votes each action was performed on")
+	return cmd
+}
+
+// historyCLI prints every performed action, oldest first, with the votes it
+// was performed on if evidence is set
+func historyCLI(stateDir string, output string, evidence bool) {
+	history := loadHistory(stateDir)
+	records := historyRecords(history)
+
+	if output == outputJSON {
+		printJSON(records)
+		return
+	}
+	for _, track := range slices.Sorted(maps.Keys(history.Compacted)) {
+		fmt.Printf("%-25s %s up to %s (compacted)\n", "", track, history.Compacted[track])
+	}
+	if len(history.Tombstones) > 0 {
+		fmt.Printf("%-25s %d more action(s) (compacted)\n", "", len(history.Tombstones))
+	}
+	if len(records) == 0 && len(history.Compacted) == 0 && len(history.Tombstones) == 0 {
+		fmt.Println("No actions have been performed.")
+		return
+	}
+	for _, r := range records {
+		if r.LogFile != "" {
+			fmt.Printf("%-25s %s (log: %s)\n", r.PerformedAt, r.Action, r.LogFile)
+		} else {
+			fmt.Printf("%-25s %s\n", r.PerformedAt, r.Action)
+		}
+		if !evidence {
+			continue
+		}
+		for _, v := range r.Votes {
+			npub, _ := nip19.EncodePublicKey(v.PubKey)
+			sig := "sig"
+			if v.Cosigned {
+				sig = "cosig"
+			}
+			relays := v.Relays
+			if len(relays) == 0 {
+				relays = []string{v.Relay}
+			}
+			fmt.Printf("  vote %s event %s %s %s via %s\n", npub, v.EventID, sig, v.Sig, strings.Join(relays, ", "))
+		}
+	}
+}
+
+// nodeStatus collects the local state of this manager without changing it
+func nodeStatus(configDir, stateDir string, kp Keypair) NodeStatus {
+	cfg := loadConfig(configDir, stateDir)
+	history := loadHistory(stateDir)
+
+	st := NodeStatus{
+		Npub:     kp.Npub,
+		Executor: cfg.Executor.Type,
+		Relays:   len(cfg.Relays),
+		Follows:  len(cfg.Follows),
+		Quorum:   cfg.Quorum,
+
+		ObserveOnly: cfg.ObserveOnly,
+	}
+	if cfg.Fleet.Enabled() {
+		st.FleetHosts = len(cfg.Fleet.Hosts)
+	}
+	if v := currentNodeVersion(cfg.Node); v != nil {
+		st.NodeVersion = v.Original()
+	}
+	if records := historyRecords(history); len(records) > 0 {
+		st.LastAction = &records[len(records)-1]
+	}
+
+	for key, lc := range history.Lifecycle {
+		if lc.State != stateDone {
+			st.Actions = append(st.Actions, ActionState{Action: key, State: lc.State, Since: lc.UpdatedAt, Error: lc.Error, LogFile: lc.LogFile})
+		}
+	}
+	slices.SortFunc(st.Actions, func(a, b ActionState) int { return strings.Compare(a.Since, b.Since) })
+
+	state, err := loadExecutionState(executionStatePath(stateDir))
+	if err != nil {
+		log.Printf("[WARN] %v", err)
+	} else if state != nil && !history.Has(state.Action) {
+		exec := &ExecutionStatus{
+			Action:     state.Action,
+			Status:     state.Status,
+			Executor:   state.Executor,
+			StepsTotal: len(state.Steps),
+			UpdatedAt:  state.UpdatedAt,
+		}
+		for _, step := range state.Steps {
+			if step.Status == stepDone {
+				exec.StepsDone++
+			} else if exec.Step == "" {
+				exec.Step = step.Name
+				exec.Error = step.Error
+			}
+		}
+		st.Execution = exec
+	}
+
+	for key, entry := range loadSchedule(stateDir).Entries {
+		if !history.Has(key) {
+			st.Scheduled = append(st.Scheduled, ScheduledStatus{Action: key, ExecuteAt: entry.ExecuteAt})
+		}
+	}
+	slices.SortFunc(st.Scheduled, func(a, b ScheduledStatus) int { return strings.Compare(a.ExecuteAt, b.ExecuteAt) })
+
+	approvals, err := loadApprovals(stateDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	for key, entry := range approvals.Entries {
+		if entry.Status == approvalAwaiting {
+			st.AwaitingApproval = append(st.AwaitingApproval, key)
+		}
+	}
+	slices.Sort(st.AwaitingApproval)
+
+	failures, err := loadFailures(stateDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	for key, entry := range failures.Entries {
+		if !history.Has(key) {
+			st.Failing = append(st.Failing, FailingStatus{Action: key, Failures: entry.Failures, RetryAt: entry.RetryAt, Blocked: entry.Blocked(), Error: entry.Error})
+		}
+	}
+	slices.SortFunc(st.Failing, func(a, b FailingStatus) int { return strings.Compare(a.Action, b.Action) })
+
+	overrides, err := loadOverrides(configDir)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	st.Skip, st.PinVersion = overrides.Skip, overrides.PinVersion
+	for key, lc := range history.Lifecycle {
+		if lc.State == stateDone {
+			continue
+		}
+		if reason := overrides.Declined(key); reason != "" {
+			st.Declined = append(st.Declined, DeclinedStatus{Action: key, Reason: reason})
+		}
+	}
+	slices.SortFunc(st.Declined, func(a, b DeclinedStatus) int { return strings.Compare(a.Action, b.Action) })
+
+	for _, e := range loadOutbox(stateDir).Entries {
+		st.Outbox = append(st.Outbox, e.Label)
+	}
+
+	participation := loadParticipation(stateDir)
+	follows := activeFollows(cfg, revokedKeys(history))
+	silent := make(map[string]bool)
+	for _, pk := range participation.Silent(follows, cfg.SignerSilenceAlertAfter) {
+		silent[pk] = true
+	}
+	for _, pk := range follows {
+		s := SignerStatus{Npub: pk, Silent: silent[pk]}
+		if npub, err := nip19.EncodePublicKey(pk); err == nil {
+			s.Npub = npub
+		}
+		if seen := participation.Signers[pk]; seen != nil {
+			s.LastSeen, s.Via = seen.LastSeen, seen.Via
+		}
+		st.Signers = append(st.Signers, s)
+	}
+
+	return st
+}
+
+func newStatusCommand(g *globals) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Print the identity, node version, last action, and work in progress",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			statusCLI(g.configDir, g.stateDir, g.keypair, g.output)
+		},
+	}
+}
+
+// statusCLI prints the local state of this manager: identity, node version,
+// last performed action, and any work in progress
+func statusCLI(configDir, stateDir string, kp Keypair, output string) {
+	st := nodeStatus(configDir, stateDir, kp)
+
+	if output == outputJSON {
+		printJSON(st)
+		return
+	}
+
+	fmt.Printf("Npub:         %s\n", st.Npub)
+	if st.NodeVersion != "" {
+		fmt.Printf("Node version: %s\n", st.NodeVersion)
+	}
+	switch {
+	case st.ObserveOnly:
+		fmt.Println("Executor:     none (observing only)")
+	case st.FleetHosts > 0:
+		fmt.Printf("Executor:     %s on %d fleet host(s)\n", st.Executor, st.FleetHosts)
+	case st.Executor != "":
+		fmt.Printf("Executor:     %s\n", st.Executor)
+	default:
+		fmt.Println("Executor:     none (actions are only recorded)")
+	}
+	fmt.Printf("Signals:      %d relay(s), %d follow(s), quorum=%d\n", st.Relays, st.Follows, st.Quorum)
+	if st.LastAction != nil {
+		fmt.Printf("Last action:  %s at %s\n", st.LastAction.Action, st.LastAction.PerformedAt)
+	} else {
+		fmt.Println("Last action:  none")
+	}
+	for _, a := range st.Actions {
+		fmt.Printf("Action:       %s %s since %s\n", a.Action, a.State, a.Since)
+		if a.Error != "" {
+			fmt.Printf("              error: %s\n", a.Error)
+		}
+		if a.LogFile != "" {
+			fmt.Printf("              log: %s\n", a.LogFile)
+		}
+	}
+	if e := st.Execution; e != nil {
+		fmt.Printf("Execution:    %s %s (%d/%d steps done", e.Action, e.Status, e.StepsDone, e.StepsTotal)
+		if e.Step != "" {
+			fmt.Printf(", next: %s", e.Step)
+		}
+		fmt.Println(")")
+		if e.Error != "" {
+			fmt.Printf("              last error: %s\n", e.Error)
+		}
+	}
+	for _, s := range st.Scheduled {
+		fmt.Printf("Scheduled:    %s at %s\n", s.Action, s.ExecuteAt)
+	}
+	for _, key := range st.AwaitingApproval {
+		fmt.Printf("Approval:     %s is awaiting approval\n", key)
+	}
+	for _, f := range st.Failing {
+		if f.Blocked {
+			fmt.Printf("Failing:      %s blocked after %d failure(s); run 'qube-manager retry-action %s'\n", f.Action, f.Failures, f.Action)
+		} else {
+			fmt.Printf("Failing:      %s failed %d time(s), next attempt at %s\n", f.Action, f.Failures, f.RetryAt)
+		}
+	}
+	if len(st.Skip) > 0 {
+		fmt.Printf("Overrides:    skip %s\n", strings.Join(st.Skip, ", "))
+	}
+	if st.PinVersion != "" {
+		fmt.Printf("Overrides:    pinned to %s\n", st.PinVersion)
+	}
+	for _, d := range st.Declined {
+		fmt.Printf("Declined:     %s (%s)\n", d.Action, d.Reason)
+	}
+	for _, label := range st.Outbox {
+		fmt.Printf("Outbox:       %s is waiting for relays to accept it\n", label)
+	}
+	for _, s := range st.Signers {
+		if s.LastSeen == "" {
+			fmt.Printf("Signer:       %s never seen", s.Npub)
+		} else {
+			fmt.Printf("Signer:       %s last seen %s (%s)", s.Npub, s.LastSeen, s.Via)
+		}
+		if s.Silent {
+			fmt.Print(" - silent while others signal")
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Defaults bounding the events read and the candidates kept per poll
+const (
+	defaultMaxEventsPerRelay = 1000 // Events read from one relay when max_events_per_relay is unset
+	defaultMaxEventsTotal    = 5000 // Events read from all relays when max_events_total is unset
+	defaultMaxCandidates     = 100  // Candidate actions tracked when max_candidates is unset
+)
+
+// addressableSignalKind is the addressable event kind (NIP-33) publishers can
+// deliver signals in, so a correction replaces the signal with the same d tag
+// instead of accumulating next to it
+const addressableSignalKind = nostr.KindApplicationSpecificData
+
+// SubscriptionConfig scopes the relay subscription so the manager does not
+// pull every note its follows ever wrote
+type SubscriptionConfig struct {
+	Tags              map[string][]string `yaml:"tags,omitempty"`                 // Single-letter tag filters for public notes, e.g. t: [hyperqube]
+	Kinds             []int               `yaml:"kinds,omitempty"`                // Additional public event kinds carrying signals, besides notes and addressable signals
+	MaxEventsPerRelay int                 `yaml:"max_events_per_relay,omitempty"` // Events read from one relay per poll before the rest are dropped
+	MaxEventsTotal    int                 `yaml:"max_events_total,omitempty"`     // Events read from all relays per poll before the remaining relays are skipped
+	MaxCandidates     int                 `yaml:"max_candidates,omitempty"`       // Candidate actions tracked at once; the least recently voted below quorum are evicted
+}
+
+// publicKinds returns the event kinds that carry public signals
+func (c SubscriptionConfig) publicKinds() []int {
+	kinds := []int{nostr.KindTextNote, addressableSignalKind}
+	for _, k := range c.Kinds {
+		if !slices.Contains(kinds, k) {
+			kinds = append(kinds, k)
+		}
+	}
+	return kinds
+}
+
+// eventTags returns the configured tag filters as tags to attach to
+// published signals, so managers scoping by tag receive them
+func (c SubscriptionConfig) eventTags() nostr.Tags {
+	names := make([]string, 0, len(c.Tags))
+	for name := range c.Tags {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var tags nostr.Tags
+	for _, name := range names {
+		for _, value := range c.Tags[name] {
+			tags = append(tags, nostr.Tag{name, value})
+		}
+	}
+	return tags
+}
+
+// validate checks that tag filters can be served by relays, which only index
+// single-letter tags, and that extra kinds are not reserved for DMs or
+// deletions
+func (c SubscriptionConfig) validate() []configProblem {
+	var problems []configProblem
+	for name, values := range c.Tags {
+		field := fmt.Sprintf("subscription.tags.%s", name)
+		if len(name) != 1 {
+			problems = append(problems, configProblem{Field: field, Message: "relays only filter on single-letter tags"})
+		}
+		if len(values) == 0 {
+			problems = append(problems, configProblem{Field: field, Message: "must list at least one value"})
+		}
+	}
+	for i, k := range c.Kinds {
+		if k < 0 || k == nostr.KindEncryptedDirectMessage || k == nostr.KindGiftWrap || k == nostr.KindDeletion {
+			problems = append(problems, configProblem{
+				Field:   fmt.Sprintf("subscription.kinds[%d]", i),
+				Message: fmt.Sprintf("kind %d cannot carry public signals", k),
+			})
+		}
+	}
+	if c.MaxEventsPerRelay < 0 {
+		problems = append(problems, configProblem{Field: "subscription.max_events_per_relay", Message: "must not be negative"})
+	}
+	if c.MaxEventsTotal < 0 {
+		problems = append(problems, configProblem{Field: "subscription.max_events_total", Message: "must not be negative"})
+	}
+	if c.MaxCandidates < 0 {
+		problems = append(problems, configProblem{Field: "subscription.max_candidates", Message: "must not be negative"})
+	}
+	return problems
+}
+
+// signalFilters returns the subscription filters for signals: public notes
+// of the configured kinds and tags from plain follows, gift-wrapped DMs to
+// this manager if any follow is encrypted, and NIP-09 deletions from all follows so retracted
+// signals stop counting. Each filter asks relays for at most the per-relay
+// event limit.
+func signalFilters(cfg SubscriptionConfig, follows []string, encrypted map[string]bool, kp Keypair) (nostr.Filters, error) {
+	var public, private []string
+	for _, pk := range follows {
+		if encrypted[pk] {
+			private = append(private, pk)
+		} else {
+			public = append(public, pk)
+		}
+	}
+
+	var filters nostr.Filters
+	if len(public) > 0 {
+		f := nostr.Filter{
+			Authors: public,
+			Kinds:   cfg.publicKinds(),
+			Limit:   cfg.MaxEventsPerRelay,
+		}
+		if len(cfg.Tags) > 0 {
+			f.Tags = nostr.TagMap(cfg.Tags)
+		}
+		filters = append(filters, f)
+	}
+	if len(private) > 0 {
+		pk, err := kp.publicKey()
+		if err != nil {
+			return nil, err
+		}
+		// Gift wraps are signed by throwaway keys, so only the recipient
+		// narrows them down; the follow is checked once unwrapped
+		filters = append(filters, nostr.Filter{
+			Kinds: []int{nostr.KindGiftWrap},
+			Tags:  nostr.TagMap{"p": []string{pk}},
+			Limit: cfg.MaxEventsPerRelay,
+		})
+	}
+	if len(follows) > 0 {
+		filters = append(filters, nostr.Filter{
+			Authors: follows,
+			Kinds:   []int{nostr.KindDeletion},
+			Limit:   cfg.MaxEventsPerRelay,
+		})
+	}
+	return filters, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// checkPoW returns an error wrapping errInsufficientPoW if ev carries a
+// signal, or is an addressable event that could replace one, without a NIP-13
+// nonce committing to at least difficulty bits. Notes that are not signals
+// need no proof of work.
+func checkPoW(ev *nostr.Event, content string, difficulty int) error {
+	if difficulty <= 0 {
+		return nil
+	}
+	if signal.Address(ev) == "" {
+		if _, err := signal.Parse(content); errors.Is(err, signal.ErrInvalidJSON) || errors.Is(err, signal.ErrUnknownMessageType) {
+			return nil
+		}
+	}
+	if got := nip13.CommittedDifficulty(ev); got < difficulty {
+		return fmt.Errorf("%w: %d/%d bits", errInsufficientPoW, got, difficulty)
+	}
+	return nil
+}
+
+// addSignal feeds an event to the evaluator and logs the outcome. content is
+// the signal it carries, as signalContent returned it; a direct message
+// arrives here already unwrapped. In threshold mode, trusted holds the
+// signers whose co-signatures count; it is nil when counting one vote per
+// event. It returns the action voted for, or the reason the event is not a
+// valid signal.
+func addSignal(e *signal.Evaluator, ev *nostr.Event, content string, relayURL string, trusted map[string]bool, verbose bool) (*signal.Action, error) {
+	var action *signal.Action
+	var err error
+	withdrawn := len(e.Withdrawn())
+	if trusted != nil {
+		action, err = e.AddCosignedEvent(ev, relayURL, trusted)
+	} else {
+		action, err = e.AddEvent(ev, relayURL)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, signal.ErrBelowThreshold), errors.Is(err, signal.ErrOtherCohort), errors.Is(err, signal.ErrOtherNetwork), errors.Is(err, signal.ErrRetracted), errors.Is(err, signal.ErrSuperseded), errors.Is(err, signal.ErrWithdrawn):
+			noisyLog.Printf(noiseEvent, "ignored:"+ev.PubKey, "[INFO] Ignoring signal %s from pubkey %s: %v", ev.ID, ev.PubKey, err)
+		case errors.Is(err, signal.ErrUnsupportedSchema), errors.Is(err, signal.ErrCandidateLimit):
+			log.Printf("[WARN] Ignoring signal %s from pubkey %s: %v", ev.ID, ev.PubKey, err)
+		case errors.Is(err, signal.ErrInvalidJSON):
+			if verbose {
+				log.Printf("[DEBUG] Skipping event with invalid JSON from pubkey %s: %s", ev.PubKey, content)
+			}
+		case errors.Is(err, signal.ErrUnknownMessageType):
+			if verbose {
+				log.Printf("[DEBUG] Ignoring event with %v", err)
+			}
+		default:
+			noisyLog.Printf(noiseEvent, "invalid:"+ev.PubKey, "[WARN] Ignoring signal %s from pubkey %s: %v", ev.ID, ev.PubKey, err)
+		}
+		return nil, err
+	}
+	for _, w := range e.Withdrawn()[withdrawn:] {
+		if _, ok := e.Actions[w.Key]; ok {
+			log.Printf("[INFO] Pubkey %s moved on to %s - vote for %s withdrawn, votes now %d/%d", w.PubKey, w.By, w.Key, len(e.Votes[w.Key]), e.Quorum())
+		} else {
+			log.Printf("[INFO] Pubkey %s moved on to %s - vote for %s withdrawn, no votes left", w.PubKey, w.By, w.Key)
+		}
+	}
+
+	switch action.Type {
+	case signal.TypeUpgrade:
+		if action.Cohort != "" {
+			log.Printf("[INFO] Parsed upgrade message: version=%s cohort=%s pubkey=%s", action.Version.Original(), action.Cohort, ev.PubKey)
+		} else {
+			log.Printf("[INFO] Parsed upgrade message: version=%s pubkey=%s", action.Version.Original(), ev.PubKey)
+		}
+	case signal.TypeReboot:
+		log.Printf("[INFO] Parsed reboot message: version=%s genesis=%s pubkey=%s", action.Version.Original(), action.Genesis, ev.PubKey)
+	case signal.TypeRollback:
+		log.Printf("[INFO] Parsed rollback message: version=%s pubkey=%s", action.Version.Original(), ev.PubKey)
+	case signal.TypeRevokeKey:
+		log.Printf("[INFO] Parsed revoke-key message: target=%s pubkey=%s", action.Target, ev.PubKey)
+	}
+	return action, nil
+}
+
+// retractSignals applies a NIP-09 deletion event to the evaluator and logs
+// every action that lost votes. It returns the keys of those actions.
+func retractSignals(e *signal.Evaluator, ev *nostr.Event) []string {
+	keys := e.Retract(ev)
+	for _, key := range keys {
+		if _, ok := e.Actions[key]; ok {
+			log.Printf("[INFO] Pubkey %s retracted its signal for %s - votes now %d/%d", ev.PubKey, key, len(e.Votes[key]), e.Quorum())
+		} else {
+			log.Printf("[INFO] Pubkey %s retracted its signal for %s - no votes left", ev.PubKey, key)
+		}
+	}
+	return keys
+}
+
+// replaceSignals applies an addressable signal event (NIP-33) to the
+// evaluator, discarding the votes cast through the earlier version it
+// replaces, and logs every action that lost votes. It returns the keys of
+// those actions, or an error wrapping signal.ErrSuperseded if ev itself was
+// replaced already.
+func replaceSignals(e *signal.Evaluator, ev *nostr.Event) ([]string, error) {
+	keys, err := e.Replace(ev)
+	if err != nil {
+		log.Printf("[INFO] Ignoring signal %s from pubkey %s: %v", ev.ID, ev.PubKey, err)
+		return nil, err
+	}
+	for _, key := range keys {
+		if _, ok := e.Actions[key]; ok {
+			log.Printf("[INFO] Pubkey %s replaced its signal for %s - votes now %d/%d", ev.PubKey, key, len(e.Votes[key]), e.Quorum())
+		} else {
+			log.Printf("[INFO] Pubkey %s replaced its signal for %s - no votes left", ev.PubKey, key)
+		}
+	}
+	return keys, nil
+}
+
+// selectAction returns the action to perform along with the number of
+// candidates not yet in history, logging candidates still below quorum,
+// superseded by a rollback, or outranked by the selected action
+func selectAction(e *signal.Evaluator, history *History) (*signal.Action, int) {
+	for _, key := range e.Superseded(history) {
+		log.Printf("[INFO] Skipping action %s - superseded by a rollback", key)
+	}
+	for _, key := range e.BelowQuorum(history) {
+		if missing := e.MissingRoles(key); len(e.Votes[key]) >= e.Quorum() && len(missing) > 0 {
+			log.Printf("[INFO] Skipping action %s - votes %d/%d but no %s voted", key, len(e.Votes[key]), e.Quorum(), strings.Join(missing, " or "))
+			continue
+		}
+		log.Printf("[INFO] Skipping action %s - votes %d/%d (below quorum)", key, len(e.Votes[key]), e.Quorum())
+	}
+	selected := e.Select(history)
+	for _, key := range e.Outranked(selected, history) {
+		log.Printf("[INFO] Skipping action %s - outranked by %s under the conflict policy", key, selected.Key)
+	}
+	return selected, e.Pending(history)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// defaultHeartbeatInterval is how often heartbeats are published in daemon
+// mode when telemetry is enabled
+const defaultHeartbeatInterval = 15 * time.Minute
+
+// TelemetryConfig configures sampling of local node stats, which are
+// published in heartbeat events and exported as metrics
+type TelemetryConfig struct {
+	Enabled           bool          `yaml:"enabled,omitempty"`            // Sample node stats every run
+	DataDir           string        `yaml:"data_dir,omitempty"`           // Node data directory whose disk usage is reported (default $HOME/.znn)
+	Process           string        `yaml:"process,omitempty"`            // Node process name whose uptime is reported (default znnd)
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval,omitempty"` // How often the daemon publishes heartbeat events
+}
+
+// applyDefaults fills in unset telemetry settings
+func (t *TelemetryConfig) applyDefaults() {
+	if !t.Enabled {
+		return
+	}
+	if t.DataDir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			t.DataDir = filepath.Join(home, ".znn")
+		}
+	}
+	if t.Process == "" {
+		t.Process = "znnd"
+	}
+	if t.HeartbeatInterval == 0 {
+		t.HeartbeatInterval = defaultHeartbeatInterval
+	}
+}
+
+// Telemetry is a sample of local node stats. Stats that could not be sampled
+// are left out.
+type Telemetry struct {
+	MomentumHeight uint64 `json:"momentumHeight,omitempty"` // Height of the node's frontier momentum
+	Peers          *int   `json:"peers,omitempty"`          // Connected peers
+	DiskUsedBytes  uint64 `json:"diskUsedBytes,omitempty"`  // Used space on the data directory's filesystem
+	DiskTotalBytes uint64 `json:"diskTotalBytes,omitempty"` // Size of the data directory's filesystem
+	UptimeSeconds  int64  `json:"uptimeSeconds,omitempty"`  // Time since the node process started
+	SampledAt      string `json:"sampledAt"`                // RFC3339 time of the sample
+}
+
+// sampleTelemetry collects node stats from the node's JSON-RPC endpoint and
+// the local system. Failures leave the stat out, since a node that is down or
+// resyncing is exactly what coordinators want to see, and are logged only if
+// verbose is set.
+func sampleTelemetry(node NodeConfig, cfg TelemetryConfig, verbose bool) *Telemetry {
+	t := &Telemetry{SampledAt: time.Now().UTC().Format(time.RFC3339)}
+
+	if node.RPCURL != "" {
+		var momentum struct {
+			Height uint64 `json:"height"`
+		}
+		if err := nodeRPC(node.RPCURL, "ledger.getFrontierMomentum", &momentum); err != nil {
+			if verbose {
+				log.Printf("[DEBUG] Telemetry: momentum height unavailable: %v", err)
+			}
+		} else {
+			t.MomentumHeight = momentum.Height
+		}
+
+		var network struct {
+			NumPeers int `json:"numPeers"`
+		}
+		if err := nodeRPC(node.RPCURL, "stats.networkInfo", &network); err != nil {
+			if verbose {
+				log.Printf("[DEBUG] Telemetry: peer count unavailable: %v", err)
+			}
+		} else {
+			t.Peers = &network.NumPeers
+		}
+	}
+
+	if cfg.DataDir != "" {
+		if used, total, err := diskUsage(cfg.DataDir); err != nil {
+			if verbose {
+				log.Printf("[DEBUG] Telemetry: disk usage unavailable: %v", err)
+			}
+		} else {
+			t.DiskUsedBytes, t.DiskTotalBytes = used, total
+		}
+	}
+
+	if uptime, err := processUptime(cfg.Process); err != nil {
+		if verbose {
+			log.Printf("[DEBUG] Telemetry: uptime of %s unavailable: %v", cfg.Process, err)
+		}
+	} else {
+		t.UptimeSeconds = uptime
+	}
+
+	return t
+}
+
+// processUptime returns the seconds since the oldest process with the given
+// name started, as reported by ps
+func processUptime(name string) (int64, error) {
+	out, err := exec.Command("ps", "-eo", "etimes=,comm=").Output()
+	if err != nil {
+		return 0, err
+	}
+
+	var uptime int64 = -1
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != name {
+			continue
+		}
+		if secs, err := strconv.ParseInt(fields[0], 10, 64); err == nil && secs > uptime {
+			uptime = secs
+		}
+	}
+	if uptime < 0 {
+		return 0, errors.New("process not running")
+	}
+	return uptime, nil
+}
+
+// HeartbeatMessage periodically reports a node's version and stats to
+// coordinators
+type HeartbeatMessage struct {
+	Type          string     `json:"type"`                    // Must be "heartbeat"
+	SchemaVersion string     `json:"schemaVersion,omitempty"` // Schema revision, "MAJOR.MINOR" (1.0 if unset)
+	NodeVersion   string     `json:"nodeVersion,omitempty"`   // Detected node version
+	Telemetry     *Telemetry `json:"telemetry"`               // Sampled node stats
+	Declined      []string   `json:"declined,omitempty"`      // Actions the operator declined through overrides.yaml
+}
+
+// newHeartbeatEvent builds the unsigned heartbeat event. It carries the
+// subscription tags so coordinators can scope their queries like signals.
+func newHeartbeatEvent(cfg Config, nodeVersion string, t *Telemetry, declined []string) (nostr.Event, error) {
+	content, err := json.Marshal(HeartbeatMessage{
+		Type:          "heartbeat",
+		SchemaVersion: signal.SchemaVersion,
+		NodeVersion:   nodeVersion,
+		Telemetry:     t,
+		Declined:      declined,
+	})
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	return nostr.Event{
+		CreatedAt: nostr.Timestamp(time.Now().Unix()),
+		Kind:      nostr.KindTextNote,
+		Tags:      append(cfg.Subscription.eventTags(), correlationTags()...),
+		Content:   string(content),
+	}, nil
+}
+
+// heightTag returns the tag recording the momentum height a node reached
+// after completing an action, or nil if it is unknown
+func heightTag(t *Telemetry) nostr.Tag {
+	if t == nil || t.MomentumHeight == 0 {
+		return nil
+	}
+	return nostr.Tag{"height", strconv.FormatUint(t.MomentumHeight, 10)}
+}
+
+// heartbeat publishes a heartbeat event if telemetry is enabled and the
+// heartbeat interval has passed since the last one
+func (m *Manager) heartbeat() {
+	cfg := m.config.Telemetry
+	if !cfg.Enabled || m.dryRun || time.Since(m.lastHeartbeat) < cfg.HeartbeatInterval {
+		return
+	}
+	m.lastHeartbeat = time.Now()
+
+	var version string
+	if v := currentNodeVersion(m.config.Node); v != nil {
+		version = v.Original()
+	}
+	ev, err := newHeartbeatEvent(m.config, version, sampleTelemetry(m.config.Node, cfg, m.verbose), m.declined)
+	if err == nil {
+		err = signEvent(m.keypair, &ev)
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to build heartbeat event: %v", err)
+		return
+	}
+
+	wait := publishToRelays(m.config.writeRelays(), ev, m.config.ConnectTimeout, m.config.ReadTimeout, m.shutdown)
+	go func() {
+		accepted := wait()
+		backgroundLog.Printf("[INFO] Heartbeat accepted by %d/%d relays", accepted, len(m.config.writeRelays()))
+	}()
+}
+
+// summary describes the sample for logs
+func (t *Telemetry) summary() string {
+	parts := []string{fmt.Sprintf("height %d", t.MomentumHeight)}
+	if t.Peers != nil {
+		parts = append(parts, fmt.Sprintf("%d peers", *t.Peers))
+	}
+	if t.DiskTotalBytes > 0 {
+		parts = append(parts, fmt.Sprintf("disk %s/%s", formatBytes(t.DiskUsedBytes), formatBytes(t.DiskTotalBytes)))
+	}
+	if t.UptimeSeconds > 0 {
+		parts = append(parts, fmt.Sprintf("up %v", time.Duration(t.UptimeSeconds)*time.Second))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatBytes renders a byte count for logs
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// diskUsage is only implemented on Unix systems
+func diskUsage(path string) (uint64, uint64, error) {
+	return 0, 0, errors.New("disk usage is not supported on this platform")
+}
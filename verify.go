@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hypercore-one/qube-manager/signal"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/spf13/cobra"
+)
+
+func newVerifyMessageCommand(g *globals) *cobra.Command {
+	var (
+		relayURL string
+		timeout  time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "verify-message [event-json | nevent1... | note1... | -]",
+		Short: "Check whether a signal event would be counted as a vote, or verify a done event's quorum",
+		Long: `Check a single signal event and report every reason it would or would not
+be counted as a vote. The event is read from the argument, from stdin if the
+argument is '-' or missing, or fetched from relays by nevent or note id.
+
+For a done event published by a manager, the signals it replies to are
+fetched instead and their signers counted, proving the node acted on a
+quorum of votes.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			input := "-"
+			if len(args) > 0 {
+				input = args[0]
+			}
+			verifyMessageCLI(g.configDir, g.stateDir, g.keypair, input, relayURL, timeout)
+		},
+	}
+	cmd.Flags().StringVar(&relayURL, "relay", "", "Additional relay to query when fetching by nevent/note id")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "Timeout for fetching the event from relays")
+	return cmd
+}
+
+// verifyMessageCLI checks a single signal event and reports every reason it
+// would or would not be counted as a vote by the manager
+func verifyMessageCLI(configDir, stateDir string, kp Keypair, input, relayURL string, timeout time.Duration) {
+	cfg := loadConfig(configDir, stateDir)
+
+	ev, err := resolveEvent(input, cfg.readRelays(), relayURL, timeout)
+	if err != nil {
+		log.Fatalf("[ERROR] Could not load event: %v", err)
+	}
+
+	if isDoneEvent(ev) {
+		if !reportDoneChecks(ev, cfg, relayURL, timeout) {
+			os.Exit(1)
+		}
+		return
+	}
+	if !reportEventChecks(ev, cfg, kp, revokedKeys(loadHistory(stateDir))) {
+		os.Exit(1)
+	}
+}
+
+// resolveEvent loads an event from raw JSON, stdin ("-"), or by fetching a
+// nevent/note identifier from relays
+func resolveEvent(input string, relays []string, extraRelay string, timeout time.Duration) (*nostr.Event, error) {
+	if strings.HasPrefix(input, "nevent1") || strings.HasPrefix(input, "note1") {
+		return fetchEvent(input, relays, extraRelay, timeout)
+	}
+
+	raw := []byte(input)
+	if input == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read event from stdin: %w", err)
+		}
+		raw = data
+	}
+
+	var ev nostr.Event
+	if err := json.Unmarshal(raw, &ev); err != nil {
+		return nil, fmt.Errorf("invalid event JSON: %w", err)
+	}
+	return &ev, nil
+}
+
+// fetchEvent decodes a nevent/note identifier and queries relays for it, using
+// relay hints from the identifier before the configured relays
+func fetchEvent(code string, relays []string, extraRelay string, timeout time.Duration) (*nostr.Event, error) {
+	prefix, value, err := nip19.Decode(code)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event identifier %s: %w", code, err)
+	}
+
+	var id string
+	var candidates []string
+	switch prefix {
+	case "note":
+		id = value.(string)
+	case "nevent":
+		ptr := value.(nostr.EventPointer)
+		id = ptr.ID
+		candidates = append(candidates, ptr.Relays...)
+	default:
+		return nil, fmt.Errorf("expected note or nevent but got %s", prefix)
+	}
+	if extraRelay != "" {
+		candidates = append(candidates, extraRelay)
+	}
+	for _, r := range relays {
+		if !slices.Contains(candidates, r) {
+			candidates = append(candidates, r)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no relays available to fetch the event from")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, url := range candidates {
+		log.Printf("[INFO] Fetching event %s from relay %s", id, url)
+		relay, err := nostr.RelayConnect(ctx, url)
+		if err != nil {
+			log.Printf("[WARN] Could not connect to relay %s: %v", url, err)
+			continue
+		}
+		events, err := relay.QuerySync(ctx, nostr.Filter{IDs: []string{id}})
+		relay.Close()
+		if err != nil {
+			log.Printf("[WARN] Query failed on relay %s: %v", url, err)
+			continue
+		}
+		if len(events) > 0 {
+			return events[0], nil
+		}
+		log.Printf("[INFO] Event %s not found on relay %s", id, url)
+	}
+
+	return nil, fmt.Errorf("event %s not found on any relay", id)
+}
+
+// reportEventChecks prints a pass/fail line for each check the manager applies
+// to a signal event and returns true if the event would be counted as a vote
+func reportEventChecks(ev *nostr.Event, cfg Config, kp Keypair, revoked map[string]bool) bool {
+	ok := true
+	check := func(passed bool, format string, args ...any) {
+		mark := "PASS"
+		if !passed {
+			mark = "FAIL"
+			ok = false
+		}
+		fmt.Printf("[%s] %s\n", mark, fmt.Sprintf(format, args...))
+	}
+
+	fmt.Printf("Event:   %s\n", ev.ID)
+	if npub, err := nip19.EncodePublicKey(ev.PubKey); err == nil {
+		fmt.Printf("Author:  %s\n", npub)
+	} else {
+		fmt.Printf("Author:  %s\n", ev.PubKey)
+	}
+	fmt.Printf("Created: %s\n", ev.CreatedAt.Time().UTC().Format(time.RFC3339))
+
+	check(ev.CheckID(), "event id matches content hash")
+
+	sigOK, err := ev.CheckSignature()
+	if err != nil {
+		check(false, "signature valid (%v)", err)
+	} else {
+		check(sigOK, "signature valid")
+	}
+
+	// The checks below apply to the direct message inside a gift wrap
+	if ev.Kind == nostr.KindGiftWrap {
+		dm, err := unwrapDM(ev, kp)
+		if err != nil {
+			check(false, "gift wrap opens with this manager's key (%v)", err)
+			return false
+		}
+		check(true, "gift wrap opens with this manager's key and its seal is validly signed")
+		ev = dm
+		fmt.Printf("Message: %s\n", ev.ID)
+		if npub, err := nip19.EncodePublicKey(ev.PubKey); err == nil {
+			fmt.Printf("Author:  %s\n", npub)
+		}
+		fmt.Printf("Created: %s\n", ev.CreatedAt.Time().UTC().Format(time.RFC3339))
+	}
+
+	encrypted := encryptedFollows(cfg.Follows)
+	if encrypted[ev.PubKey] {
+		check(ev.Kind == nostr.KindDirectMessage, "event is a gift-wrapped direct message (kind %d) for encrypted follow (got kind %d)", nostr.KindDirectMessage, ev.Kind)
+	} else {
+		kinds := cfg.Subscription.publicKinds()
+		check(slices.Contains(kinds, ev.Kind), "event kind is one of %v (got %d)", kinds, ev.Kind)
+		for name, values := range cfg.Subscription.Tags {
+			check(slices.ContainsFunc(values, func(v string) bool { return ev.Tags.FindWithValue(name, v) != nil }),
+				"event has a %q tag matching the subscription %v", name, values)
+		}
+	}
+
+	followed := slices.Contains(decodeFollows(cfg.Follows), ev.PubKey)
+	check(followed, "author is in the follow list")
+	if followed {
+		check(!revoked[ev.PubKey], "author key has not been revoked")
+	}
+
+	action, err := signal.Parse(ev.Content)
+	if err != nil {
+		check(false, "content parses as a signal: %v", err)
+	} else {
+		check(true, "content parses as %s signal", action.Type)
+		key := action.Key
+		if cfg.Network != "" && action.Type != signal.TypeRevokeKey {
+			if action.Network == "" {
+				key = signal.NetworkKey(cfg.Network, key)
+			} else {
+				check(action.Network == cfg.Network, "signal targets this node's network %s (targets %s)", cfg.Network, action.Network)
+			}
+		}
+		fmt.Printf("Action:  %s (quorum %d)\n", key, cfg.Quorum)
+	}
+
+	// In threshold mode the event itself must carry enough signatures
+	if trusted := trustedSigners(cfg, activeFollows(cfg, revoked)); trusted != nil {
+		signers, errs := signal.Cosigners(ev)
+		for _, err := range errs {
+			check(false, "%v", err)
+		}
+		count := 0
+		for _, pk := range signers {
+			if trusted[pk] {
+				count++
+			}
+		}
+		check(count >= cfg.Quorum, "event carries %d/%d trusted signature(s)", count, cfg.Quorum)
+	}
+
+	if ok {
+		fmt.Println("Result:  event would be counted as a vote")
+	} else {
+		fmt.Println("Result:  event would NOT be counted as a vote")
+	}
+	return ok
+}